@@ -5,3 +5,8 @@ go mod tidy
 git diff --exit-code -- go.mod || (echo "Go file go.mod is dirty, update the file with 'go mod tidy' locally." && exit 1)
 git diff --exit-code -- go.sum || (echo "Go file go.sum is dirty, update the file with 'go mod tidy' locally." && exit 1)
 go mod verify || (echo "One or more Go dependencies failed verification. Either a version is no longer available, or the author or someone else has modified the version so it no longer points to the same code." && exit 1)
+
+# token-transfer-export is a separate module so that its Parquet dependencies
+# are not required by the SDK.
+(cd tools/token-transfer-export && go mod tidy)
+git diff --exit-code -- tools/token-transfer-export/go.mod tools/token-transfer-export/go.sum || (echo "tools/token-transfer-export go.mod or go.sum is dirty, update the files with 'go mod tidy' locally." && exit 1)
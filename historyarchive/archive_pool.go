@@ -6,7 +6,9 @@ package historyarchive
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,13 +19,95 @@ import (
 	backoff "github.com/cenkalti/backoff/v4"
 )
 
+const (
+	// healthSmoothing is the weight of the latest request in the moving
+	// averages of the latency and error rate of an archive.
+	healthSmoothing = 0.3
+	// latencyTolerance is how much slower than the fastest archive an
+	// archive can be and still be used, so that requests are distributed
+	// among archives of similar latency.
+	latencyTolerance    = 2
+	minLatencyTolerance = 10 * time.Millisecond
+)
+
+// ArchivePoolOptions configures the health tracking of an ArchivePool.
+type ArchivePoolOptions struct {
+	// Backoff is the retry strategy of every request, 3 retries with a
+	// constant 250ms backoff if unset.
+	Backoff backoff.BackOff
+	// Cooldown is how long an unhealthy archive is ejected from the pool,
+	// 1 minute if unset.
+	Cooldown time.Duration
+	// MaxErrorRate is the moving average of the error rate above which an
+	// archive is ejected, 0.5 if unset.
+	MaxErrorRate float64
+	// MaxLag is the number of ledgers the root HAS of an archive can be
+	// behind the most recent root HAS seen in the pool before the archive is
+	// ejected, two checkpoints if unset.
+	MaxLag uint32
+}
+
+// ArchiveHealth is the health of an archive of an ArchivePool.
+type ArchiveHealth struct {
+	// Latency is the moving average of the request latency.
+	Latency time.Duration
+	// ErrorRate is the moving average of the request error rate.
+	ErrorRate float64
+	// LatestLedger is the current ledger of the last root HAS of the
+	// archive, 0 if it wasn't fetched yet.
+	LatestLedger uint32
+	// Lag is the number of ledgers LatestLedger is behind the most recent
+	// root HAS seen in the pool.
+	Lag uint32
+	// EjectedUntil is the end of the cooldown of an ejected archive.
+	EjectedUntil time.Time
+}
+
+// Ejected returns true if the archive is skipped by the pool at the given
+// time.
+func (h ArchiveHealth) Ejected(now time.Time) bool {
+	return now.Before(h.EjectedUntil)
+}
+
+// ArchiveHealthStats are the stats returned by ArchivePool.GetStats, with the
+// health of the archive.
+type ArchiveHealthStats interface {
+	ArchiveStats
+	GetHealth() ArchiveHealth
+}
+
+type archiveHealthStats struct {
+	ArchiveStats
+	health ArchiveHealth
+}
+
+func (s archiveHealthStats) GetHealth() ArchiveHealth {
+	return s.health
+}
+
+type archiveHealth struct {
+	measured bool
+	ArchiveHealth
+}
+
 // An ArchivePool is just a collection of `ArchiveInterface`s so that we can
 // distribute requests fairly throughout the pool.
+//
+// The pool tracks the latency, error rate and freshness of every archive and
+// prefers the fastest archives among the healthy ones. Archives with too many
+// errors, or whose root HAS lags behind the others, are ejected for a cooldown
+// period.
 type ArchivePool struct {
 	logger  *log.Entry
 	backoff backoff.BackOff
+	opts    ArchivePoolOptions
 	pool    []ArchiveInterface
-	curr    int
+
+	mutex        sync.Mutex
+	curr         int
+	health       []archiveHealth
+	latestLedger uint32
+	timeNow      func() time.Time
 }
 
 // NewArchivePool tries connecting to each of the provided history archive URLs,
@@ -33,23 +117,21 @@ type ArchivePool struct {
 // failed archive. Note that the errors for each individual archive are hard to
 // track if there's success overall.
 func NewArchivePool(archiveURLs []string, opts ArchiveOptions) (ArchiveInterface, error) {
-	return NewArchivePoolWithBackoff(
-		archiveURLs,
-		opts,
-		backoff.WithMaxRetries(backoff.NewConstantBackOff(250*time.Millisecond), 3),
-	)
+	return NewArchivePoolWithOptions(archiveURLs, opts, ArchivePoolOptions{})
 }
 
 func NewArchivePoolWithBackoff(archiveURLs []string, opts ArchiveOptions, strategy backoff.BackOff) (ArchiveInterface, error) {
+	return NewArchivePoolWithOptions(archiveURLs, opts, ArchivePoolOptions{Backoff: strategy})
+}
+
+// NewArchivePoolWithOptions is like NewArchivePool with the given health
+// tracking options.
+func NewArchivePoolWithOptions(archiveURLs []string, opts ArchiveOptions, poolOpts ArchivePoolOptions) (ArchiveInterface, error) {
 	if len(archiveURLs) <= 0 {
 		return nil, errors.New("No history archives provided")
 	}
 
-	ap := ArchivePool{
-		pool:    make([]ArchiveInterface, 0, len(archiveURLs)),
-		backoff: strategy,
-		logger:  opts.Logger,
-	}
+	pool := make([]ArchiveInterface, 0, len(archiveURLs))
 	var lastErr error
 
 	// Try connecting to all of the listed archives, but only store valid ones.
@@ -60,47 +142,249 @@ func NewArchivePoolWithBackoff(archiveURLs []string, opts ArchiveOptions, strate
 			continue
 		}
 
-		ap.pool = append(ap.pool, archive)
+		pool = append(pool, archive)
 	}
 
-	if len(ap.pool) == 0 {
+	if len(pool) == 0 {
 		return nil, lastErr
 	}
 
-	ap.curr = rand.Intn(len(ap.pool)) // don't necessarily start at zero
-	return &ap, nil
+	return newArchivePool(pool, opts, poolOpts), nil
 }
 
+func newArchivePool(pool []ArchiveInterface, opts ArchiveOptions, poolOpts ArchivePoolOptions) *ArchivePool {
+	if poolOpts.Backoff == nil {
+		poolOpts.Backoff = backoff.WithMaxRetries(backoff.NewConstantBackOff(250*time.Millisecond), 3)
+	}
+	if poolOpts.Cooldown == 0 {
+		poolOpts.Cooldown = time.Minute
+	}
+	if poolOpts.MaxErrorRate == 0 {
+		poolOpts.MaxErrorRate = 0.5
+	}
+	if poolOpts.MaxLag == 0 {
+		frequency := opts.CheckpointFrequency
+		if frequency == 0 {
+			frequency = DefaultCheckpointFrequency
+		}
+		poolOpts.MaxLag = 2 * frequency
+	}
+
+	return &ArchivePool{
+		pool:    pool,
+		backoff: poolOpts.Backoff,
+		opts:    poolOpts,
+		logger:  opts.Logger,
+		health:  make([]archiveHealth, len(pool)),
+		curr:    rand.Intn(len(pool)), // don't necessarily start at zero
+		timeNow: time.Now,
+	}
+}
+
+// GetStats returns the stats of every archive of the pool, which implement
+// ArchiveHealthStats.
 func (pa *ArchivePool) GetStats() []ArchiveStats {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
 	stats := []ArchiveStats{}
-	for _, archive := range pa.pool {
-		stats = append(stats, archive.GetStats()...)
+	for i, archive := range pa.pool {
+		for _, s := range archive.GetStats() {
+			stats = append(stats, archiveHealthStats{ArchiveStats: s, health: pa.healthOf(i)})
+		}
 	}
 	return stats
 }
 
+// CheckHealth fetches the root HAS of every archive of the pool, including
+// the ejected ones, to update their health. It returns the number of healthy
+// archives.
+func (pa *ArchivePool) CheckHealth() int {
+	ledgers := make([]uint32, len(pa.pool))
+	for i, ai := range pa.pool {
+		_ = pa.run(i, ai, func(ai ArchiveInterface) error {
+			has, err := ai.GetRootHAS()
+			ledgers[i] = has.CurrentLedger
+			return err
+		})
+	}
+
+	// the lag of every archive is relative to the most recent root HAS
+	pa.mutex.Lock()
+	for _, ledger := range ledgers {
+		if ledger > pa.latestLedger {
+			pa.latestLedger = ledger
+		}
+	}
+	pa.mutex.Unlock()
+	for i, ledger := range ledgers {
+		if ledger > 0 {
+			_ = pa.recordLatestLedger(i, ledger)
+		}
+	}
+
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	healthy := 0
+	now := pa.timeNow()
+	for i := range pa.pool {
+		if !pa.healthOf(i).Ejected(now) {
+			healthy++
+		}
+	}
+	return healthy
+}
+
 // Ensure the pool conforms to the ArchiveInterface
 var _ ArchiveInterface = &ArchivePool{}
 
 //
-// These are helpers to round-robin calls through archives.
+// These are helpers to select archives and track their health.
 //
 
-// getNextArchive statefully round-robins through the pool
-func (pa *ArchivePool) getNextArchive() ArchiveInterface {
-	// Round-robin through the archives
-	pa.curr = (pa.curr + 1) % len(pa.pool)
-	return pa.pool[pa.curr]
+func (pa *ArchivePool) healthOf(i int) ArchiveHealth {
+	health := pa.health[i].ArchiveHealth
+	if health.LatestLedger > 0 && health.LatestLedger < pa.latestLedger {
+		health.Lag = pa.latestLedger - health.LatestLedger
+	}
+	return health
 }
 
-// runRoundRobin is a helper method that will run a particular action on every
-// archive in the pool until it succeeds or the pool is exhausted (whichever
-// comes first), repeating with a constant 500ms backoff.
+// score is the expected latency of a successful request to the archive.
+func (h archiveHealth) score() time.Duration {
+	errorRate := h.ErrorRate
+	if errorRate > 0.99 {
+		errorRate = 0.99
+	}
+	return time.Duration(float64(h.Latency) / (1 - errorRate))
+}
+
+// getNextArchive statefully round-robins through the healthy archives of the
+// pool whose score is close to the best score, so that requests are
+// distributed among the fastest archives. Archives whose health is unknown
+// are tried first. If every archive is ejected, the one whose cooldown ends
+// first is returned.
+func (pa *ArchivePool) getNextArchive() (int, ArchiveInterface) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+
+	now := pa.timeNow()
+	best, soonest := time.Duration(-1), -1
+	for i, health := range pa.health {
+		if health.Ejected(now) {
+			if soonest < 0 || health.EjectedUntil.Before(pa.health[soonest].EjectedUntil) {
+				soonest = i
+			}
+			continue
+		}
+		score := time.Duration(0)
+		if health.measured {
+			score = health.score()
+		}
+		if best < 0 || score < best {
+			best = score
+		}
+	}
+	if best < 0 {
+		pa.curr = soonest
+		return pa.curr, pa.pool[pa.curr]
+	}
+
+	tolerance := best * latencyTolerance
+	if best > 0 && tolerance < best+minLatencyTolerance {
+		tolerance = best + minLatencyTolerance
+	}
+	for range pa.pool {
+		// Round-robin through the archives
+		pa.curr = (pa.curr + 1) % len(pa.pool)
+		health := pa.health[pa.curr]
+		if health.Ejected(now) {
+			continue
+		}
+		if !health.measured || health.score() <= tolerance {
+			break
+		}
+	}
+	return pa.curr, pa.pool[pa.curr]
+}
+
+// record updates the health of the archive with the outcome of a request.
+func (pa *ArchivePool) record(i int, latency time.Duration, err error) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+
+	health := &pa.health[i]
+	failure := 0.0
+	if err != nil {
+		failure = 1
+	}
+	if !health.measured {
+		health.measured = true
+		health.Latency = latency
+	} else {
+		health.Latency = time.Duration(healthSmoothing*float64(latency) + (1-healthSmoothing)*float64(health.Latency))
+	}
+	// a single error doesn't eject an archive
+	health.ErrorRate = healthSmoothing*failure + (1-healthSmoothing)*health.ErrorRate
+	if err != nil && health.ErrorRate > pa.opts.MaxErrorRate {
+		pa.eject(i, fmt.Sprintf("error rate %.2f", health.ErrorRate))
+	}
+}
+
+// recordLatestLedger updates the freshness of the archive with the current
+// ledger of its root HAS, returning an error if it lags behind.
+func (pa *ArchivePool) recordLatestLedger(i int, ledger uint32) error {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+
+	pa.health[i].LatestLedger = ledger
+	if ledger > pa.latestLedger {
+		pa.latestLedger = ledger
+	}
+	if lag := pa.latestLedger - ledger; lag > pa.opts.MaxLag {
+		pa.eject(i, fmt.Sprintf("lagging %d ledgers behind", lag))
+		return errors.Errorf("archive is lagging %d ledgers behind ledger %d", lag, pa.latestLedger)
+	}
+	return nil
+}
+
+func (pa *ArchivePool) eject(i int, reason string) {
+	pa.health[i].EjectedUntil = pa.timeNow().Add(pa.opts.Cooldown)
+	if stats := pa.pool[i].GetStats(); len(stats) > 0 && pa.logger != nil {
+		pa.logger.WithField("reason", reason).Warnf(
+			"Ejecting archive '%s' for %v",
+			stats[0].GetBackendName(), pa.opts.Cooldown)
+	}
+}
+
+// run runs the action on the archive and records its outcome, context errors
+// don't affect the health of the archive.
+func (pa *ArchivePool) run(i int, ai ArchiveInterface, runner func(ai ArchiveInterface) error) error {
+	start := time.Now()
+	err := runner(ai)
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	pa.record(i, time.Since(start), err)
+	return err
+}
+
+// runRoundRobin is a helper method that will run a particular action on the
+// archives selected by getNextArchive until it succeeds or the retries of the
+// backoff strategy are exhausted (whichever comes first).
 func (pa *ArchivePool) runRoundRobin(runner func(ai ArchiveInterface) error) error {
+	return pa.runRoundRobinIndexed(func(_ int, ai ArchiveInterface) error {
+		return runner(ai)
+	})
+}
+
+func (pa *ArchivePool) runRoundRobinIndexed(runner func(i int, ai ArchiveInterface) error) error {
 	return backoff.Retry(func() error {
-		var err error
-		ai := pa.getNextArchive()
-		if err = runner(ai); err == nil {
+		i, ai := pa.getNextArchive()
+		err := pa.run(i, ai, func(ai ArchiveInterface) error {
+			return runner(i, ai)
+		})
+		if err == nil {
 			return nil
 		}
 
@@ -176,12 +460,17 @@ func (pa *ArchivePool) GetLedgerHeader(chk uint32) (xdr.LedgerHeaderHistoryEntry
 	})
 }
 
+// GetRootHAS returns the root HAS of an archive which doesn't lag behind the
+// most recent root HAS seen in the pool.
 func (pa *ArchivePool) GetRootHAS() (HistoryArchiveState, error) {
 	var state HistoryArchiveState
-	return state, pa.runRoundRobin(func(ai ArchiveInterface) error {
+	return state, pa.runRoundRobinIndexed(func(i int, ai ArchiveInterface) error {
 		var err error
 		state, err = ai.GetRootHAS()
-		return err
+		if err != nil {
+			return err
+		}
+		return pa.recordLatestLedger(i, state.CurrentLedger)
 	})
 }
 
@@ -245,7 +534,8 @@ func (pa *ArchivePool) GetXdrStream(pth string) (*xdr.Stream, error) {
 }
 
 func (pa *ArchivePool) GetCheckpointManager() CheckpointManager {
-	return pa.getNextArchive().GetCheckpointManager()
+	_, ai := pa.getNextArchive()
+	return ai.GetCheckpointManager()
 }
 
 //
@@ -253,17 +543,21 @@ func (pa *ArchivePool) GetCheckpointManager() CheckpointManager {
 //
 
 func (pa *ArchivePool) ListBucket(dp DirPrefix) (chan string, chan error) {
-	return pa.getNextArchive().ListBucket(dp)
+	_, ai := pa.getNextArchive()
+	return ai.ListBucket(dp)
 }
 
 func (pa *ArchivePool) ListAllBuckets() (chan string, chan error) {
-	return pa.getNextArchive().ListAllBuckets()
+	_, ai := pa.getNextArchive()
+	return ai.ListAllBuckets()
 }
 
 func (pa *ArchivePool) ListAllBucketHashes() (chan Hash, chan error) {
-	return pa.getNextArchive().ListAllBucketHashes()
+	_, ai := pa.getNextArchive()
+	return ai.ListAllBucketHashes()
 }
 
 func (pa *ArchivePool) ListCategoryCheckpoints(cat string, pth string) (chan uint32, chan error) {
-	return pa.getNextArchive().ListCategoryCheckpoints(cat, pth)
+	_, ai := pa.getNextArchive()
+	return ai.ListCategoryCheckpoints(cat, pth)
 }
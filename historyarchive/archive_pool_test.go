@@ -5,6 +5,7 @@
 package historyarchive
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -12,7 +13,9 @@ import (
 	"testing"
 	"time"
 
+	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/stellar/go-stellar-sdk/support/storage"
+	"github.com/stellar/go-stellar-sdk/xdr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -108,3 +111,115 @@ func TestArchivePoolCycles(t *testing.T) {
 		740*time.Millisecond, // some leeway
 		"")
 }
+
+func testHealthPool(t *testing.T, poolOpts ArchivePoolOptions, names ...string) (*ArchivePool, []*MockArchive) {
+	var (
+		archives []*MockArchive
+		pool     []ArchiveInterface
+	)
+	for _, name := range names {
+		archive := &MockArchive{}
+		archive.On("GetStats").Return([]ArchiveStats{&archiveStats{backendName: name}}).Maybe()
+		archives = append(archives, archive)
+		pool = append(pool, archive)
+	}
+	if poolOpts.Backoff == nil {
+		poolOpts.Backoff = backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 5)
+	}
+	return newArchivePool(pool, ArchiveOptions{CheckpointFrequency: 64}, poolOpts), archives
+}
+
+func TestArchivePoolPrefersFastArchives(t *testing.T) {
+	pool, archives := testHealthPool(t, ArchivePoolOptions{}, "fast", "slow")
+	fast, slow := archives[0], archives[1]
+	fast.On("GetLedgerHeader", uint32(63)).Return(xdr.LedgerHeaderHistoryEntry{}, nil)
+	slow.On("GetLedgerHeader", uint32(63)).Return(xdr.LedgerHeaderHistoryEntry{}, nil).After(30 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		_, err := pool.GetLedgerHeader(63)
+		require.NoError(t, err)
+	}
+	// the slow archive is only used until its latency is known
+	slow.AssertNumberOfCalls(t, "GetLedgerHeader", 1)
+	fast.AssertNumberOfCalls(t, "GetLedgerHeader", 9)
+
+	stats := pool.GetStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "slow", stats[1].GetBackendName())
+	assert.GreaterOrEqual(t, stats[1].(ArchiveHealthStats).GetHealth().Latency, 30*time.Millisecond)
+	assert.Less(t, stats[0].(ArchiveHealthStats).GetHealth().Latency, 30*time.Millisecond)
+}
+
+func TestArchivePoolEjectsFailingArchives(t *testing.T) {
+	pool, archives := testHealthPool(t, ArchivePoolOptions{Cooldown: time.Minute}, "good", "failing")
+	good, failing := archives[0], archives[1]
+	good.On("BucketExists", Hash{}).Return(true, nil)
+	failing.On("BucketExists", Hash{}).Return(false, errors.New("boom"))
+	now := time.Unix(1000, 0)
+	pool.timeNow = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		ok, err := pool.BucketExists(Hash{})
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	// the failing archive is ejected after two consecutive errors
+	failing.AssertNumberOfCalls(t, "BucketExists", 2)
+	health := pool.GetStats()[1].(ArchiveHealthStats).GetHealth()
+	assert.True(t, health.Ejected(now))
+	assert.Equal(t, now.Add(time.Minute), health.EjectedUntil)
+	assert.Greater(t, health.ErrorRate, 0.5)
+
+	// it is tried again after the cooldown, and ejected again on error
+	now = now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		_, err := pool.BucketExists(Hash{})
+		require.NoError(t, err)
+	}
+	failing.AssertNumberOfCalls(t, "BucketExists", 3)
+
+	// when every archive is ejected, the one whose cooldown ends first is
+	// still used
+	now = now.Add(time.Second)
+	good.ExpectedCalls = nil
+	good.On("GetStats").Return([]ArchiveStats{&archiveStats{backendName: "good"}})
+	good.On("BucketExists", Hash{}).Return(false, errors.New("boom"))
+	_, err := pool.BucketExists(Hash{})
+	assert.EqualError(t, err, "boom")
+	good.AssertNumberOfCalls(t, "BucketExists", 20+5)
+	failing.AssertNumberOfCalls(t, "BucketExists", 3+1)
+}
+
+func TestArchivePoolEjectsLaggingArchives(t *testing.T) {
+	pool, archives := testHealthPool(t, ArchivePoolOptions{}, "fresh", "stale")
+	fresh, stale := archives[0], archives[1]
+	fresh.On("GetRootHAS").Return(HistoryArchiveState{CurrentLedger: 1023}, nil)
+	stale.On("GetRootHAS").Return(HistoryArchiveState{CurrentLedger: 767}, nil)
+
+	assert.Equal(t, 1, pool.CheckHealth())
+	health := pool.GetStats()[1].(ArchiveHealthStats).GetHealth()
+	assert.Equal(t, uint32(767), health.LatestLedger)
+	assert.Equal(t, uint32(256), health.Lag)
+	assert.True(t, health.Ejected(time.Now()))
+
+	for i := 0; i < 5; i++ {
+		sequence, err := pool.GetLatestLedgerSequence()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1023), sequence)
+	}
+	stale.AssertNumberOfCalls(t, "GetRootHAS", 1)
+
+	// a lagging root HAS is retried on another archive
+	pool, archives = testHealthPool(t, ArchivePoolOptions{}, "fresh", "stale")
+	fresh, stale = archives[0], archives[1]
+	fresh.On("GetRootHAS").Return(HistoryArchiveState{CurrentLedger: 1023}, nil)
+	stale.On("GetRootHAS").Return(HistoryArchiveState{CurrentLedger: 767}, nil)
+	pool.curr = 1
+	has, err := pool.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1023), has.CurrentLedger)
+	has, err = pool.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1023), has.CurrentLedger)
+	stale.AssertNumberOfCalls(t, "GetRootHAS", 1)
+}
@@ -0,0 +1,542 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// BucketStatsOptions configures Archive.BucketStats.
+type BucketStatsOptions struct {
+	// Range is the range of the reported checkpoints, up to the latest
+	// checkpoint of the archive if High is zero.
+	Range Range
+	// Interval is the time between reported checkpoints: the first checkpoint
+	// of the range is reported, then the first checkpoint closing at or after
+	// each following Interval. Every checkpoint of the range is reported if
+	// zero.
+	Interval time.Duration
+	// Entries reads the buckets to count their entries, only the sizes of the
+	// bucket files are reported otherwise.
+	Entries bool
+	// Concurrency is the number of HASes and buckets read concurrently.
+	Concurrency int
+	// LiveState returns the statistics of the live ledger state of a
+	// checkpoint, e.g. ingest.CheckpointLiveStateStats, which resolves the
+	// entries of the buckets by key. It is called for every reported
+	// checkpoint, one at a time since the whole bucket list is read, and the
+	// live state is not reported if nil.
+	LiveState func(checkpoint uint32) (LiveStateStats, error)
+}
+
+// LiveStateStats are the statistics of the live ledger state of a checkpoint,
+// where every ledger entry is counted once.
+type LiveStateStats struct {
+	// Entries is the number of ledger entries by LedgerEntryType.
+	Entries map[string]int64 `json:"entries"`
+	// SorobanBytes is the XDR size of the contract data and contract code
+	// entries.
+	SorobanBytes int64 `json:"sorobanBytes"`
+}
+
+func (s *LiveStateStats) entries() int64 {
+	if s == nil {
+		return 0
+	}
+	var n int64
+	for _, count := range s.Entries {
+		n += count
+	}
+	return n
+}
+
+func (s *LiveStateStats) sorobanBytes() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.SorobanBytes
+}
+
+// BucketLevelStats are the statistics of a level of a bucket list.
+type BucketLevelStats struct {
+	// CurrSize and SnapSize are the sizes of the compressed bucket files.
+	CurrSize int64 `json:"currSize"`
+	SnapSize int64 `json:"snapSize"`
+	// Entries is the number of entries of both buckets, only reported with
+	// BucketStatsOptions.Entries.
+	Entries int64 `json:"entries,omitempty"`
+}
+
+// CheckpointBucketStats are the statistics of the bucket lists of a
+// checkpoint.
+type CheckpointBucketStats struct {
+	Checkpoint uint32    `json:"checkpoint"`
+	CloseTime  time.Time `json:"closeTime"`
+	// Live and HotArchive are the levels of the live and hot archive bucket
+	// lists, the hot archive bucket list is empty before protocol 23.
+	Live           [NumLevels]BucketLevelStats `json:"live"`
+	HotArchive     [NumLevels]BucketLevelStats `json:"hotArchive"`
+	LiveSize       int64                       `json:"liveSize"`
+	HotArchiveSize int64                       `json:"hotArchiveSize"`
+
+	// The following are only reported with BucketStatsOptions.Entries. They
+	// are summed over the buckets of every level, without resolving entries
+	// by key: the older versions of the entries updated or deleted in newer
+	// levels are counted too, so they overstate the ledger state, which is
+	// reported by LiveState.
+
+	// BucketLiveEntries is the number of live and init entries of the live
+	// bucket list by LedgerEntryType.
+	BucketLiveEntries map[string]int64 `json:"bucketLiveEntries,omitempty"`
+	DeadEntries       int64            `json:"deadEntries"`
+	// BucketSorobanBytes is the XDR size of the contract data and contract
+	// code entries of the live bucket list.
+	BucketSorobanBytes int64 `json:"bucketSorobanBytes"`
+	// HotArchiveEntries is the number of archived entries of the hot archive
+	// bucket list.
+	HotArchiveEntries int64 `json:"hotArchiveEntries"`
+
+	// LiveState is only reported with BucketStatsOptions.LiveState.
+	LiveState *LiveStateStats `json:"liveState,omitempty"`
+}
+
+func (s CheckpointBucketStats) bucketLiveEntries() int64 {
+	var n int64
+	for _, count := range s.BucketLiveEntries {
+		n += count
+	}
+	return n
+}
+
+// BucketStatsGrowth is the growth of the bucket lists between two reported
+// checkpoints. The rates are zero if both checkpoints closed at the same
+// time.
+type BucketStatsGrowth struct {
+	From                     uint32  `json:"from"`
+	To                       uint32  `json:"to"`
+	Days                     float64 `json:"days"`
+	LiveSize                 int64   `json:"liveSize"`
+	LiveSizePerDay           float64 `json:"liveSizePerDay"`
+	HotArchiveSize           int64   `json:"hotArchiveSize"`
+	HotArchiveSizePerDay     float64 `json:"hotArchiveSizePerDay"`
+	BucketLiveEntries        int64   `json:"bucketLiveEntries"`
+	BucketLiveEntriesPerDay  float64 `json:"bucketLiveEntriesPerDay"`
+	BucketSorobanBytes       int64   `json:"bucketSorobanBytes"`
+	BucketSorobanBytesPerDay float64 `json:"bucketSorobanBytesPerDay"`
+	LiveStateEntries         int64   `json:"liveStateEntries"`
+	LiveStateEntriesPerDay   float64 `json:"liveStateEntriesPerDay"`
+	LiveSorobanBytes         int64   `json:"liveSorobanBytes"`
+	LiveSorobanBytesPerDay   float64 `json:"liveSorobanBytesPerDay"`
+}
+
+func bucketStatsGrowth(from, to CheckpointBucketStats) BucketStatsGrowth {
+	growth := BucketStatsGrowth{
+		From:               from.Checkpoint,
+		To:                 to.Checkpoint,
+		Days:               to.CloseTime.Sub(from.CloseTime).Hours() / 24,
+		LiveSize:           to.LiveSize - from.LiveSize,
+		HotArchiveSize:     to.HotArchiveSize - from.HotArchiveSize,
+		BucketLiveEntries:  to.bucketLiveEntries() - from.bucketLiveEntries(),
+		BucketSorobanBytes: to.BucketSorobanBytes - from.BucketSorobanBytes,
+		LiveStateEntries:   to.LiveState.entries() - from.LiveState.entries(),
+		LiveSorobanBytes:   to.LiveState.sorobanBytes() - from.LiveState.sorobanBytes(),
+	}
+	if growth.Days > 0 {
+		growth.LiveSizePerDay = float64(growth.LiveSize) / growth.Days
+		growth.HotArchiveSizePerDay = float64(growth.HotArchiveSize) / growth.Days
+		growth.BucketLiveEntriesPerDay = float64(growth.BucketLiveEntries) / growth.Days
+		growth.BucketSorobanBytesPerDay = float64(growth.BucketSorobanBytes) / growth.Days
+		growth.LiveStateEntriesPerDay = float64(growth.LiveStateEntries) / growth.Days
+		growth.LiveSorobanBytesPerDay = float64(growth.LiveSorobanBytes) / growth.Days
+	}
+	return growth
+}
+
+// BucketStatsReport is the outcome of Archive.BucketStats.
+type BucketStatsReport struct {
+	// Checkpoints are the statistics of the reported checkpoints, in order.
+	Checkpoints []CheckpointBucketStats `json:"checkpoints"`
+	// Growth is the growth between consecutive reported checkpoints, Total
+	// between the first and last ones.
+	Growth []BucketStatsGrowth `json:"growth"`
+	Total  BucketStatsGrowth   `json:"total"`
+}
+
+// bucketStats are the statistics of a bucket file, shared by the checkpoints
+// referring to it.
+type bucketStats struct {
+	size              int64
+	entries           int64
+	liveEntries       map[xdr.LedgerEntryType]int64
+	deadEntries       int64
+	sorobanBytes      int64
+	hotArchiveEntries int64
+}
+
+// BucketStats reports the sizes of the bucket lists of the checkpoints in the
+// range, and their growth. Each bucket is only read once, however many
+// reported checkpoints refer to it.
+func (arch *Archive) BucketStats(opts BucketStatsOptions) (BucketStatsReport, error) {
+	var report BucketStatsReport
+	if opts.Concurrency <= 0 {
+		return report, errors.New("Zero concurrency")
+	}
+	checkpoints, err := arch.bucketStatsCheckpoints(opts)
+	if err != nil {
+		return report, err
+	}
+
+	report.Checkpoints = make([]CheckpointBucketStats, len(checkpoints))
+	hases := make([]HistoryArchiveState, len(checkpoints))
+	err = runConcurrently(len(checkpoints), opts.Concurrency, func(i int) error {
+		chk := checkpoints[i]
+		has, err := arch.GetCheckpointHAS(chk)
+		if err != nil {
+			return errors.Wrapf(err, "error getting HAS of checkpoint %d", chk)
+		}
+		header, err := arch.GetLedgerHeader(chk)
+		if err != nil {
+			return errors.Wrapf(err, "error getting header of checkpoint %d", chk)
+		}
+		hases[i] = has
+		report.Checkpoints[i] = CheckpointBucketStats{
+			Checkpoint: chk,
+			CloseTime:  closeTimeFromHeader(header),
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// the buckets of the hot archive bucket lists have a different entry type
+	hot := map[Hash]bool{}
+	for _, has := range hases {
+		for _, list := range has.bucketLists() {
+			for _, level := range list.levels {
+				for _, bucket := range level {
+					if !bucket.IsZero() {
+						hot[bucket] = list.hot
+					}
+				}
+			}
+		}
+	}
+	buckets := make([]Hash, 0, len(hot))
+	for bucket := range hot {
+		buckets = append(buckets, bucket)
+	}
+	log.Printf("Reading %d buckets of %d checkpoints", len(buckets), len(checkpoints))
+
+	var mutex sync.Mutex
+	stats := make(map[Hash]bucketStats, len(buckets))
+	err = runConcurrently(len(buckets), opts.Concurrency, func(i int) error {
+		s, err := arch.readBucketStats(buckets[i], hot[buckets[i]], opts.Entries)
+		if err != nil {
+			return errors.Wrapf(err, "error reading bucket %s", buckets[i])
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		stats[buckets[i]] = s
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if opts.LiveState != nil {
+		for i := range report.Checkpoints {
+			chk := report.Checkpoints[i].Checkpoint
+			live, err := opts.LiveState(chk)
+			if err != nil {
+				return report, errors.Wrapf(err, "error reading live state of checkpoint %d", chk)
+			}
+			report.Checkpoints[i].LiveState = &live
+		}
+	}
+
+	for i, has := range hases {
+		report.Checkpoints[i].addBuckets(has, stats, opts.Entries)
+		if i > 0 {
+			report.Growth = append(report.Growth, bucketStatsGrowth(report.Checkpoints[i-1], report.Checkpoints[i]))
+		}
+	}
+	if len(report.Checkpoints) > 0 {
+		report.Total = bucketStatsGrowth(report.Checkpoints[0], report.Checkpoints[len(report.Checkpoints)-1])
+	}
+	return report, nil
+}
+
+// bucketStatsCheckpoints returns the checkpoints reported with the options.
+func (arch *Archive) bucketStatsCheckpoints(opts BucketStatsOptions) ([]uint32, error) {
+	root, err := arch.GetRootHAS()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting root HAS")
+	}
+	if opts.Range.High == 0 || opts.Range.High > root.CurrentLedger {
+		opts.Range.High = root.CurrentLedger
+	}
+	r := opts.Range.clamp(root.Range(), arch.checkpointManager)
+
+	var checkpoints []uint32
+	if opts.Interval <= 0 {
+		for chk := range r.GenerateCheckpoints(arch.checkpointManager) {
+			checkpoints = append(checkpoints, chk)
+		}
+		return checkpoints, nil
+	}
+
+	closeTimes := make([]time.Time, 2)
+	for i, chk := range []uint32{r.Low, r.High} {
+		header, err := arch.GetLedgerHeader(chk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting header of checkpoint %d", chk)
+		}
+		closeTimes[i] = closeTimeFromHeader(header)
+	}
+	checkpoints = append(checkpoints, r.Low)
+	for t := closeTimes[0].Add(opts.Interval); !t.After(closeTimes[1]); t = t.Add(opts.Interval) {
+		seq, err := LedgerForTime(arch, t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting ledger closing at %s", t)
+		}
+		// the checkpoints before the ledger's one closed before t
+		chk := arch.checkpointManager.GetCheckpoint(seq)
+		if chk > checkpoints[len(checkpoints)-1] && chk <= r.High {
+			checkpoints = append(checkpoints, chk)
+		}
+	}
+	return checkpoints, nil
+}
+
+type hasBucketList struct {
+	hot    bool
+	levels [NumLevels][2]Hash
+}
+
+// bucketLists returns the curr and snap buckets of the levels of the live
+// bucket list, and of the hot archive bucket list for version 2+.
+func (h *HistoryArchiveState) bucketLists() []hasBucketList {
+	lists := []hasBucketList{{hot: false}, {hot: true}}
+	for i, list := range []BucketList{h.CurrentBuckets, h.HotArchiveBuckets} {
+		if i == 1 && h.Version < HistoryArchiveStateVersionForProtocol23 {
+			break
+		}
+		for level, b := range list {
+			for j, bs := range []string{b.Curr, b.Snap} {
+				// invalid hashes were rejected when decoding the HAS, empty
+				// ones are zero
+				lists[i].levels[level][j], _ = DecodeHash(bs)
+			}
+		}
+	}
+	return lists
+}
+
+func (s *CheckpointBucketStats) addBuckets(has HistoryArchiveState, stats map[Hash]bucketStats, entries bool) {
+	if entries {
+		s.BucketLiveEntries = map[string]int64{}
+	}
+	for _, list := range has.bucketLists() {
+		levels, total := &s.Live, &s.LiveSize
+		if list.hot {
+			levels, total = &s.HotArchive, &s.HotArchiveSize
+		}
+		for i, level := range list.levels {
+			for j, bucket := range level {
+				if bucket.IsZero() {
+					continue
+				}
+				b := stats[bucket]
+				if j == 0 {
+					levels[i].CurrSize = b.size
+				} else {
+					levels[i].SnapSize = b.size
+				}
+				levels[i].Entries += b.entries
+				*total += b.size
+				for t, n := range b.liveEntries {
+					s.BucketLiveEntries[t.String()] += n
+				}
+				s.DeadEntries += b.deadEntries
+				s.BucketSorobanBytes += b.sorobanBytes
+				s.HotArchiveEntries += b.hotArchiveEntries
+			}
+		}
+	}
+}
+
+// readBucketStats returns the size of the bucket, and counts its entries if
+// entries is set.
+func (arch *Archive) readBucketStats(bucket Hash, hot bool, entries bool) (bucketStats, error) {
+	var stats bucketStats
+	var err error
+	if stats.size, err = arch.BucketSize(bucket); err != nil {
+		return stats, err
+	}
+	if !entries {
+		return stats, nil
+	}
+
+	stream, err := arch.GetXdrStream(BucketPath(bucket))
+	if err != nil {
+		return stats, err
+	}
+	defer stream.Close()
+	stats.liveEntries = map[xdr.LedgerEntryType]int64{}
+	for {
+		read := stream.BytesRead()
+		if hot {
+			var entry xdr.HotArchiveBucketEntry
+			if err = stream.ReadOne(&entry); err == io.EOF {
+				return stats, nil
+			} else if err != nil {
+				return stats, err
+			}
+			switch entry.Type {
+			case xdr.HotArchiveBucketEntryTypeHotArchiveArchived:
+				stats.entries++
+				stats.hotArchiveEntries++
+			case xdr.HotArchiveBucketEntryTypeHotArchiveLive:
+				stats.entries++
+			}
+			continue
+		}
+
+		var entry xdr.BucketEntry
+		if err = stream.ReadOne(&entry); err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, err
+		}
+		switch entry.Type {
+		case xdr.BucketEntryTypeLiveentry, xdr.BucketEntryTypeInitentry:
+			stats.entries++
+			t := entry.LiveEntry.Data.Type
+			stats.liveEntries[t]++
+			if t == xdr.LedgerEntryTypeContractData || t == xdr.LedgerEntryTypeContractCode {
+				// the size of the ledger entry, without the 4 byte record mark
+				// and bucket entry type
+				stats.sorobanBytes += stream.BytesRead() - read - 8
+			}
+		case xdr.BucketEntryTypeDeadentry:
+			stats.entries++
+			stats.deadEntries++
+		}
+	}
+}
+
+// WriteCSV writes the report as CSV, one row per checkpoint with its growth
+// since the previous one. The entry and live state columns are only written
+// if they were reported.
+func (r BucketStatsReport) WriteCSV(w io.Writer) error {
+	entries, liveState := false, false
+	for _, s := range r.Checkpoints {
+		entries = entries || s.BucketLiveEntries != nil
+		liveState = liveState || s.LiveState != nil
+	}
+	var entryTypes []xdr.LedgerEntryType
+	for t := xdr.LedgerEntryType(0); (entries || liveState) && t.ValidEnum(int32(t)); t++ {
+		entryTypes = append(entryTypes, t)
+	}
+
+	header := []string{"checkpoint", "closeTime", "liveSize", "hotArchiveSize"}
+	for level := 0; level < NumLevels; level++ {
+		header = append(header, "live"+strconv.Itoa(level)+"Size")
+	}
+	for level := 0; level < NumLevels; level++ {
+		header = append(header, "hotArchive"+strconv.Itoa(level)+"Size")
+	}
+	if entries {
+		for _, t := range entryTypes {
+			header = append(header, t.String())
+		}
+		header = append(header, "deadEntries", "bucketSorobanBytes", "hotArchiveEntries")
+	}
+	if liveState {
+		for _, t := range entryTypes {
+			header = append(header, "liveState"+strings.TrimPrefix(t.String(), "LedgerEntryType"))
+		}
+		header = append(header, "liveSorobanBytes")
+	}
+	header = append(header, "liveSizePerDay", "hotArchiveSizePerDay")
+	if entries {
+		header = append(header, "bucketLiveEntriesPerDay", "bucketSorobanBytesPerDay")
+	}
+	if liveState {
+		header = append(header, "liveStateEntriesPerDay", "liveSorobanBytesPerDay")
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	formatInt := func(n int64) string { return strconv.FormatInt(n, 10) }
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	for i, s := range r.Checkpoints {
+		row := []string{
+			strconv.FormatUint(uint64(s.Checkpoint), 10),
+			s.CloseTime.Format(time.RFC3339),
+			formatInt(s.LiveSize),
+			formatInt(s.HotArchiveSize),
+		}
+		for _, level := range s.Live {
+			row = append(row, formatInt(level.CurrSize+level.SnapSize))
+		}
+		for _, level := range s.HotArchive {
+			row = append(row, formatInt(level.CurrSize+level.SnapSize))
+		}
+		if entries {
+			for _, t := range entryTypes {
+				row = append(row, formatInt(s.BucketLiveEntries[t.String()]))
+			}
+			row = append(row, formatInt(s.DeadEntries), formatInt(s.BucketSorobanBytes), formatInt(s.HotArchiveEntries))
+		}
+		if liveState {
+			var live LiveStateStats
+			if s.LiveState != nil {
+				live = *s.LiveState
+			}
+			for _, t := range entryTypes {
+				row = append(row, formatInt(live.Entries[t.String()]))
+			}
+			row = append(row, formatInt(live.SorobanBytes))
+		}
+		// the first checkpoint has no growth
+		if i == 0 {
+			row = append(row, "", "")
+			if entries {
+				row = append(row, "", "")
+			}
+			if liveState {
+				row = append(row, "", "")
+			}
+		} else {
+			growth := r.Growth[i-1]
+			row = append(row, formatFloat(growth.LiveSizePerDay), formatFloat(growth.HotArchiveSizePerDay))
+			if entries {
+				row = append(row, formatFloat(growth.BucketLiveEntriesPerDay), formatFloat(growth.BucketSorobanBytesPerDay))
+			}
+			if liveState {
+				row = append(row, formatFloat(growth.LiveStateEntriesPerDay), formatFloat(growth.LiveSorobanBytesPerDay))
+			}
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,266 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// bucketStatsTestArchive returns the test chain up to checkpoint 191 with
+// bucket lists: bucket a is the level 0 curr of every checkpoint, b the level
+// 1 snap of checkpoints 127 and 191, and c the level 0 curr of the hot archive
+// of checkpoint 191. It also returns the contract data entry of b.
+func bucketStatsTestArchive(t *testing.T) (*Archive, Hash, Hash, Hash, xdr.LedgerEntry) {
+	archive, _ := publishTestChain(t, 191)
+	a, b, c := Hash{0xa}, Hash{0xb}, Hash{0xc}
+
+	account := xdr.LedgerEntry{Data: xdr.LedgerEntryData{
+		Type:    xdr.LedgerEntryTypeAccount,
+		Account: &xdr.AccountEntry{AccountId: xdr.MustAddress("GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")},
+	}}
+	key := xdr.Uint32(1)
+	contractData := xdr.LedgerEntry{Data: xdr.LedgerEntryData{
+		Type: xdr.LedgerEntryTypeContractData,
+		ContractData: &xdr.ContractDataEntry{
+			Contract:   xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &xdr.ContractId{1}},
+			Key:        xdr.ScVal{Type: xdr.ScValTypeScvU32, U32: &key},
+			Durability: xdr.ContractDataDurabilityPersistent,
+			Val:        xdr.ScVal{Type: xdr.ScValTypeScvU32, U32: &key},
+		},
+	}}
+	contractCode := xdr.LedgerEntry{Data: xdr.LedgerEntryData{
+		Type:         xdr.LedgerEntryTypeContractCode,
+		ContractCode: &xdr.ContractCodeEntry{Code: []byte("code")},
+	}}
+	meta := &xdr.BucketMetadata{LedgerVersion: 23}
+
+	writeCategoryFile(t, archive.backend, BucketPath(a), []xdrEntry{
+		xdr.BucketEntry{Type: xdr.BucketEntryTypeMetaentry, MetaEntry: meta},
+		xdr.BucketEntry{Type: xdr.BucketEntryTypeLiveentry, LiveEntry: &account},
+	})
+	deadKey, err := account.LedgerKey()
+	require.NoError(t, err)
+	writeCategoryFile(t, archive.backend, BucketPath(b), []xdrEntry{
+		xdr.BucketEntry{Type: xdr.BucketEntryTypeMetaentry, MetaEntry: meta},
+		xdr.BucketEntry{Type: xdr.BucketEntryTypeInitentry, LiveEntry: &contractData},
+		xdr.BucketEntry{Type: xdr.BucketEntryTypeDeadentry, DeadEntry: &deadKey},
+	})
+	writeCategoryFile(t, archive.backend, BucketPath(c), []xdrEntry{
+		xdr.HotArchiveBucketEntry{Type: xdr.HotArchiveBucketEntryTypeHotArchiveMetaentry, MetaEntry: meta},
+		xdr.HotArchiveBucketEntry{Type: xdr.HotArchiveBucketEntryTypeHotArchiveArchived, ArchivedEntry: &contractCode},
+		xdr.HotArchiveBucketEntry{Type: xdr.HotArchiveBucketEntryTypeHotArchiveLive, Key: &deadKey},
+	})
+
+	zero := Hash{}.String()
+	for checkpoint := uint32(63); checkpoint <= 191; checkpoint += 64 {
+		has, err := archive.GetCheckpointHAS(checkpoint)
+		require.NoError(t, err)
+		for i := range has.CurrentBuckets {
+			has.CurrentBuckets[i].Curr = zero
+			has.CurrentBuckets[i].Snap = zero
+			has.HotArchiveBuckets[i].Curr = zero
+			has.HotArchiveBuckets[i].Snap = zero
+		}
+		has.CurrentBuckets[0].Curr = a.String()
+		if checkpoint >= 127 {
+			has.CurrentBuckets[1].Snap = b.String()
+		}
+		if checkpoint == 191 {
+			has.Version = HistoryArchiveStateVersionForProtocol23
+			has.HotArchiveBuckets[0].Curr = c.String()
+		}
+		require.NoError(t, archive.PutCheckpointHAS(checkpoint, has, &CommandOptions{Force: true}))
+	}
+	return archive, a, b, c, contractData
+}
+
+func mustParseInt(t *testing.T, s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	require.NoError(t, err)
+	return n
+}
+
+func bucketSize(t *testing.T, archive *Archive, bucket Hash) int64 {
+	size, err := archive.BucketSize(bucket)
+	require.NoError(t, err)
+	return size
+}
+
+func TestBucketStats(t *testing.T) {
+	archive, a, b, c, contractData := bucketStatsTestArchive(t)
+	sizeA, sizeB, sizeC := bucketSize(t, archive, a), bucketSize(t, archive, b), bucketSize(t, archive, c)
+
+	_, err := archive.BucketStats(BucketStatsOptions{})
+	assert.EqualError(t, err, "Zero concurrency")
+	_, err = archive.BucketStats(BucketStatsOptions{Concurrency: -1})
+	assert.EqualError(t, err, "Zero concurrency")
+
+	report, err := archive.BucketStats(BucketStatsOptions{Concurrency: 2})
+	require.NoError(t, err)
+	require.Len(t, report.Checkpoints, 3)
+	first, last := report.Checkpoints[0], report.Checkpoints[2]
+	assert.Equal(t, uint32(63), first.Checkpoint)
+	assert.Equal(t, testLedgerTime(63), first.CloseTime)
+	assert.Equal(t, sizeA, first.LiveSize)
+	assert.Zero(t, first.HotArchiveSize)
+	assert.Equal(t, uint32(191), last.Checkpoint)
+	assert.Equal(t, BucketLevelStats{CurrSize: sizeA}, last.Live[0])
+	assert.Equal(t, BucketLevelStats{SnapSize: sizeB}, last.Live[1])
+	assert.Equal(t, sizeA+sizeB, last.LiveSize)
+	assert.Equal(t, BucketLevelStats{CurrSize: sizeC}, last.HotArchive[0])
+	assert.Equal(t, sizeC, last.HotArchiveSize)
+	assert.Nil(t, last.BucketLiveEntries)
+
+	require.Len(t, report.Growth, 2)
+	days := (64 * 5 * time.Second).Hours() / 24
+	assert.Equal(t, BucketStatsGrowth{
+		From:           63,
+		To:             127,
+		Days:           days,
+		LiveSize:       sizeB,
+		LiveSizePerDay: float64(sizeB) / days,
+	}, report.Growth[0])
+	assert.Equal(t, uint32(63), report.Total.From)
+	assert.Equal(t, uint32(191), report.Total.To)
+	assert.Equal(t, sizeB, report.Total.LiveSize)
+	assert.Equal(t, sizeC, report.Total.HotArchiveSize)
+
+	report, err = archive.BucketStats(BucketStatsOptions{Range: Range{Low: 191}, Entries: true, Concurrency: 3})
+	require.NoError(t, err)
+	require.Len(t, report.Checkpoints, 1)
+	last = report.Checkpoints[0]
+	assert.Equal(t, map[string]int64{
+		"LedgerEntryTypeAccount":      1,
+		"LedgerEntryTypeContractData": 1,
+	}, last.BucketLiveEntries)
+	assert.Equal(t, int64(1), last.DeadEntries)
+	assert.Equal(t, int64(1), last.Live[0].Entries)
+	assert.Equal(t, int64(2), last.Live[1].Entries)
+	assert.Equal(t, int64(2), last.HotArchive[0].Entries)
+	assert.Equal(t, int64(1), last.HotArchiveEntries)
+	contractDataXDR, err := contractData.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contractDataXDR)), last.BucketSorobanBytes)
+	assert.Empty(t, report.Growth)
+}
+
+func TestBucketStatsInterval(t *testing.T) {
+	archive, _, _, _, _ := bucketStatsTestArchive(t)
+
+	// the checkpoints close every 320 seconds
+	for _, tc := range []struct {
+		interval    time.Duration
+		checkpoints []uint32
+	}{
+		{320 * time.Second, []uint32{63, 127, 191}},
+		{100 * time.Second, []uint32{63, 127, 191}},
+		{600 * time.Second, []uint32{63, 191}},
+		{time.Hour, []uint32{63}},
+	} {
+		report, err := archive.BucketStats(BucketStatsOptions{Interval: tc.interval, Concurrency: 2})
+		require.NoError(t, err)
+		var checkpoints []uint32
+		for _, s := range report.Checkpoints {
+			checkpoints = append(checkpoints, s.Checkpoint)
+		}
+		assert.Equal(t, tc.checkpoints, checkpoints, tc.interval)
+	}
+}
+
+func TestBucketStatsWriteCSV(t *testing.T) {
+	archive, a, b, _, _ := bucketStatsTestArchive(t)
+
+	for _, entries := range []bool{false, true} {
+		report, err := archive.BucketStats(BucketStatsOptions{Range: Range{Low: 127}, Entries: entries, Concurrency: 2})
+		require.NoError(t, err)
+		var out bytes.Buffer
+		require.NoError(t, report.WriteCSV(&out))
+		rows, err := csv.NewReader(&out).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+
+		columns := map[string]int{}
+		for i, name := range rows[0] {
+			columns[name] = i
+		}
+		assert.Equal(t, "127", rows[1][columns["checkpoint"]])
+		assert.Equal(t, testLedgerTime(127).Format(time.RFC3339), rows[1][columns["closeTime"]])
+		assert.Equal(t, "", rows[1][columns["liveSizePerDay"]])
+		assert.Equal(t, "0", rows[2][columns["liveSizePerDay"]])
+		size := bucketSize(t, archive, a) + bucketSize(t, archive, b)
+		assert.Equal(t, size, mustParseInt(t, rows[2][columns["liveSize"]]))
+		assert.Equal(t, bucketSize(t, archive, b), mustParseInt(t, rows[2][columns["live1Size"]]))
+
+		_, ok := columns["LedgerEntryTypeAccount"]
+		assert.Equal(t, entries, ok)
+		if entries {
+			assert.Equal(t, "1", rows[2][columns["LedgerEntryTypeContractData"]])
+			assert.Equal(t, "1", rows[2][columns["hotArchiveEntries"]])
+			assert.Equal(t, "0", rows[2][columns["bucketSorobanBytesPerDay"]])
+		}
+	}
+}
+
+func TestBucketStatsLiveState(t *testing.T) {
+	archive, _, _, _, _ := bucketStatsTestArchive(t)
+
+	// the live state grows by one account and 100 Soroban bytes per checkpoint
+	var read []uint32
+	liveState := func(checkpoint uint32) (LiveStateStats, error) {
+		read = append(read, checkpoint)
+		n := int64(checkpoint+1) / 64
+		return LiveStateStats{
+			Entries:      map[string]int64{"LedgerEntryTypeAccount": n},
+			SorobanBytes: 100 * n,
+		}, nil
+	}
+	report, err := archive.BucketStats(BucketStatsOptions{Range: Range{Low: 127}, LiveState: liveState, Concurrency: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{127, 191}, read)
+	require.Len(t, report.Checkpoints, 2)
+	assert.Equal(t, &LiveStateStats{Entries: map[string]int64{"LedgerEntryTypeAccount": 3}, SorobanBytes: 300}, report.Checkpoints[1].LiveState)
+	assert.Nil(t, report.Checkpoints[1].BucketLiveEntries)
+	days := (64 * 5 * time.Second).Hours() / 24
+	assert.Equal(t, int64(1), report.Total.LiveStateEntries)
+	assert.Equal(t, 1/days, report.Total.LiveStateEntriesPerDay)
+	assert.Equal(t, int64(100), report.Total.LiveSorobanBytes)
+	assert.Equal(t, 100/days, report.Total.LiveSorobanBytesPerDay)
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteCSV(&out))
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	_, ok := columns["LedgerEntryTypeAccount"]
+	assert.False(t, ok)
+	assert.Equal(t, "3", rows[2][columns["liveStateAccount"]])
+	assert.Equal(t, "0", rows[2][columns["liveStateContractData"]])
+	assert.Equal(t, "300", rows[2][columns["liveSorobanBytes"]])
+	assert.Equal(t, "", rows[1][columns["liveStateEntriesPerDay"]])
+	assert.Equal(t, strconv.FormatFloat(100/days, 'f', -1, 64), rows[2][columns["liveSorobanBytesPerDay"]])
+
+	_, err = archive.BucketStats(BucketStatsOptions{
+		Range:       Range{Low: 191},
+		Concurrency: 2,
+		LiveState: func(uint32) (LiveStateStats, error) {
+			return LiveStateStats{}, errors.New("boom")
+		},
+	})
+	assert.EqualError(t, err, "error reading live state of checkpoint 191: boom")
+}
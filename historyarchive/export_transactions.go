@@ -0,0 +1,227 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// TransactionExportOptions configures Archive.ExportTransactions.
+type TransactionExportOptions struct {
+	// Range is the range of ledgers to export, up to the latest ledger of
+	// the archive if High is zero.
+	Range Range
+	// Concurrency is the number of checkpoints read concurrently.
+	Concurrency int
+	// NetworkPassphrase is used to hash the transactions, the passphrase of
+	// the archive options, or else of the root HAS, if empty.
+	NetworkPassphrase string
+}
+
+// ExportedTransaction is a transaction of the transactions category joined
+// with its result of the results category.
+type ExportedTransaction struct {
+	Ledger uint32 `json:"ledger"`
+	// Index is the 1-based index of the transaction in the application order
+	// of the ledger.
+	Index     uint32    `json:"index"`
+	CloseTime time.Time `json:"closeTime"`
+	Hash      string    `json:"hash"`
+	// Envelope and Result are the base64 encoded XDR of the
+	// TransactionEnvelope and TransactionResult.
+	Envelope   string `json:"envelope"`
+	Result     string `json:"result"`
+	ResultCode string `json:"resultCode"`
+	Successful bool   `json:"successful"`
+	FeeCharged int64  `json:"feeCharged"`
+}
+
+// ExportTransactions returns an iterator over the transactions of the ledgers
+// in the range, in application order, for ledgers which are only available
+// in history archives. Checkpoints are read concurrently but transactions are
+// yielded in ledger order. The iteration stops at the first error.
+func (arch *Archive) ExportTransactions(ctx context.Context, opts TransactionExportOptions) iter.Seq2[ExportedTransaction, error] {
+	return func(yield func(ExportedTransaction, error) bool) {
+		if opts.Concurrency <= 0 {
+			yield(ExportedTransaction{}, errors.New("Zero concurrency"))
+			return
+		}
+		has, err := arch.GetRootHAS()
+		if err != nil {
+			yield(ExportedTransaction{}, errors.Wrap(err, "error getting root HAS"))
+			return
+		}
+		if opts.Range.High == 0 || opts.Range.High > has.CurrentLedger {
+			opts.Range.High = has.CurrentLedger
+		}
+		for _, passphrase := range []string{arch.networkPassphrase, has.NetworkPassphrase} {
+			if opts.NetworkPassphrase == "" {
+				opts.NetworkPassphrase = passphrase
+			}
+		}
+		if opts.NetworkPassphrase == "" {
+			yield(ExportedTransaction{}, errors.New("network passphrase is required to hash transactions"))
+			return
+		}
+		opts.Range.Low = max(opts.Range.Low, 1)
+		if opts.Range.Low > opts.Range.High {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type checkpointResult struct {
+			transactions []ExportedTransaction
+			err          error
+		}
+		// the checkpoints are read in order, at most Concurrency ahead of the
+		// one being yielded
+		pending := make(chan chan checkpointResult, opts.Concurrency)
+		go func() {
+			defer close(pending)
+			manager := arch.checkpointManager
+			first := manager.GetCheckpoint(opts.Range.Low)
+			last := manager.GetCheckpoint(opts.Range.High)
+			for chk := first; chk <= last; chk += manager.GetCheckpointFrequency() {
+				result := make(chan checkpointResult, 1)
+				select {
+				case pending <- result:
+				case <-ctx.Done():
+					return
+				}
+				go func(chk uint32) {
+					transactions, err := arch.exportCheckpointTransactions(chk, opts)
+					result <- checkpointResult{transactions, err}
+				}(chk)
+				if chk == last {
+					// avoid overflowing after the last checkpoint
+					break
+				}
+			}
+		}()
+
+		for result := range pending {
+			var r checkpointResult
+			select {
+			case r = <-result:
+			case <-ctx.Done():
+				yield(ExportedTransaction{}, ctx.Err())
+				return
+			}
+			if r.err != nil {
+				yield(ExportedTransaction{}, r.err)
+				return
+			}
+			for _, tx := range r.transactions {
+				if !yield(tx, nil) {
+					return
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			yield(ExportedTransaction{}, err)
+		}
+	}
+}
+
+// exportCheckpointTransactions returns the transactions of the ledgers of the
+// checkpoint which are in the range.
+func (arch *Archive) exportCheckpointTransactions(chk uint32, opts TransactionExportOptions) ([]ExportedTransaction, error) {
+	checkpointRange := arch.checkpointManager.GetCheckpointRange(chk)
+	low := max(checkpointRange.Low, opts.Range.Low)
+	high := min(checkpointRange.High, opts.Range.High)
+	ledgers, err := arch.GetLedgers(low, high)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting ledgers of checkpoint %d", chk)
+	}
+
+	var transactions []ExportedTransaction
+	for seq := low; seq <= high; seq++ {
+		ledger, ok := ledgers[seq]
+		if !ok {
+			// ledgers without transactions are not in the transactions and
+			// results categories, but they have a header
+			return nil, errors.Errorf("ledger %d is missing in checkpoint %d", seq, chk)
+		}
+		if ledger.Header.Header.LedgerSeq == 0 {
+			return nil, errors.Errorf("header of ledger %d is missing in checkpoint %d", seq, chk)
+		}
+		results := ledger.TransactionResult.TxResultSet.Results
+		if len(results) == 0 {
+			continue
+		}
+
+		// the transaction set is not in application order, the results are,
+		// so the transactions are joined with their results by hash
+		envelopes := map[xdr.Hash]xdr.TransactionEnvelope{}
+		for _, envelope := range transactionHistoryEnvelopes(ledger.Transaction) {
+			hash, err := network.HashTransactionInEnvelope(envelope, opts.NetworkPassphrase)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error hashing transaction of ledger %d", seq)
+			}
+			envelopes[hash] = envelope
+		}
+
+		closeTime := time.Unix(int64(ledger.Header.Header.ScpValue.CloseTime), 0).UTC()
+		for i, result := range results {
+			envelope, ok := envelopes[result.TransactionHash]
+			if !ok {
+				return nil, errors.Errorf("transaction %s of ledger %d is not in its transaction set",
+					Hash(result.TransactionHash), seq)
+			}
+			envelopeXDR, err := xdr.MarshalBase64(envelope)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error encoding transaction %s", Hash(result.TransactionHash))
+			}
+			resultXDR, err := xdr.MarshalBase64(result.Result)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error encoding result of transaction %s", Hash(result.TransactionHash))
+			}
+			transactions = append(transactions, ExportedTransaction{
+				Ledger:     seq,
+				Index:      uint32(i + 1),
+				CloseTime:  closeTime,
+				Hash:       Hash(result.TransactionHash).String(),
+				Envelope:   envelopeXDR,
+				Result:     resultXDR,
+				ResultCode: result.Result.Result.Code.String(),
+				Successful: result.Result.Successful(),
+				FeeCharged: int64(result.Result.FeeCharged),
+			})
+		}
+	}
+	return transactions, nil
+}
+
+// transactionHistoryEnvelopes returns the envelopes of the transaction set of
+// the entry, generalized or not.
+func transactionHistoryEnvelopes(entry xdr.TransactionHistoryEntry) []xdr.TransactionEnvelope {
+	if entry.Ext.V != 1 {
+		return entry.TxSet.Txs
+	}
+	var envelopes []xdr.TransactionEnvelope
+	for _, phase := range entry.Ext.MustGeneralizedTxSet().MustV1TxSet().Phases {
+		switch phase.V {
+		case 0:
+			for _, component := range *phase.V0Components {
+				envelopes = append(envelopes, component.TxsMaybeDiscountedFee.Txs...)
+			}
+		case 1:
+			for _, stage := range phase.ParallelTxsComponent.ExecutionStages {
+				for _, cluster := range stage {
+					envelopes = append(envelopes, cluster...)
+				}
+			}
+		}
+	}
+	return envelopes
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func exportTransactions(t *testing.T, archive *Archive, opts TransactionExportOptions) ([]ExportedTransaction, error) {
+	var transactions []ExportedTransaction
+	for tx, err := range archive.ExportTransactions(context.Background(), opts) {
+		if err != nil {
+			return transactions, err
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions, nil
+}
+
+func TestExportTransactions(t *testing.T) {
+	archive, ledgers := publishTestChain(t, 191)
+
+	_, err := exportTransactions(t, archive, TransactionExportOptions{})
+	assert.EqualError(t, err, "Zero concurrency")
+	_, err = exportTransactions(t, archive, TransactionExportOptions{Concurrency: -1})
+	assert.EqualError(t, err, "Zero concurrency")
+
+	transactions, err := exportTransactions(t, archive, TransactionExportOptions{Concurrency: 2})
+	require.NoError(t, err)
+	// every third ledger has a transaction
+	require.Len(t, transactions, 63)
+	for i, tx := range transactions {
+		seq := uint32(3 * (i + 1))
+		lcm := ledgers[seq]
+		envelope, err := xdr.MarshalBase64(lcm.TransactionEnvelopes()[0])
+		require.NoError(t, err)
+		result, err := xdr.MarshalBase64(lcm.TransactionResultPair(0).Result)
+		require.NoError(t, err)
+		assert.Equal(t, ExportedTransaction{
+			Ledger:     seq,
+			Index:      1,
+			CloseTime:  time.Unix(int64(lcm.LedgerCloseTime()), 0).UTC(),
+			Hash:       Hash(lcm.TransactionHash(0)).String(),
+			Envelope:   envelope,
+			Result:     result,
+			ResultCode: "TransactionResultCodeTxSuccess",
+			Successful: true,
+		}, tx)
+	}
+
+	transactions, err = exportTransactions(t, archive, TransactionExportOptions{
+		Range:       Range{Low: 60, High: 70},
+		Concurrency: 3,
+	})
+	require.NoError(t, err)
+	var seqs []uint32
+	for _, tx := range transactions {
+		seqs = append(seqs, tx.Ledger)
+	}
+	assert.Equal(t, []uint32{60, 63, 66, 69}, seqs)
+
+	// iteration can stop early
+	count := 0
+	for range archive.ExportTransactions(context.Background(), TransactionExportOptions{Concurrency: 2}) {
+		count++
+		break
+	}
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, archive.backend.(*MockArchiveBackend).DeleteFile(CategoryCheckpointPath("results", 127)))
+	transactions, err = exportTransactions(t, archive, TransactionExportOptions{Concurrency: 2})
+	assert.EqualError(t, err, "error getting ledgers of checkpoint 127: checkpoint 127 is not published")
+	assert.Len(t, transactions, 21)
+}
+
+func TestExportTransactionsApplicationOrder(t *testing.T) {
+	archive, ledgers := publishTestChain(t, 63)
+
+	// ledger 3 has two transactions, the transaction set is not in the
+	// application order of the results
+	first := ledgers[3].TransactionEnvelopes()[0]
+	second := first
+	second.V1 = &xdr.TransactionV1Envelope{Tx: first.V1.Tx}
+	second.V1.Tx.SeqNum++
+	var results []xdr.TransactionResultPair
+	for i, envelope := range []xdr.TransactionEnvelope{first, second} {
+		hash, err := network.HashTransactionInEnvelope(envelope, network.TestNetworkPassphrase)
+		require.NoError(t, err)
+		pair := ledgers[3].TransactionResultPair(0)
+		pair.TransactionHash = hash
+		pair.Result.FeeCharged = xdr.Int64(100 * (i + 1))
+		results = append(results, pair)
+	}
+	var transactions, txResults []xdrEntry
+	for seq := uint32(3); seq <= 63; seq += 3 {
+		entry := xdr.TransactionHistoryEntry{LedgerSeq: xdr.Uint32(seq), TxSet: ledgers[seq].MustV0().TxSet}
+		resultEntry := xdr.TransactionHistoryResultEntry{LedgerSeq: xdr.Uint32(seq), TxResultSet: xdr.TransactionResultSet{
+			Results: []xdr.TransactionResultPair{ledgers[seq].TransactionResultPair(0)},
+		}}
+		if seq == 3 {
+			entry.TxSet.Txs = []xdr.TransactionEnvelope{second, first}
+			resultEntry.TxResultSet.Results = results
+		}
+		transactions = append(transactions, entry)
+		txResults = append(txResults, resultEntry)
+	}
+	writeCategoryFile(t, archive.backend, CategoryCheckpointPath("transactions", 63), transactions)
+	writeCategoryFile(t, archive.backend, CategoryCheckpointPath("results", 63), txResults)
+
+	exported, err := exportTransactions(t, archive, TransactionExportOptions{Range: Range{Low: 3, High: 3}, Concurrency: 1})
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+	for i, tx := range exported {
+		assert.Equal(t, uint32(i+1), tx.Index)
+		assert.Equal(t, Hash(results[i].TransactionHash).String(), tx.Hash)
+		assert.Equal(t, int64(100*(i+1)), tx.FeeCharged)
+	}
+	envelope, err := xdr.MarshalBase64(first)
+	require.NoError(t, err)
+	assert.Equal(t, envelope, exported[0].Envelope)
+
+	_, err = exportTransactions(t, archive, TransactionExportOptions{
+		Range:             Range{Low: 3, High: 3},
+		Concurrency:       1,
+		NetworkPassphrase: network.PublicNetworkPassphrase,
+	})
+	assert.ErrorContains(t, err, "of ledger 3 is not in its transaction set")
+}
@@ -0,0 +1,240 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/support/storage"
+)
+
+// BucketGCOptions configures Archive.CollectBuckets.
+type BucketGCOptions struct {
+	// RetainCheckpoints is the number of most recent checkpoints whose HAS
+	// buckets are retained. If zero, the buckets of every checkpoint are
+	// retained and only the buckets no HAS refers to are collected.
+	RetainCheckpoints uint32
+	// DryRun only reports the unreferenced buckets, without deleting them.
+	DryRun bool
+	// Concurrency is the number of HASes read, and buckets sized or deleted,
+	// concurrently.
+	Concurrency int
+}
+
+// UnreferencedBucket is a bucket which no HAS of the retention window refers
+// to.
+type UnreferencedBucket struct {
+	Hash Hash  `json:"hash"`
+	Size int64 `json:"size"`
+}
+
+// BucketGCReport is the outcome of Archive.CollectBuckets.
+type BucketGCReport struct {
+	// RetainedFrom and RetainedTo are the first and last checkpoints whose
+	// buckets are retained.
+	RetainedFrom uint32 `json:"retainedFrom"`
+	RetainedTo   uint32 `json:"retainedTo"`
+	// Buckets is the number of buckets in the archive, Referenced the number
+	// of buckets referenced by the retained checkpoints.
+	Buckets    int `json:"buckets"`
+	Referenced int `json:"referenced"`
+	// Unreferenced are the buckets which can be collected, sorted by hash.
+	Unreferenced     []UnreferencedBucket `json:"unreferenced"`
+	UnreferencedSize int64                `json:"unreferencedSize"`
+	// Deleted is the number of deleted buckets, always 0 in dry runs.
+	Deleted int `json:"deleted"`
+}
+
+// CollectBuckets deletes the buckets which are not referenced by the HAS of
+// any checkpoint in the retention window, which ends at the latest checkpoint
+// of the archive. The HASes of older checkpoints are kept, but will refer to
+// missing buckets.
+//
+// The buckets are listed before the HASes are read, so a bucket uploaded
+// during the collection is never deleted, and the root HAS is read again
+// before deleting anything so that the buckets of checkpoints published in
+// the meantime are retained too.
+func (arch *Archive) CollectBuckets(opts BucketGCOptions) (BucketGCReport, error) {
+	var report BucketGCReport
+	if opts.Concurrency <= 0 {
+		return report, errors.New("Zero concurrency")
+	}
+	if !arch.backend.CanListFiles() {
+		return report, errors.New("archive backend cannot list files")
+	}
+	deleter, canDelete := arch.backend.(storage.Deleter)
+	if !opts.DryRun && !canDelete {
+		return report, errors.New("archive backend cannot delete files")
+	}
+
+	root, err := arch.GetRootHAS()
+	if err != nil {
+		return report, errors.Wrap(err, "error getting root HAS")
+	}
+	report.RetainedTo = root.CurrentLedger
+	report.RetainedFrom = arch.checkpointManager.GetCheckpoint(0)
+	freq := arch.checkpointManager.GetCheckpointFrequency()
+	if opts.RetainCheckpoints > 0 {
+		window := uint64(opts.RetainCheckpoints-1) * uint64(freq)
+		if uint64(report.RetainedTo) > uint64(report.RetainedFrom)+window {
+			report.RetainedFrom = report.RetainedTo - uint32(window)
+		}
+	}
+
+	all := map[Hash]bool{}
+	buckets, errs := arch.ListAllBucketHashes()
+	for bucket := range buckets {
+		all[bucket] = true
+	}
+	if n := drainErrors(errs); n != 0 {
+		return report, errors.Errorf("%d errors while listing buckets", n)
+	}
+	report.Buckets = len(all)
+	log.Printf("Found %d buckets, reading the HASes of checkpoints %d to %d",
+		len(all), report.RetainedFrom, report.RetainedTo)
+
+	referenced := map[Hash]bool{}
+	err = arch.noteCheckpointBuckets(referenced, report.RetainedFrom, report.RetainedTo, opts.Concurrency)
+	if err != nil {
+		return report, err
+	}
+
+	// retain the buckets of the checkpoints published since the beginning
+	root, err = arch.GetRootHAS()
+	if err != nil {
+		return report, errors.Wrap(err, "error getting root HAS")
+	}
+	if root.CurrentLedger > report.RetainedTo {
+		err = arch.noteCheckpointBuckets(referenced, report.RetainedTo+freq, root.CurrentLedger, opts.Concurrency)
+		if err != nil {
+			return report, err
+		}
+		report.RetainedTo = root.CurrentLedger
+	}
+	rootBuckets, err := root.Buckets()
+	if err != nil {
+		return report, errors.Wrap(err, "error getting buckets of root HAS")
+	}
+	for _, bucket := range rootBuckets {
+		referenced[bucket] = true
+	}
+	report.Referenced = len(referenced)
+
+	for bucket := range all {
+		if !referenced[bucket] {
+			report.Unreferenced = append(report.Unreferenced, UnreferencedBucket{Hash: bucket})
+		}
+	}
+	sort.Slice(report.Unreferenced, func(i, j int) bool {
+		return bytes.Compare(report.Unreferenced[i].Hash[:], report.Unreferenced[j].Hash[:]) < 0
+	})
+
+	err = runConcurrently(len(report.Unreferenced), opts.Concurrency, func(i int) error {
+		bucket := &report.Unreferenced[i]
+		size, err := arch.BucketSize(bucket.Hash)
+		if err != nil {
+			return errors.Wrapf(err, "error getting size of bucket %s", bucket.Hash)
+		}
+		bucket.Size = size
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, bucket := range report.Unreferenced {
+		report.UnreferencedSize += bucket.Size
+	}
+	log.Printf("Found %d unreferenced buckets (%d bytes)", len(report.Unreferenced), report.UnreferencedSize)
+	if opts.DryRun {
+		return report, nil
+	}
+
+	var mutex sync.Mutex
+	err = runConcurrently(len(report.Unreferenced), opts.Concurrency, func(i int) error {
+		bucket := report.Unreferenced[i].Hash
+		arch.stats.incrementRequests()
+		if err := deleter.DeleteFile(BucketPath(bucket)); err != nil {
+			return errors.Wrapf(err, "error deleting bucket %s", bucket)
+		}
+		log.WithField("bucket", bucket.String()).Debug("deleted unreferenced bucket")
+		mutex.Lock()
+		report.Deleted++
+		mutex.Unlock()
+		return nil
+	})
+	log.Printf("Deleted %d unreferenced buckets", report.Deleted)
+	return report, err
+}
+
+// noteCheckpointBuckets adds the buckets of the HASes of the checkpoints from
+// low to high to referenced.
+func (arch *Archive) noteCheckpointBuckets(referenced map[Hash]bool, low, high uint32, concurrency int) error {
+	var checkpoints []uint32
+	for chk := range arch.checkpointManager.MakeRange(low, high).GenerateCheckpoints(arch.checkpointManager) {
+		checkpoints = append(checkpoints, chk)
+	}
+
+	var mutex sync.Mutex
+	return runConcurrently(len(checkpoints), concurrency, func(i int) error {
+		has, err := arch.GetCheckpointHAS(checkpoints[i])
+		if err != nil {
+			// the buckets of a missing HAS are unknown, they could be deleted
+			return errors.Wrapf(err, "error getting HAS of checkpoint %d", checkpoints[i])
+		}
+		buckets, err := has.Buckets()
+		if err != nil {
+			return errors.Wrapf(err, "error getting buckets of checkpoint %d", checkpoints[i])
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, bucket := range buckets {
+			referenced[bucket] = true
+		}
+		return nil
+	})
+}
+
+// runConcurrently calls fn with every index from 0 to n-1 in concurrent
+// goroutines, returning the first error.
+func runConcurrently(n, concurrency int, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+	)
+	indexes := make(chan int)
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(i); err != nil {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		mutex.Lock()
+		failed := firstErr != nil
+		mutex.Unlock()
+		if failed {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gcTestArchive returns an archive with checkpoints 7, 15 and 23 whose HASes
+// refer to the given buckets, and the buckets of all the checkpoints plus an
+// unreferenced bucket.
+func gcTestArchive(t *testing.T, checkpointBuckets map[uint32][]Hash) *Archive {
+	archive := testPublisherArchive(t)
+	put := func(bucket Hash, contents string) {
+		require.NoError(t, archive.backend.PutFile(BucketPath(bucket), io.NopCloser(strings.NewReader(contents))))
+	}
+	put(Hash{0xff}, "unreferenced")
+
+	zero := Hash{}.String()
+	for checkpoint := uint32(7); checkpoint <= 23; checkpoint += 8 {
+		has := HistoryArchiveState{Version: 1, CurrentLedger: checkpoint}
+		for i := range has.CurrentBuckets {
+			has.CurrentBuckets[i].Curr = zero
+			has.CurrentBuckets[i].Snap = zero
+		}
+		for i, bucket := range checkpointBuckets[checkpoint] {
+			has.CurrentBuckets[i].Curr = bucket.String()
+			put(bucket, bucket.String())
+		}
+		require.NoError(t, archive.PutCheckpointHAS(checkpoint, has, &CommandOptions{}))
+		require.NoError(t, archive.PutRootHAS(has, &CommandOptions{Force: true}))
+	}
+	return archive
+}
+
+func bucketExists(t *testing.T, archive *Archive, bucket Hash) bool {
+	ok, err := archive.BucketExists(bucket)
+	require.NoError(t, err)
+	return ok
+}
+
+func TestCollectBuckets(t *testing.T) {
+	a, b, c, d := Hash{0xa}, Hash{0xb}, Hash{0xc}, Hash{0xd}
+	checkpointBuckets := map[uint32][]Hash{
+		7:  {a, b},
+		15: {b, c},
+		23: {c, d},
+	}
+
+	archive := gcTestArchive(t, checkpointBuckets)
+	_, err := archive.CollectBuckets(BucketGCOptions{})
+	assert.EqualError(t, err, "Zero concurrency")
+	_, err = archive.CollectBuckets(BucketGCOptions{Concurrency: -1})
+	assert.EqualError(t, err, "Zero concurrency")
+
+	report, err := archive.CollectBuckets(BucketGCOptions{RetainCheckpoints: 2, DryRun: true, Concurrency: 2})
+	require.NoError(t, err)
+	assert.Equal(t, BucketGCReport{
+		RetainedFrom: 15,
+		RetainedTo:   23,
+		Buckets:      5,
+		Referenced:   3,
+		Unreferenced: []UnreferencedBucket{
+			{Hash: a, Size: int64(len(a.String()))},
+			{Hash: Hash{0xff}, Size: int64(len("unreferenced"))},
+		},
+		UnreferencedSize: int64(len(a.String()) + len("unreferenced")),
+	}, report)
+	assert.True(t, bucketExists(t, archive, a))
+
+	report, err = archive.CollectBuckets(BucketGCOptions{RetainCheckpoints: 2, Concurrency: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Deleted)
+	assert.False(t, bucketExists(t, archive, a))
+	assert.False(t, bucketExists(t, archive, Hash{0xff}))
+	for _, bucket := range []Hash{b, c, d} {
+		assert.True(t, bucketExists(t, archive, bucket))
+	}
+
+	// by default the buckets of every checkpoint are retained
+	archive = gcTestArchive(t, checkpointBuckets)
+	report, err = archive.CollectBuckets(BucketGCOptions{Concurrency: 3})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), report.RetainedFrom)
+	assert.Equal(t, []UnreferencedBucket{{Hash: Hash{0xff}, Size: int64(len("unreferenced"))}}, report.Unreferenced)
+	assert.Equal(t, 1, report.Deleted)
+	assert.True(t, bucketExists(t, archive, a))
+}
+
+func TestCollectBucketsMissingHAS(t *testing.T) {
+	archive := gcTestArchive(t, map[uint32][]Hash{7: {{0xa}}, 15: {{0xb}}, 23: {{0xc}}})
+	require.NoError(t, archive.backend.(*MockArchiveBackend).DeleteFile(CategoryCheckpointPath("history", 15)))
+
+	_, err := archive.CollectBuckets(BucketGCOptions{Concurrency: 1})
+	assert.ErrorContains(t, err, "error getting HAS of checkpoint 15")
+	assert.True(t, bucketExists(t, archive, Hash{0xff}))
+}
@@ -0,0 +1,380 @@
+package historyarchive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/support/datastore"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+const (
+	ledgerTimeIndexMagic = "LTI1"
+	// maxResolvedCheckpoints bounds the close times of the ledgers of the
+	// checkpoints kept in memory by a LedgerTimeIndex.
+	maxResolvedCheckpoints = 256
+)
+
+// LedgerTimeIndex maps every checkpoint of an archive to the close time of
+// its checkpoint ledger. It is built incrementally with Update, can be stored
+// locally or in a DataStore, and narrows the lookups of LedgerForTime and
+// LedgerRangeForTimespan to a single checkpoint without any request.
+//
+// The close times of the other ledgers are read from the ledger headers of
+// the checkpoint, once per checkpoint: lookups in recently resolved
+// checkpoints are answered from memory.
+type LedgerTimeIndex struct {
+	mutex               sync.RWMutex
+	checkpointFrequency uint32
+	// closeTimes[i] is the close time, in unix seconds, of the checkpoint
+	// ledger (i+1)*checkpointFrequency-1.
+	closeTimes []int64
+
+	resolvedMutex sync.Mutex
+	resolved      map[uint32][]int64
+}
+
+// NewLedgerTimeIndex returns an empty index of an archive with the given
+// checkpoint frequency, DefaultCheckpointFrequency if zero.
+func NewLedgerTimeIndex(checkpointFrequency uint32) *LedgerTimeIndex {
+	if checkpointFrequency == 0 {
+		checkpointFrequency = DefaultCheckpointFrequency
+	}
+	return &LedgerTimeIndex{
+		checkpointFrequency: checkpointFrequency,
+		resolved:            map[uint32][]int64{},
+	}
+}
+
+// LatestCheckpoint returns the latest indexed checkpoint, false if the index
+// is empty.
+func (idx *LedgerTimeIndex) LatestCheckpoint() (uint32, bool) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	if len(idx.closeTimes) == 0 {
+		return 0, false
+	}
+	return idx.checkpoint(len(idx.closeTimes) - 1), true
+}
+
+func (idx *LedgerTimeIndex) checkpoint(i int) uint32 {
+	return uint32(i+1)*idx.checkpointFrequency - 1
+}
+
+// Update adds the checkpoints published since the latest indexed checkpoint,
+// reading the header of `concurrency` checkpoint ledgers concurrently. It
+// returns the number of checkpoints added, which are kept even if an error
+// occurs.
+func (idx *LedgerTimeIndex) Update(ctx context.Context, archive ArchiveInterface, concurrency int) (int, error) {
+	if concurrency <= 0 {
+		return 0, fmt.Errorf("invalid concurrency %d", concurrency)
+	}
+	if freq := archive.GetCheckpointManager().GetCheckpointFrequency(); freq != idx.checkpointFrequency {
+		return 0, fmt.Errorf("archive checkpoint frequency %d does not match index checkpoint frequency %d",
+			freq, idx.checkpointFrequency)
+	}
+	root, err := archive.GetRootHAS()
+	if err != nil {
+		return 0, fmt.Errorf("getting root HAS: %w", err)
+	}
+
+	idx.mutex.RLock()
+	next := len(idx.closeTimes)
+	idx.mutex.RUnlock()
+
+	added := 0
+	for idx.checkpoint(next) <= root.CurrentLedger {
+		batch := make([]int64, 0, concurrency)
+		for i := next; i < next+concurrency && idx.checkpoint(i) <= root.CurrentLedger; i++ {
+			batch = append(batch, 0)
+		}
+
+		var (
+			wg       sync.WaitGroup
+			errMutex sync.Mutex
+			firstErr error
+		)
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				checkpoint := idx.checkpoint(next + i)
+				header, err := archive.GetLedgerHeader(checkpoint)
+				if err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("getting header for checkpoint ledger %d: %w", checkpoint, err)
+					}
+					errMutex.Unlock()
+					return
+				}
+				batch[i] = int64(header.Header.ScpValue.CloseTime)
+			}(i)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return added, firstErr
+		}
+
+		idx.mutex.Lock()
+		// the index can't be updated concurrently
+		if len(idx.closeTimes) != next {
+			idx.mutex.Unlock()
+			return added, fmt.Errorf("index was updated concurrently")
+		}
+		idx.closeTimes = append(idx.closeTimes, batch...)
+		idx.mutex.Unlock()
+		next += len(batch)
+		added += len(batch)
+
+		if err := ctx.Err(); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+// CheckpointForTime returns the first indexed checkpoint whose close time is
+// at or after the target, false if the target is after the latest indexed
+// checkpoint.
+func (idx *LedgerTimeIndex) CheckpointForTime(target time.Time) (uint32, bool) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	i, ok := idx.search(target)
+	if !ok {
+		return 0, false
+	}
+	return idx.checkpoint(i), true
+}
+
+func (idx *LedgerTimeIndex) search(target time.Time) (int, bool) {
+	seconds := target.Unix()
+	i := sort.Search(len(idx.closeTimes), func(i int) bool {
+		return idx.closeTimes[i] >= seconds
+	})
+	return i, i < len(idx.closeTimes)
+}
+
+// LedgerForTime returns the smallest ledger sequence L such that
+// closeTime(L) >= target, like the LedgerForTime function. If target is
+// before the genesis ledger, it returns the genesis ledger. If after the
+// latest indexed checkpoint, it returns the latest indexed checkpoint.
+//
+// Only the ledger headers of the checkpoint containing L are read from the
+// archive, unless they were read by a previous lookup.
+func (idx *LedgerTimeIndex) LedgerForTime(archive ArchiveInterface, target time.Time) (uint32, error) {
+	idx.mutex.RLock()
+	if len(idx.closeTimes) == 0 {
+		idx.mutex.RUnlock()
+		return 0, fmt.Errorf("ledger time index is empty")
+	}
+	i, ok := idx.search(target)
+	latest := idx.checkpoint(len(idx.closeTimes) - 1)
+	idx.mutex.RUnlock()
+	if !ok {
+		return latest, nil
+	}
+
+	checkpoint := idx.checkpoint(i)
+	closeTimes, err := idx.resolve(archive, checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	first := checkpoint + 1 - uint32(len(closeTimes))
+	seconds := target.Unix()
+	j := sort.Search(len(closeTimes), func(j int) bool {
+		return closeTimes[j] >= seconds
+	})
+	if j == len(closeTimes) {
+		// the index and the ledger headers of the checkpoint disagree
+		return 0, fmt.Errorf("no ledger found for time %v in checkpoint %d", target.UTC(), checkpoint)
+	}
+	return first + uint32(j), nil
+}
+
+// LedgerRangeForTimespan returns [startLedger, endLedger] such that:
+//
+//   - startLedger is the first ledger whose closeTime >= startTime
+//   - endLedger   is the first ledger whose closeTime >= endTime
+//
+// like the LedgerRangeForTimespan function. startTime must be <= endTime.
+func (idx *LedgerTimeIndex) LedgerRangeForTimespan(archive ArchiveInterface, startTime, endTime time.Time) (uint32, uint32, error) {
+	if startTime.After(endTime) {
+		return 0, 0, fmt.Errorf("startTime must be <= endTime")
+	}
+
+	startSeq, err := idx.LedgerForTime(archive, startTime)
+	if err != nil {
+		return 0, 0, fmt.Errorf("finding start ledger: %w", err)
+	}
+
+	endSeq, err := idx.LedgerForTime(archive, endTime)
+	if err != nil {
+		return 0, 0, fmt.Errorf("finding end ledger: %w", err)
+	}
+
+	return startSeq, endSeq, nil
+}
+
+// resolve returns the close times of the ledgers of the checkpoint, from the
+// genesis ledger for the first checkpoint.
+func (idx *LedgerTimeIndex) resolve(archive ArchiveInterface, checkpoint uint32) ([]int64, error) {
+	idx.resolvedMutex.Lock()
+	closeTimes, ok := idx.resolved[checkpoint]
+	idx.resolvedMutex.Unlock()
+	if ok {
+		return closeTimes, nil
+	}
+
+	first := checkpoint + 1 - idx.checkpointFrequency
+	if first < genesisLedgerSeq {
+		first = genesisLedgerSeq
+	}
+	closeTimes = make([]int64, checkpoint-first+1)
+	found := 0
+
+	stream, err := archive.GetXdrStream(CategoryCheckpointPath("ledger", checkpoint))
+	if err != nil {
+		return nil, fmt.Errorf("opening ledger headers of checkpoint %d: %w", checkpoint, err)
+	}
+	defer stream.Close()
+	for {
+		var header xdr.LedgerHeaderHistoryEntry
+		if err := stream.ReadOne(&header); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading ledger headers of checkpoint %d: %w", checkpoint, err)
+		}
+		seq := uint32(header.Header.LedgerSeq)
+		if seq < first || seq > checkpoint {
+			continue
+		}
+		closeTimes[seq-first] = int64(header.Header.ScpValue.CloseTime)
+		found++
+	}
+	if found != len(closeTimes) {
+		return nil, fmt.Errorf("checkpoint %d has %d ledger headers, expected %d", checkpoint, found, len(closeTimes))
+	}
+
+	idx.resolvedMutex.Lock()
+	defer idx.resolvedMutex.Unlock()
+	if len(idx.resolved) >= maxResolvedCheckpoints {
+		idx.resolved = map[uint32][]int64{}
+	}
+	idx.resolved[checkpoint] = closeTimes
+	return closeTimes, nil
+}
+
+// WriteTo writes the index, gzipped, to w. The index implements io.WriterTo
+// so that it can be stored with DataStore.PutFile.
+func (idx *LedgerTimeIndex) WriteTo(w io.Writer) (int64, error) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	header := []uint32{idx.checkpointFrequency, uint32(len(idx.closeTimes))}
+	if _, err := zw.Write([]byte(ledgerTimeIndexMagic)); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(zw, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(zw, binary.BigEndian, idx.closeTimes); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(w)
+}
+
+// ReadLedgerTimeIndex reads an index written by LedgerTimeIndex.WriteTo.
+func ReadLedgerTimeIndex(r io.Reader) (*LedgerTimeIndex, error) {
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("reading ledger time index: %w", err)
+	}
+	defer zr.Close()
+
+	magic := make([]byte, len(ledgerTimeIndexMagic))
+	if _, err := io.ReadFull(zr, magic); err != nil {
+		return nil, fmt.Errorf("reading ledger time index: %w", err)
+	}
+	if string(magic) != ledgerTimeIndexMagic {
+		return nil, fmt.Errorf("not a ledger time index")
+	}
+	header := make([]uint32, 2)
+	if err := binary.Read(zr, binary.BigEndian, header); err != nil {
+		return nil, fmt.Errorf("reading ledger time index: %w", err)
+	}
+	if header[0] == 0 {
+		return nil, fmt.Errorf("invalid ledger time index checkpoint frequency 0")
+	}
+
+	idx := NewLedgerTimeIndex(header[0])
+	// the entries are read in chunks so that a corrupted count doesn't
+	// allocate a huge slice
+	for remaining := int(header[1]); remaining > 0; {
+		chunk := make([]int64, min(remaining, 1<<16))
+		if err := binary.Read(zr, binary.BigEndian, chunk); err != nil {
+			return nil, fmt.Errorf("reading ledger time index: %w", err)
+		}
+		idx.closeTimes = append(idx.closeTimes, chunk...)
+		remaining -= len(chunk)
+	}
+	return idx, nil
+}
+
+// Save writes the index to a local file, atomically.
+func (idx *LedgerTimeIndex) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := idx.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadLedgerTimeIndex reads an index from a local file written by Save.
+func LoadLedgerTimeIndex(path string) (*LedgerTimeIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadLedgerTimeIndex(file)
+}
+
+// SaveToDataStore writes the index to a file of the DataStore.
+func (idx *LedgerTimeIndex) SaveToDataStore(ctx context.Context, store datastore.DataStore, path string) error {
+	return store.PutFile(ctx, path, idx, nil)
+}
+
+// LoadLedgerTimeIndexFromDataStore reads an index from a file of the
+// DataStore written by SaveToDataStore.
+func LoadLedgerTimeIndexFromDataStore(ctx context.Context, store datastore.DataStore, path string) (*LedgerTimeIndex, error) {
+	reader, err := store.GetFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ReadLedgerTimeIndex(reader)
+}
@@ -0,0 +1,125 @@
+package historyarchive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/support/datastore"
+)
+
+// in the test chain, ledger n closes at 5n
+func testLedgerTime(seq uint32) time.Time {
+	return time.Unix(int64(5*seq), 0).UTC()
+}
+
+func TestLedgerTimeIndex(t *testing.T) {
+	archive, _ := publishTestChain(t, 191)
+	idx := NewLedgerTimeIndex(64)
+
+	_, ok := idx.LatestCheckpoint()
+	assert.False(t, ok)
+	_, err := idx.LedgerForTime(archive, testLedgerTime(100))
+	assert.EqualError(t, err, "ledger time index is empty")
+
+	added, err := idx.Update(context.Background(), archive, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+	latest, ok := idx.LatestCheckpoint()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(191), latest)
+
+	checkpoint, ok := idx.CheckpointForTime(testLedgerTime(100))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(127), checkpoint)
+	_, ok = idx.CheckpointForTime(testLedgerTime(200))
+	assert.False(t, ok)
+
+	for _, tc := range []struct {
+		target   time.Time
+		expected uint32
+	}{
+		{time.Unix(0, 0), 2},
+		{testLedgerTime(1), 2},
+		{testLedgerTime(63), 63},
+		{testLedgerTime(64), 64},
+		{testLedgerTime(100).Add(-2 * time.Second), 100},
+		{testLedgerTime(100), 100},
+		{testLedgerTime(191), 191},
+		{testLedgerTime(1000), 191},
+	} {
+		seq, err := idx.LedgerForTime(archive, tc.target)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, seq, tc.target)
+
+		expected, err := LedgerForTime(archive, tc.target)
+		require.NoError(t, err)
+		assert.Equal(t, expected, seq, tc.target)
+	}
+
+	// the ledger headers of a checkpoint are only read once
+	requests := archive.GetStats()[0].GetRequests()
+	start, end, err := idx.LedgerRangeForTimespan(archive, testLedgerTime(70), testLedgerTime(80))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(70), start)
+	assert.Equal(t, uint32(80), end)
+	assert.Equal(t, requests, archive.GetStats()[0].GetRequests())
+
+	_, _, err = idx.LedgerRangeForTimespan(archive, testLedgerTime(80), testLedgerTime(70))
+	assert.EqualError(t, err, "startTime must be <= endTime")
+
+	added, err = idx.Update(context.Background(), archive, 2)
+	require.NoError(t, err)
+	assert.Zero(t, added)
+
+	_, err = NewLedgerTimeIndex(8).Update(context.Background(), archive, 1)
+	assert.EqualError(t, err, "archive checkpoint frequency 64 does not match index checkpoint frequency 8")
+}
+
+func TestLedgerTimeIndexPersistence(t *testing.T) {
+	archive, _ := publishTestChain(t, 127)
+	idx := NewLedgerTimeIndex(64)
+	_, err := idx.Update(context.Background(), archive, 4)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "index")
+	require.NoError(t, idx.Save(path))
+	loaded, err := LoadLedgerTimeIndex(path)
+	require.NoError(t, err)
+	assert.Equal(t, idx.closeTimes, loaded.closeTimes)
+	assert.Equal(t, idx.checkpointFrequency, loaded.checkpointFrequency)
+
+	// the loaded index is updated with the new checkpoints
+	archive, _ = publishTestChain(t, 191)
+	added, err := loaded.Update(context.Background(), archive, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	seq, err := loaded.LedgerForTime(archive, testLedgerTime(150))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(150), seq)
+
+	var stored bytes.Buffer
+	store := &datastore.MockDataStore{}
+	store.On("PutFile", mock.Anything, "ledger-time-index", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			_, err := args.Get(2).(io.WriterTo).WriteTo(&stored)
+			require.NoError(t, err)
+		}).Return(nil)
+	require.NoError(t, loaded.SaveToDataStore(context.Background(), store, "ledger-time-index"))
+	store.On("GetFile", mock.Anything, "ledger-time-index").
+		Return(io.NopCloser(bytes.NewReader(stored.Bytes())), nil)
+	fromStore, err := LoadLedgerTimeIndexFromDataStore(context.Background(), store, "ledger-time-index")
+	require.NoError(t, err)
+	assert.Equal(t, loaded.closeTimes, fromStore.closeTimes)
+	store.AssertExpectations(t)
+
+	_, err = ReadLedgerTimeIndex(bytes.NewReader(stored.Bytes()[:len(stored.Bytes())/2]))
+	assert.Error(t, err)
+}
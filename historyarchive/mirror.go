@@ -5,16 +5,217 @@
 package historyarchive
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"slices"
+	"sort"
 	"sync"
 	"sync/atomic"
 
+	"golang.org/x/time/rate"
+
 	"github.com/stellar/go-stellar-sdk/support/errors"
 )
 
+// MirrorOptions configures MirrorWithOptions.
+type MirrorOptions struct {
+	// Categories are the checkpoint categories to copy, all of them if
+	// empty. Optional categories are still skipped with SkipOptional.
+	Categories []string
+	// SkipBuckets skips the buckets referenced by the checkpoints.
+	SkipBuckets bool
+	// MaxBytesPerSecond caps the bandwidth of the copied files, unlimited if
+	// zero.
+	MaxBytesPerSecond int64
+	// MaxRequestsPerSecond caps the requests to both archives, unlimited if
+	// zero.
+	MaxRequestsPerSecond float64
+	// StatePath is a file the completed checkpoints are saved to, the
+	// checkpoints completed by a previous mirror are skipped. A state saved
+	// by a mirror with other Categories, SkipBuckets or SkipOptional is
+	// rejected.
+	StatePath string
+}
+
+// mirrorState is the progress of a mirror saved to MirrorOptions.StatePath.
+type mirrorState struct {
+	// Categories, SkipBuckets and SkipOptional select the files which were
+	// copied for each completed checkpoint, the state is only resumed by
+	// mirrors copying the same files.
+	Categories   []string `json:"categories"`
+	SkipBuckets  bool     `json:"skip_buckets"`
+	SkipOptional bool     `json:"skip_optional"`
+	// Completed are the sorted, disjoint ranges of checkpoints whose files
+	// were all copied.
+	Completed []Range `json:"completed"`
+}
+
+// sameFiles returns true if both states select the same files.
+func (s *mirrorState) sameFiles(other mirrorState) bool {
+	return slices.Equal(s.Categories, other.Categories) &&
+		s.SkipBuckets == other.SkipBuckets &&
+		s.SkipOptional == other.SkipOptional
+}
+
+func (s *mirrorState) contains(chk uint32) bool {
+	i := sort.Search(len(s.Completed), func(i int) bool {
+		return s.Completed[i].High >= chk
+	})
+	return i < len(s.Completed) && s.Completed[i].Low <= chk
+}
+
+// add adds the checkpoint to the completed ranges, merging the ranges of
+// consecutive checkpoints.
+func (s *mirrorState) add(chk uint32, manager CheckpointManager) {
+	consecutive := func(a, b uint32) bool {
+		return uint64(a)+uint64(manager.GetCheckpointFrequency()) == uint64(b)
+	}
+	if s.contains(chk) {
+		return
+	}
+	i := sort.Search(len(s.Completed), func(i int) bool {
+		return s.Completed[i].Low > chk
+	})
+	s.Completed = append(s.Completed, Range{})
+	copy(s.Completed[i+1:], s.Completed[i:])
+	s.Completed[i] = Range{Low: chk, High: chk}
+	if i+1 < len(s.Completed) && consecutive(chk, s.Completed[i+1].Low) {
+		s.Completed[i].High = s.Completed[i+1].High
+		s.Completed = append(s.Completed[:i+1], s.Completed[i+2:]...)
+	}
+	if i > 0 && consecutive(s.Completed[i-1].High, chk) {
+		s.Completed[i-1].High = s.Completed[i].High
+		s.Completed = append(s.Completed[:i], s.Completed[i+1:]...)
+	}
+}
+
+func loadMirrorState(pth string) (mirrorState, error) {
+	var state mirrorState
+	buf, err := os.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, errors.Wrapf(err, "error reading state %s", pth)
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return state, errors.Wrapf(err, "error decoding state %s", pth)
+	}
+	return state, nil
+}
+
+// saveMirrorState atomically replaces the state file.
+func saveMirrorState(pth string, state mirrorState) error {
+	buf, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding state")
+	}
+	tmp := pth + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return errors.Wrapf(err, "error writing state %s", tmp)
+	}
+	if err := os.Rename(tmp, pth); err != nil {
+		return errors.Wrapf(err, "error writing state %s", pth)
+	}
+	return nil
+}
+
+// mirrorThrottle limits the requests and bandwidth of a mirror, its limiters
+// are nil when unlimited.
+type mirrorThrottle struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+func newMirrorThrottle(opts MirrorOptions) *mirrorThrottle {
+	t := &mirrorThrottle{}
+	if opts.MaxRequestsPerSecond > 0 {
+		burst := max(int(opts.MaxRequestsPerSecond), 1)
+		t.requests = rate.NewLimiter(rate.Limit(opts.MaxRequestsPerSecond), burst)
+	}
+	if opts.MaxBytesPerSecond > 0 {
+		burst := int(min(opts.MaxBytesPerSecond, 1<<20))
+		t.bytes = rate.NewLimiter(rate.Limit(opts.MaxBytesPerSecond), burst)
+	}
+	return t
+}
+
+func (t *mirrorThrottle) request() {
+	if t.requests != nil {
+		// Wait only fails when the context is done
+		t.requests.Wait(context.Background())
+	}
+}
+
+func (t *mirrorThrottle) reader(in io.ReadCloser) io.ReadCloser {
+	if t.bytes == nil {
+		return in
+	}
+	return &throttledReader{in, t.bytes}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.limiter.WaitN(context.Background(), n)
+	}
+	return n, err
+}
+
 // Mirror mirrors an archive, it assumes that the source and destination have the same checkpoint ledger frequency
 func Mirror(src *Archive, dst *Archive, opts *CommandOptions) error {
+	return MirrorWithOptions(src, dst, opts, MirrorOptions{})
+}
+
+// MirrorWithOptions mirrors the categories and buckets of the archive selected
+// by mirrorOpts, throttled, and verifies each copied file against the source
+// when opts.Verify is set. It assumes that the source and destination have the
+// same checkpoint ledger frequency.
+func MirrorWithOptions(src *Archive, dst *Archive, opts *CommandOptions, mirrorOpts MirrorOptions) error {
+	categories := Categories()
+	if len(mirrorOpts.Categories) > 0 {
+		for _, cat := range mirrorOpts.Categories {
+			if !slices.Contains(categories, cat) {
+				return errors.Errorf("unknown category %s", cat)
+			}
+		}
+		categories = mirrorOpts.Categories
+	}
+
+	state := mirrorState{
+		Categories:   slices.Sorted(slices.Values(categories)),
+		SkipBuckets:  mirrorOpts.SkipBuckets,
+		SkipOptional: opts.SkipOptional,
+	}
+	if mirrorOpts.StatePath != "" {
+		saved, err := loadMirrorState(mirrorOpts.StatePath)
+		if err != nil {
+			return err
+		}
+		// the checkpoints completed by a mirror of other files may still be
+		// missing some of the files selected now
+		if len(saved.Completed) > 0 && !saved.sameFiles(state) {
+			return errors.Errorf("state %s was saved by a mirror of categories %v with skip buckets %t "+
+				"and skip optional %t, use another state file",
+				mirrorOpts.StatePath, saved.Categories, saved.SkipBuckets, saved.SkipOptional)
+		}
+		state.Completed = saved.Completed
+	}
+
+	throttle := newMirrorThrottle(mirrorOpts)
+	hooks := copyHooks{beforeRequest: throttle.request, reader: throttle.reader, verify: opts.Verify}
+	throttle.request()
 	rootHAS, e := src.GetRootHAS()
 	if e != nil {
 		return e
@@ -29,6 +230,22 @@ func Mirror(src *Archive, dst *Archive, opts *CommandOptions) error {
 	bucketFetch := make(map[Hash]bool)
 	var bucketFetchMutex sync.Mutex
 
+	var stateMutex sync.Mutex
+	resumed := func(ix uint32) bool {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		return state.contains(ix)
+	}
+	completed := func(ix uint32) error {
+		if mirrorOpts.StatePath == "" || opts.DryRun {
+			return nil
+		}
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		state.add(ix, src.checkpointManager)
+		return saveMirrorState(mirrorOpts.StatePath, state)
+	}
+
 	var errs uint32
 	tick := makeTicker(func(ticks uint) {
 		bucketFetchMutex.Lock()
@@ -50,43 +267,56 @@ func Mirror(src *Archive, dst *Archive, opts *CommandOptions) error {
 				if !ok {
 					break
 				}
-				has, err := src.GetCheckpointHAS(ix)
-				if err != nil {
-					atomic.AddUint32(&errs, noteError(err))
+				if resumed(ix) {
+					tick <- true
 					continue
 				}
+				var checkpointErrs uint32
 
-				buckets, err := has.Buckets()
-				if err != nil {
-					panic(errors.Wrap(err, "error getting buckets"))
-				}
+				if !mirrorOpts.SkipBuckets {
+					throttle.request()
+					has, err := src.GetCheckpointHAS(ix)
+					if err != nil {
+						atomic.AddUint32(&errs, noteError(err))
+						continue
+					}
 
-				for _, bucket := range buckets {
-					alreadyFetching := false
-					bucketFetchMutex.Lock()
-					_, alreadyFetching = bucketFetch[bucket]
-					if !alreadyFetching {
-						bucketFetch[bucket] = true
+					buckets, err := has.Buckets()
+					if err != nil {
+						panic(errors.Wrap(err, "error getting buckets"))
 					}
-					bucketFetchMutex.Unlock()
-					if !alreadyFetching {
-						pth := BucketPath(bucket)
-						err = copyPath(src, dst, pth, opts)
-						atomic.AddUint32(&errs, noteError(err))
+
+					for _, bucket := range buckets {
+						alreadyFetching := false
+						bucketFetchMutex.Lock()
+						_, alreadyFetching = bucketFetch[bucket]
+						if !alreadyFetching {
+							bucketFetch[bucket] = true
+						}
+						bucketFetchMutex.Unlock()
+						if !alreadyFetching {
+							pth := BucketPath(bucket)
+							err = copyPath(src, dst, pth, opts, hooks)
+							checkpointErrs += noteError(err)
+						}
 					}
 				}
 
-				for _, cat := range Categories() {
+				for _, cat := range categories {
 					if opts.SkipOptional && !categoryRequired(cat) {
 						continue
 					}
 					pth := CategoryCheckpointPath(cat, ix)
-					err = copyPath(src, dst, pth, opts)
+					err := copyPath(src, dst, pth, opts, hooks)
 					if err != nil && !categoryRequired(cat) {
 						continue
 					}
-					atomic.AddUint32(&errs, noteError(err))
+					checkpointErrs += noteError(err)
+				}
+				if checkpointErrs == 0 {
+					checkpointErrs += noteError(completed(ix))
 				}
+				atomic.AddUint32(&errs, checkpointErrs)
 				tick <- true
 			}
 			wg.Done()
@@ -100,9 +330,11 @@ func Mirror(src *Archive, dst *Archive, opts *CommandOptions) error {
 	if rootHAS.CurrentLedger == opts.Range.High {
 		log.Printf("updating destination archive current-ledger pointer to 0x%8.8x",
 			rootHAS.CurrentLedger)
+		throttle.request()
 		e = dst.PutRootHAS(rootHAS, opts)
 		errs += noteError(e)
 	} else {
+		throttle.request()
 		dstHAS, e := dst.GetRootHAS()
 		if e != nil {
 			errs += noteError(e)
@@ -0,0 +1,183 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/support/storage"
+)
+
+func fileExists(t *testing.T, arch *Archive, pth string) bool {
+	ok, err := arch.backend.Exists(pth)
+	require.NoError(t, err)
+	return ok
+}
+
+func TestMirrorCategories(t *testing.T) {
+	defer cleanup()
+	opts := testOptions()
+	src := GetRandomPopulatedArchive()
+	dst := GetTestArchive()
+
+	err := MirrorWithOptions(src, dst, opts, MirrorOptions{Categories: []string{"headers"}})
+	assert.EqualError(t, err, "unknown category headers")
+
+	require.NoError(t, MirrorWithOptions(src, dst, opts, MirrorOptions{
+		Categories:  []string{"ledger"},
+		SkipBuckets: true,
+	}))
+	for _, chk := range opts.Range.allCheckpoints() {
+		assert.True(t, fileExists(t, dst, CategoryCheckpointPath("ledger", chk)))
+		assert.False(t, fileExists(t, dst, CategoryCheckpointPath("history", chk)))
+		assert.False(t, fileExists(t, dst, CategoryCheckpointPath("transactions", chk)))
+	}
+	has, err := src.GetCheckpointHAS(opts.Range.Low)
+	require.NoError(t, err)
+	buckets, err := has.Buckets()
+	require.NoError(t, err)
+	for _, bucket := range buckets {
+		assert.False(t, fileExists(t, dst, BucketPath(bucket)))
+	}
+
+	// the remaining files are mirrored later
+	require.NoError(t, Mirror(src, dst, opts))
+	assert.Equal(t, 0, countMissing(dst, opts))
+}
+
+func TestMirrorResume(t *testing.T) {
+	defer cleanup()
+	opts := testOptions()
+	src := GetRandomPopulatedArchive()
+	dst := GetTestArchive()
+	statePath := filepath.Join(t.TempDir(), "state")
+
+	// a checkpoint with a missing file is not completed
+	bad := opts.Range.Low + 4*src.checkpointManager.GetCheckpointFrequency()
+	pth := CategoryCheckpointPath("ledger", bad)
+	rdr, err := src.backend.GetFile(pth)
+	require.NoError(t, err)
+	contents, err := io.ReadAll(rdr)
+	require.NoError(t, err)
+	require.NoError(t, src.backend.(*MockArchiveBackend).DeleteFile(pth))
+
+	err = MirrorWithOptions(src, dst, opts, MirrorOptions{StatePath: statePath})
+	assert.EqualError(t, err, "1 errors while mirroring")
+	state, err := loadMirrorState(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{
+		{Low: opts.Range.Low, High: bad - src.checkpointManager.GetCheckpointFrequency()},
+		{Low: bad + src.checkpointManager.GetCheckpointFrequency(), High: opts.Range.High},
+	}, state.Completed)
+
+	// only the incomplete checkpoint is mirrored again
+	require.NoError(t, src.backend.PutFile(pth, io.NopCloser(bytes.NewReader(contents))))
+	completed := CategoryCheckpointPath("transactions", opts.Range.Low)
+	require.NoError(t, dst.backend.(*MockArchiveBackend).DeleteFile(completed))
+	require.NoError(t, MirrorWithOptions(src, dst, opts, MirrorOptions{StatePath: statePath}))
+	assert.True(t, fileExists(t, dst, pth))
+	assert.False(t, fileExists(t, dst, completed))
+	state, err = loadMirrorState(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, []Range{opts.Range}, state.Completed)
+}
+
+func TestMirrorResumeOtherFiles(t *testing.T) {
+	defer cleanup()
+	opts := testOptions()
+	src := GetRandomPopulatedArchive()
+	dst := GetTestArchive()
+	statePath := filepath.Join(t.TempDir(), "state")
+
+	headersOnly := MirrorOptions{Categories: []string{"ledger"}, SkipBuckets: true, StatePath: statePath}
+	require.NoError(t, MirrorWithOptions(src, dst, opts, headersOnly))
+
+	// the checkpoints completed by the headers only mirror are missing the
+	// other files
+	err := MirrorWithOptions(src, dst, opts, MirrorOptions{StatePath: statePath})
+	assert.EqualError(t, err, "state "+statePath+" was saved by a mirror of categories [ledger] "+
+		"with skip buckets true and skip optional false, use another state file")
+
+	// the categories are compared regardless of their order
+	statePath = filepath.Join(t.TempDir(), "state")
+	require.NoError(t, MirrorWithOptions(src, dst, opts, MirrorOptions{
+		Categories: []string{"results", "ledger"}, SkipBuckets: true, StatePath: statePath,
+	}))
+	require.NoError(t, MirrorWithOptions(src, dst, opts, MirrorOptions{
+		Categories: []string{"ledger", "results"}, SkipBuckets: true, StatePath: statePath,
+	}))
+
+	require.NoError(t, MirrorWithOptions(src, dst, opts, MirrorOptions{StatePath: filepath.Join(t.TempDir(), "state")}))
+	assert.Equal(t, 0, countMissing(dst, opts))
+}
+
+type corruptingBackend struct {
+	storage.Storage
+}
+
+func (b corruptingBackend) PutFile(pth string, in io.ReadCloser) error {
+	buf, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(pth, "ledger") {
+		buf = buf[:len(buf)/2]
+	}
+	return b.Storage.PutFile(pth, io.NopCloser(bytes.NewReader(buf)))
+}
+
+func TestMirrorVerify(t *testing.T) {
+	defer cleanup()
+	opts := testOptions()
+	src := GetRandomPopulatedArchive()
+	dst := GetTestArchive()
+	dst.backend = corruptingBackend{dst.backend}
+
+	require.NoError(t, Mirror(src, dst, opts))
+
+	opts.Force = true
+	opts.Verify = true
+	err := Mirror(src, dst, opts)
+	// every ledger file is corrupted
+	assert.EqualError(t, err, fmt.Sprintf("%d errors while mirroring", opts.Range.SizeInCheckPoints(src.checkpointManager)))
+}
+
+func TestMirrorThrottle(t *testing.T) {
+	throttle := newMirrorThrottle(MirrorOptions{MaxBytesPerSecond: 10000})
+	start := time.Now()
+	n, err := io.Copy(io.Discard, throttle.reader(io.NopCloser(bytes.NewReader(make([]byte, 20000)))))
+	require.NoError(t, err)
+	assert.Equal(t, int64(20000), n)
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+
+	throttle = newMirrorThrottle(MirrorOptions{MaxRequestsPerSecond: 20})
+	start = time.Now()
+	for i := 0; i < 30; i++ {
+		throttle.request()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 450*time.Millisecond)
+}
+
+func TestMirrorStateAdd(t *testing.T) {
+	manager := NewCheckpointManager(64)
+	var state mirrorState
+	for _, chk := range []uint32{255, 63, 191, 127, 383} {
+		state.add(chk, manager)
+	}
+	assert.Equal(t, []Range{{Low: 63, High: 255}, {Low: 383, High: 383}}, state.Completed)
+	assert.True(t, state.contains(127))
+	assert.False(t, state.contains(319))
+	state.add(319, manager)
+	assert.Equal(t, []Range{{Low: 63, High: 383}}, state.Completed)
+}
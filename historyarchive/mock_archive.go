@@ -9,6 +9,7 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 	"sync"
 
@@ -60,6 +61,16 @@ func (b *MockArchiveBackend) PutFile(pth string, in io.ReadCloser) error {
 	return nil
 }
 
+func (b *MockArchiveBackend) DeleteFile(pth string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.files[pth]; !ok {
+		return os.ErrNotExist
+	}
+	delete(b.files, pth)
+	return nil
+}
+
 func (b *MockArchiveBackend) ListFiles(pth string) (chan string, chan error) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
@@ -0,0 +1,299 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// PublisherOptions configures a Publisher.
+type PublisherOptions struct {
+	// CommandOptions.Force overwrites the checkpoint files which already
+	// exist in the archive, they are skipped otherwise.
+	CommandOptions
+
+	// Server is written to the HAS files.
+	Server string
+
+	// BucketLists returns the live and hot archive bucket lists as of the
+	// given checkpoint ledger, which are written to the HAS files. Their hash
+	// is checked against the BucketListHash of the checkpoint ledger header.
+	//
+	// The bucket lists can't be derived from LedgerCloseMeta: without
+	// BucketLists the HAS files reference empty bucket lists, which is enough
+	// to read the ledger, transactions and results categories of the archive
+	// but not to catch up from it.
+	BucketLists func(checkpoint uint32) (live BucketList, hotArchive BucketList, err error)
+}
+
+// Publisher writes the ledger, transactions and results files of the
+// checkpoints of an archive, and their HAS files, from LedgerCloseMeta. It
+// can be used to rebuild or backfill the archives of a network, e.g. a private
+// one, without running a validator.
+//
+// Ledgers are added in order with AddLedger, starting with the first ledger
+// of a checkpoint. The files of a checkpoint are written when its last ledger
+// is added; Publisher does not write partial checkpoints. The root HAS is only
+// written if it doesn't point to a later checkpoint, so older checkpoints can
+// be backfilled into an archive without rewinding it.
+type Publisher struct {
+	archive *Archive
+	opts    PublisherOptions
+
+	headers      []xdr.LedgerHeaderHistoryEntry
+	transactions []xdr.TransactionHistoryEntry
+	results      []xdr.TransactionHistoryResultEntry
+
+	// next is the sequence of the next ledger to add, 0 before the first
+	// ledger is added.
+	next         uint32
+	previousHash xdr.Hash
+}
+
+// NewPublisher returns a Publisher writing to the given archive.
+func NewPublisher(archive *Archive, opts PublisherOptions) *Publisher {
+	return &Publisher{archive: archive, opts: opts}
+}
+
+// AddLedger validates the ledger and adds it to the current checkpoint,
+// writing the files of the checkpoint if it is its last ledger. The ledger is
+// not added if an error is returned, so it can be added again, e.g. once the
+// archive is reachable again.
+func (p *Publisher) AddLedger(lcm xdr.LedgerCloseMeta) error {
+	manager := p.archive.GetCheckpointManager()
+	sequence := lcm.LedgerSequence()
+	if p.next == 0 {
+		if first := manager.GetCheckpointRange(sequence).Low; sequence != first {
+			return errors.Errorf(
+				"ledger %d is not the first ledger of checkpoint %d, expected %d",
+				sequence, manager.GetCheckpoint(sequence), first,
+			)
+		}
+	} else if sequence != p.next {
+		return errors.Errorf("expected ledger %d but got %d", p.next, sequence)
+	}
+
+	header := lcm.LedgerHeaderHistoryEntry()
+	if err := p.archive.VerifyLedgerHeaderHistoryEntry(&header); err != nil {
+		return errors.Wrapf(err, "invalid header of ledger %d", sequence)
+	}
+	if p.next != 0 && header.Header.PreviousLedgerHash != p.previousHash {
+		return errors.Errorf(
+			"ledger %d has previous ledger hash %s, expected %s",
+			sequence, Hash(header.Header.PreviousLedgerHash), Hash(p.previousHash),
+		)
+	}
+
+	transactions, err := transactionHistoryEntry(lcm)
+	if err != nil {
+		return errors.Wrapf(err, "invalid transaction set of ledger %d", sequence)
+	}
+	results, err := transactionHistoryResultEntry(lcm)
+	if err != nil {
+		return errors.Wrapf(err, "invalid transaction results of ledger %d", sequence)
+	}
+
+	// the ledger is only added to the checkpoint once it has been published
+	headers := append(p.headers, header)
+	transactionEntries, resultEntries := p.transactions, p.results
+	// like stellar-core, ledgers without transactions have no entries in the
+	// transactions and results categories
+	if lcm.CountTransactions() > 0 {
+		transactionEntries = append(transactionEntries, transactions)
+		resultEntries = append(resultEntries, results)
+	}
+
+	if manager.IsCheckpoint(sequence) {
+		if err := p.publish(sequence, header, headers, transactionEntries, resultEntries); err != nil {
+			return errors.Wrapf(err, "error publishing checkpoint %d", sequence)
+		}
+		headers, transactionEntries, resultEntries = headers[:0], transactionEntries[:0], resultEntries[:0]
+	}
+	p.headers, p.transactions, p.results = headers, transactionEntries, resultEntries
+	p.next = sequence + 1
+	p.previousHash = header.Hash
+	return nil
+}
+
+// NextLedger returns the sequence of the next ledger to add, 0 if no ledger
+// was added yet.
+func (p *Publisher) NextLedger() uint32 {
+	return p.next
+}
+
+func transactionHistoryEntry(lcm xdr.LedgerCloseMeta) (xdr.TransactionHistoryEntry, error) {
+	header := lcm.LedgerHeaderHistoryEntry().Header
+	entry := xdr.TransactionHistoryEntry{LedgerSeq: header.LedgerSeq}
+
+	switch lcm.V {
+	case 0:
+		txSet := lcm.MustV0().TxSet
+		// HashTxSet sorts the transactions in place, copy them to leave the
+		// ledger untouched
+		txSet.Txs = append([]xdr.TransactionEnvelope(nil), txSet.Txs...)
+		entry.TxSet = txSet
+	case 1:
+		txSet := lcm.MustV1().TxSet
+		entry.Ext = xdr.TransactionHistoryEntryExt{V: 1, GeneralizedTxSet: &txSet}
+	case 2:
+		txSet := lcm.MustV2().TxSet
+		entry.Ext = xdr.TransactionHistoryEntryExt{V: 1, GeneralizedTxSet: &txSet}
+	default:
+		return entry, errors.Errorf("unsupported LedgerCloseMeta.V: %d", lcm.V)
+	}
+	hash, err := transactionHistoryEntryHash(&entry)
+	if err != nil {
+		return entry, err
+	}
+	if hash != Hash(header.ScpValue.TxSetHash) {
+		return entry, errors.Errorf("expected hash %s, got %s", Hash(header.ScpValue.TxSetHash), hash)
+	}
+	return entry, nil
+}
+
+func transactionHistoryResultEntry(lcm xdr.LedgerCloseMeta) (xdr.TransactionHistoryResultEntry, error) {
+	header := lcm.LedgerHeaderHistoryEntry().Header
+	entry := xdr.TransactionHistoryResultEntry{LedgerSeq: header.LedgerSeq}
+	for i := 0; i < lcm.CountTransactions(); i++ {
+		entry.TxResultSet.Results = append(entry.TxResultSet.Results, lcm.TransactionResultPair(i))
+	}
+	hash, err := xdr.HashXdr(&entry.TxResultSet)
+	if err != nil {
+		return entry, err
+	}
+	if hash != header.TxSetResultHash {
+		return entry, errors.Errorf("expected hash %s, got %s", Hash(header.TxSetResultHash), Hash(hash))
+	}
+	return entry, nil
+}
+
+func (p *Publisher) publish(
+	checkpoint uint32,
+	header xdr.LedgerHeaderHistoryEntry,
+	headers []xdr.LedgerHeaderHistoryEntry,
+	transactions []xdr.TransactionHistoryEntry,
+	results []xdr.TransactionHistoryResultEntry,
+) error {
+	has, err := p.historyArchiveState(checkpoint, header)
+	if err != nil {
+		return err
+	}
+
+	if err := putCategoryCheckpoint(p.archive, "ledger", checkpoint, headers, &p.opts.CommandOptions); err != nil {
+		return err
+	}
+	if err := putCategoryCheckpoint(p.archive, "transactions", checkpoint, transactions, &p.opts.CommandOptions); err != nil {
+		return err
+	}
+	if err := putCategoryCheckpoint(p.archive, "results", checkpoint, results, &p.opts.CommandOptions); err != nil {
+		return err
+	}
+	if err := p.archive.PutCheckpointHAS(checkpoint, has, &p.opts.CommandOptions); err != nil {
+		return errors.Wrap(err, "error writing checkpoint HAS")
+	}
+	// backfilling older checkpoints must not rewind the root HAS of an
+	// archive which already contains newer ones
+	if newer, err := p.newerRootHAS(checkpoint); err != nil {
+		return errors.Wrap(err, "error reading root HAS")
+	} else if !newer {
+		if err := p.archive.PutRootHAS(has, &p.opts.CommandOptions); err != nil {
+			return errors.Wrap(err, "error writing root HAS")
+		}
+	}
+	return nil
+}
+
+// newerRootHAS returns true if the root HAS of the archive points to a
+// checkpoint after the given one.
+func (p *Publisher) newerRootHAS(checkpoint uint32) (bool, error) {
+	exists, err := p.archive.backend.Exists(rootHASPath)
+	if err != nil || !exists {
+		return false, err
+	}
+	root, err := p.archive.GetRootHAS()
+	if err != nil {
+		return false, err
+	}
+	return root.CurrentLedger > checkpoint, nil
+}
+
+func (p *Publisher) historyArchiveState(checkpoint uint32, header xdr.LedgerHeaderHistoryEntry) (HistoryArchiveState, error) {
+	has := HistoryArchiveState{
+		Version:           1,
+		Server:            p.opts.Server,
+		CurrentLedger:     checkpoint,
+		NetworkPassphrase: p.archive.networkPassphrase,
+	}
+	if header.Header.LedgerVersion >= 23 {
+		has.Version = HistoryArchiveStateVersionForProtocol23
+	}
+
+	if p.opts.BucketLists == nil {
+		zero := Hash{}.String()
+		for i := range has.CurrentBuckets {
+			has.CurrentBuckets[i].Curr = zero
+			has.CurrentBuckets[i].Snap = zero
+			has.HotArchiveBuckets[i].Curr = zero
+			has.HotArchiveBuckets[i].Snap = zero
+		}
+		return has, nil
+	}
+
+	var err error
+	has.CurrentBuckets, has.HotArchiveBuckets, err = p.opts.BucketLists(checkpoint)
+	if err != nil {
+		return has, errors.Wrap(err, "error getting bucket lists")
+	}
+	hash, err := has.BucketListHash()
+	if err != nil {
+		return has, errors.Wrap(err, "error hashing bucket lists")
+	}
+	if hash != header.Header.BucketListHash {
+		return has, errors.Errorf(
+			"bucket list hash %s does not match the hash in the ledger header %s",
+			Hash(hash), Hash(header.Header.BucketListHash),
+		)
+	}
+	return has, nil
+}
+
+// putCategoryCheckpoint writes the entries to the gzipped, XDR framed file of
+// the category and checkpoint.
+func putCategoryCheckpoint[T any](a *Archive, category string, checkpoint uint32, entries []T, opts *CommandOptions) error {
+	pth := CategoryCheckpointPath(category, checkpoint)
+	exists, err := a.backend.Exists(pth)
+	a.stats.incrementRequests()
+	if err != nil {
+		return errors.Wrapf(err, "error checking if %s exists", pth)
+	}
+	if exists && !opts.Force {
+		log.Printf("skipping existing %s", pth)
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	for i := range entries {
+		if err := xdr.MarshalFramed(writer, &entries[i]); err != nil {
+			return errors.Wrapf(err, "error encoding %s entry", category)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return errors.Wrapf(err, "error compressing %s", pth)
+	}
+
+	a.stats.incrementUploads()
+	if err := a.backend.PutFile(pth, io.NopCloser(buf)); err != nil {
+		return errors.Wrapf(err, "error writing %s", pth)
+	}
+	return nil
+}
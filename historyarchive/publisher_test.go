@@ -0,0 +1,335 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func testPublisherArchive(t *testing.T) *Archive {
+	archive, err := Connect("mock://test", ArchiveOptions{
+		CheckpointFrequency: 8,
+		NetworkPassphrase:   network.TestNetworkPassphrase,
+	})
+	require.NoError(t, err)
+	return archive
+}
+
+// testLedgerCloseMeta returns a valid ledger following the given one, with a
+// transaction if withTx is true.
+func testLedgerCloseMeta(t *testing.T, previous xdr.LedgerHeaderHistoryEntry, withTx bool) xdr.LedgerCloseMeta {
+	txSet := xdr.TransactionSet{PreviousLedgerHash: previous.Hash}
+	var processing []xdr.TransactionResultMeta
+	if withTx {
+		source := xdr.MustAddress("GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+		txSet.Txs = []xdr.TransactionEnvelope{{
+			Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+			V1: &xdr.TransactionV1Envelope{
+				Tx: xdr.Transaction{
+					SourceAccount: source.ToMuxedAccount(),
+					SeqNum:        xdr.SequenceNumber(previous.Header.LedgerSeq + 1),
+					Operations: []xdr.Operation{{
+						Body: xdr.OperationBody{Type: xdr.OperationTypeBumpSequence, BumpSequenceOp: &xdr.BumpSequenceOp{}},
+					}},
+				},
+			},
+		}}
+		hash, err := network.HashTransactionInEnvelope(txSet.Txs[0], network.TestNetworkPassphrase)
+		require.NoError(t, err)
+		processing = []xdr.TransactionResultMeta{{
+			Result: xdr.TransactionResultPair{
+				TransactionHash: hash,
+				Result: xdr.TransactionResult{
+					Result: xdr.TransactionResultResult{
+						Code:    xdr.TransactionResultCodeTxSuccess,
+						Results: &[]xdr.OperationResult{},
+					},
+				},
+			},
+			TxApplyProcessing: xdr.TransactionMeta{V: 2, V2: &xdr.TransactionMetaV2{}},
+		}}
+	}
+
+	header := xdr.LedgerHeader{
+		LedgerSeq:          previous.Header.LedgerSeq + 1,
+		LedgerVersion:      22,
+		PreviousLedgerHash: previous.Hash,
+		ScpValue:           xdr.StellarValue{CloseTime: previous.Header.ScpValue.CloseTime + 5},
+	}
+	txSetHash, err := HashTxSet(&xdr.TransactionSet{
+		PreviousLedgerHash: txSet.PreviousLedgerHash,
+		Txs:                append([]xdr.TransactionEnvelope(nil), txSet.Txs...),
+	})
+	require.NoError(t, err)
+	header.ScpValue.TxSetHash = xdr.Hash(txSetHash)
+	results := xdr.TransactionResultSet{}
+	for _, meta := range processing {
+		results.Results = append(results.Results, meta.Result)
+	}
+	header.TxSetResultHash, err = xdr.HashXdr(&results)
+	require.NoError(t, err)
+	hash, err := xdr.HashXdr(&header)
+	require.NoError(t, err)
+
+	return xdr.LedgerCloseMeta{
+		V: 0,
+		V0: &xdr.LedgerCloseMetaV0{
+			LedgerHeader: xdr.LedgerHeaderHistoryEntry{Hash: hash, Header: header},
+			TxSet:        txSet,
+			TxProcessing: processing,
+		},
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	archive := testPublisherArchive(t)
+	publisher := NewPublisher(archive, PublisherOptions{Server: "test"})
+
+	var (
+		previous xdr.LedgerHeaderHistoryEntry
+		ledgers  = map[uint32]xdr.LedgerCloseMeta{}
+	)
+	for sequence := uint32(1); sequence <= 17; sequence++ {
+		lcm := testLedgerCloseMeta(t, previous, sequence%3 == 0)
+		require.NoError(t, publisher.AddLedger(lcm))
+		ledgers[sequence] = lcm
+		previous = lcm.LedgerHeaderHistoryEntry()
+	}
+	assert.Equal(t, uint32(18), publisher.NextLedger())
+
+	// ledgers 16 and 17 are in a partial checkpoint
+	for _, category := range []string{"ledger", "transactions", "results"} {
+		for _, checkpoint := range []uint32{7, 15} {
+			ok, err := archive.CategoryCheckpointExists(category, checkpoint)
+			require.NoError(t, err)
+			assert.True(t, ok, "%s %d", category, checkpoint)
+		}
+		ok, err := archive.CategoryCheckpointExists(category, 23)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	has, err := archive.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(15), has.CurrentLedger)
+	assert.Equal(t, "test", has.Server)
+	assert.Equal(t, network.TestNetworkPassphrase, has.NetworkPassphrase)
+	has, err = archive.GetCheckpointHAS(7)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), has.CurrentLedger)
+
+	published, err := archive.GetLedgers(1, 15)
+	require.NoError(t, err)
+	require.Len(t, published, 15)
+	for sequence := uint32(1); sequence <= 15; sequence++ {
+		ledger := published[sequence]
+		lcm := ledgers[sequence]
+		assert.Equal(t, lcm.LedgerHeaderHistoryEntry(), ledger.Header)
+		require.NoError(t, archive.VerifyLedgerHeaderHistoryEntry(&ledger.Header))
+		if sequence%3 != 0 {
+			// ledgers without transactions are not in the transactions and
+			// results categories
+			assert.Zero(t, ledger.Transaction.LedgerSeq)
+			assert.Zero(t, ledger.TransactionResult.LedgerSeq)
+			continue
+		}
+		assert.Equal(t, lcm.TransactionEnvelopes(), ledger.Transaction.TxSet.Txs)
+		assertXdrEquals(t, lcm.TransactionResultPair(0), ledger.TransactionResult.TxResultSet.Results[0])
+		require.NoError(t, archive.VerifyTransactionHistoryEntry(&ledger.Transaction))
+		require.NoError(t, archive.VerifyTransactionHistoryResultEntry(&ledger.TransactionResult))
+	}
+	invalid, err := archive.ReportInvalid(&CommandOptions{Verify: true})
+	require.NoError(t, err)
+	assert.False(t, invalid)
+}
+
+func TestPublisherBackfill(t *testing.T) {
+	var (
+		previous xdr.LedgerHeaderHistoryEntry
+		ledgers  []xdr.LedgerCloseMeta
+	)
+	for sequence := uint32(1); sequence <= 23; sequence++ {
+		lcm := testLedgerCloseMeta(t, previous, false)
+		ledgers = append(ledgers, lcm)
+		previous = lcm.LedgerHeaderHistoryEntry()
+	}
+
+	archive := testPublisherArchive(t)
+	publisher := NewPublisher(archive, PublisherOptions{})
+	for _, lcm := range ledgers[15:] {
+		require.NoError(t, publisher.AddLedger(lcm))
+	}
+	has, err := archive.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(23), has.CurrentLedger)
+
+	// the older checkpoints are published but the root HAS still points to
+	// the latest one
+	publisher = NewPublisher(archive, PublisherOptions{})
+	for _, lcm := range ledgers[:15] {
+		require.NoError(t, publisher.AddLedger(lcm))
+	}
+	for _, checkpoint := range []uint32{7, 15} {
+		ok, err := archive.CategoryCheckpointExists("ledger", checkpoint)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	has, err = archive.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(23), has.CurrentLedger)
+}
+
+// countCategoryEntries returns the number of entries in the file of the
+// category and checkpoint.
+func countCategoryEntries[T any, PT interface {
+	*T
+	xdr.DecoderFrom
+}](t *testing.T, archive *Archive, category string, checkpoint uint32) int {
+	stream, err := archive.GetXdrStream(CategoryCheckpointPath(category, checkpoint))
+	require.NoError(t, err)
+	defer stream.Close()
+	var n int
+	for {
+		var entry T
+		err := stream.ReadOne(PT(&entry))
+		if err == io.EOF {
+			return n
+		}
+		require.NoError(t, err)
+		n++
+	}
+}
+
+func TestPublisherErrors(t *testing.T) {
+	genesis := testLedgerCloseMeta(t, xdr.LedgerHeaderHistoryEntry{}, false)
+	second := testLedgerCloseMeta(t, genesis.LedgerHeaderHistoryEntry(), true)
+
+	publisher := NewPublisher(testPublisherArchive(t), PublisherOptions{})
+	assert.EqualError(t, publisher.AddLedger(second), "ledger 2 is not the first ledger of checkpoint 7, expected 1")
+	require.NoError(t, publisher.AddLedger(genesis))
+	assert.EqualError(t, publisher.AddLedger(testLedgerCloseMeta(t, second.LedgerHeaderHistoryEntry(), false)),
+		"expected ledger 2 but got 3")
+
+	forked := testLedgerCloseMeta(t, xdr.LedgerHeaderHistoryEntry{Header: xdr.LedgerHeader{LedgerSeq: 1}}, false)
+	assert.EqualError(t, publisher.AddLedger(forked), "ledger 2 has previous ledger hash "+
+		Hash(forked.PreviousLedgerHash()).String()+", expected "+Hash(genesis.LedgerHash()).String())
+
+	invalid := testLedgerCloseMeta(t, genesis.LedgerHeaderHistoryEntry(), true)
+	invalid.V0.LedgerHeader.Header.ScpValue.CloseTime++
+	assert.ErrorContains(t, publisher.AddLedger(invalid), "invalid header of ledger 2")
+
+	invalid = testLedgerCloseMeta(t, genesis.LedgerHeaderHistoryEntry(), true)
+	invalid.V0.TxSet.Txs = nil
+	assert.ErrorContains(t, publisher.AddLedger(invalid), "invalid transaction set of ledger 2: expected hash")
+
+	invalid = testLedgerCloseMeta(t, genesis.LedgerHeaderHistoryEntry(), true)
+	invalid.V0.TxProcessing[0].Result.Result.FeeCharged = 100
+	assert.ErrorContains(t, publisher.AddLedger(invalid), "invalid transaction results of ledger 2: expected hash")
+
+	require.NoError(t, publisher.AddLedger(second))
+
+	// the checkpoint ledger can be added again once its bucket lists can be
+	// read
+	zero := BucketList{}
+	for i := range zero {
+		zero[i].Curr = Hash{}.String()
+		zero[i].Snap = Hash{}.String()
+	}
+	bucketListHash, err := zero.Hash()
+	require.NoError(t, err)
+	archive := testPublisherArchive(t)
+	bucketListsErr := errors.New("bucket lists not available")
+	publisher = NewPublisher(archive, PublisherOptions{
+		BucketLists: func(uint32) (BucketList, BucketList, error) {
+			return zero, zero, bucketListsErr
+		},
+	})
+	var previous xdr.LedgerHeaderHistoryEntry
+	for sequence := uint32(1); sequence < 7; sequence++ {
+		lcm := testLedgerCloseMeta(t, previous, true)
+		require.NoError(t, publisher.AddLedger(lcm))
+		previous = lcm.LedgerHeaderHistoryEntry()
+	}
+	checkpoint := testLedgerCloseMeta(t, previous, true)
+	header := &checkpoint.V0.LedgerHeader
+	header.Header.BucketListHash = bucketListHash
+	header.Hash, err = xdr.HashXdr(&header.Header)
+	require.NoError(t, err)
+	assert.EqualError(t, publisher.AddLedger(checkpoint),
+		"error publishing checkpoint 7: error getting bucket lists: bucket lists not available")
+	assert.Equal(t, uint32(7), publisher.NextLedger())
+
+	bucketListsErr = nil
+	require.NoError(t, publisher.AddLedger(checkpoint))
+	assert.Equal(t, uint32(8), publisher.NextLedger())
+	// the ledgers of the failed attempt are not published twice
+	assert.Equal(t, 7, countCategoryEntries[xdr.LedgerHeaderHistoryEntry](t, archive, "ledger", 7))
+	assert.Equal(t, 7, countCategoryEntries[xdr.TransactionHistoryEntry](t, archive, "transactions", 7))
+	assert.Equal(t, 7, countCategoryEntries[xdr.TransactionHistoryResultEntry](t, archive, "results", 7))
+}
+
+func TestPublisherBucketLists(t *testing.T) {
+	bucketList := BucketList{}
+	for i := range bucketList {
+		bucketList[i].Curr = Hash{byte(i)}.String()
+		bucketList[i].Snap = Hash{}.String()
+	}
+	bucketListHash, err := bucketList.Hash()
+	require.NoError(t, err)
+
+	var (
+		ledgers  []xdr.LedgerCloseMeta
+		previous xdr.LedgerHeaderHistoryEntry
+	)
+	for sequence := uint32(1); sequence <= 7; sequence++ {
+		lcm := testLedgerCloseMeta(t, previous, false)
+		if sequence == 7 {
+			header := &lcm.V0.LedgerHeader
+			header.Header.BucketListHash = bucketListHash
+			header.Hash, err = xdr.HashXdr(&header.Header)
+			require.NoError(t, err)
+		}
+		ledgers = append(ledgers, lcm)
+		previous = lcm.LedgerHeaderHistoryEntry()
+	}
+
+	publish := func(bucketList BucketList) (*Archive, error) {
+		archive := testPublisherArchive(t)
+		publisher := NewPublisher(archive, PublisherOptions{
+			BucketLists: func(checkpoint uint32) (BucketList, BucketList, error) {
+				assert.Equal(t, uint32(7), checkpoint)
+				return bucketList, BucketList{}, nil
+			},
+		})
+		for _, lcm := range ledgers {
+			if err := publisher.AddLedger(lcm); err != nil {
+				return archive, err
+			}
+		}
+		return archive, nil
+	}
+
+	archive, err := publish(bucketList)
+	require.NoError(t, err)
+	has, err := archive.GetCheckpointHAS(7)
+	require.NoError(t, err)
+	assert.Equal(t, bucketList, has.CurrentBuckets)
+
+	other := bucketList
+	other[0].Curr = Hash{1}.String()
+	archive, err = publish(other)
+	assert.ErrorContains(t, err, "does not match the hash in the ledger header")
+	ok, err := archive.CategoryCheckpointExists("ledger", 7)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
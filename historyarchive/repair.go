@@ -42,7 +42,7 @@ func Repair(src *Archive, dst *Archive, opts *CommandOptions) error {
 				continue
 			}
 			log.Printf("Repairing %s", pth)
-			errs += noteError(copyPath(src, dst, pth, opts))
+			errs += noteError(copyPath(src, dst, pth, opts, copyHooks{}))
 			if cat == "history" {
 				repairedHistory = true
 			}
@@ -63,7 +63,7 @@ func Repair(src *Archive, dst *Archive, opts *CommandOptions) error {
 	for bkt := range missingBuckets {
 		pth := BucketPath(bkt)
 		log.Printf("Repairing %s", pth)
-		errs += noteError(copyPath(src, dst, pth, opts))
+		errs += noteError(copyPath(src, dst, pth, opts, copyHooks{}))
 	}
 
 	if errs != 0 {
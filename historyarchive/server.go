@@ -0,0 +1,164 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ArchiveHandlerOptions configures the faults injected by an ArchiveHandler,
+// the zero value serves the archive without faults.
+type ArchiveHandlerOptions struct {
+	// Latency is added before every response.
+	Latency time.Duration
+	// ServerErrorRate is the probability, between 0 and 1, that a request
+	// fails with ServerErrorStatus.
+	ServerErrorRate float64
+	// ServerErrorStatus is the status of the failed requests, 503 if unset.
+	ServerErrorStatus int
+	// TruncateRate is the probability, between 0 and 1, that the body of a
+	// GET response is truncated: the Content-Length of the whole file is
+	// sent but only half of the file is, so clients see an unexpected EOF.
+	TruncateRate float64
+	// Seed seeds the random source of the faults, the same seed injects the
+	// same faults in the same sequence of requests.
+	Seed int64
+}
+
+// ArchiveHandlerStats counts the requests served by an ArchiveHandler.
+type ArchiveHandlerStats struct {
+	Requests     uint32
+	ServerErrors uint32
+	Truncated    uint32
+}
+
+// ArchiveHandler is an http.Handler serving a history archive from a
+// directory, e.g. one written by a file:// archive, with the same path layout
+// as the archive so that it can be connected to with an http:// URL. It is
+// meant for tests of the HTTP backend, retries and ArchivePool failover
+// without network access.
+type ArchiveHandler struct {
+	root string
+	opts ArchiveHandlerOptions
+
+	randMutex sync.Mutex
+	rand      *rand.Rand
+
+	requests     atomic.Uint32
+	serverErrors atomic.Uint32
+	truncated    atomic.Uint32
+}
+
+// NewArchiveHandler returns a handler serving the archive in the root
+// directory.
+func NewArchiveHandler(root string, opts ArchiveHandlerOptions) *ArchiveHandler {
+	if opts.ServerErrorStatus == 0 {
+		opts.ServerErrorStatus = http.StatusServiceUnavailable
+	}
+	return &ArchiveHandler{
+		root: root,
+		opts: opts,
+		rand: rand.New(rand.NewSource(opts.Seed)),
+	}
+}
+
+// GetStats returns the number of requests served so far.
+func (h *ArchiveHandler) GetStats() ArchiveHandlerStats {
+	return ArchiveHandlerStats{
+		Requests:     h.requests.Load(),
+		ServerErrors: h.serverErrors.Load(),
+		Truncated:    h.truncated.Load(),
+	}
+}
+
+func (h *ArchiveHandler) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	h.randMutex.Lock()
+	defer h.randMutex.Unlock()
+	return h.rand.Float64() < rate
+}
+
+func (h *ArchiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.requests.Add(1)
+	logger := log.WithField("method", r.Method).WithField("path", r.URL.Path)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.opts.Latency > 0 {
+		select {
+		case <-time.After(h.opts.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if h.chance(h.opts.ServerErrorRate) {
+		h.serverErrors.Add(1)
+		logger.WithField("status", h.opts.ServerErrorStatus).Debug("archive handler: injected server error")
+		http.Error(w, http.StatusText(h.opts.ServerErrorStatus), h.opts.ServerErrorStatus)
+		return
+	}
+
+	// cleaning the rooted path removes any .. elements
+	pth := filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	file, err := os.Open(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			logger.WithError(err).Error("archive handler: error opening file")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		logger.WithError(err).Error("archive handler: error reading file")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// directories are not part of the archive layout
+	if !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	size := info.Size()
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	n := size
+	if h.chance(h.opts.TruncateRate) {
+		h.truncated.Add(1)
+		n = size / 2
+		logger.WithField("bytes", n).Debug("archive handler: injected truncated body")
+	}
+	if _, err := io.CopyN(w, file, n); err != nil {
+		logger.WithError(err).Debug("archive handler: error writing body")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// publishTestDirectory publishes the ledgers up to checkpoint 15 to an
+// archive in a new directory and returns the directory.
+func publishTestDirectory(t *testing.T) string {
+	dir := t.TempDir()
+	archive, err := Connect("file://"+dir, ArchiveOptions{
+		CheckpointFrequency: 8,
+		NetworkPassphrase:   network.TestNetworkPassphrase,
+	})
+	require.NoError(t, err)
+	publisher := NewPublisher(archive, PublisherOptions{})
+	var previous xdr.LedgerHeaderHistoryEntry
+	for sequence := uint32(1); sequence <= 15; sequence++ {
+		lcm := testLedgerCloseMeta(t, previous, sequence%3 == 0)
+		require.NoError(t, publisher.AddLedger(lcm))
+		previous = lcm.LedgerHeaderHistoryEntry()
+	}
+	return dir
+}
+
+func serveTestDirectory(t *testing.T, dir string, opts ArchiveHandlerOptions) (*ArchiveHandler, *Archive) {
+	handler := NewArchiveHandler(dir, opts)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	archive, err := Connect(server.URL, ArchiveOptions{
+		CheckpointFrequency: 8,
+		NetworkPassphrase:   network.TestNetworkPassphrase,
+	})
+	require.NoError(t, err)
+	return handler, archive
+}
+
+func TestArchiveHandler(t *testing.T) {
+	dir := publishTestDirectory(t)
+	handler, archive := serveTestDirectory(t, dir, ArchiveHandlerOptions{})
+
+	has, err := archive.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(15), has.CurrentLedger)
+
+	ledgers, err := archive.GetLedgers(1, 15)
+	require.NoError(t, err)
+	assert.Len(t, ledgers, 15)
+
+	ok, err := archive.CategoryCheckpointExists("ledger", 7)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = archive.CategoryCheckpointExists("ledger", 23)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// only files inside the directory are served
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	for pth, status := range map[string]int{
+		"/ledger": http.StatusNotFound,
+		"/../" + dir + "/.well-known/stellar-history.json": http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + pth)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, pth)
+	}
+	resp, err := http.Post(server.URL+"/.well-known/stellar-history.json", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	stats := handler.GetStats()
+	assert.NotZero(t, stats.Requests)
+	assert.Zero(t, stats.ServerErrors)
+	assert.Zero(t, stats.Truncated)
+}
+
+func TestArchiveHandlerFaults(t *testing.T) {
+	dir := publishTestDirectory(t)
+
+	handler, archive := serveTestDirectory(t, dir, ArchiveHandlerOptions{ServerErrorRate: 1})
+	_, err := archive.GetRootHAS()
+	assert.ErrorContains(t, err, "503")
+	_, err = archive.CategoryCheckpointExists("ledger", 7)
+	assert.Error(t, err)
+	assert.Equal(t, uint32(2), handler.GetStats().ServerErrors)
+
+	handler, archive = serveTestDirectory(t, dir, ArchiveHandlerOptions{TruncateRate: 1})
+	_, err = archive.GetLedgers(1, 7)
+	assert.Error(t, err)
+	assert.NotZero(t, handler.GetStats().Truncated)
+	// HEAD requests are not truncated
+	ok, err := archive.CategoryCheckpointExists("ledger", 7)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, archive = serveTestDirectory(t, dir, ArchiveHandlerOptions{Latency: 50 * time.Millisecond})
+	start := time.Now()
+	_, err = archive.GetRootHAS()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestArchiveHandlerPoolFailover(t *testing.T) {
+	dir := publishTestDirectory(t)
+	failing := httptest.NewServer(NewArchiveHandler(dir, ArchiveHandlerOptions{ServerErrorRate: 1}))
+	defer failing.Close()
+	flaky := NewArchiveHandler(dir, ArchiveHandlerOptions{ServerErrorRate: 0.5, TruncateRate: 0.2, Seed: 1})
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+
+	pool, err := NewArchivePoolWithBackoff(
+		[]string{failing.URL, flakyServer.URL},
+		ArchiveOptions{CheckpointFrequency: 8, NetworkPassphrase: network.TestNetworkPassphrase},
+		backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 50),
+	)
+	require.NoError(t, err)
+
+	for checkpoint := uint32(7); checkpoint <= 15; checkpoint += 8 {
+		has, err := pool.GetCheckpointHAS(checkpoint)
+		require.NoError(t, err)
+		assert.Equal(t, checkpoint, has.CurrentLedger)
+	}
+	has, err := pool.GetRootHAS()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(15), has.CurrentLedger)
+	assert.NotZero(t, flaky.GetStats().ServerErrors)
+}
@@ -6,11 +6,16 @@ package historyarchive
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"path"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
 )
 
 func makeTicker(onTick func(uint)) chan bool {
@@ -34,11 +39,42 @@ func bufReadCloser(in io.ReadCloser) io.ReadCloser {
 	}{bufio.NewReader(in), in}
 }
 
-func copyPath(src *Archive, dst *Archive, pth string, opts *CommandOptions) error {
+// copyHooks extend copyPath, the zero value copies files unchanged.
+type copyHooks struct {
+	// beforeRequest, if set, is called before every request to either
+	// archive, e.g. to throttle them.
+	beforeRequest func()
+	// reader, if set, wraps the contents of the source file, e.g. to limit
+	// the bandwidth of the copy.
+	reader func(io.ReadCloser) io.ReadCloser
+	// verify reads the copy back from dst and checks that it matches the
+	// source.
+	verify bool
+}
+
+func (h copyHooks) request() {
+	if h.beforeRequest != nil {
+		h.beforeRequest()
+	}
+}
+
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	return n, err
+}
+
+func copyPath(src *Archive, dst *Archive, pth string, opts *CommandOptions, hooks copyHooks) error {
 	if opts.DryRun {
 		log.Printf("dryrun skipping %s", pth)
 		return nil
 	}
+	hooks.request()
 	exists, err := dst.backend.Exists(pth)
 	if err != nil {
 		return err
@@ -47,13 +83,39 @@ func copyPath(src *Archive, dst *Archive, pth string, opts *CommandOptions) erro
 		log.Printf("skipping existing %s", pth)
 		return nil
 	}
+	hooks.request()
 	rdr, err := src.backend.GetFile(pth)
 	if err != nil {
 		return err
 	}
 	defer rdr.Close()
-	err = dst.backend.PutFile(pth, bufReadCloser(rdr))
-	return err
+	if hooks.reader != nil {
+		rdr = hooks.reader(rdr)
+	}
+	if !hooks.verify {
+		hooks.request()
+		return dst.backend.PutFile(pth, bufReadCloser(rdr))
+	}
+	copied := &hashingReader{rdr, sha256.New()}
+	hooks.request()
+	if err = dst.backend.PutFile(pth, bufReadCloser(copied)); err != nil {
+		return err
+	}
+
+	hooks.request()
+	rdr, err = dst.backend.GetFile(pth)
+	if err != nil {
+		return errors.Wrapf(err, "error verifying copy of %s", pth)
+	}
+	defer rdr.Close()
+	stored := sha256.New()
+	if _, err = io.Copy(stored, bufReadCloser(rdr)); err != nil {
+		return errors.Wrapf(err, "error verifying copy of %s", pth)
+	}
+	if !bytes.Equal(stored.Sum(nil), copied.hash.Sum(nil)) {
+		return errors.Errorf("copy of %s does not match the source", pth)
+	}
+	return nil
 }
 
 func Categories() []string {
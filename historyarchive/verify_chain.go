@@ -0,0 +1,428 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// ChainVerificationOptions configures Archive.VerifyChain.
+type ChainVerificationOptions struct {
+	// Range is the range of ledgers to verify, it defaults to the whole
+	// archive.
+	Range Range
+	// Concurrency is the number of checkpoints verified in parallel.
+	Concurrency int
+	// StatePath is the file the progress of the verification is saved to
+	// after every checkpoint. If it exists, the verification resumes from
+	// the progress saved in it.
+	StatePath string
+}
+
+// InvalidItem is an item of an archive which failed verification.
+type InvalidItem struct {
+	Checkpoint uint32 `json:"checkpoint"`
+	// Category is the category of the checkpoint file the item belongs to:
+	// ledger, transactions or results.
+	Category string `json:"category"`
+	// Ledger is the ledger of the item, 0 if the item is a whole file.
+	Ledger   uint32 `json:"ledger,omitempty"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// ChainVerificationReport is the result of Archive.VerifyChain, it is also
+// the progress saved to ChainVerificationOptions.StatePath.
+type ChainVerificationReport struct {
+	Low  uint32 `json:"low"`
+	High uint32 `json:"high"`
+	// VerifiedThrough is the last checkpoint verified, all the checkpoints
+	// from Low to VerifiedThrough were verified. It is 0 if no checkpoint was
+	// verified.
+	VerifiedThrough uint32 `json:"verifiedThrough"`
+	// LastLedgerHash is the hash of the header of the VerifiedThrough ledger,
+	// which the next checkpoint is chained to.
+	LastLedgerHash string        `json:"lastLedgerHash,omitempty"`
+	Invalid        []InvalidItem `json:"invalid"`
+}
+
+// Complete returns true if the whole range was verified.
+func (r ChainVerificationReport) Complete() bool {
+	return r.VerifiedThrough >= r.High
+}
+
+// Valid returns true if no invalid item was found.
+func (r ChainVerificationReport) Valid() bool {
+	return len(r.Invalid) == 0
+}
+
+type checkpointVerification struct {
+	checkpoint uint32
+	// previousLedgerHash is the previous ledger hash of the first ledger of
+	// the checkpoint and lastLedgerHash the hash of its last ledger, they are
+	// zero if the headers are missing.
+	previousLedgerHash Hash
+	lastLedgerHash     Hash
+	invalid            []InvalidItem
+	err                error
+}
+
+// VerifyChain verifies the ledger, transactions and results files of the
+// checkpoints in the range: the hashes of the ledger headers and their chain,
+// from the first ledger of the range to the last, and the hashes of the
+// transaction sets and results against the ledger headers.
+//
+// The checkpoints are verified in parallel and chained in order. Unlike
+// Scan and ReportInvalid, VerifyChain does not keep the hashes of the whole
+// archive in memory and can resume an interrupted verification from
+// ChainVerificationOptions.StatePath.
+//
+// Invalid and missing items are reported, errors reading the archive stop the
+// verification and are returned with the report of the checkpoints verified
+// so far.
+func (arch *Archive) VerifyChain(ctx context.Context, opts ChainVerificationOptions) (ChainVerificationReport, error) {
+	if opts.Concurrency <= 0 {
+		return ChainVerificationReport{}, errors.New("Zero concurrency")
+	}
+	state, err := arch.GetRootHAS()
+	if err != nil {
+		return ChainVerificationReport{}, errors.Wrap(err, "error getting root HAS")
+	}
+	rng := opts.Range
+	if rng == (Range{}) {
+		rng = state.Range()
+	}
+	rng = rng.clamp(state.Range(), arch.checkpointManager)
+
+	report := ChainVerificationReport{Low: rng.Low, High: rng.High, Invalid: []InvalidItem{}}
+	if opts.StatePath != "" {
+		saved, ok, err := loadChainVerificationReport(opts.StatePath)
+		if err != nil {
+			return report, err
+		}
+		if ok {
+			if saved.Low != rng.Low {
+				return report, errors.Errorf(
+					"state %s starts at checkpoint %d, not %d", opts.StatePath, saved.Low, rng.Low,
+				)
+			}
+			report = saved
+			report.High = rng.High
+		}
+	}
+
+	frequency := arch.checkpointManager.GetCheckpointFrequency()
+	next := rng.Low
+	if report.VerifiedThrough != 0 {
+		next = report.VerifiedThrough + frequency
+	}
+	if next > rng.High {
+		return report, nil
+	}
+	log.Printf("Verifying ledger chain of checkpoints in range: %s", Range{Low: next, High: rng.High})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	checkpoints := make(chan uint32)
+	go func() {
+		defer close(checkpoints)
+		for chk := uint64(next); chk <= uint64(rng.High); chk += uint64(frequency) {
+			select {
+			case checkpoints <- uint32(chk):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan checkpointVerification)
+	var wg sync.WaitGroup
+	wg.Add(opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for chk := range checkpoints {
+				result := arch.verifyCheckpointChain(chk)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// checkpoints are verified out of order but chained in order
+	pending := map[uint32]checkpointVerification{}
+	for result := range results {
+		if result.err != nil {
+			return report, errors.Wrapf(result.err, "error verifying checkpoint %d", result.checkpoint)
+		}
+		pending[result.checkpoint] = result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			report.chain(result, arch.checkpointManager)
+			if opts.StatePath != "" {
+				if err := saveChainVerificationReport(opts.StatePath, report); err != nil {
+					return report, err
+				}
+			}
+			next += frequency
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// chain adds the verification of the checkpoint following VerifiedThrough to
+// the report.
+func (r *ChainVerificationReport) chain(result checkpointVerification, manager CheckpointManager) {
+	r.Invalid = append(r.Invalid, result.invalid...)
+	if r.LastLedgerHash != "" && !result.previousLedgerHash.IsZero() &&
+		result.previousLedgerHash.String() != r.LastLedgerHash {
+		r.Invalid = append(r.Invalid, InvalidItem{
+			Checkpoint: result.checkpoint,
+			Category:   "ledger",
+			Ledger:     manager.GetCheckpointRange(result.checkpoint).Low,
+			Reason:     "previous ledger hash does not match the previous checkpoint",
+			Expected:   r.LastLedgerHash,
+			Actual:     result.previousLedgerHash.String(),
+		})
+	}
+	r.VerifiedThrough = result.checkpoint
+	r.LastLedgerHash = ""
+	if !result.lastLedgerHash.IsZero() {
+		r.LastLedgerHash = result.lastLedgerHash.String()
+	}
+}
+
+func (arch *Archive) verifyCheckpointChain(chk uint32) checkpointVerification {
+	result := checkpointVerification{checkpoint: chk}
+	invalid := func(category string, ledger uint32, reason string, expected, actual Hash) {
+		item := InvalidItem{Checkpoint: chk, Category: category, Ledger: ledger, Reason: reason}
+		if !expected.IsZero() || !actual.IsZero() {
+			item.Expected, item.Actual = expected.String(), actual.String()
+		}
+		result.invalid = append(result.invalid, item)
+	}
+	rng := arch.checkpointManager.GetCheckpointRange(chk)
+
+	headers := map[uint32]xdr.LedgerHeaderHistoryEntry{}
+	ok, err := readCategoryForVerification(arch, chk, "ledger", invalid, func(entry *xdr.LedgerHeaderHistoryEntry) error {
+		seq := uint32(entry.Header.LedgerSeq)
+		if !rng.InRange(seq) {
+			invalid("ledger", seq, "ledger is not in the checkpoint", Hash{}, Hash{})
+			return nil
+		}
+		h, err := xdr.HashXdr(&entry.Header)
+		if err != nil {
+			return err
+		}
+		if h != entry.Hash {
+			invalid("ledger", seq, "ledger header hash mismatch", Hash(entry.Hash), Hash(h))
+		}
+		headers[seq] = *entry
+		return nil
+	})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if !ok {
+		// without headers nothing else can be verified
+		return result
+	}
+	for seq := rng.Low; seq <= rng.High; seq++ {
+		header, ok := headers[seq]
+		if !ok {
+			invalid("ledger", seq, "missing ledger header", Hash{}, Hash{})
+			continue
+		}
+		if previous, ok := headers[seq-1]; ok && previous.Hash != header.Header.PreviousLedgerHash {
+			invalid("ledger", seq, "previous ledger hash mismatch",
+				Hash(previous.Hash), Hash(header.Header.PreviousLedgerHash))
+		}
+	}
+	if header, ok := headers[rng.Low]; ok {
+		result.previousLedgerHash = Hash(header.Header.PreviousLedgerHash)
+	}
+	if header, ok := headers[rng.High]; ok {
+		result.lastLedgerHash = Hash(header.Hash)
+	}
+
+	emptyResults := EmptyXdrArrayHash()
+	// ledgers without transactions have no entries in the transactions and
+	// results files
+	checkMissing := func(category string, found map[uint32]bool) {
+		for seq := rng.Low; seq <= rng.High; seq++ {
+			header, ok := headers[seq]
+			if ok && !found[seq] && Hash(header.Header.TxSetResultHash) != emptyResults {
+				invalid(category, seq, "missing "+category+" entry", Hash{}, Hash{})
+			}
+		}
+	}
+
+	found := map[uint32]bool{}
+	ok, err = readCategoryForVerification(arch, chk, "transactions", invalid, func(entry *xdr.TransactionHistoryEntry) error {
+		seq := uint32(entry.LedgerSeq)
+		header, ok := headers[seq]
+		if !ok {
+			invalid("transactions", seq, "transaction set of unknown ledger", Hash{}, Hash{})
+			return nil
+		}
+		found[seq] = true
+		h, err := transactionHistoryEntryHash(entry)
+		if err != nil {
+			return err
+		}
+		if h != Hash(header.Header.ScpValue.TxSetHash) {
+			invalid("transactions", seq, "transaction set hash mismatch", Hash(header.Header.ScpValue.TxSetHash), h)
+		}
+		return nil
+	})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if ok {
+		checkMissing("transactions", found)
+	}
+
+	found = map[uint32]bool{}
+	ok, err = readCategoryForVerification(arch, chk, "results", invalid, func(entry *xdr.TransactionHistoryResultEntry) error {
+		seq := uint32(entry.LedgerSeq)
+		header, ok := headers[seq]
+		if !ok {
+			invalid("results", seq, "transaction results of unknown ledger", Hash{}, Hash{})
+			return nil
+		}
+		found[seq] = true
+		h, err := xdr.HashXdr(&entry.TxResultSet)
+		if err != nil {
+			return err
+		}
+		if h != header.Header.TxSetResultHash {
+			invalid("results", seq, "transaction result set hash mismatch", Hash(header.Header.TxSetResultHash), Hash(h))
+		}
+		return nil
+	})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if ok {
+		checkMissing("results", found)
+	}
+	return result
+}
+
+// readCategoryForVerification calls fn for every entry of the category file
+// of the checkpoint. Missing and undecodable files are reported as invalid,
+// in which case ok is false.
+func readCategoryForVerification[T any, PT interface {
+	*T
+	xdr.DecoderFrom
+}](
+	arch *Archive,
+	chk uint32,
+	category string,
+	invalid func(category string, ledger uint32, reason string, expected, actual Hash),
+	fn func(PT) error,
+) (bool, error) {
+	pth := CategoryCheckpointPath(category, chk)
+	exists, err := arch.CategoryCheckpointExists(category, chk)
+	if err != nil {
+		return false, errors.Wrapf(err, "error checking if %s exists", pth)
+	}
+	if !exists {
+		invalid(category, 0, "missing file", Hash{}, Hash{})
+		return false, nil
+	}
+	file, err := arch.cachedGet(pth)
+	if err != nil {
+		return false, errors.Wrapf(err, "error opening %s", pth)
+	}
+	rdr, err := xdr.NewGzStream(file)
+	if err != nil {
+		file.Close()
+		invalid(category, 0, "undecodable file: "+err.Error(), Hash{}, Hash{})
+		return false, nil
+	}
+	defer rdr.Close()
+
+	for {
+		var entry T
+		if err := rdr.ReadOne(PT(&entry)); err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			invalid(category, 0, "undecodable file: "+err.Error(), Hash{}, Hash{})
+			return false, nil
+		}
+		if err := fn(&entry); err != nil {
+			return false, err
+		}
+	}
+}
+
+// transactionHistoryEntryHash returns the hash of the transaction set of the
+// entry, which is its generalized transaction set from protocol 20.
+func transactionHistoryEntryHash(entry *xdr.TransactionHistoryEntry) (Hash, error) {
+	if entry.Ext.V == 1 {
+		h, err := xdr.HashXdr(entry.Ext.MustGeneralizedTxSet())
+		return Hash(h), err
+	}
+	return HashTxSet(&entry.TxSet)
+}
+
+func loadChainVerificationReport(pth string) (ChainVerificationReport, bool, error) {
+	var report ChainVerificationReport
+	buf, err := os.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return report, false, nil
+	} else if err != nil {
+		return report, false, errors.Wrapf(err, "error reading state %s", pth)
+	}
+	if err := json.Unmarshal(buf, &report); err != nil {
+		return report, false, errors.Wrapf(err, "error decoding state %s", pth)
+	}
+	return report, true, nil
+}
+
+// saveChainVerificationReport atomically replaces the state file.
+func saveChainVerificationReport(pth string, report ChainVerificationReport) error {
+	buf, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding state")
+	}
+	tmp := pth + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return errors.Wrapf(err, "error writing state %s", tmp)
+	}
+	if err := os.Rename(tmp, pth); err != nil {
+		return errors.Wrapf(err, "error writing state %s", pth)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Stellar Development Foundation and contributors. Licensed
+// under the Apache License, Version 2.0. See the COPYING file at the root
+// of this distribution or at http://www.apache.org/licenses/LICENSE-2.0
+
+package historyarchive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// publishTestChain publishes the ledgers up to the given checkpoint to a new
+// archive and returns it with the published ledgers, every third ledger has
+// a transaction.
+func publishTestChain(t *testing.T, checkpoint uint32) (*Archive, []xdr.LedgerCloseMeta) {
+	archive, err := Connect("mock://test", ArchiveOptions{
+		CheckpointFrequency: 64,
+		NetworkPassphrase:   network.TestNetworkPassphrase,
+	})
+	require.NoError(t, err)
+	publisher := NewPublisher(archive, PublisherOptions{})
+
+	// ledgers[i] is ledger i, ledger 0 does not exist
+	ledgers := []xdr.LedgerCloseMeta{{}}
+	var previous xdr.LedgerHeaderHistoryEntry
+	for sequence := uint32(1); sequence <= checkpoint; sequence++ {
+		lcm := testLedgerCloseMeta(t, previous, sequence%3 == 0)
+		require.NoError(t, publisher.AddLedger(lcm))
+		ledgers = append(ledgers, lcm)
+		previous = lcm.LedgerHeaderHistoryEntry()
+	}
+	return archive, ledgers
+}
+
+func TestVerifyChain(t *testing.T) {
+	archive, ledgers := publishTestChain(t, 191)
+
+	_, err := archive.VerifyChain(context.Background(), ChainVerificationOptions{})
+	assert.EqualError(t, err, "Zero concurrency")
+
+	report, err := archive.VerifyChain(context.Background(), ChainVerificationOptions{Concurrency: 4})
+	require.NoError(t, err)
+	assert.True(t, report.Complete())
+	assert.True(t, report.Valid())
+	assert.Equal(t, ChainVerificationReport{
+		Low:             63,
+		High:            191,
+		VerifiedThrough: 191,
+		LastLedgerHash:  Hash(ledgers[191].LedgerHash()).String(),
+		Invalid:         []InvalidItem{},
+	}, report)
+}
+
+func TestVerifyChainInvalid(t *testing.T) {
+	archive, ledgers := publishTestChain(t, 191)
+	headers := func(low, high uint32) []xdrEntry {
+		var entries []xdrEntry
+		for seq := low; seq <= high; seq++ {
+			entries = append(entries, ledgers[seq].LedgerHeaderHistoryEntry())
+		}
+		return entries
+	}
+
+	// ledger 64 is replaced by a ledger of a fork
+	forked := testLedgerCloseMeta(t, xdr.LedgerHeaderHistoryEntry{Hash: xdr.Hash{1}, Header: xdr.LedgerHeader{LedgerSeq: 63}}, false)
+	writeCategoryFile(t, archive.backend, CategoryCheckpointPath("ledger", 127),
+		append([]xdrEntry{forked.LedgerHeaderHistoryEntry()}, headers(65, 127)...))
+
+	// ledger 150, which has a transaction, is missing
+	writeCategoryFile(t, archive.backend, CategoryCheckpointPath("ledger", 191),
+		append(headers(128, 149), headers(151, 191)...))
+
+	// the result of the transaction of ledger 66 is altered
+	var results []xdrEntry
+	for seq := uint32(66); seq <= 127; seq += 3 {
+		entry := xdr.TransactionHistoryResultEntry{LedgerSeq: xdr.Uint32(seq), TxResultSet: xdr.TransactionResultSet{
+			Results: []xdr.TransactionResultPair{ledgers[seq].TransactionResultPair(0)},
+		}}
+		if seq == 66 {
+			entry.TxResultSet.Results[0].Result.FeeCharged = 100
+		}
+		results = append(results, entry)
+	}
+	writeCategoryFile(t, archive.backend, CategoryCheckpointPath("results", 127), results)
+
+	require.NoError(t, archive.backend.PutFile(
+		CategoryCheckpointPath("transactions", 63), io.NopCloser(strings.NewReader("garbage")),
+	))
+
+	report, err := archive.VerifyChain(context.Background(), ChainVerificationOptions{Concurrency: 3})
+	require.NoError(t, err)
+	assert.True(t, report.Complete())
+	assert.False(t, report.Valid())
+
+	var items []string
+	for _, item := range report.Invalid {
+		items = append(items, fmt.Sprintf("%s %d %d %s", item.Category, item.Checkpoint, item.Ledger, item.Reason))
+	}
+	assert.Equal(t, []string{
+		"transactions 63 0 undecodable file: unexpected EOF",
+		"ledger 127 65 previous ledger hash mismatch",
+		"results 127 66 transaction result set hash mismatch",
+		"ledger 127 64 previous ledger hash does not match the previous checkpoint",
+		"ledger 191 150 missing ledger header",
+		"transactions 191 150 transaction set of unknown ledger",
+		"results 191 150 transaction results of unknown ledger",
+	}, items)
+
+	mismatch := report.Invalid[2]
+	assert.Equal(t, Hash(ledgers[66].LedgerHeaderHistoryEntry().Header.TxSetResultHash).String(), mismatch.Expected)
+	assert.NotEmpty(t, mismatch.Actual)
+}
+
+func TestVerifyChainResume(t *testing.T) {
+	archive, _ := publishTestChain(t, 191)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	report, err := archive.VerifyChain(context.Background(), ChainVerificationOptions{
+		Range:       Range{Low: 0, High: 127},
+		Concurrency: 2,
+		StatePath:   statePath,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(127), report.VerifiedThrough)
+	assert.True(t, report.Complete())
+
+	saved, ok, err := loadChainVerificationReport(statePath)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, report, saved)
+
+	// checkpoints which were already verified are not verified again
+	require.NoError(t, archive.backend.PutFile(
+		CategoryCheckpointPath("ledger", 63), io.NopCloser(strings.NewReader("garbage")),
+	))
+	report, err = archive.VerifyChain(context.Background(), ChainVerificationOptions{
+		Range:       Range{Low: 0, High: 191},
+		Concurrency: 2,
+		StatePath:   statePath,
+	})
+	require.NoError(t, err)
+	assert.True(t, report.Valid())
+	assert.Equal(t, uint32(191), report.VerifiedThrough)
+
+	_, err = archive.VerifyChain(context.Background(), ChainVerificationOptions{
+		Range:       Range{Low: 128, High: 191},
+		Concurrency: 2,
+		StatePath:   statePath,
+	})
+	assert.EqualError(t, err, "state "+statePath+" starts at checkpoint 63, not 127")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = archive.VerifyChain(ctx, ChainVerificationOptions{Concurrency: 2})
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,170 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"iter"
+	"sort"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// BucketListSnapshot is the live bucket list of a HistoryArchiveState, with
+// the archive its buckets are read from.
+type BucketListSnapshot struct {
+	Archive historyarchive.ArchiveInterface
+	HAS     historyarchive.HistoryArchiveState
+}
+
+// NewBucketListDiff returns an iterator over the differences between the
+// ledger entries of the given type in the live bucket lists of two
+// HistoryArchiveStates, which can come from the same or different archives,
+// e.g. the archives of two nodes or of two networks forked from the same
+// checkpoint. Buckets are streamed like in CheckpointChangeReader, with
+// shadowed and dead entries resolved.
+//
+// Every difference is a Change of the entry from the `from` bucket list to the
+// `to` bucket list:
+//   - LedgerEntryChangeTypeLedgerEntryCreated: the entry is only in `to`,
+//     Pre is nil,
+//   - LedgerEntryChangeTypeLedgerEntryRemoved: the entry is only in `from`,
+//     Post is nil,
+//   - LedgerEntryChangeTypeLedgerEntryUpdated: the entry is in both bucket
+//     lists but differs.
+//
+// The key of the entry is Change.LedgerKey(). The entries of the type in the
+// `from` bucket list are kept in memory while the `to` bucket list is
+// streamed. Additional options, e.g. WithFilter to select a subset of the
+// entries, are passed through to the underlying CheckpointChangeReaders.
+func NewBucketListDiff(
+	ctx context.Context,
+	from, to BucketListSnapshot,
+	entryType xdr.LedgerEntryType,
+	opts ...CheckpointReaderOption,
+) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+		// buckets are content addressed, identical bucket lists have the same
+		// entries
+		if from.HAS.CurrentBuckets == to.HAS.CurrentBuckets {
+			return
+		}
+
+		encodingBuffer := xdr.NewEncodingBuffer()
+		fromEntries := map[string][]byte{}
+		err := readBucketListEntries(ctx, from, entryType, opts, func(key string, entry xdr.LedgerEntry) error {
+			raw, err := encodingBuffer.MarshalBinary(&entry)
+			if err != nil {
+				return errors.Wrap(err, "error marshaling ledger entry")
+			}
+			fromEntries[key] = raw
+			return nil
+		})
+		if err != nil {
+			yield(Change{}, errors.Wrapf(err, "error reading bucket list of ledger %d", from.HAS.CurrentLedger))
+			return
+		}
+
+		stopped := errors.New("stopped")
+		err = readBucketListEntries(ctx, to, entryType, opts, func(key string, entry xdr.LedgerEntry) error {
+			change := Change{Type: entryType, Post: &entry}
+			if raw, ok := fromEntries[key]; !ok {
+				change.ChangeType = xdr.LedgerEntryChangeTypeLedgerEntryCreated
+			} else {
+				delete(fromEntries, key)
+				current, err := encodingBuffer.UnsafeMarshalBinary(&entry)
+				if err != nil {
+					return errors.Wrap(err, "error marshaling ledger entry")
+				}
+				if bytes.Equal(raw, current) {
+					return nil
+				}
+				var pre xdr.LedgerEntry
+				if err := xdr.SafeUnmarshal(raw, &pre); err != nil {
+					return errors.Wrap(err, "error unmarshaling ledger entry")
+				}
+				change.ChangeType = xdr.LedgerEntryChangeTypeLedgerEntryUpdated
+				change.Pre = &pre
+			}
+			if !yield(change, nil) {
+				return stopped
+			}
+			return nil
+		})
+		if err == stopped {
+			return
+		} else if err != nil {
+			yield(Change{}, errors.Wrapf(err, "error reading bucket list of ledger %d", to.HAS.CurrentLedger))
+			return
+		}
+
+		// the removed entries are sorted by key to have a stable order
+		removed := make([]string, 0, len(fromEntries))
+		for key := range fromEntries {
+			removed = append(removed, key)
+		}
+		sort.Strings(removed)
+		for _, key := range removed {
+			var pre xdr.LedgerEntry
+			if err := xdr.SafeUnmarshal(fromEntries[key], &pre); err != nil {
+				yield(Change{}, errors.Wrap(err, "error unmarshaling ledger entry"))
+				return
+			}
+			change := Change{
+				Type:       entryType,
+				ChangeType: xdr.LedgerEntryChangeTypeLedgerEntryRemoved,
+				Pre:        &pre,
+			}
+			if !yield(change, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readBucketListEntries calls fn with the compressed key and the entry of
+// every ledger entry of the type in the live bucket list of the snapshot.
+func readBucketListEntries(
+	ctx context.Context,
+	snapshot BucketListSnapshot,
+	entryType xdr.LedgerEntryType,
+	opts []CheckpointReaderOption,
+	fn func(key string, entry xdr.LedgerEntry) error,
+) error {
+	reader := newCheckpointChangeReaderFromHAS(
+		ctx, snapshot.Archive, snapshot.HAS, snapshot.HAS.CurrentLedger, xdr.BucketListTypeLive, opts...,
+	)
+	defer reader.Close()
+
+	// the entry type is combined with the filter of the options
+	entryFilter, keyFilter := reader.ledgerEntryFilter, reader.ledgerKeyFilter
+	reader.ledgerEntryFilter = func(entry xdr.LedgerEntry) bool {
+		return entry.Data.Type == entryType && (entryFilter == nil || entryFilter(entry))
+	}
+	reader.ledgerKeyFilter = func(key xdr.LedgerKey) bool {
+		return key.Type == entryType && (keyFilter == nil || keyFilter(key))
+	}
+
+	encodingBuffer := xdr.NewEncodingBuffer()
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		key, err := change.Post.LedgerKey()
+		if err != nil {
+			return errors.Wrap(err, "error getting ledger key")
+		}
+		keyBytes, err := encodingBuffer.LedgerKeyUnsafeMarshalBinaryCompress(key)
+		if err != nil {
+			return errors.Wrap(err, "error marshaling ledger key")
+		}
+		if err := fn(string(keyBytes), *change.Post); err != nil {
+			return err
+		}
+	}
+}
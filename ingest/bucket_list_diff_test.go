@@ -0,0 +1,170 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+const (
+	diffAccountX = "GC3C4AKRBQLHOJ45U4XG35ESVWRDECWO5XLDGYADO6DPR3L7KIDVUMML"
+	diffAccountY = "GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z"
+	diffAccountZ = "GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON"
+	diffAccountW = "GCXKG6RN4ONIEPCMNFB732A436Z5PNDSRLGWK7GBLCMQLIFO4S7EYWVU"
+)
+
+// diffSnapshot returns a snapshot of a bucket list with a single level whose
+// curr and snap buckets have the given entries.
+func diffSnapshot(ledger uint32, curr, snap historyarchive.Hash, buckets map[historyarchive.Hash][]interface{}) BucketListSnapshot {
+	has := historyarchive.HistoryArchiveState{CurrentLedger: ledger}
+	zero := historyarchive.Hash{}.String()
+	for i := range has.CurrentBuckets {
+		has.CurrentBuckets[i].Curr = zero
+		has.CurrentBuckets[i].Snap = zero
+	}
+	has.CurrentBuckets[0].Curr = curr.String()
+	has.CurrentBuckets[0].Snap = snap.String()
+
+	archive := &historyarchive.MockArchive{}
+	for _, hash := range []historyarchive.Hash{curr, snap} {
+		archive.On("BucketExists", hash).Return(true, nil).Maybe()
+		archive.On("BucketSize", hash).Return(int64(100), nil).Maybe()
+		entries := buckets[hash]
+		// every read gets a new stream
+		call := archive.On("GetXdrStreamForHash", hash).Maybe()
+		call.Run(func(mock.Arguments) {
+			call.ReturnArguments = mock.Arguments{createXdrStream(entries...), nil}
+		})
+	}
+	return BucketListSnapshot{Archive: archive, HAS: has}
+}
+
+func TestBucketListDiff(t *testing.T) {
+	fromCurr, toCurr, snap := historyarchive.Hash{1}, historyarchive.Hash{2}, historyarchive.Hash{3}
+	offer := entryOffer(xdr.BucketEntryTypeLiveentry, diffAccountX, 1)
+	buckets := map[historyarchive.Hash][]interface{}{
+		fromCurr: {
+			metaEntry(23),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountX, 1),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountY, 5),
+			offer,
+		},
+		toCurr: {
+			metaEntry(23),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountX, 1),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountY, 6),
+			entryAccount(xdr.BucketEntryTypeInitentry, diffAccountW, 7),
+			entryAccount(xdr.BucketEntryTypeDeadentry, diffAccountZ, 0),
+		},
+		// the bucket shared by both bucket lists
+		snap: {
+			metaEntry(23),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountZ, 2),
+		},
+	}
+	from := diffSnapshot(63, fromCurr, snap, buckets)
+	to := diffSnapshot(127, toCurr, snap, buckets)
+
+	var changes []Change
+	for change, err := range NewBucketListDiff(context.Background(), from, to, xdr.LedgerEntryTypeAccount, DisableBucketListValidation) {
+		require.NoError(t, err)
+		changes = append(changes, change)
+	}
+	require.Len(t, changes, 3)
+
+	assert.Equal(t, xdr.LedgerEntryChangeTypeLedgerEntryUpdated, changes[0].ChangeType)
+	assert.Equal(t, diffAccountY, changes[0].Pre.Data.MustAccount().AccountId.Address())
+	assert.Equal(t, xdr.Int64(5), changes[0].Pre.Data.MustAccount().Balance)
+	assert.Equal(t, xdr.Int64(6), changes[0].Post.Data.MustAccount().Balance)
+
+	assert.Equal(t, xdr.LedgerEntryChangeTypeLedgerEntryCreated, changes[1].ChangeType)
+	assert.Nil(t, changes[1].Pre)
+	assert.Equal(t, diffAccountW, changes[1].Post.Data.MustAccount().AccountId.Address())
+
+	// the account is removed by the dead entry shadowing the shared bucket
+	assert.Equal(t, xdr.LedgerEntryChangeTypeLedgerEntryRemoved, changes[2].ChangeType)
+	assert.Nil(t, changes[2].Post)
+	key, err := changes[2].LedgerKey()
+	require.NoError(t, err)
+	assert.Equal(t, diffAccountZ, key.MustAccount().AccountId.Address())
+	for _, change := range changes {
+		assert.Equal(t, xdr.LedgerEntryTypeAccount, change.Type)
+	}
+
+	// the entries of other types are not compared
+	changes = nil
+	for change, err := range NewBucketListDiff(context.Background(), from, to, xdr.LedgerEntryTypeOffer, DisableBucketListValidation) {
+		require.NoError(t, err)
+		changes = append(changes, change)
+	}
+	require.Len(t, changes, 1)
+	assert.Equal(t, xdr.LedgerEntryChangeTypeLedgerEntryRemoved, changes[0].ChangeType)
+	assert.Equal(t, *offer.LiveEntry, *changes[0].Pre)
+}
+
+func TestBucketListDiffFilter(t *testing.T) {
+	fromCurr, toCurr, snap := historyarchive.Hash{1}, historyarchive.Hash{2}, historyarchive.Hash{3}
+	buckets := map[historyarchive.Hash][]interface{}{
+		fromCurr: {metaEntry(23), entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountX, 1)},
+		toCurr: {
+			metaEntry(23),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountX, 2),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountY, 2),
+		},
+		snap: {metaEntry(23)},
+	}
+	from := diffSnapshot(63, fromCurr, snap, buckets)
+	to := diffSnapshot(127, toCurr, snap, buckets)
+
+	onlyY := WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			return entry.Data.MustAccount().AccountId.Address() == diffAccountY
+		},
+		nil,
+	)
+	var changes []Change
+	for change, err := range NewBucketListDiff(context.Background(), from, to, xdr.LedgerEntryTypeAccount, DisableBucketListValidation, onlyY) {
+		require.NoError(t, err)
+		changes = append(changes, change)
+	}
+	require.Len(t, changes, 1)
+	assert.Equal(t, xdr.LedgerEntryChangeTypeLedgerEntryCreated, changes[0].ChangeType)
+	assert.Equal(t, diffAccountY, changes[0].Post.Data.MustAccount().AccountId.Address())
+
+	// iteration can stop early
+	count := 0
+	for range NewBucketListDiff(context.Background(), from, to, xdr.LedgerEntryTypeAccount, DisableBucketListValidation) {
+		count++
+		break
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestBucketListDiffIdenticalBucketLists(t *testing.T) {
+	from := diffSnapshot(63, historyarchive.Hash{1}, historyarchive.Hash{2}, nil)
+	to := diffSnapshot(63, historyarchive.Hash{1}, historyarchive.Hash{2}, nil)
+	for range NewBucketListDiff(context.Background(), from, to, xdr.LedgerEntryTypeAccount) {
+		assert.Fail(t, "unexpected difference")
+	}
+	from.Archive.(*historyarchive.MockArchive).AssertNotCalled(t, "GetXdrStreamForHash", mock.Anything)
+}
+
+func TestBucketListDiffError(t *testing.T) {
+	from := diffSnapshot(63, historyarchive.Hash{1}, historyarchive.Hash{2}, nil)
+	to := BucketListSnapshot{Archive: &historyarchive.MockArchive{}, HAS: from.HAS}
+	to.HAS.CurrentBuckets[0].Curr = historyarchive.Hash{4}.String()
+	to.Archive.(*historyarchive.MockArchive).On("BucketExists", historyarchive.Hash{4}).Return(false, nil)
+
+	var errs []error
+	for _, err := range NewBucketListDiff(context.Background(), from, to, xdr.LedgerEntryTypeAccount, DisableBucketListValidation) {
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], "error reading bucket list of ledger 63: bucket hash does not exist: "+historyarchive.Hash{4}.String())
+}
@@ -189,6 +189,19 @@ func newCheckpointChangeReaderWithBucketList(
 		return nil, errors.Wrapf(err, "unable to get checkpoint HAS at ledger sequence %d", sequence)
 	}
 
+	return newCheckpointChangeReaderFromHAS(ctx, archive, has, sequence, bucketListType, opts...), nil
+}
+
+// newCheckpointChangeReaderFromHAS constructs a CheckpointChangeReader which
+// enumerates the ledger entries of the given HAS of the sequence.
+func newCheckpointChangeReaderFromHAS(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	has historyarchive.HistoryArchiveState,
+	sequence uint32,
+	bucketListType xdr.BucketListType,
+	opts ...CheckpointReaderOption,
+) *CheckpointChangeReader {
 	var cancel context.CancelCauseFunc
 	ctx, cancel = context.WithCancelCause(ctx)
 	r := &CheckpointChangeReader{
@@ -208,7 +221,7 @@ func newCheckpointChangeReaderWithBucketList(
 		opt(r)
 	}
 
-	return r, nil
+	return r
 }
 
 // VerifyBucketList verifies that the bucket list hash computed from the history archive snapshot
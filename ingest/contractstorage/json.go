@@ -0,0 +1,189 @@
+package contractstorage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+type entryJSON struct {
+	Key                   interface{} `json:"key"`
+	Value                 interface{} `json:"value"`
+	Durability            string      `json:"durability"`
+	LiveUntilLedgerSeq    uint32      `json:"live_until_ledger_seq,omitempty"`
+	Archived              bool        `json:"archived"`
+	LastModifiedLedgerSeq uint32      `json:"last_modified_ledger_seq"`
+}
+
+type storageJSON struct {
+	Contract string  `json:"contract"`
+	Ledger   uint32  `json:"ledger"`
+	Instance *Entry  `json:"instance"`
+	Entries  []Entry `json:"entries"`
+}
+
+// MarshalJSON encodes the entry with its key and value rendered as tagged
+// JSON values, e.g. {"symbol":"Balance"} or {"i128":"100"}.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	key, err := scValJSON(e.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode key")
+	}
+	value, err := scValJSON(e.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode value")
+	}
+	durability := "temporary"
+	if e.Durability == xdr.ContractDataDurabilityPersistent {
+		durability = "persistent"
+	}
+	return json.Marshal(entryJSON{
+		Key:                   key,
+		Value:                 value,
+		Durability:            durability,
+		LiveUntilLedgerSeq:    e.LiveUntilLedgerSeq,
+		Archived:              e.Archived,
+		LastModifiedLedgerSeq: e.LastModifiedLedgerSeq,
+	})
+}
+
+// MarshalJSON encodes the storage with the contract as a strkey.
+func (s Storage) MarshalJSON() ([]byte, error) {
+	contract, err := s.Contract.String()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode contract address")
+	}
+	entries := s.Entries
+	if entries == nil {
+		entries = []Entry{}
+	}
+	return json.Marshal(storageJSON{
+		Contract: contract,
+		Ledger:   s.Ledger,
+		Instance: s.Instance,
+		Entries:  entries,
+	})
+}
+
+type mapEntryJSON struct {
+	Key interface{} `json:"key"`
+	Val interface{} `json:"val"`
+}
+
+// scValJSON converts an ScVal to a value that encodes to JSON as an object
+// keyed by the value type. 64 bit and larger integers are encoded as strings
+// so they don't lose precision.
+func scValJSON(val xdr.ScVal) (interface{}, error) {
+	switch val.Type {
+	case xdr.ScValTypeScvBool:
+		return map[string]interface{}{"bool": val.MustB()}, nil
+	case xdr.ScValTypeScvVoid:
+		return "void", nil
+	case xdr.ScValTypeScvError:
+		return map[string]interface{}{"error": val.String()}, nil
+	case xdr.ScValTypeScvU32:
+		return map[string]interface{}{"u32": uint32(val.MustU32())}, nil
+	case xdr.ScValTypeScvI32:
+		return map[string]interface{}{"i32": int32(val.MustI32())}, nil
+	case xdr.ScValTypeScvU64:
+		return map[string]interface{}{"u64": strconv.FormatUint(uint64(val.MustU64()), 10)}, nil
+	case xdr.ScValTypeScvI64:
+		return map[string]interface{}{"i64": strconv.FormatInt(int64(val.MustI64()), 10)}, nil
+	case xdr.ScValTypeScvTimepoint:
+		return map[string]interface{}{"timepoint": strconv.FormatUint(uint64(val.MustTimepoint()), 10)}, nil
+	case xdr.ScValTypeScvDuration:
+		return map[string]interface{}{"duration": strconv.FormatUint(uint64(val.MustDuration()), 10)}, nil
+	case xdr.ScValTypeScvU128:
+		return map[string]interface{}{"u128": val.String()}, nil
+	case xdr.ScValTypeScvI128:
+		return map[string]interface{}{"i128": val.String()}, nil
+	case xdr.ScValTypeScvU256:
+		return map[string]interface{}{"u256": val.String()}, nil
+	case xdr.ScValTypeScvI256:
+		return map[string]interface{}{"i256": val.String()}, nil
+	case xdr.ScValTypeScvBytes:
+		return map[string]interface{}{"bytes": hex.EncodeToString(val.MustBytes())}, nil
+	case xdr.ScValTypeScvString:
+		return map[string]interface{}{"string": string(val.MustStr())}, nil
+	case xdr.ScValTypeScvSymbol:
+		return map[string]interface{}{"symbol": string(val.MustSym())}, nil
+	case xdr.ScValTypeScvVec:
+		vec := val.MustVec()
+		if vec == nil {
+			return map[string]interface{}{"vec": nil}, nil
+		}
+		items := make([]interface{}, 0, len(*vec))
+		for _, item := range *vec {
+			converted, err := scValJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, converted)
+		}
+		return map[string]interface{}{"vec": items}, nil
+	case xdr.ScValTypeScvMap:
+		m := val.MustMap()
+		if m == nil {
+			return map[string]interface{}{"map": nil}, nil
+		}
+		entries, err := scMapJSON(*m)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"map": entries}, nil
+	case xdr.ScValTypeScvAddress:
+		address, err := val.MustAddress().String()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"address": address}, nil
+	case xdr.ScValTypeScvContractInstance:
+		instance := val.MustInstance()
+		var executable interface{}
+		switch instance.Executable.Type {
+		case xdr.ContractExecutableTypeContractExecutableWasm:
+			executable = map[string]interface{}{"wasm": instance.Executable.MustWasmHash().HexString()}
+		case xdr.ContractExecutableTypeContractExecutableStellarAsset:
+			executable = "stellar_asset"
+		default:
+			return nil, errors.Errorf("unknown contract executable type %d", instance.Executable.Type)
+		}
+		var storage []mapEntryJSON
+		if instance.Storage != nil {
+			var err error
+			if storage, err = scMapJSON(*instance.Storage); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"contract_instance": map[string]interface{}{
+			"executable": executable,
+			"storage":    storage,
+		}}, nil
+	case xdr.ScValTypeScvLedgerKeyContractInstance:
+		return "ledger_key_contract_instance", nil
+	case xdr.ScValTypeScvLedgerKeyNonce:
+		return map[string]interface{}{"ledger_key_nonce": map[string]interface{}{
+			"nonce": strconv.FormatInt(int64(val.MustNonceKey().Nonce), 10),
+		}}, nil
+	}
+	return nil, errors.Errorf("unknown ScVal type %d", val.Type)
+}
+
+func scMapJSON(m xdr.ScMap) ([]mapEntryJSON, error) {
+	entries := make([]mapEntryJSON, 0, len(m))
+	for _, entry := range m {
+		key, err := scValJSON(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := scValJSON(entry.Val)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, mapEntryJSON{Key: key, Val: val})
+	}
+	return entries, nil
+}
@@ -0,0 +1,81 @@
+package contractstorage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func TestStorageJSON(t *testing.T) {
+	instance := instanceEntry()
+	storage := Storage{
+		Contract: contract,
+		Ledger:   63,
+		Instance: &Entry{
+			Key:                   instance.Data.ContractData.Key,
+			Value:                 instance.Data.ContractData.Val,
+			Durability:            xdr.ContractDataDurabilityPersistent,
+			LiveUntilLedgerSeq:    1000,
+			LastModifiedLedgerSeq: 10,
+		},
+		Entries: []Entry{{
+			Key:        symbol("nonce"),
+			Value:      u32(7),
+			Durability: xdr.ContractDataDurabilityTemporary,
+			Archived:   true,
+		}},
+	}
+	b, err := json.Marshal(storage)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"contract": "CAAQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABDQF",
+		"ledger": 63,
+		"instance": {
+			"key": "ledger_key_contract_instance",
+			"value": {"contract_instance": {
+				"executable": {"wasm": "aa00000000000000000000000000000000000000000000000000000000000000"},
+				"storage": [{"key": {"symbol": "admin"}, "val": {"address": "GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT"}}]
+			}},
+			"durability": "persistent",
+			"live_until_ledger_seq": 1000,
+			"archived": false,
+			"last_modified_ledger_seq": 10
+		},
+		"entries": [{
+			"key": {"symbol": "nonce"},
+			"value": {"u32": 7},
+			"durability": "temporary",
+			"archived": true,
+			"last_modified_ledger_seq": 0
+		}]
+	}`, string(b))
+}
+
+func TestScValJSON(t *testing.T) {
+	i128 := xdr.Int128Parts{Hi: -1, Lo: 0xffffffffffffff9c}
+	i64 := xdr.Int64(-5)
+	bytesVal := xdr.ScBytes{0xde, 0xad}
+	vec := &xdr.ScVec{u32(1), {Type: xdr.ScValTypeScvVoid}}
+	m := &xdr.ScMap{{Key: symbol("a"), Val: xdr.ScVal{Type: xdr.ScValTypeScvI128, I128: &i128}}}
+
+	for _, testCase := range []struct {
+		val      xdr.ScVal
+		expected string
+	}{
+		{xdr.ScVal{Type: xdr.ScValTypeScvI128, I128: &i128}, `{"i128":"-100"}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvI64, I64: &i64}, `{"i64":"-5"}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvBytes, Bytes: &bytesVal}, `{"bytes":"dead"}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvVec, Vec: &vec}, `{"vec":[{"u32":1},"void"]}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvMap, Map: &m}, `{"map":[{"key":{"symbol":"a"},"val":{"i128":"-100"}}]}`},
+	} {
+		converted, err := scValJSON(testCase.val)
+		require.NoError(t, err)
+		b, err := json.Marshal(converted)
+		require.NoError(t, err)
+		assert.JSONEq(t, testCase.expected, string(b))
+	}
+}
@@ -0,0 +1,408 @@
+// Package contractstorage reconstructs the storage of a single Soroban
+// contract as of an arbitrary ledger, using the checkpoint preceding that
+// ledger in the history archives and the ledger metadata after it.
+package contractstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"sort"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Config configures how the storage of a contract is reconstructed.
+type Config struct {
+	// Archive, required, is used to stream the live and hot archive bucket
+	// lists of the checkpoint preceding the target ledger.
+	Archive historyarchive.ArchiveInterface
+	// LedgerBackend, optional, provides the ledgers between the checkpoint and
+	// the target ledger. It is only required when the target ledger is not a
+	// checkpoint ledger.
+	LedgerBackend ledgerbackend.LedgerBackend
+	// NetworkPassphrase is required when LedgerBackend is set.
+	NetworkPassphrase string
+	// ReaderOptions are passed on to the checkpoint readers.
+	ReaderOptions []ingest.CheckpointReaderOption
+}
+
+// Entry is a ContractData entry of a contract.
+type Entry struct {
+	Key        xdr.ScVal
+	Value      xdr.ScVal
+	Durability xdr.ContractDataDurability
+	// LiveUntilLedgerSeq is the ledger until which the entry is live. It is
+	// zero for archived entries.
+	LiveUntilLedgerSeq uint32
+	// Archived is true if the entry was evicted to the hot archive and has
+	// not been restored since.
+	Archived              bool
+	LastModifiedLedgerSeq uint32
+	LedgerEntry           xdr.LedgerEntry
+}
+
+// Expired returns true if the entry is not archived but its TTL has run out
+// as of the given ledger. Expired entries are not accessible until they are
+// restored (persistent entries) or recreated (temporary entries).
+func (e Entry) Expired(ledger uint32) bool {
+	return !e.Archived && e.LiveUntilLedgerSeq < ledger
+}
+
+// Storage is the storage of a contract as of a given ledger.
+type Storage struct {
+	Contract xdr.ScAddress
+	Ledger   uint32
+	// Instance is the contract instance entry, nil if the contract does not
+	// exist as of Ledger.
+	Instance *Entry
+	// Entries are all the other ContractData entries of the contract, sorted
+	// by ledger key.
+	Entries []Entry
+}
+
+// InstanceStorage returns the instance storage of the contract.
+func (s Storage) InstanceStorage() xdr.ScMap {
+	if s.Instance == nil {
+		return nil
+	}
+	instance, ok := s.Instance.Value.GetInstance()
+	if !ok || instance.Storage == nil {
+		return nil
+	}
+	return *instance.Storage
+}
+
+type reconstruction struct {
+	contract       xdr.ScAddress
+	live           map[string]xdr.LedgerEntry
+	archived       map[string]xdr.LedgerEntry
+	ttls           map[xdr.Hash]uint32
+	encodingBuffer *xdr.EncodingBuffer
+}
+
+// Reconstruct returns the storage of the given contract as of the given
+// ledger. The ContractData entries of the contract are read from the
+// checkpoint preceding ledger, archived entries from the hot archive bucket
+// list of the same checkpoint, and the changes of the remaining ledgers (if
+// any) are applied on top using config.LedgerBackend.
+//
+// Note that TTL entries cannot be associated with a contract until all its
+// ContractData entries are known, so all the TTL entries of the checkpoint are
+// kept in memory while streaming it.
+func Reconstruct(ctx context.Context, config Config, contract xdr.ScAddress, ledger uint32) (Storage, error) {
+	if contract.Type != xdr.ScAddressTypeScAddressTypeContract {
+		return Storage{}, errors.Errorf("address type %s is not a contract", contract.Type)
+	}
+	if config.Archive == nil {
+		return Storage{}, errors.New("archive is required")
+	}
+
+	checkpoint := config.Archive.GetCheckpointManager().PrevCheckpoint(ledger)
+	if checkpoint > ledger {
+		return Storage{}, errors.Errorf("ledger %d precedes the first checkpoint %d", ledger, checkpoint)
+	}
+	if checkpoint < ledger && config.LedgerBackend == nil {
+		return Storage{}, errors.Errorf("ledger backend is required to reconstruct storage at non-checkpoint ledger %d", ledger)
+	}
+
+	r := &reconstruction{
+		contract:       contract,
+		live:           map[string]xdr.LedgerEntry{},
+		archived:       map[string]xdr.LedgerEntry{},
+		ttls:           map[xdr.Hash]uint32{},
+		encodingBuffer: xdr.NewEncodingBuffer(),
+	}
+	if err := r.readCheckpoint(ctx, config, checkpoint); err != nil {
+		return Storage{}, err
+	}
+	if err := r.prune(); err != nil {
+		return Storage{}, err
+	}
+	if checkpoint < ledger {
+		if err := r.applyLedgers(ctx, config, checkpoint+1, ledger); err != nil {
+			return Storage{}, err
+		}
+	}
+	return r.storage(ledger)
+}
+
+func (r *reconstruction) owns(entry xdr.LedgerEntry) bool {
+	data, ok := entry.Data.GetContractData()
+	return ok && data.Contract.Equals(r.contract)
+}
+
+func (r *reconstruction) ownsKey(key xdr.LedgerKey) bool {
+	data, ok := key.GetContractData()
+	return ok && data.Contract.Equals(r.contract)
+}
+
+func (r *reconstruction) key(key xdr.LedgerKey) (string, error) {
+	b, err := r.encodingBuffer.LedgerKeyUnsafeMarshalBinaryCompress(key)
+	if err != nil {
+		return "", errors.Wrap(err, "could not marshal ledger key")
+	}
+	return string(b), nil
+}
+
+func (r *reconstruction) readCheckpoint(ctx context.Context, config Config, checkpoint uint32) error {
+	opts := append([]ingest.CheckpointReaderOption{}, config.ReaderOptions...)
+	opts = append(opts, ingest.WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			return entry.Data.Type == xdr.LedgerEntryTypeTtl || r.owns(entry)
+		},
+		func(key xdr.LedgerKey) bool {
+			return key.Type == xdr.LedgerEntryTypeTtl || r.ownsKey(key)
+		},
+	))
+
+	reader, err := ingest.NewCheckpointChangeReader(ctx, config.Archive, checkpoint, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "could not create checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not read checkpoint %d", checkpoint)
+		}
+		entry := *change.Post
+		if ttl, ok := entry.Data.GetTtl(); ok {
+			r.ttls[ttl.KeyHash] = uint32(ttl.LiveUntilLedgerSeq)
+			continue
+		}
+		key, err := r.entryKey(entry)
+		if err != nil {
+			return err
+		}
+		r.live[key] = entry
+	}
+
+	has, err := config.Archive.GetCheckpointHAS(checkpoint)
+	if err != nil {
+		return errors.Wrapf(err, "could not get history archive state for ledger %d", checkpoint)
+	}
+	if has.Version < historyarchive.HistoryArchiveStateVersionForProtocol23 {
+		return nil
+	}
+	for entry, err := range ingest.NewHotArchiveIterator(ctx, config.Archive, checkpoint, opts...) {
+		if err != nil {
+			return errors.Wrapf(err, "could not read hot archive of checkpoint %d", checkpoint)
+		}
+		if !r.owns(entry) {
+			continue
+		}
+		key, err := r.entryKey(entry)
+		if err != nil {
+			return err
+		}
+		r.archived[key] = entry
+	}
+	return nil
+}
+
+func (r *reconstruction) entryKey(entry xdr.LedgerEntry) (string, error) {
+	key, err := entry.LedgerKey()
+	if err != nil {
+		return "", errors.Wrap(err, "could not get ledger key")
+	}
+	return r.key(key)
+}
+
+// prune drops the TTL entries which don't belong to the live entries of the
+// contract.
+func (r *reconstruction) prune() error {
+	ttls := map[xdr.Hash]uint32{}
+	for _, entry := range r.live {
+		keyHash, err := ledgerKeyHash(entry)
+		if err != nil {
+			return err
+		}
+		if liveUntil, ok := r.ttls[keyHash]; ok {
+			ttls[keyHash] = liveUntil
+		}
+	}
+	r.ttls = ttls
+	return nil
+}
+
+func (r *reconstruction) applyLedgers(ctx context.Context, config Config, from, to uint32) error {
+	if err := config.LedgerBackend.PrepareRange(ctx, ledgerbackend.BoundedRange(from, to)); err != nil {
+		return errors.Wrapf(err, "could not prepare range [%d, %d]", from, to)
+	}
+	for sequence := from; sequence <= to; sequence++ {
+		ledger, err := config.LedgerBackend.GetLedger(ctx, sequence)
+		if err != nil {
+			return errors.Wrapf(err, "could not get ledger %d", sequence)
+		}
+		if err := r.applyLedger(config.NetworkPassphrase, ledger); err != nil {
+			return errors.Wrapf(err, "could not apply ledger %d", sequence)
+		}
+	}
+	return nil
+}
+
+func (r *reconstruction) applyLedger(networkPassphrase string, ledger xdr.LedgerCloseMeta) error {
+	reader, err := ingest.NewLedgerChangeReaderFromLedgerCloseMeta(networkPassphrase, ledger)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.applyChange(change); err != nil {
+			return err
+		}
+	}
+
+	evicted, err := ledger.EvictedLedgerKeys()
+	if err != nil {
+		return errors.Wrap(err, "could not get evicted ledger keys")
+	}
+	for _, key := range evicted {
+		switch {
+		case key.Type == xdr.LedgerEntryTypeTtl:
+			delete(r.ttls, key.MustTtl().KeyHash)
+		case r.ownsKey(key):
+			k, err := r.key(key)
+			if err != nil {
+				return err
+			}
+			entry, ok := r.live[k]
+			if !ok {
+				continue
+			}
+			delete(r.live, k)
+			// temporary entries are deleted on eviction while persistent
+			// entries are moved to the hot archive
+			if entry.Data.MustContractData().Durability == xdr.ContractDataDurabilityPersistent {
+				r.archived[k] = entry
+			}
+		}
+	}
+	return nil
+}
+
+func (r *reconstruction) applyChange(change ingest.Change) error {
+	switch change.Type {
+	case xdr.LedgerEntryTypeTtl:
+		if change.Post != nil {
+			ttl := change.Post.Data.MustTtl()
+			r.ttls[ttl.KeyHash] = uint32(ttl.LiveUntilLedgerSeq)
+		} else {
+			delete(r.ttls, change.Pre.Data.MustTtl().KeyHash)
+		}
+	case xdr.LedgerEntryTypeContractData:
+		ledgerKey, err := change.LedgerKey()
+		if err != nil {
+			return errors.Wrap(err, "could not get ledger key")
+		}
+		if !r.ownsKey(ledgerKey) {
+			return nil
+		}
+		key, err := r.key(ledgerKey)
+		if err != nil {
+			return err
+		}
+		if change.Post != nil {
+			r.live[key] = *change.Post
+			delete(r.archived, key)
+		} else {
+			delete(r.live, key)
+		}
+	}
+	return nil
+}
+
+func (r *reconstruction) storage(ledger uint32) (Storage, error) {
+	storage := Storage{Contract: r.contract, Ledger: ledger}
+	type sortableEntry struct {
+		key   []byte
+		entry Entry
+	}
+	var entries []sortableEntry
+	add := func(ledgerEntry xdr.LedgerEntry, archived bool) error {
+		data := ledgerEntry.Data.MustContractData()
+		entry := Entry{
+			Key:                   data.Key,
+			Value:                 data.Val,
+			Durability:            data.Durability,
+			Archived:              archived,
+			LastModifiedLedgerSeq: uint32(ledgerEntry.LastModifiedLedgerSeq),
+			LedgerEntry:           ledgerEntry,
+		}
+		if !archived {
+			keyHash, err := ledgerKeyHash(ledgerEntry)
+			if err != nil {
+				return err
+			}
+			entry.LiveUntilLedgerSeq = r.ttls[keyHash]
+		}
+		if data.Key.Type == xdr.ScValTypeScvLedgerKeyContractInstance {
+			storage.Instance = &entry
+			return nil
+		}
+		key, err := ledgerKeyBytes(ledgerEntry)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, sortableEntry{key: key, entry: entry})
+		return nil
+	}
+
+	for _, entry := range r.live {
+		if err := add(entry, false); err != nil {
+			return Storage{}, err
+		}
+	}
+	for _, entry := range r.archived {
+		if err := add(entry, true); err != nil {
+			return Storage{}, err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	for _, entry := range entries {
+		storage.Entries = append(storage.Entries, entry.entry)
+	}
+	return storage, nil
+}
+
+func ledgerKeyBytes(entry xdr.LedgerEntry) ([]byte, error) {
+	key, err := entry.LedgerKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get ledger key")
+	}
+	b, err := key.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal ledger key")
+	}
+	return b, nil
+}
+
+// ledgerKeyHash returns the key hash of the TTL entry associated with the
+// given ledger entry.
+func ledgerKeyHash(entry xdr.LedgerEntry) (xdr.Hash, error) {
+	b, err := ledgerKeyBytes(entry)
+	if err != nil {
+		return xdr.Hash{}, err
+	}
+	return sha256.Sum256(b), nil
+}
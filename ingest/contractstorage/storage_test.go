@@ -0,0 +1,285 @@
+package contractstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	contract      = contractAddress(1)
+	otherContract = contractAddress(2)
+	adminAddress  = xdr.MustAddress("GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT")
+)
+
+func contractAddress(b byte) xdr.ScAddress {
+	id := xdr.ContractId{b}
+	return xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &id}
+}
+
+func symbol(s string) xdr.ScVal {
+	sym := xdr.ScSymbol(s)
+	return xdr.ScVal{Type: xdr.ScValTypeScvSymbol, Sym: &sym}
+}
+
+func u32(v uint32) xdr.ScVal {
+	u := xdr.Uint32(v)
+	return xdr.ScVal{Type: xdr.ScValTypeScvU32, U32: &u}
+}
+
+func contractDataEntry(address xdr.ScAddress, key, val xdr.ScVal, durability xdr.ContractDataDurability, lastModified uint32) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		LastModifiedLedgerSeq: xdr.Uint32(lastModified),
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeContractData,
+			ContractData: &xdr.ContractDataEntry{
+				Contract:   address,
+				Key:        key,
+				Durability: durability,
+				Val:        val,
+			},
+		},
+	}
+}
+
+func instanceEntry() xdr.LedgerEntry {
+	wasmHash := xdr.Hash{0xaa}
+	storage := xdr.ScMap{{
+		Key: symbol("admin"),
+		Val: xdr.ScVal{
+			Type:    xdr.ScValTypeScvAddress,
+			Address: &xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeAccount, AccountId: &adminAddress},
+		},
+	}}
+	return contractDataEntry(
+		contract,
+		xdr.ScVal{Type: xdr.ScValTypeScvLedgerKeyContractInstance},
+		xdr.ScVal{
+			Type: xdr.ScValTypeScvContractInstance,
+			Instance: &xdr.ScContractInstance{
+				Executable: xdr.ContractExecutable{
+					Type:     xdr.ContractExecutableTypeContractExecutableWasm,
+					WasmHash: &wasmHash,
+				},
+				Storage: &storage,
+			},
+		},
+		xdr.ContractDataDurabilityPersistent,
+		10,
+	)
+}
+
+func ledgerKey(entry xdr.LedgerEntry) xdr.LedgerKey {
+	key, err := entry.LedgerKey()
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func ttlEntry(entry xdr.LedgerEntry, liveUntil uint32) xdr.LedgerEntry {
+	keyHash, err := ledgerKeyHash(entry)
+	if err != nil {
+		panic(err)
+	}
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeTtl,
+			Ttl:  &xdr.TtlEntry{KeyHash: keyHash, LiveUntilLedgerSeq: xdr.Uint32(liveUntil)},
+		},
+	}
+}
+
+func liveEntry(entry xdr.LedgerEntry) xdr.BucketEntry {
+	return xdr.BucketEntry{Type: xdr.BucketEntryTypeLiveentry, LiveEntry: &entry}
+}
+
+func archivedEntry(entry xdr.LedgerEntry) xdr.HotArchiveBucketEntry {
+	return xdr.HotArchiveBucketEntry{Type: xdr.HotArchiveBucketEntryTypeHotArchiveArchived, ArchivedEntry: &entry}
+}
+
+func xdrStream(entries ...interface{}) *xdr.Stream {
+	b := &bytes.Buffer{}
+	for _, e := range entries {
+		if err := xdr.MarshalFramed(b, e); err != nil {
+			panic(err)
+		}
+	}
+	return xdr.NewStream(io.NopCloser(b))
+}
+
+var (
+	balance     = contractDataEntry(contract, symbol("balance"), u32(100), xdr.ContractDataDurabilityPersistent, 20)
+	config      = contractDataEntry(contract, symbol("config"), u32(1), xdr.ContractDataDurabilityPersistent, 21)
+	nonce       = contractDataEntry(contract, symbol("nonce"), u32(7), xdr.ContractDataDurabilityTemporary, 30)
+	archived    = contractDataEntry(contract, symbol("archived"), u32(3), xdr.ContractDataDurabilityPersistent, 5)
+	otherEntry  = contractDataEntry(otherContract, symbol("balance"), u32(5), xdr.ContractDataDurabilityPersistent, 40)
+	liveBucket  = historyarchive.Hash{1}
+	hotBucket   = historyarchive.Hash{2}
+	hotArchived = contractDataEntry(otherContract, symbol("archived"), u32(4), xdr.ContractDataDurabilityPersistent, 6)
+)
+
+func mockCheckpoint() *historyarchive.MockArchive {
+	zero := historyarchive.Hash{}.String()
+	has := historyarchive.HistoryArchiveState{
+		Version:       historyarchive.HistoryArchiveStateVersionForProtocol23,
+		CurrentLedger: 63,
+	}
+	for i := range has.CurrentBuckets {
+		has.CurrentBuckets[i].Curr = zero
+		has.CurrentBuckets[i].Snap = zero
+		has.HotArchiveBuckets[i].Curr = zero
+		has.HotArchiveBuckets[i].Snap = zero
+	}
+	has.CurrentBuckets[0].Curr = liveBucket.String()
+	has.HotArchiveBuckets[0].Curr = hotBucket.String()
+
+	hotArchiveType := xdr.BucketListTypeHotArchive
+	archive := &historyarchive.MockArchive{}
+	archive.On("GetCheckpointManager").
+		Return(historyarchive.NewCheckpointManager(historyarchive.DefaultCheckpointFrequency))
+	archive.On("GetCheckpointHAS", uint32(63)).Return(has, nil)
+	for _, hash := range []historyarchive.Hash{liveBucket, hotBucket} {
+		archive.On("BucketExists", hash).Return(true, nil)
+		archive.On("BucketSize", hash).Return(int64(100), nil)
+	}
+	archive.On("GetXdrStreamForHash", liveBucket).Return(xdrStream(
+		xdr.BucketEntry{Type: xdr.BucketEntryTypeMetaentry, MetaEntry: &xdr.BucketMetadata{LedgerVersion: 23}},
+		liveEntry(instanceEntry()),
+		liveEntry(ttlEntry(instanceEntry(), 1000)),
+		liveEntry(balance),
+		liveEntry(ttlEntry(balance, 500)),
+		liveEntry(config),
+		liveEntry(ttlEntry(config, 64)),
+		liveEntry(nonce),
+		liveEntry(ttlEntry(nonce, 70)),
+		liveEntry(otherEntry),
+		liveEntry(ttlEntry(otherEntry, 2000)),
+	), nil).Once()
+	archive.On("GetXdrStreamForHash", hotBucket).Return(xdrStream(
+		xdr.HotArchiveBucketEntry{
+			Type: xdr.HotArchiveBucketEntryTypeHotArchiveMetaentry,
+			MetaEntry: &xdr.BucketMetadata{
+				LedgerVersion: 23,
+				Ext:           xdr.BucketMetadataExt{V: 1, BucketListType: &hotArchiveType},
+			},
+		},
+		archivedEntry(archived),
+		archivedEntry(hotArchived),
+	), nil).Once()
+	return archive
+}
+
+func TestReconstructCheckpoint(t *testing.T) {
+	archive := mockCheckpoint()
+	storage, err := Reconstruct(context.Background(), Config{
+		Archive:       archive,
+		ReaderOptions: []ingest.CheckpointReaderOption{ingest.DisableBucketListValidation},
+	}, contract, 63)
+	require.NoError(t, err)
+	archive.AssertExpectations(t)
+
+	assert.Equal(t, contract, storage.Contract)
+	assert.Equal(t, uint32(63), storage.Ledger)
+	require.NotNil(t, storage.Instance)
+	assert.Equal(t, uint32(1000), storage.Instance.LiveUntilLedgerSeq)
+	assert.Equal(t, uint32(10), storage.Instance.LastModifiedLedgerSeq)
+	require.Len(t, storage.InstanceStorage(), 1)
+	assert.Equal(t, "admin", storage.InstanceStorage()[0].Key.String())
+
+	require.Len(t, storage.Entries, 4)
+	byKey := map[string]Entry{}
+	for _, entry := range storage.Entries {
+		byKey[entry.Key.String()] = entry
+	}
+	assert.Equal(t, uint32(500), byKey["balance"].LiveUntilLedgerSeq)
+	assert.Equal(t, uint32(64), byKey["config"].LiveUntilLedgerSeq)
+	assert.Equal(t, uint32(70), byKey["nonce"].LiveUntilLedgerSeq)
+	assert.Equal(t, xdr.ContractDataDurabilityTemporary, byKey["nonce"].Durability)
+	assert.True(t, byKey["archived"].Archived)
+	assert.Zero(t, byKey["archived"].LiveUntilLedgerSeq)
+	assert.False(t, byKey["archived"].Expired(63))
+	assert.False(t, byKey["config"].Expired(64))
+	assert.True(t, byKey["config"].Expired(65))
+}
+
+func TestReconstructAppliesLedgers(t *testing.T) {
+	archive := mockCheckpoint()
+	ctx := context.Background()
+
+	updatedBalance := contractDataEntry(contract, symbol("balance"), u32(150), xdr.ContractDataDurabilityPersistent, 64)
+	restored := archived
+	restored.LastModifiedLedgerSeq = 65
+	newEntry := contractDataEntry(contract, symbol("new"), u32(9), xdr.ContractDataDurabilityTemporary, 65)
+	ttl := ttlEntry(newEntry, 80)
+
+	backend := &ledgerbackend.MockDatabaseBackend{}
+	backend.On("PrepareRange", ctx, ledgerbackend.BoundedRange(64, 65)).Return(nil).Once()
+	backend.On("GetLedger", ctx, uint32(64)).Return(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &balance},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated, Updated: &updatedBalance},
+		// a change to another contract must be ignored
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &hotArchived},
+	), nil).Once()
+	backend.On("GetLedger", ctx, uint32(65)).Return(ingesttest.LedgerWithUpgradeChanges(65,
+		[]xdr.LedgerKey{ledgerKey(config), ledgerKey(ttlEntry(config, 64)), ledgerKey(nonce)},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryRestored, Restored: &restored},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &newEntry},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &ttl},
+	), nil).Once()
+
+	storage, err := Reconstruct(ctx, Config{
+		Archive:           archive,
+		LedgerBackend:     backend,
+		NetworkPassphrase: network.TestNetworkPassphrase,
+		ReaderOptions:     []ingest.CheckpointReaderOption{ingest.DisableBucketListValidation},
+	}, contract, 65)
+	require.NoError(t, err)
+	archive.AssertExpectations(t)
+	backend.AssertExpectations(t)
+
+	var keys []string
+	byKey := map[string]Entry{}
+	for _, entry := range storage.Entries {
+		keys = append(keys, entry.Key.String())
+		byKey[entry.Key.String()] = entry
+	}
+	assert.ElementsMatch(t, []string{"balance", "config", "archived", "new"}, keys)
+	assert.Equal(t, "150", byKey["balance"].Value.String())
+	assert.Equal(t, uint32(500), byKey["balance"].LiveUntilLedgerSeq)
+	// evicted persistent entries are archived, evicted temporary entries are gone
+	assert.True(t, byKey["config"].Archived)
+	assert.False(t, byKey["archived"].Archived)
+	assert.Equal(t, uint32(65), byKey["archived"].LastModifiedLedgerSeq)
+	assert.Equal(t, uint32(80), byKey["new"].LiveUntilLedgerSeq)
+}
+
+func TestReconstructErrors(t *testing.T) {
+	archive := &historyarchive.MockArchive{}
+	archive.On("GetCheckpointManager").
+		Return(historyarchive.NewCheckpointManager(historyarchive.DefaultCheckpointFrequency))
+	ctx := context.Background()
+
+	_, err := Reconstruct(ctx, Config{Archive: archive}, xdr.ScAddress{
+		Type:      xdr.ScAddressTypeScAddressTypeAccount,
+		AccountId: &adminAddress,
+	}, 63)
+	assert.EqualError(t, err, "address type ScAddressTypeScAddressTypeAccount is not a contract")
+
+	_, err = Reconstruct(ctx, Config{Archive: archive}, contract, 10)
+	assert.EqualError(t, err, "ledger 10 precedes the first checkpoint 63")
+
+	_, err = Reconstruct(ctx, Config{Archive: archive}, contract, 70)
+	assert.EqualError(t, err, "ledger backend is required to reconstruct storage at non-checkpoint ledger 70")
+}
@@ -0,0 +1,51 @@
+package ingest
+
+import (
+	"context"
+	"io"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// CheckpointLiveStateStats returns the number of ledger entries by type of
+// the live ledger state of the checkpoint, and the XDR size of its contract
+// data and contract code entries. The checkpoint is streamed with a
+// CheckpointChangeReader, so unlike the bucket entries reported by
+// historyarchive.Archive.BucketStats, the entries updated or deleted in newer
+// buckets are only counted once, if at all. It can be used as
+// historyarchive.BucketStatsOptions.LiveState. Additional options are passed
+// through to the underlying CheckpointChangeReader.
+func CheckpointLiveStateStats(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...CheckpointReaderOption,
+) (historyarchive.LiveStateStats, error) {
+	stats := historyarchive.LiveStateStats{Entries: map[string]int64{}}
+	reader, err := NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return stats, errors.Wrapf(err, "error creating checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	encodingBuffer := xdr.NewEncodingBuffer()
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		stats.Entries[change.Type.String()]++
+		if change.Type == xdr.LedgerEntryTypeContractData || change.Type == xdr.LedgerEntryTypeContractCode {
+			raw, err := encodingBuffer.MarshalBinary(change.Post)
+			if err != nil {
+				return stats, errors.Wrap(err, "error marshaling ledger entry")
+			}
+			stats.SorobanBytes += int64(len(raw))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func TestCheckpointLiveStateStats(t *testing.T) {
+	curr, snap := historyarchive.Hash{1}, historyarchive.Hash{2}
+	key := xdr.Uint32(1)
+	contractData := xdr.LedgerEntry{Data: xdr.LedgerEntryData{
+		Type: xdr.LedgerEntryTypeContractData,
+		ContractData: &xdr.ContractDataEntry{
+			Contract:   xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &xdr.ContractId{1}},
+			Key:        xdr.ScVal{Type: xdr.ScValTypeScvU32, U32: &key},
+			Durability: xdr.ContractDataDurabilityPersistent,
+			Val:        xdr.ScVal{Type: xdr.ScValTypeScvU32, U32: &key},
+		},
+	}}
+	snapshot := diffSnapshot(127, curr, snap, map[historyarchive.Hash][]interface{}{
+		curr: {
+			metaEntry(23),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountX, 2),
+			entryAccount(xdr.BucketEntryTypeDeadentry, diffAccountZ, 0),
+			xdr.BucketEntry{Type: xdr.BucketEntryTypeInitentry, LiveEntry: &contractData},
+		},
+		// the account updated and the account deleted in the curr bucket
+		// are not counted
+		snap: {
+			metaEntry(23),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountX, 1),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountY, 1),
+			entryAccount(xdr.BucketEntryTypeLiveentry, diffAccountZ, 1),
+		},
+	})
+	archive := snapshot.Archive.(*historyarchive.MockArchive)
+	archive.On("GetCheckpointManager").
+		Return(historyarchive.NewCheckpointManager(historyarchive.DefaultCheckpointFrequency))
+	archive.On("GetCheckpointHAS", uint32(127)).Return(snapshot.HAS, nil)
+
+	stats, err := CheckpointLiveStateStats(context.Background(), archive, 127, DisableBucketListValidation)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"LedgerEntryTypeAccount":      2,
+		"LedgerEntryTypeContractData": 1,
+	}, stats.Entries)
+	contractDataXDR, err := contractData.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contractDataXDR)), stats.SorobanBytes)
+
+	_, err = CheckpointLiveStateStats(context.Background(), archive, 100, DisableBucketListValidation)
+	assert.ErrorContains(t, err, "error creating checkpoint reader for ledger 100")
+}
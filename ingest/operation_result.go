@@ -0,0 +1,482 @@
+package ingest
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/stellar/go-stellar-sdk/amount"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// OperationResultExplanation is a human readable explanation of the result of
+// an operation within a transaction.
+type OperationResultExplanation struct {
+	// Index is the 0-indexed position of the operation in the transaction.
+	Index      uint32
+	Type       xdr.OperationType
+	Successful bool
+	// Code is the result code of the operation in the format used by Horizon,
+	// e.g. "op_underfunded".
+	Code string
+	// Reason explains the result code in plain English.
+	Reason string
+	// OffersClaimed are the offers (or liquidity pools) the operation traded
+	// with. Only set for successful path payments and offer operations.
+	OffersClaimed []xdr.ClaimAtom
+	// ClaimableBalanceID is the strkey encoded ID of the claimable balance
+	// created, claimed or clawed back by the operation.
+	ClaimableBalanceID string
+	// Details are additional operation specific values, e.g. the ID of the
+	// offer created by a manage offer operation.
+	Details map[string]string
+	// DiagnosticEvents are the diagnostic events emitted by failed
+	// InvokeHostFunction operations. They usually contain the error which
+	// caused the contract invocation to fail.
+	DiagnosticEvents []xdr.DiagnosticEvent
+}
+
+type resultCodeExplanation struct {
+	code   string
+	reason string
+}
+
+// transactionResultExplanations maps transaction result codes to their
+// explanation.
+var transactionResultExplanations = map[xdr.TransactionResultCode]resultCodeExplanation{
+	xdr.TransactionResultCodeTxFeeBumpInnerSuccess: {"tx_fee_bump_inner_success", "the fee bump transaction and its inner transaction succeeded"},
+	xdr.TransactionResultCodeTxSuccess:             {"tx_success", "the transaction succeeded"},
+	xdr.TransactionResultCodeTxFailed:              {"tx_failed", "one of the operations failed, none of the operations were applied"},
+	xdr.TransactionResultCodeTxTooEarly:            {"tx_too_early", "the transaction was submitted before its time or ledger bounds"},
+	xdr.TransactionResultCodeTxTooLate:             {"tx_too_late", "the transaction was submitted after its time or ledger bounds"},
+	xdr.TransactionResultCodeTxMissingOperation:    {"tx_missing_operation", "the transaction has no operations"},
+	xdr.TransactionResultCodeTxBadSeq:              {"tx_bad_seq", "the sequence number of the transaction does not match the source account"},
+	xdr.TransactionResultCodeTxBadAuth:             {"tx_bad_auth", "the transaction does not have enough valid signatures or the network passphrase is wrong"},
+	xdr.TransactionResultCodeTxInsufficientBalance: {"tx_insufficient_balance", "paying the fee would bring the source account below its minimum balance"},
+	xdr.TransactionResultCodeTxNoAccount:           {"tx_no_source_account", "the source account does not exist"},
+	xdr.TransactionResultCodeTxInsufficientFee:     {"tx_insufficient_fee", "the fee is too low for the transaction to be included in the ledger"},
+	xdr.TransactionResultCodeTxBadAuthExtra:        {"tx_bad_auth_extra", "the transaction has unused signatures"},
+	xdr.TransactionResultCodeTxInternalError:       {"tx_internal_error", "an unknown error occurred in stellar-core"},
+	xdr.TransactionResultCodeTxNotSupported:        {"tx_not_supported", "the transaction type is not supported"},
+	xdr.TransactionResultCodeTxFeeBumpInnerFailed:  {"tx_fee_bump_inner_failed", "the inner transaction of the fee bump transaction failed"},
+	xdr.TransactionResultCodeTxBadSponsorship:      {"tx_bad_sponsorship", "a sponsorship was not ended with an EndSponsoringFutureReserves operation"},
+	xdr.TransactionResultCodeTxBadMinSeqAgeOrGap:   {"tx_bad_minseq_age_or_gap", "the minimum sequence age or ledger gap precondition was not met"},
+	xdr.TransactionResultCodeTxMalformed:           {"tx_malformed", "the transaction is malformed"},
+	xdr.TransactionResultCodeTxSorobanInvalid:      {"tx_soroban_invalid", "the Soroban resources or fees of the transaction are invalid"},
+}
+
+// operationResultExplanations maps the top level operation result codes,
+// other than opINNER, to their explanation.
+var operationResultExplanations = map[xdr.OperationResultCode]resultCodeExplanation{
+	xdr.OperationResultCodeOpBadAuth:           {"op_bad_auth", "the operation does not have enough valid signatures"},
+	xdr.OperationResultCodeOpNoAccount:         {"op_no_source_account", "the source account of the operation does not exist"},
+	xdr.OperationResultCodeOpNotSupported:      {"op_not_supported", "the operation is not supported by the protocol"},
+	xdr.OperationResultCodeOpTooManySubentries: {"op_too_many_subentries", "the source account has reached the maximum number of subentries"},
+	xdr.OperationResultCodeOpExceededWorkLimit: {"op_exceeded_work_limit", "the operation did too much work"},
+	xdr.OperationResultCodeOpTooManySponsoring: {"op_too_many_sponsoring", "the account is sponsoring too many entries"},
+	xdr.OperationResultCodeOpInner:             {"op_inner", "the operation was applied"},
+}
+
+// innerResultExplanations maps the XDR names of the result codes of every
+// operation type to their explanation.
+var innerResultExplanations = map[string]resultCodeExplanation{
+	// CreateAccount
+	"CreateAccountResultCodeCreateAccountSuccess":      {"op_success", "the account was created"},
+	"CreateAccountResultCodeCreateAccountMalformed":    {"op_malformed", "the destination is invalid or the starting balance is negative"},
+	"CreateAccountResultCodeCreateAccountUnderfunded":  {"op_underfunded", "the source account does not have enough XLM to fund the new account"},
+	"CreateAccountResultCodeCreateAccountLowReserve":   {"op_low_reserve", "the starting balance is lower than the minimum balance of an account"},
+	"CreateAccountResultCodeCreateAccountAlreadyExist": {"op_already_exists", "the destination account already exists"},
+
+	// Payment
+	"PaymentResultCodePaymentSuccess":          {"op_success", "the payment was sent"},
+	"PaymentResultCodePaymentMalformed":        {"op_malformed", "the amount is not positive or the asset is invalid"},
+	"PaymentResultCodePaymentUnderfunded":      {"op_underfunded", "the source account does not have enough funds to send the payment"},
+	"PaymentResultCodePaymentSrcNoTrust":       {"op_src_no_trust", "the source account does not have a trustline for the asset"},
+	"PaymentResultCodePaymentSrcNotAuthorized": {"op_src_not_authorized", "the source account is not authorized by the issuer to send the asset"},
+	"PaymentResultCodePaymentNoDestination":    {"op_no_destination", "the destination account does not exist"},
+	"PaymentResultCodePaymentNoTrust":          {"op_no_trust", "the destination account does not have a trustline for the asset"},
+	"PaymentResultCodePaymentNotAuthorized":    {"op_not_authorized", "the destination account is not authorized by the issuer to hold the asset"},
+	"PaymentResultCodePaymentLineFull":         {"op_line_full", "the payment would exceed the destination's trustline limit"},
+	"PaymentResultCodePaymentNoIssuer":         {"op_no_issuer", "the issuer of the asset does not exist"},
+
+	// PathPaymentStrictReceive
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveSuccess":          {"op_success", "the path payment was sent"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveMalformed":        {"op_malformed", "the amounts are not positive or an asset is invalid"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveUnderfunded":      {"op_underfunded", "the source account does not have enough funds to send the payment"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveSrcNoTrust":       {"op_src_no_trust", "the source account does not have a trustline for the send asset"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveSrcNotAuthorized": {"op_src_not_authorized", "the source account is not authorized by the issuer to send the asset"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveNoDestination":    {"op_no_destination", "the destination account does not exist"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveNoTrust":          {"op_no_trust", "the destination account does not have a trustline for the destination asset"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveNotAuthorized":    {"op_not_authorized", "the destination account is not authorized by the issuer to hold the asset"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveLineFull":         {"op_line_full", "the payment would exceed the destination's trustline limit"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveNoIssuer":         {"op_no_issuer", "the issuer of one of the assets does not exist"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveTooFewOffers":     {"op_too_few_offers", "there is not enough liquidity along the path to send the payment"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveOfferCrossSelf":   {"op_cross_self", "the payment would cross an offer of the source account"},
+	"PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveOverSendmax":      {"op_over_source_max", "receiving the destination amount would cost more than the maximum send amount"},
+
+	// PathPaymentStrictSend
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendSuccess":          {"op_success", "the path payment was sent"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendMalformed":        {"op_malformed", "the amounts are not positive or an asset is invalid"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendUnderfunded":      {"op_underfunded", "the source account does not have enough funds to send the payment"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendSrcNoTrust":       {"op_src_no_trust", "the source account does not have a trustline for the send asset"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendSrcNotAuthorized": {"op_src_not_authorized", "the source account is not authorized by the issuer to send the asset"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendNoDestination":    {"op_no_destination", "the destination account does not exist"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendNoTrust":          {"op_no_trust", "the destination account does not have a trustline for the destination asset"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendNotAuthorized":    {"op_not_authorized", "the destination account is not authorized by the issuer to hold the asset"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendLineFull":         {"op_line_full", "the payment would exceed the destination's trustline limit"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendNoIssuer":         {"op_no_issuer", "the issuer of one of the assets does not exist"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendTooFewOffers":     {"op_too_few_offers", "there is not enough liquidity along the path to send the payment"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendOfferCrossSelf":   {"op_cross_self", "the payment would cross an offer of the source account"},
+	"PathPaymentStrictSendResultCodePathPaymentStrictSendUnderDestmin":     {"op_under_dest_min", "the amount received would be less than the minimum destination amount"},
+
+	// ManageSellOffer and CreatePassiveSellOffer
+	"ManageSellOfferResultCodeManageSellOfferSuccess":           {"op_success", "the offer was applied"},
+	"ManageSellOfferResultCodeManageSellOfferMalformed":         {"op_malformed", "the assets, amount or price of the offer are invalid"},
+	"ManageSellOfferResultCodeManageSellOfferSellNoTrust":       {"op_sell_no_trust", "the source account does not have a trustline for the selling asset"},
+	"ManageSellOfferResultCodeManageSellOfferBuyNoTrust":        {"op_buy_no_trust", "the source account does not have a trustline for the buying asset"},
+	"ManageSellOfferResultCodeManageSellOfferSellNotAuthorized": {"op_sell_not_authorized", "the source account is not authorized to sell the selling asset"},
+	"ManageSellOfferResultCodeManageSellOfferBuyNotAuthorized":  {"op_buy_not_authorized", "the source account is not authorized to buy the buying asset"},
+	"ManageSellOfferResultCodeManageSellOfferLineFull":          {"op_line_full", "the source account cannot receive more of the buying asset"},
+	"ManageSellOfferResultCodeManageSellOfferUnderfunded":       {"op_underfunded", "the source account does not have enough of the selling asset"},
+	"ManageSellOfferResultCodeManageSellOfferCrossSelf":         {"op_cross_self", "the offer would cross an offer of the source account"},
+	"ManageSellOfferResultCodeManageSellOfferSellNoIssuer":      {"op_sell_no_issuer", "the issuer of the selling asset does not exist"},
+	"ManageSellOfferResultCodeManageSellOfferBuyNoIssuer":       {"op_buy_no_issuer", "the issuer of the buying asset does not exist"},
+	"ManageSellOfferResultCodeManageSellOfferNotFound":          {"op_offer_not_found", "the offer to update or delete does not exist"},
+	"ManageSellOfferResultCodeManageSellOfferLowReserve":        {"op_low_reserve", "the source account does not have enough XLM to create the offer"},
+	"ManageBuyOfferResultCodeManageBuyOfferSuccess":             {"op_success", "the offer was applied"},
+	"ManageBuyOfferResultCodeManageBuyOfferMalformed":           {"op_malformed", "the assets, amount or price of the offer are invalid"},
+	"ManageBuyOfferResultCodeManageBuyOfferSellNoTrust":         {"op_sell_no_trust", "the source account does not have a trustline for the selling asset"},
+	"ManageBuyOfferResultCodeManageBuyOfferBuyNoTrust":          {"op_buy_no_trust", "the source account does not have a trustline for the buying asset"},
+	"ManageBuyOfferResultCodeManageBuyOfferSellNotAuthorized":   {"op_sell_not_authorized", "the source account is not authorized to sell the selling asset"},
+	"ManageBuyOfferResultCodeManageBuyOfferBuyNotAuthorized":    {"op_buy_not_authorized", "the source account is not authorized to buy the buying asset"},
+	"ManageBuyOfferResultCodeManageBuyOfferLineFull":            {"op_line_full", "the source account cannot receive more of the buying asset"},
+	"ManageBuyOfferResultCodeManageBuyOfferUnderfunded":         {"op_underfunded", "the source account does not have enough of the selling asset"},
+	"ManageBuyOfferResultCodeManageBuyOfferCrossSelf":           {"op_cross_self", "the offer would cross an offer of the source account"},
+	"ManageBuyOfferResultCodeManageBuyOfferSellNoIssuer":        {"op_sell_no_issuer", "the issuer of the selling asset does not exist"},
+	"ManageBuyOfferResultCodeManageBuyOfferBuyNoIssuer":         {"op_buy_no_issuer", "the issuer of the buying asset does not exist"},
+	"ManageBuyOfferResultCodeManageBuyOfferNotFound":            {"op_offer_not_found", "the offer to update or delete does not exist"},
+	"ManageBuyOfferResultCodeManageBuyOfferLowReserve":          {"op_low_reserve", "the source account does not have enough XLM to create the offer"},
+
+	// SetOptions
+	"SetOptionsResultCodeSetOptionsSuccess":               {"op_success", "the account options were set"},
+	"SetOptionsResultCodeSetOptionsLowReserve":            {"op_low_reserve", "the source account does not have enough XLM to add a signer"},
+	"SetOptionsResultCodeSetOptionsTooManySigners":        {"op_too_many_signers", "the account already has the maximum number of signers"},
+	"SetOptionsResultCodeSetOptionsBadFlags":              {"op_bad_flags", "the same flag is both set and cleared"},
+	"SetOptionsResultCodeSetOptionsInvalidInflation":      {"op_invalid_inflation", "the inflation destination does not exist"},
+	"SetOptionsResultCodeSetOptionsCantChange":            {"op_cant_change", "the flags cannot be changed because the account is immutable"},
+	"SetOptionsResultCodeSetOptionsUnknownFlag":           {"op_unknown_flag", "one of the flags is unknown"},
+	"SetOptionsResultCodeSetOptionsThresholdOutOfRange":   {"op_threshold_out_of_range", "a threshold or signer weight is larger than 255"},
+	"SetOptionsResultCodeSetOptionsBadSigner":             {"op_bad_signer", "the signer is the master key of the account or is invalid"},
+	"SetOptionsResultCodeSetOptionsInvalidHomeDomain":     {"op_invalid_home_domain", "the home domain is malformed"},
+	"SetOptionsResultCodeSetOptionsAuthRevocableRequired": {"op_auth_revocable_required", "clawback can only be enabled on accounts with the auth revocable flag"},
+
+	// ChangeTrust
+	"ChangeTrustResultCodeChangeTrustSuccess":                    {"op_success", "the trustline was changed"},
+	"ChangeTrustResultCodeChangeTrustMalformed":                  {"op_malformed", "the asset or limit is invalid"},
+	"ChangeTrustResultCodeChangeTrustNoIssuer":                   {"op_no_issuer", "the issuer of the asset does not exist"},
+	"ChangeTrustResultCodeChangeTrustInvalidLimit":               {"op_invalid_limit", "the limit is lower than the current balance or liabilities of the trustline"},
+	"ChangeTrustResultCodeChangeTrustLowReserve":                 {"op_low_reserve", "the source account does not have enough XLM to create the trustline"},
+	"ChangeTrustResultCodeChangeTrustSelfNotAllowed":             {"op_self_not_allowed", "the source account is the issuer of the asset"},
+	"ChangeTrustResultCodeChangeTrustTrustLineMissing":           {"op_trust_line_missing", "the liquidity pool shares require trustlines for both pool assets"},
+	"ChangeTrustResultCodeChangeTrustCannotDelete":               {"op_cannot_delete", "the trustline is still used by a liquidity pool share trustline"},
+	"ChangeTrustResultCodeChangeTrustNotAuthMaintainLiabilities": {"op_not_aut_maintain_liabilities", "the trustline of a pool asset is not authorized to maintain liabilities"},
+
+	// AllowTrust
+	"AllowTrustResultCodeAllowTrustSuccess":          {"op_success", "the trustline authorization was changed"},
+	"AllowTrustResultCodeAllowTrustMalformed":        {"op_malformed", "the asset is invalid or native"},
+	"AllowTrustResultCodeAllowTrustNoTrustLine":      {"op_no_trustline", "the trustor does not have a trustline for the asset"},
+	"AllowTrustResultCodeAllowTrustTrustNotRequired": {"op_not_required", "the issuer does not require authorization"},
+	"AllowTrustResultCodeAllowTrustCantRevoke":       {"op_cant_revoke", "the issuer cannot revoke authorization without the auth revocable flag"},
+	"AllowTrustResultCodeAllowTrustSelfNotAllowed":   {"op_self_not_allowed", "the trustor is the source account"},
+	"AllowTrustResultCodeAllowTrustLowReserve":       {"op_low_reserve", "the offers and pool shares removed by the revocation need more reserves"},
+
+	// AccountMerge
+	"AccountMergeResultCodeAccountMergeSuccess":       {"op_success", "the account was merged"},
+	"AccountMergeResultCodeAccountMergeMalformed":     {"op_malformed", "the account cannot be merged into itself"},
+	"AccountMergeResultCodeAccountMergeNoAccount":     {"op_no_account", "the destination account does not exist"},
+	"AccountMergeResultCodeAccountMergeImmutableSet":  {"op_immutable_set", "the source account has the auth immutable flag set"},
+	"AccountMergeResultCodeAccountMergeHasSubEntries": {"op_has_sub_entries", "the source account still has trustlines, offers, signers or data entries"},
+	"AccountMergeResultCodeAccountMergeSeqnumTooFar":  {"op_seq_num_too_far", "the sequence number of the source account is too high to be merged"},
+	"AccountMergeResultCodeAccountMergeDestFull":      {"op_dest_full", "the destination account cannot receive the XLM balance of the source account"},
+	"AccountMergeResultCodeAccountMergeIsSponsor":     {"op_is_sponsor", "the source account is sponsoring other entries"},
+
+	// Inflation
+	"InflationResultCodeInflationSuccess": {"op_success", "inflation was run"},
+	"InflationResultCodeInflationNotTime": {"op_not_time", "inflation cannot be run yet"},
+
+	// ManageData
+	"ManageDataResultCodeManageDataSuccess":         {"op_success", "the data entry was changed"},
+	"ManageDataResultCodeManageDataNotSupportedYet": {"op_not_supported_yet", "data entries are not supported by the protocol"},
+	"ManageDataResultCodeManageDataNameNotFound":    {"op_data_name_not_found", "the data entry to delete does not exist"},
+	"ManageDataResultCodeManageDataLowReserve":      {"op_low_reserve", "the source account does not have enough XLM to create the data entry"},
+	"ManageDataResultCodeManageDataInvalidName":     {"op_data_invalid_name", "the name of the data entry is invalid"},
+
+	// BumpSequence
+	"BumpSequenceResultCodeBumpSequenceSuccess": {"op_success", "the sequence number was bumped"},
+	"BumpSequenceResultCodeBumpSequenceBadSeq":  {"op_bad_seq", "the sequence number to bump to is invalid"},
+
+	// CreateClaimableBalance
+	"CreateClaimableBalanceResultCodeCreateClaimableBalanceSuccess":       {"op_success", "the claimable balance was created"},
+	"CreateClaimableBalanceResultCodeCreateClaimableBalanceMalformed":     {"op_malformed", "the asset, amount or claimants are invalid"},
+	"CreateClaimableBalanceResultCodeCreateClaimableBalanceLowReserve":    {"op_low_reserve", "the source account does not have enough XLM to create the claimable balance"},
+	"CreateClaimableBalanceResultCodeCreateClaimableBalanceNoTrust":       {"op_no_trust", "the source account does not have a trustline for the asset"},
+	"CreateClaimableBalanceResultCodeCreateClaimableBalanceNotAuthorized": {"op_not_authorized", "the source account is not authorized to send the asset"},
+	"CreateClaimableBalanceResultCodeCreateClaimableBalanceUnderfunded":   {"op_underfunded", "the source account does not have enough funds"},
+
+	// ClaimClaimableBalance
+	"ClaimClaimableBalanceResultCodeClaimClaimableBalanceSuccess":       {"op_success", "the claimable balance was claimed"},
+	"ClaimClaimableBalanceResultCodeClaimClaimableBalanceDoesNotExist":  {"op_does_not_exist", "the claimable balance does not exist"},
+	"ClaimClaimableBalanceResultCodeClaimClaimableBalanceCannotClaim":   {"op_cannot_claim", "the source account is not a claimant or the claim predicate is not satisfied"},
+	"ClaimClaimableBalanceResultCodeClaimClaimableBalanceLineFull":      {"op_line_full", "claiming would exceed the source account's trustline limit"},
+	"ClaimClaimableBalanceResultCodeClaimClaimableBalanceNoTrust":       {"op_no_trust", "the source account does not have a trustline for the asset"},
+	"ClaimClaimableBalanceResultCodeClaimClaimableBalanceNotAuthorized": {"op_not_authorized", "the source account is not authorized to hold the asset"},
+
+	// BeginSponsoringFutureReserves
+	"BeginSponsoringFutureReservesResultCodeBeginSponsoringFutureReservesSuccess":          {"op_success", "the sponsorship was started"},
+	"BeginSponsoringFutureReservesResultCodeBeginSponsoringFutureReservesMalformed":        {"op_malformed", "the source account cannot sponsor itself"},
+	"BeginSponsoringFutureReservesResultCodeBeginSponsoringFutureReservesAlreadySponsored": {"op_already_sponsored", "the sponsored account is already being sponsored"},
+	"BeginSponsoringFutureReservesResultCodeBeginSponsoringFutureReservesRecursive":        {"op_recursive", "the sponsor is itself being sponsored"},
+
+	// EndSponsoringFutureReserves
+	"EndSponsoringFutureReservesResultCodeEndSponsoringFutureReservesSuccess":      {"op_success", "the sponsorship was ended"},
+	"EndSponsoringFutureReservesResultCodeEndSponsoringFutureReservesNotSponsored": {"op_not_sponsored", "the source account is not being sponsored"},
+
+	// RevokeSponsorship
+	"RevokeSponsorshipResultCodeRevokeSponsorshipSuccess":          {"op_success", "the sponsorship was revoked or transferred"},
+	"RevokeSponsorshipResultCodeRevokeSponsorshipDoesNotExist":     {"op_does_not_exist", "the sponsored entry or signer does not exist"},
+	"RevokeSponsorshipResultCodeRevokeSponsorshipNotSponsor":       {"op_not_sponsor", "the source account is not the sponsor"},
+	"RevokeSponsorshipResultCodeRevokeSponsorshipLowReserve":       {"op_low_reserve", "the new sponsor or owner does not have enough XLM for the reserve"},
+	"RevokeSponsorshipResultCodeRevokeSponsorshipOnlyTransferable": {"op_only_transferable", "the sponsorship of claimable balances can only be transferred"},
+	"RevokeSponsorshipResultCodeRevokeSponsorshipMalformed":        {"op_malformed", "the ledger key or signer is invalid"},
+
+	// Clawback
+	"ClawbackResultCodeClawbackSuccess":            {"op_success", "the asset was clawed back"},
+	"ClawbackResultCodeClawbackMalformed":          {"op_malformed", "the asset or amount is invalid or the source account is not the issuer"},
+	"ClawbackResultCodeClawbackNotClawbackEnabled": {"op_not_clawback_enabled", "clawback is not enabled on the trustline"},
+	"ClawbackResultCodeClawbackNoTrust":            {"op_no_trust", "the account does not have a trustline for the asset"},
+	"ClawbackResultCodeClawbackUnderfunded":        {"op_underfunded", "the account does not have enough of the asset to claw back"},
+
+	// ClawbackClaimableBalance
+	"ClawbackClaimableBalanceResultCodeClawbackClaimableBalanceSuccess":            {"op_success", "the claimable balance was clawed back"},
+	"ClawbackClaimableBalanceResultCodeClawbackClaimableBalanceDoesNotExist":       {"op_does_not_exist", "the claimable balance does not exist"},
+	"ClawbackClaimableBalanceResultCodeClawbackClaimableBalanceNotIssuer":          {"op_not_issuer", "the source account is not the issuer of the asset"},
+	"ClawbackClaimableBalanceResultCodeClawbackClaimableBalanceNotClawbackEnabled": {"op_not_clawback_enabled", "clawback is not enabled on the claimable balance"},
+
+	// SetTrustLineFlags
+	"SetTrustLineFlagsResultCodeSetTrustLineFlagsSuccess":      {"op_success", "the trustline flags were set"},
+	"SetTrustLineFlagsResultCodeSetTrustLineFlagsMalformed":    {"op_malformed", "the asset or flags are invalid or the source account is not the issuer"},
+	"SetTrustLineFlagsResultCodeSetTrustLineFlagsNoTrustLine":  {"op_no_trustline", "the trustor does not have a trustline for the asset"},
+	"SetTrustLineFlagsResultCodeSetTrustLineFlagsCantRevoke":   {"op_cant_revoke", "the issuer cannot revoke authorization without the auth revocable flag"},
+	"SetTrustLineFlagsResultCodeSetTrustLineFlagsInvalidState": {"op_invalid_state", "the resulting combination of flags is invalid"},
+	"SetTrustLineFlagsResultCodeSetTrustLineFlagsLowReserve":   {"op_low_reserve", "the offers and pool shares removed by the revocation need more reserves"},
+
+	// LiquidityPoolDeposit
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositSuccess":       {"op_success", "the assets were deposited in the liquidity pool"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositMalformed":     {"op_malformed", "the amounts or price bounds are invalid"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositNoTrust":       {"op_no_trust", "the source account does not have a trustline for the pool shares or one of the assets"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositNotAuthorized": {"op_not_authorized", "the source account is not authorized to hold one of the pool assets"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositUnderfunded":   {"op_underfunded", "the source account does not have enough of one of the pool assets"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositLineFull":      {"op_line_full", "the pool shares trustline is full"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositBadPrice":      {"op_bad_price", "the pool price is outside of the price bounds"},
+	"LiquidityPoolDepositResultCodeLiquidityPoolDepositPoolFull":      {"op_pool_full", "the pool reserves would exceed the maximum"},
+
+	// LiquidityPoolWithdraw
+	"LiquidityPoolWithdrawResultCodeLiquidityPoolWithdrawSuccess":      {"op_success", "the assets were withdrawn from the liquidity pool"},
+	"LiquidityPoolWithdrawResultCodeLiquidityPoolWithdrawMalformed":    {"op_malformed", "the amounts are invalid"},
+	"LiquidityPoolWithdrawResultCodeLiquidityPoolWithdrawNoTrust":      {"op_no_trust", "the source account does not have a trustline for the pool shares"},
+	"LiquidityPoolWithdrawResultCodeLiquidityPoolWithdrawUnderfunded":  {"op_underfunded", "the source account does not have enough pool shares"},
+	"LiquidityPoolWithdrawResultCodeLiquidityPoolWithdrawLineFull":     {"op_line_full", "one of the asset trustlines of the source account is full"},
+	"LiquidityPoolWithdrawResultCodeLiquidityPoolWithdrawUnderMinimum": {"op_under_minimum", "the amounts withdrawn would be less than the minimum amounts"},
+
+	// InvokeHostFunction
+	"InvokeHostFunctionResultCodeInvokeHostFunctionSuccess":                   {"op_success", "the host function was invoked"},
+	"InvokeHostFunctionResultCodeInvokeHostFunctionMalformed":                 {"op_malformed", "the host function or its footprint is invalid"},
+	"InvokeHostFunctionResultCodeInvokeHostFunctionTrapped":                   {"function_trapped", "the contract invocation failed, see the diagnostic events for the error"},
+	"InvokeHostFunctionResultCodeInvokeHostFunctionResourceLimitExceeded":     {"op_resource_limit_exceeded", "the invocation exceeded the resources declared by the transaction"},
+	"InvokeHostFunctionResultCodeInvokeHostFunctionEntryArchived":             {"op_entry_archived", "the footprint contains an archived entry which must be restored first"},
+	"InvokeHostFunctionResultCodeInvokeHostFunctionInsufficientRefundableFee": {"op_insufficient_refundable_fee", "the refundable fee does not cover the rent and events of the invocation"},
+
+	// ExtendFootprintTtl
+	"ExtendFootprintTtlResultCodeExtendFootprintTtlSuccess":                   {"op_success", "the TTL of the footprint entries was extended"},
+	"ExtendFootprintTtlResultCodeExtendFootprintTtlMalformed":                 {"op_malformed", "the footprint or TTL extension is invalid"},
+	"ExtendFootprintTtlResultCodeExtendFootprintTtlResourceLimitExceeded":     {"op_resource_limit_exceeded", "the operation exceeded the resources declared by the transaction"},
+	"ExtendFootprintTtlResultCodeExtendFootprintTtlInsufficientRefundableFee": {"op_insufficient_refundable_fee", "the refundable fee does not cover the rent of the extension"},
+
+	// RestoreFootprint
+	"RestoreFootprintResultCodeRestoreFootprintSuccess":                   {"op_success", "the footprint entries were restored"},
+	"RestoreFootprintResultCodeRestoreFootprintMalformed":                 {"op_malformed", "the footprint is invalid"},
+	"RestoreFootprintResultCodeRestoreFootprintResourceLimitExceeded":     {"op_resource_limit_exceeded", "the operation exceeded the resources declared by the transaction"},
+	"RestoreFootprintResultCodeRestoreFootprintInsufficientRefundableFee": {"op_insufficient_refundable_fee", "the refundable fee does not cover the rent of the restored entries"},
+}
+
+// ResultReason returns a human readable explanation of the result code of
+// the transaction. For fee bump transactions whose inner transaction failed
+// the result code of the inner transaction is explained.
+func (t *LedgerTransaction) ResultReason() string {
+	result := t.Result.Result.Result
+	if inner, ok := result.GetInnerResultPair(); ok && result.Code == xdr.TransactionResultCodeTxFeeBumpInnerFailed {
+		explanation := transactionResultExplanations[inner.Result.Result.Code]
+		return "the inner transaction of the fee bump transaction failed: " + explanation.reason
+	}
+	if explanation, ok := transactionResultExplanations[result.Code]; ok {
+		return explanation.reason
+	}
+	return fmt.Sprintf("unknown transaction result code %d", result.Code)
+}
+
+// ExplainOperationResults returns a human readable explanation of the result
+// of every operation in the transaction. Nil is returned if the
+// transaction failed before its operations were applied (e.g. because of a
+// bad sequence number), use ResultReason to explain such failures.
+func (t *LedgerTransaction) ExplainOperationResults() ([]OperationResultExplanation, error) {
+	results, ok := t.Result.OperationResults()
+	if !ok {
+		return nil, nil
+	}
+
+	explanations := make([]OperationResultExplanation, 0, len(results))
+	for i, result := range results {
+		op, ok := t.GetOperation(uint32(i))
+		if !ok {
+			return nil, errors.Errorf("operation %d not found in transaction envelope", i)
+		}
+		explanation, err := t.explainOperationResult(uint32(i), op, result)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not explain result of operation %d", i)
+		}
+		explanations = append(explanations, explanation)
+	}
+	return explanations, nil
+}
+
+func (t *LedgerTransaction) explainOperationResult(
+	index uint32,
+	op xdr.Operation,
+	result xdr.OperationResult,
+) (OperationResultExplanation, error) {
+	explanation := OperationResultExplanation{
+		Index: index,
+		Type:  op.Body.Type,
+	}
+	if result.Code != xdr.OperationResultCodeOpInner {
+		codeExplanation, ok := operationResultExplanations[result.Code]
+		if !ok {
+			return explanation, errors.Errorf("unknown operation result code %d", result.Code)
+		}
+		explanation.Code = codeExplanation.code
+		explanation.Reason = codeExplanation.reason
+		return explanation, nil
+	}
+
+	tr := result.MustTr()
+	code, err := tr.MapOperationResultTr()
+	if err != nil {
+		return explanation, err
+	}
+	codeExplanation, ok := innerResultExplanations[code]
+	if !ok {
+		return explanation, errors.Errorf("unknown result code %s", code)
+	}
+	explanation.Code = codeExplanation.code
+	explanation.Reason = codeExplanation.reason
+	explanation.Successful = codeExplanation.code == "op_success"
+	explanation.Details = map[string]string{}
+
+	switch tr.Type {
+	case xdr.OperationTypePathPaymentStrictReceive:
+		r := tr.MustPathPaymentStrictReceiveResult()
+		switch r.Code {
+		case xdr.PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveSuccess:
+			success := r.MustSuccess()
+			explanation.OffersClaimed = success.Offers
+			addPaymentDetails(explanation.Details, success.Last)
+		case xdr.PathPaymentStrictReceiveResultCodePathPaymentStrictReceiveNoIssuer:
+			explanation.Details["asset"] = r.MustNoIssuer().StringCanonical()
+		}
+	case xdr.OperationTypePathPaymentStrictSend:
+		r := tr.MustPathPaymentStrictSendResult()
+		switch r.Code {
+		case xdr.PathPaymentStrictSendResultCodePathPaymentStrictSendSuccess:
+			success := r.MustSuccess()
+			explanation.OffersClaimed = success.Offers
+			addPaymentDetails(explanation.Details, success.Last)
+		case xdr.PathPaymentStrictSendResultCodePathPaymentStrictSendNoIssuer:
+			explanation.Details["asset"] = r.MustNoIssuer().StringCanonical()
+		}
+	case xdr.OperationTypeManageSellOffer:
+		if success, ok := tr.MustManageSellOfferResult().GetSuccess(); ok {
+			explainManageOffer(&explanation, success)
+		}
+	case xdr.OperationTypeCreatePassiveSellOffer:
+		if success, ok := tr.MustCreatePassiveSellOfferResult().GetSuccess(); ok {
+			explainManageOffer(&explanation, success)
+		}
+	case xdr.OperationTypeManageBuyOffer:
+		if success, ok := tr.MustManageBuyOfferResult().GetSuccess(); ok {
+			explainManageOffer(&explanation, success)
+		}
+	case xdr.OperationTypeAccountMerge:
+		if balance, ok := tr.MustAccountMergeResult().GetSourceAccountBalance(); ok {
+			explanation.Details["source_account_balance"] = amount.String(balance)
+		}
+	case xdr.OperationTypeInflation:
+		if payouts, ok := tr.MustInflationResult().GetPayouts(); ok {
+			explanation.Details["payouts"] = strconv.Itoa(len(payouts))
+		}
+	case xdr.OperationTypeCreateClaimableBalance:
+		if balanceID, ok := tr.MustCreateClaimableBalanceResult().GetBalanceId(); ok {
+			if explanation.ClaimableBalanceID, err = balanceID.EncodeToStrkey(); err != nil {
+				return explanation, err
+			}
+		}
+	case xdr.OperationTypeClaimClaimableBalance:
+		if explanation.ClaimableBalanceID, err = op.Body.MustClaimClaimableBalanceOp().BalanceId.EncodeToStrkey(); err != nil {
+			return explanation, err
+		}
+	case xdr.OperationTypeClawbackClaimableBalance:
+		if explanation.ClaimableBalanceID, err = op.Body.MustClawbackClaimableBalanceOp().BalanceId.EncodeToStrkey(); err != nil {
+			return explanation, err
+		}
+	case xdr.OperationTypeLiquidityPoolDeposit:
+		poolID := op.Body.MustLiquidityPoolDepositOp().LiquidityPoolId
+		explanation.Details["liquidity_pool_id"] = hex.EncodeToString(poolID[:])
+	case xdr.OperationTypeLiquidityPoolWithdraw:
+		poolID := op.Body.MustLiquidityPoolWithdrawOp().LiquidityPoolId
+		explanation.Details["liquidity_pool_id"] = hex.EncodeToString(poolID[:])
+	case xdr.OperationTypeInvokeHostFunction:
+		r := tr.MustInvokeHostFunctionResult()
+		if resultHash, ok := r.GetSuccess(); ok {
+			explanation.Details["result_hash"] = hex.EncodeToString(resultHash[:])
+		} else {
+			if explanation.DiagnosticEvents, err = t.GetDiagnosticEvents(); err != nil {
+				return explanation, err
+			}
+		}
+	}
+	if len(explanation.Details) == 0 {
+		explanation.Details = nil
+	}
+	return explanation, nil
+}
+
+func addPaymentDetails(details map[string]string, last xdr.SimplePaymentResult) {
+	details["destination"] = last.Destination.Address()
+	details["destination_asset"] = last.Asset.StringCanonical()
+	details["destination_amount"] = amount.String(last.Amount)
+}
+
+func explainManageOffer(explanation *OperationResultExplanation, success xdr.ManageOfferSuccessResult) {
+	explanation.OffersClaimed = success.OffersClaimed
+	switch success.Offer.Effect {
+	case xdr.ManageOfferEffectManageOfferCreated:
+		explanation.Details["offer_effect"] = "created"
+	case xdr.ManageOfferEffectManageOfferUpdated:
+		explanation.Details["offer_effect"] = "updated"
+	case xdr.ManageOfferEffectManageOfferDeleted:
+		explanation.Details["offer_effect"] = "deleted"
+	}
+	if offer, ok := success.Offer.GetOffer(); ok {
+		explanation.Details["offer_id"] = strconv.FormatInt(int64(offer.OfferId), 10)
+	}
+}
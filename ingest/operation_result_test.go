@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func innerOperationResult(tr xdr.OperationResultTr) xdr.OperationResult {
+	return xdr.OperationResult{Code: xdr.OperationResultCodeOpInner, Tr: &tr}
+}
+
+func TestExplainOperationResults(t *testing.T) {
+	source := xdr.MustMuxedAddress("GAUJETIZVEP2NRYLUESJ3LS66NVCEGMON4UDCBCSBEVPIID773P2W6AY")
+	balanceID := xdr.ClaimableBalanceId{
+		Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0,
+		V0:   &xdr.Hash{1, 2, 3},
+	}
+	tx := LedgerTransaction{
+		Envelope: xdr.TransactionEnvelope{
+			Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+			V1: &xdr.TransactionV1Envelope{
+				Tx: xdr.Transaction{
+					SourceAccount: source,
+					Operations: []xdr.Operation{
+						{Body: xdr.OperationBody{Type: xdr.OperationTypeManageSellOffer, ManageSellOfferOp: &xdr.ManageSellOfferOp{}}},
+						{Body: xdr.OperationBody{Type: xdr.OperationTypeCreateClaimableBalance, CreateClaimableBalanceOp: &xdr.CreateClaimableBalanceOp{}}},
+						{Body: xdr.OperationBody{Type: xdr.OperationTypePayment, PaymentOp: &xdr.PaymentOp{}}},
+						{Body: xdr.OperationBody{Type: xdr.OperationTypeBumpSequence, BumpSequenceOp: &xdr.BumpSequenceOp{}}},
+					},
+				},
+			},
+		},
+		Result: xdr.TransactionResultPair{
+			Result: xdr.TransactionResult{
+				Result: xdr.TransactionResultResult{
+					Code: xdr.TransactionResultCodeTxFailed,
+					Results: &[]xdr.OperationResult{
+						innerOperationResult(xdr.OperationResultTr{
+							Type: xdr.OperationTypeManageSellOffer,
+							ManageSellOfferResult: &xdr.ManageSellOfferResult{
+								Code: xdr.ManageSellOfferResultCodeManageSellOfferSuccess,
+								Success: &xdr.ManageOfferSuccessResult{
+									OffersClaimed: []xdr.ClaimAtom{{
+										Type:      xdr.ClaimAtomTypeClaimAtomTypeOrderBook,
+										OrderBook: &xdr.ClaimOfferAtom{OfferId: 7},
+									}},
+									Offer: xdr.ManageOfferSuccessResultOffer{
+										Effect: xdr.ManageOfferEffectManageOfferCreated,
+										Offer:  &xdr.OfferEntry{OfferId: 42},
+									},
+								},
+							},
+						}),
+						innerOperationResult(xdr.OperationResultTr{
+							Type: xdr.OperationTypeCreateClaimableBalance,
+							CreateClaimableBalanceResult: &xdr.CreateClaimableBalanceResult{
+								Code:      xdr.CreateClaimableBalanceResultCodeCreateClaimableBalanceSuccess,
+								BalanceId: &balanceID,
+							},
+						}),
+						innerOperationResult(xdr.OperationResultTr{
+							Type:          xdr.OperationTypePayment,
+							PaymentResult: &xdr.PaymentResult{Code: xdr.PaymentResultCodePaymentUnderfunded},
+						}),
+						{Code: xdr.OperationResultCodeOpBadAuth},
+					},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, "one of the operations failed, none of the operations were applied", tx.ResultReason())
+
+	explanations, err := tx.ExplainOperationResults()
+	require.NoError(t, err)
+	require.Len(t, explanations, 4)
+
+	offer := explanations[0]
+	assert.Equal(t, uint32(0), offer.Index)
+	assert.Equal(t, xdr.OperationTypeManageSellOffer, offer.Type)
+	assert.True(t, offer.Successful)
+	assert.Equal(t, "op_success", offer.Code)
+	assert.Len(t, offer.OffersClaimed, 1)
+	assert.Equal(t, map[string]string{"offer_effect": "created", "offer_id": "42"}, offer.Details)
+
+	claimableBalance := explanations[1]
+	assert.True(t, claimableBalance.Successful)
+	expectedBalanceID, err := balanceID.EncodeToStrkey()
+	require.NoError(t, err)
+	assert.Equal(t, expectedBalanceID, claimableBalance.ClaimableBalanceID)
+	assert.Nil(t, claimableBalance.Details)
+
+	payment := explanations[2]
+	assert.False(t, payment.Successful)
+	assert.Equal(t, "op_underfunded", payment.Code)
+	assert.Equal(t, "the source account does not have enough funds to send the payment", payment.Reason)
+
+	badAuth := explanations[3]
+	assert.Equal(t, uint32(3), badAuth.Index)
+	assert.False(t, badAuth.Successful)
+	assert.Equal(t, "op_bad_auth", badAuth.Code)
+}
+
+func TestExplainInvokeHostFunctionFailure(t *testing.T) {
+	tx := LedgerTransaction{
+		Envelope: xdr.TransactionEnvelope{
+			Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+			V1: &xdr.TransactionV1Envelope{
+				Tx: xdr.Transaction{
+					Operations: []xdr.Operation{
+						{Body: xdr.OperationBody{Type: xdr.OperationTypeInvokeHostFunction, InvokeHostFunctionOp: &xdr.InvokeHostFunctionOp{}}},
+					},
+				},
+			},
+		},
+		Result: xdr.TransactionResultPair{
+			Result: xdr.TransactionResult{
+				Result: xdr.TransactionResultResult{
+					Code: xdr.TransactionResultCodeTxFailed,
+					Results: &[]xdr.OperationResult{
+						innerOperationResult(xdr.OperationResultTr{
+							Type: xdr.OperationTypeInvokeHostFunction,
+							InvokeHostFunctionResult: &xdr.InvokeHostFunctionResult{
+								Code: xdr.InvokeHostFunctionResultCodeInvokeHostFunctionTrapped,
+							},
+						}),
+					},
+				},
+			},
+		},
+		UnsafeMeta: xdr.TransactionMeta{
+			V: 4,
+			V4: &xdr.TransactionMetaV4{
+				DiagnosticEvents: []xdr.DiagnosticEvent{mockDiagnosticEvent1},
+			},
+		},
+	}
+
+	explanations, err := tx.ExplainOperationResults()
+	require.NoError(t, err)
+	require.Len(t, explanations, 1)
+	assert.False(t, explanations[0].Successful)
+	assert.Equal(t, "function_trapped", explanations[0].Code)
+	assert.Equal(t, []xdr.DiagnosticEvent{mockDiagnosticEvent1}, explanations[0].DiagnosticEvents)
+}
+
+func TestExplainTransactionLevelFailure(t *testing.T) {
+	tx := LedgerTransaction{
+		Result: xdr.TransactionResultPair{
+			Result: xdr.TransactionResult{
+				Result: xdr.TransactionResultResult{
+					Code: xdr.TransactionResultCodeTxFeeBumpInnerFailed,
+					InnerResultPair: &xdr.InnerTransactionResultPair{
+						Result: xdr.InnerTransactionResult{
+							Result: xdr.InnerTransactionResultResult{
+								Code: xdr.TransactionResultCodeTxBadSeq,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	explanations, err := tx.ExplainOperationResults()
+	require.NoError(t, err)
+	assert.Empty(t, explanations)
+	assert.Equal(t,
+		"the inner transaction of the fee bump transaction failed: the sequence number of the transaction does not match the source account",
+		tx.ResultReason(),
+	)
+}
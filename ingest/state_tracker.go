@@ -0,0 +1,555 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// StateTracker maintains an in-memory view of (a filtered subset of) the
+// ledger entries on the network.
+//
+// The state is bootstrapped from a history archive checkpoint using a
+// CheckpointChangeReader and then kept up to date by applying the changes
+// found in every subsequent LedgerCloseMeta, one ledger at a time. Ledgers
+// must be applied in order, starting with the ledger right after the
+// checkpoint (or snapshot) the state was initialized from.
+//
+// The changes of each ledger are squashed with a ChangeCompactor before being
+// applied so the CAP-63 TTL semantics are respected. Entries listed in
+// LedgerCloseMeta.EvictedLedgerKeys are removed from the state after all the
+// changes in the ledger were applied.
+//
+// StateTracker is safe for concurrent use: lookups can be served while ledgers
+// are being applied.
+type StateTracker struct {
+	mutex          sync.RWMutex
+	entries        map[string]xdr.LedgerEntry
+	sequence       uint32
+	bootstrapped   bool
+	encodingBuffer *xdr.EncodingBuffer
+
+	networkPassphrase string
+	entryTypes        map[xdr.LedgerEntryType]bool
+	entryFilter       func(xdr.LedgerEntry) bool
+}
+
+// StateTrackerOption configures a StateTracker's behavior.
+type StateTrackerOption func(*StateTracker)
+
+// WithTrackedEntryTypes restricts the StateTracker to ledger entries of the
+// given types. By default all entry types are tracked.
+func WithTrackedEntryTypes(types ...xdr.LedgerEntryType) StateTrackerOption {
+	return func(s *StateTracker) {
+		s.entryTypes = make(map[xdr.LedgerEntryType]bool, len(types))
+		for _, t := range types {
+			s.entryTypes[t] = true
+		}
+	}
+}
+
+// WithTrackedEntryFilter restricts the StateTracker to ledger entries for which
+// the given function returns true. The filter is applied on top of the entry
+// types configured with WithTrackedEntryTypes.
+//
+// The filter must be a pure function of the entry: an entry which stops
+// matching the filter after an update is removed from the state and an entry
+// which starts matching it is added.
+func WithTrackedEntryFilter(filter func(xdr.LedgerEntry) bool) StateTrackerOption {
+	return func(s *StateTracker) {
+		s.entryFilter = filter
+	}
+}
+
+// NewStateTracker returns a new, empty StateTracker. Bootstrap or
+// RestoreSnapshot must be called before any ledger can be applied.
+func NewStateTracker(networkPassphrase string, opts ...StateTrackerOption) *StateTracker {
+	s := &StateTracker{
+		entries:           make(map[string]xdr.LedgerEntry),
+		encodingBuffer:    xdr.NewEncodingBuffer(),
+		networkPassphrase: networkPassphrase,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// tracksType returns true if entries of the given type are tracked.
+func (s *StateTracker) tracksType(t xdr.LedgerEntryType) bool {
+	return len(s.entryTypes) == 0 || s.entryTypes[t]
+}
+
+// tracks returns true if the given entry belongs in the state.
+func (s *StateTracker) tracks(entry xdr.LedgerEntry) bool {
+	if !s.tracksType(entry.Data.Type) {
+		return false
+	}
+	return s.entryFilter == nil || s.entryFilter(entry)
+}
+
+// checkpointFilter returns a CheckpointReaderOption which only streams the
+// entries tracked by the StateTracker.
+func (s *StateTracker) checkpointFilter() CheckpointReaderOption {
+	return WithFilter(
+		s.tracks,
+		func(key xdr.LedgerKey) bool {
+			return s.tracksType(key.Type)
+		},
+	)
+}
+
+func (s *StateTracker) ledgerKey(key xdr.LedgerKey) (string, error) {
+	keyBytes, err := s.encodingBuffer.LedgerKeyUnsafeMarshalBinaryCompress(key)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling ledger key")
+	}
+	return string(keyBytes), nil
+}
+
+func (s *StateTracker) entryKey(entry xdr.LedgerEntry) (string, error) {
+	key, err := entry.LedgerKey()
+	if err != nil {
+		return "", errors.Wrap(err, "error getting ledger key")
+	}
+	return s.ledgerKey(key)
+}
+
+// Bootstrap replaces the state with the ledger entries found in the given
+// checkpoint of the history archive. Additional options are passed through to
+// the underlying CheckpointChangeReader.
+func (s *StateTracker) Bootstrap(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...CheckpointReaderOption,
+) error {
+	opts = append(opts, s.checkpointFilter())
+	reader, err := NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make(map[string]xdr.LedgerEntry)
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		key, err := s.entryKey(*change.Post)
+		if err != nil {
+			return err
+		}
+		entries[key] = *change.Post
+	}
+
+	s.entries = entries
+	s.sequence = checkpoint
+	s.bootstrapped = true
+	return nil
+}
+
+// ApplyLedger applies all the ledger entry changes (including evictions) of
+// the given ledger to the state. The ledger must directly follow the last
+// ledger applied to the state. Either all the changes of the ledger are
+// applied or, if an error is returned, none of them are.
+func (s *StateTracker) ApplyLedger(ledger xdr.LedgerCloseMeta) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.bootstrapped {
+		return errors.New("state tracker has not been bootstrapped")
+	}
+	if seq := ledger.LedgerSequence(); seq != s.sequence+1 {
+		return errors.Errorf("expected ledger %d but got %d", s.sequence+1, seq)
+	}
+
+	reader, err := NewLedgerChangeReaderFromLedgerCloseMeta(s.networkPassphrase, ledger)
+	if err != nil {
+		return errors.Wrap(err, "error creating ledger change reader")
+	}
+	defer reader.Close()
+
+	compactor := NewChangeCompactor(ChangeCompactorConfig{})
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading changes of ledger %d", ledger.LedgerSequence())
+		}
+		if !s.tracksType(change.Type) {
+			continue
+		}
+		if err = compactor.AddChange(change); err != nil {
+			return errors.Wrapf(err, "error compacting changes of ledger %d", ledger.LedgerSequence())
+		}
+	}
+
+	// The changes are validated before any of them is applied so the state
+	// is left untouched if the ledger is rejected.
+	updates := make(map[string]*xdr.LedgerEntry)
+	for _, change := range compactor.GetChanges() {
+		k, post, err := s.stageChange(change)
+		if err != nil {
+			return err
+		}
+		updates[k] = post
+	}
+
+	evicted, err := ledger.EvictedLedgerKeys()
+	if err != nil {
+		return errors.Wrap(err, "error getting evicted ledger keys")
+	}
+	for _, key := range evicted {
+		if !s.tracksType(key.Type) {
+			continue
+		}
+		k, err := s.ledgerKey(key)
+		if err != nil {
+			return err
+		}
+		updates[k] = nil
+	}
+
+	for k, post := range updates {
+		if post != nil {
+			s.entries[k] = *post
+		} else {
+			delete(s.entries, k)
+		}
+	}
+	s.sequence = ledger.LedgerSequence()
+	return nil
+}
+
+// stageChange validates a single (compacted) change against the state and
+// returns the key of the changed entry along with the entry to store, which is
+// nil if the entry must be removed from the state. A StateError is returned if
+// the change is inconsistent with the current state.
+func (s *StateTracker) stageChange(change Change) (string, *xdr.LedgerEntry, error) {
+	key, err := change.LedgerKey()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "error getting ledger key of change")
+	}
+	k, err := s.ledgerKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	_, exists := s.entries[k]
+
+	// Pre tells us whether the entry should already be part of the state.
+	// Restored entries are the exception: they may or may not be present
+	// depending on whether they were evicted before being restored.
+	if change.Pre != nil && s.tracks(*change.Pre) && !exists {
+		return "", nil, NewStateError(errors.Errorf(
+			"%s change for an entry which is not present in the state (ledger key = %s)",
+			change.ChangeType, base64.StdEncoding.EncodeToString([]byte(k)),
+		))
+	}
+	if change.ChangeType == xdr.LedgerEntryChangeTypeLedgerEntryCreated && exists {
+		return "", nil, NewStateError(errors.Errorf(
+			"can't create an entry that already exists (ledger key = %s)",
+			base64.StdEncoding.EncodeToString([]byte(k)),
+		))
+	}
+
+	if change.Post != nil && s.tracks(*change.Post) {
+		return k, change.Post, nil
+	}
+	return k, nil, nil
+}
+
+// Sequence returns the sequence of the last ledger reflected in the state.
+func (s *StateTracker) Sequence() uint32 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sequence
+}
+
+// Len returns the number of ledger entries in the state.
+func (s *StateTracker) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.entries)
+}
+
+// Get returns the ledger entry with the given key. The second return value is
+// false if the entry is not present in the state.
+func (s *StateTracker) Get(key xdr.LedgerKey) (xdr.LedgerEntry, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.get(key)
+}
+
+// get is Get for callers holding the lock.
+func (s *StateTracker) get(key xdr.LedgerKey) (xdr.LedgerEntry, bool, error) {
+	// s.encodingBuffer is not safe to share between concurrent readers.
+	k, err := xdr.NewEncodingBuffer().LedgerKeyUnsafeMarshalBinaryCompress(key)
+	if err != nil {
+		return xdr.LedgerEntry{}, false, errors.Wrap(err, "error marshaling ledger key")
+	}
+	entry, ok := s.entries[string(k)]
+	return entry, ok, nil
+}
+
+// Entries returns an iterator over all ledger entries in the state, in no
+// particular order. The state is read locked while iterating so ApplyLedger
+// must not be called from within the loop.
+func (s *StateTracker) Entries() iter.Seq[xdr.LedgerEntry] {
+	return func(yield func(xdr.LedgerEntry) bool) {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		for _, entry := range s.entries {
+			if !yield(entry) {
+				return
+			}
+		}
+	}
+}
+
+// WriteSnapshot writes the state to w. The snapshot is a gzip compressed
+// stream of framed XDR values: the ledger sequence followed by all ledger
+// entries ordered by ledger key.
+func (s *StateTracker) WriteSnapshot(w io.Writer) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.bootstrapped {
+		return errors.New("state tracker has not been bootstrapped")
+	}
+
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	zw := gzip.NewWriter(w)
+	if err := xdr.MarshalFramed(zw, xdr.Uint32(s.sequence)); err != nil {
+		return errors.Wrap(err, "error writing snapshot header")
+	}
+	for _, k := range keys {
+		if err := xdr.MarshalFramed(zw, s.entries[k]); err != nil {
+			return errors.Wrap(err, "error writing snapshot entry")
+		}
+	}
+	return zw.Close()
+}
+
+// RestoreSnapshot replaces the state with a snapshot written by WriteSnapshot.
+// Entries in the snapshot which are not tracked with the current options are
+// skipped.
+func (s *StateTracker) RestoreSnapshot(r io.Reader) error {
+	stream, err := xdr.NewGzStream(io.NopCloser(r))
+	if err != nil {
+		return errors.Wrap(err, "error opening snapshot")
+	}
+	defer stream.Close()
+
+	var sequence xdr.Uint32
+	if err = stream.ReadOne(&sequence); err != nil {
+		return errors.Wrap(err, "error reading snapshot header")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make(map[string]xdr.LedgerEntry)
+	for {
+		var entry xdr.LedgerEntry
+		err = stream.ReadOne(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading snapshot entry")
+		}
+		if !s.tracks(entry) {
+			continue
+		}
+		key, err := s.entryKey(entry)
+		if err != nil {
+			return err
+		}
+		entries[key] = entry
+	}
+
+	s.entries = entries
+	s.sequence = uint32(sequence)
+	s.bootstrapped = true
+	return nil
+}
+
+// SaveSnapshot writes the state to the file at path. The file is replaced
+// atomically so a crash never leaves a partially written snapshot behind.
+func (s *StateTracker) SaveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "error creating snapshot file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = s.WriteSnapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "error closing snapshot file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "error renaming snapshot file")
+}
+
+// LoadSnapshot replaces the state with the snapshot stored in the file at path.
+func (s *StateTracker) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "error opening snapshot file")
+	}
+	defer f.Close()
+	return s.RestoreSnapshot(f)
+}
+
+// GetLedgerEntries implements StateStore.
+func (s *StateTracker) GetLedgerEntries(ctx context.Context, keys []xdr.LedgerKey) ([]xdr.LedgerEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.getLedgerEntries(keys)
+}
+
+func (s *StateTracker) getLedgerEntries(keys []xdr.LedgerKey) ([]xdr.LedgerEntry, error) {
+	entries := make([]xdr.LedgerEntry, 0, len(keys))
+	for _, key := range keys {
+		entry, ok, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// LedgerKeys implements StateStore.
+func (s *StateTracker) LedgerKeys(ctx context.Context, entryType xdr.LedgerEntryType) iter.Seq2[xdr.LedgerKey, error] {
+	return func(yield func(xdr.LedgerKey, error) bool) {
+		// Collect the keys first so the state isn't locked while the caller
+		// processes them.
+		s.mutex.RLock()
+		keys, err := s.ledgerKeys(entryType)
+		s.mutex.RUnlock()
+		yieldLedgerKeys(keys, err, yield)
+	}
+}
+
+func (s *StateTracker) ledgerKeys(entryType xdr.LedgerEntryType) ([]xdr.LedgerKey, error) {
+	var keys []xdr.LedgerKey
+	for _, entry := range s.entries {
+		if entry.Data.Type != entryType {
+			continue
+		}
+		key, err := entry.LedgerKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting ledger key")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func yieldLedgerKeys(keys []xdr.LedgerKey, err error, yield func(xdr.LedgerKey, error) bool) {
+	if err != nil {
+		yield(xdr.LedgerKey{}, err)
+		return
+	}
+	for _, key := range keys {
+		if !yield(key, nil) {
+			return
+		}
+	}
+}
+
+// lockedStateTracker is the StateStore of a StateTracker whose read lock is
+// held by the caller. The lock can't be taken again by the store methods: a
+// pending ApplyLedger would block them.
+type lockedStateTracker struct {
+	s *StateTracker
+}
+
+func (l lockedStateTracker) GetLedgerEntries(ctx context.Context, keys []xdr.LedgerKey) ([]xdr.LedgerEntry, error) {
+	return l.s.getLedgerEntries(keys)
+}
+
+func (l lockedStateTracker) LedgerKeys(ctx context.Context, entryType xdr.LedgerEntryType) iter.Seq2[xdr.LedgerKey, error] {
+	return func(yield func(xdr.LedgerKey, error) bool) {
+		keys, err := l.s.ledgerKeys(entryType)
+		yieldLedgerKeys(keys, err, yield)
+	}
+}
+
+// VerifyCheckpoint compares the state with the checkpoint of the history
+// archive at the current sequence using VerifyState. It should be called once
+// the ledger of a checkpoint was applied and the checkpoint was published. The
+// state is read locked until it returns, so ApplyLedger waits for the
+// verification. A StateError summarizing the discrepancies is returned if the
+// state doesn't match the archive.
+func (s *StateTracker) VerifyCheckpoint(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	opts ...CheckpointReaderOption,
+) error {
+	entryTypes := make([]xdr.LedgerEntryType, 0, len(xdr.LedgerEntryTypeMap))
+	for t := range xdr.LedgerEntryTypeMap {
+		if s.tracksType(xdr.LedgerEntryType(t)) {
+			entryTypes = append(entryTypes, xdr.LedgerEntryType(t))
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	report, err := VerifyState(ctx, archive, s.sequence, lockedStateTracker{s}, StateVerifierConfig{
+		EntryTypes: entryTypes,
+		TransformEntry: func(entry xdr.LedgerEntry) (xdr.LedgerEntry, bool) {
+			return entry, s.tracks(entry)
+		},
+		ReaderOptions: opts,
+	})
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// ledgerEntriesEqual compares the XDR encoding of both entries.
+func ledgerEntriesEqual(a, b xdr.LedgerEntry) (bool, error) {
+	aBytes, err := a.MarshalBinary()
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling ledger entry")
+	}
+	bBytes, err := b.MarshalBinary()
+	if err != nil {
+		return false, errors.Wrap(err, "error marshaling ledger entry")
+	}
+	return bytes.Equal(aBytes, bBytes), nil
+}
@@ -0,0 +1,257 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+const (
+	trackedAddress1 = "GC3C4AKRBQLHOJ45U4XG35ESVWRDECWO5XLDGYADO6DPR3L7KIDVUMML"
+	trackedAddress2 = "GAHK7EEG2WWHVKDNT4CEQFZGKF2LGDSW2IVM4S5DP42RBW3K6BTODB4A"
+	trackedAddress3 = "GACMZD5VJXTRLKVET72CETCYKELPNCOTTBDC6DHFEUPLG5DHEK534JQX"
+)
+
+func accountEntry(address string, balance int64, lastModified uint32) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		LastModifiedLedgerSeq: xdr.Uint32(lastModified),
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeAccount,
+			Account: &xdr.AccountEntry{
+				AccountId: xdr.MustAddress(address),
+				Balance:   xdr.Int64(balance),
+			},
+		},
+	}
+}
+
+func accountKey(address string) xdr.LedgerKey {
+	var key xdr.LedgerKey
+	if err := key.SetAccount(xdr.MustAddress(address)); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func stateChange(entry xdr.LedgerEntry) xdr.LedgerEntryChange {
+	return xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &entry}
+}
+
+func createdChange(entry xdr.LedgerEntry) xdr.LedgerEntryChange {
+	return xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &entry}
+}
+
+func updatedChange(entry xdr.LedgerEntry) xdr.LedgerEntryChange {
+	return xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated, Updated: &entry}
+}
+
+func removedChange(key xdr.LedgerKey) xdr.LedgerEntryChange {
+	return xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &key}
+}
+
+func bootstrappedStateTracker(t *testing.T, opts ...StateTrackerOption) (*StateTracker, *historyarchive.MockArchive) {
+	archive := &historyarchive.MockArchive{}
+	ingesttest.AddMockCheckpoint(t, archive, 63, 2,
+		accountEntry(trackedAddress1, 100, 10),
+		accountEntry(trackedAddress2, 200, 20),
+		*entryOffer(xdr.BucketEntryTypeLiveentry, trackedAddress1, 1).LiveEntry,
+	)
+
+	tracker := NewStateTracker(network.TestNetworkPassphrase, opts...)
+	require.NoError(t, tracker.Bootstrap(context.Background(), archive, 63, DisableBucketListValidation))
+	return tracker, archive
+}
+
+func TestStateTrackerBootstrap(t *testing.T) {
+	tracker, _ := bootstrappedStateTracker(t, WithTrackedEntryTypes(xdr.LedgerEntryTypeAccount))
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	assert.Equal(t, 2, tracker.Len())
+
+	entry, ok, err := tracker.Get(accountKey(trackedAddress1))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, accountEntry(trackedAddress1, 100, 10), entry)
+
+	_, ok, err = tracker.Get(accountKey(trackedAddress3))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestStateTrackerApplyLedger(t *testing.T) {
+	tracker, _ := bootstrappedStateTracker(t, WithTrackedEntryTypes(xdr.LedgerEntryTypeAccount))
+
+	err := tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(65, nil))
+	assert.EqualError(t, err, "expected ledger 64 but got 65")
+
+	require.NoError(t, tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		stateChange(accountEntry(trackedAddress1, 100, 10)),
+		updatedChange(accountEntry(trackedAddress1, 150, 64)),
+		stateChange(accountEntry(trackedAddress2, 200, 20)),
+		removedChange(accountKey(trackedAddress2)),
+		createdChange(accountEntry(trackedAddress3, 300, 64)),
+	)))
+	assert.Equal(t, uint32(64), tracker.Sequence())
+	assert.Equal(t, 2, tracker.Len())
+
+	entry, ok, err := tracker.Get(accountKey(trackedAddress1))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, accountEntry(trackedAddress1, 150, 64), entry)
+
+	_, ok, err = tracker.Get(accountKey(trackedAddress2))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// evicted entries are removed from the state
+	require.NoError(t, tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(65,
+		[]xdr.LedgerKey{accountKey(trackedAddress3)},
+	)))
+	assert.Equal(t, 1, tracker.Len())
+}
+
+func TestStateTrackerApplyLedgerStateError(t *testing.T) {
+	tracker, _ := bootstrappedStateTracker(t, WithTrackedEntryTypes(xdr.LedgerEntryTypeAccount))
+
+	err := tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		createdChange(accountEntry(trackedAddress1, 100, 64)),
+	))
+	require.Error(t, err)
+	assert.IsType(t, StateError{}, err)
+
+	err = tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		stateChange(accountEntry(trackedAddress3, 100, 10)),
+		removedChange(accountKey(trackedAddress3)),
+	))
+	require.Error(t, err)
+	assert.IsType(t, StateError{}, err)
+
+	// a rejected ledger leaves the state untouched so it can be retried
+	err = tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		stateChange(accountEntry(trackedAddress1, 100, 10)),
+		updatedChange(accountEntry(trackedAddress1, 150, 64)),
+		createdChange(accountEntry(trackedAddress2, 100, 64)),
+	))
+	require.Error(t, err)
+	assert.IsType(t, StateError{}, err)
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	entry, ok, err := tracker.Get(accountKey(trackedAddress1))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, accountEntry(trackedAddress1, 100, 10), entry)
+
+	require.NoError(t, tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		stateChange(accountEntry(trackedAddress1, 100, 10)),
+		updatedChange(accountEntry(trackedAddress1, 150, 64)),
+	)))
+	assert.Equal(t, uint32(64), tracker.Sequence())
+}
+
+func TestStateTrackerEntryFilter(t *testing.T) {
+	tracker, _ := bootstrappedStateTracker(t,
+		WithTrackedEntryTypes(xdr.LedgerEntryTypeAccount),
+		WithTrackedEntryFilter(func(entry xdr.LedgerEntry) bool {
+			return entry.Data.MustAccount().Balance >= 150
+		}),
+	)
+	assert.Equal(t, 1, tracker.Len())
+
+	// trackedAddress1 starts matching the filter and trackedAddress2 stops
+	// matching it.
+	require.NoError(t, tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		stateChange(accountEntry(trackedAddress1, 100, 10)),
+		updatedChange(accountEntry(trackedAddress1, 150, 64)),
+		stateChange(accountEntry(trackedAddress2, 200, 20)),
+		updatedChange(accountEntry(trackedAddress2, 50, 64)),
+	)))
+	assert.Equal(t, 1, tracker.Len())
+	_, ok, err := tracker.Get(accountKey(trackedAddress1))
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestStateTrackerSnapshot(t *testing.T) {
+	tracker, _ := bootstrappedStateTracker(t)
+	assert.Equal(t, 3, tracker.Len())
+
+	var buf bytes.Buffer
+	require.NoError(t, tracker.WriteSnapshot(&buf))
+
+	restored := NewStateTracker(network.TestNetworkPassphrase)
+	require.NoError(t, restored.RestoreSnapshot(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, tracker.Sequence(), restored.Sequence())
+	assert.ElementsMatch(t, collectEntries(tracker), collectEntries(restored))
+
+	// restoring applies the tracker's filters
+	filtered := NewStateTracker(network.TestNetworkPassphrase, WithTrackedEntryTypes(xdr.LedgerEntryTypeOffer))
+	require.NoError(t, filtered.RestoreSnapshot(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, 1, filtered.Len())
+
+	path := filepath.Join(t.TempDir(), "state.xdr.gz")
+	require.NoError(t, tracker.SaveSnapshot(path))
+	loaded := NewStateTracker(network.TestNetworkPassphrase)
+	require.NoError(t, loaded.LoadSnapshot(path))
+	assert.ElementsMatch(t, collectEntries(tracker), collectEntries(loaded))
+}
+
+func TestStateTrackerVerifyCheckpoint(t *testing.T) {
+	tracker, archive := bootstrappedStateTracker(t, WithTrackedEntryTypes(xdr.LedgerEntryTypeAccount))
+	ctx := context.Background()
+	require.NoError(t, tracker.VerifyCheckpoint(ctx, archive, DisableBucketListValidation))
+
+	require.NoError(t, tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(64, nil,
+		stateChange(accountEntry(trackedAddress1, 100, 10)),
+		updatedChange(accountEntry(trackedAddress1, 150, 64)),
+	)))
+	for seq := uint32(65); seq <= 127; seq++ {
+		require.NoError(t, tracker.ApplyLedger(ingesttest.LedgerWithUpgradeChanges(seq, nil)))
+	}
+
+	ingesttest.AddMockCheckpoint(t, archive, 127, 1,
+		accountEntry(trackedAddress1, 150, 64),
+		accountEntry(trackedAddress3, 300, 100),
+	)
+	err := tracker.VerifyCheckpoint(ctx, archive, DisableBucketListValidation)
+	assert.EqualError(t, err, "state does not match checkpoint 127: 1 missing, 1 extra, 0 differing entries")
+	archive.AssertNumberOfCalls(t, "GetXdrStreamForHash", 3)
+}
+
+// lockCheckingArchive asserts that the tracker can't be updated while the
+// buckets of a checkpoint are read.
+type lockCheckingArchive struct {
+	*historyarchive.MockArchive
+	t       *testing.T
+	tracker *StateTracker
+}
+
+func (a lockCheckingArchive) GetXdrStreamForHash(hash historyarchive.Hash) (*xdr.Stream, error) {
+	locked := a.tracker.mutex.TryLock()
+	if locked {
+		a.tracker.mutex.Unlock()
+	}
+	assert.False(a.t, locked, "the state can be updated during the verification")
+	return a.MockArchive.GetXdrStreamForHash(hash)
+}
+
+func TestStateTrackerVerifyCheckpointLocksState(t *testing.T) {
+	tracker, archive := bootstrappedStateTracker(t, WithTrackedEntryTypes(xdr.LedgerEntryTypeAccount))
+	require.NoError(t, tracker.VerifyCheckpoint(context.Background(),
+		lockCheckingArchive{archive, t, tracker}, DisableBucketListValidation))
+	archive.AssertNumberOfCalls(t, "GetXdrStreamForHash", 2)
+}
+
+func collectEntries(tracker *StateTracker) []xdr.LedgerEntry {
+	var entries []xdr.LedgerEntry
+	for entry := range tracker.Entries() {
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,434 @@
+package ingest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// defaultStateVerifierBatchSize is the number of ledger keys requested from a
+// StateStore in a single GetLedgerEntries call.
+const defaultStateVerifierBatchSize = 1000
+
+// StateStore is implemented by any store of ledger entries derived from
+// ledger deltas (e.g. a database populated from LedgerChangeReader) which
+// should be verified against a history archive checkpoint with VerifyState.
+type StateStore interface {
+	// GetLedgerEntries returns the entries stored for the given keys. Keys
+	// which are not present in the store must be omitted from the result.
+	GetLedgerEntries(ctx context.Context, keys []xdr.LedgerKey) ([]xdr.LedgerEntry, error)
+	// LedgerKeys returns an iterator over the keys of all entries of the
+	// given type present in the store.
+	LedgerKeys(ctx context.Context, entryType xdr.LedgerEntryType) iter.Seq2[xdr.LedgerKey, error]
+}
+
+// StateVerifierConfig configures VerifyState.
+type StateVerifierConfig struct {
+	// EntryTypes are the ledger entry types which are verified. All other
+	// entries in the checkpoint are skipped.
+	EntryTypes []xdr.LedgerEntryType
+	// TransformEntry is applied to entries from both the checkpoint and the
+	// store before they are compared. It can be used to normalize fields the
+	// store doesn't keep. Entries for which it returns false are ignored.
+	// If nil, entries are compared as is.
+	TransformEntry func(xdr.LedgerEntry) (xdr.LedgerEntry, bool)
+	// BatchSize is the number of keys requested from the store at once.
+	// Defaults to 1000.
+	BatchSize int
+	// ReaderOptions are passed through to the CheckpointChangeReader.
+	ReaderOptions []CheckpointReaderOption
+}
+
+// StateDiffType describes how an entry in a StateStore differs from the
+// checkpoint.
+type StateDiffType int
+
+const (
+	// StateDiffMissing indicates an entry which is in the checkpoint but not
+	// in the store.
+	StateDiffMissing StateDiffType = iota
+	// StateDiffExtra indicates an entry which is in the store but not in the
+	// checkpoint.
+	StateDiffExtra
+	// StateDiffModified indicates an entry which is present in both but with
+	// different contents.
+	StateDiffModified
+)
+
+func (t StateDiffType) String() string {
+	switch t {
+	case StateDiffMissing:
+		return "missing"
+	case StateDiffExtra:
+		return "extra"
+	case StateDiffModified:
+		return "modified"
+	default:
+		return fmt.Sprintf("StateDiffType(%d)", int(t))
+	}
+}
+
+// StateDiff describes a single entry which doesn't match the checkpoint.
+type StateDiff struct {
+	Type StateDiffType
+	Key  xdr.LedgerKey
+	// Expected is the entry found in the checkpoint. It is nil for
+	// StateDiffExtra.
+	Expected *xdr.LedgerEntry
+	// Actual is the entry found in the store. It is nil for
+	// StateDiffMissing.
+	Actual *xdr.LedgerEntry
+	// Fields lists the fields which differ for StateDiffModified, e.g.
+	// "Data.Account.Balance: expected 100, got 150".
+	Fields []string
+}
+
+// StateVerificationReport is the result of VerifyState.
+type StateVerificationReport struct {
+	// Checkpoint is the checkpoint ledger the store was verified against.
+	Checkpoint uint32
+	// EntriesVerified is the number of checkpoint entries compared with the
+	// store.
+	EntriesVerified int
+	// Diffs lists all entries which don't match the checkpoint.
+	Diffs []StateDiff
+}
+
+// OK returns true if the store matches the checkpoint.
+func (r StateVerificationReport) OK() bool {
+	return len(r.Diffs) == 0
+}
+
+// Counts returns the number of missing, extra and modified entries.
+func (r StateVerificationReport) Counts() (missing, extra, modified int) {
+	for _, diff := range r.Diffs {
+		switch diff.Type {
+		case StateDiffMissing:
+			missing++
+		case StateDiffExtra:
+			extra++
+		case StateDiffModified:
+			modified++
+		}
+	}
+	return
+}
+
+// Err returns a StateError summarizing the report or nil if the store
+// matches the checkpoint.
+func (r StateVerificationReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	missing, extra, modified := r.Counts()
+	return NewStateError(errors.Errorf(
+		"state does not match checkpoint %d: %d missing, %d extra, %d differing entries",
+		r.Checkpoint, missing, extra, modified,
+	))
+}
+
+// stateVerifier holds the state of a single VerifyState run.
+type stateVerifier struct {
+	store          StateStore
+	config         StateVerifierConfig
+	encodingBuffer *xdr.EncodingBuffer
+	seen           map[string]bool
+	pending        []xdr.LedgerEntry
+	report         StateVerificationReport
+}
+
+// VerifyState compares the entries of the configured types in store with the
+// given checkpoint of the history archive. The store must reflect the state of
+// the network at the checkpoint ledger, i.e. all ledger deltas up to and
+// including the checkpoint ledger must have been applied.
+//
+// The checkpoint is streamed with a CheckpointChangeReader and the store is
+// queried in batches, so the memory usage is proportional to the number of
+// ledger keys of the verified types, not to the size of the entries.
+func VerifyState(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	store StateStore,
+	config StateVerifierConfig,
+) (StateVerificationReport, error) {
+	if len(config.EntryTypes) == 0 {
+		return StateVerificationReport{}, errors.New("no entry types to verify")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultStateVerifierBatchSize
+	}
+	if config.TransformEntry == nil {
+		config.TransformEntry = func(entry xdr.LedgerEntry) (xdr.LedgerEntry, bool) {
+			return entry, true
+		}
+	}
+
+	types := make(map[xdr.LedgerEntryType]bool, len(config.EntryTypes))
+	for _, t := range config.EntryTypes {
+		types[t] = true
+	}
+	opts := append([]CheckpointReaderOption{}, config.ReaderOptions...)
+	opts = append(opts, WithFilter(
+		func(entry xdr.LedgerEntry) bool { return types[entry.Data.Type] },
+		func(key xdr.LedgerKey) bool { return types[key.Type] },
+	))
+	reader, err := NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return StateVerificationReport{}, err
+	}
+	defer reader.Close()
+
+	v := &stateVerifier{
+		store:          store,
+		config:         config,
+		encodingBuffer: xdr.NewEncodingBuffer(),
+		seen:           make(map[string]bool),
+		report:         StateVerificationReport{Checkpoint: checkpoint},
+	}
+
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return v.report, errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		entry, keep := config.TransformEntry(*change.Post)
+		if !keep {
+			continue
+		}
+		v.pending = append(v.pending, entry)
+		if len(v.pending) >= config.BatchSize {
+			if err = v.verifyPending(ctx); err != nil {
+				return v.report, err
+			}
+		}
+	}
+	if err = v.verifyPending(ctx); err != nil {
+		return v.report, err
+	}
+
+	for _, t := range config.EntryTypes {
+		if err = v.findExtra(ctx, t); err != nil {
+			return v.report, err
+		}
+	}
+	return v.report, nil
+}
+
+func (v *stateVerifier) key(key xdr.LedgerKey) (string, error) {
+	keyBytes, err := v.encodingBuffer.LedgerKeyUnsafeMarshalBinaryCompress(key)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshaling ledger key")
+	}
+	return string(keyBytes), nil
+}
+
+// fetch returns the transformed entries stored for the given keys indexed by
+// their encoded key.
+func (v *stateVerifier) fetch(ctx context.Context, keys []xdr.LedgerKey) (map[string]xdr.LedgerEntry, error) {
+	entries, err := v.store.GetLedgerEntries(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting ledger entries from store")
+	}
+	result := make(map[string]xdr.LedgerEntry, len(entries))
+	for _, entry := range entries {
+		entry, keep := v.config.TransformEntry(entry)
+		if !keep {
+			continue
+		}
+		key, err := entry.LedgerKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting ledger key")
+		}
+		k, err := v.key(key)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = entry
+	}
+	return result, nil
+}
+
+// verifyPending compares the pending checkpoint entries with the store.
+func (v *stateVerifier) verifyPending(ctx context.Context) error {
+	if len(v.pending) == 0 {
+		return nil
+	}
+	keys := make([]xdr.LedgerKey, len(v.pending))
+	for i := range v.pending {
+		key, err := v.pending[i].LedgerKey()
+		if err != nil {
+			return errors.Wrap(err, "error getting ledger key")
+		}
+		keys[i] = key
+	}
+	actual, err := v.fetch(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	for i, expected := range v.pending {
+		k, err := v.key(keys[i])
+		if err != nil {
+			return err
+		}
+		v.seen[k] = true
+		v.report.EntriesVerified++
+
+		entry, ok := actual[k]
+		if !ok {
+			v.report.Diffs = append(v.report.Diffs, StateDiff{
+				Type:     StateDiffMissing,
+				Key:      keys[i],
+				Expected: &expected,
+			})
+			continue
+		}
+		equal, err := ledgerEntriesEqual(expected, entry)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			v.report.Diffs = append(v.report.Diffs, StateDiff{
+				Type:     StateDiffModified,
+				Key:      keys[i],
+				Expected: &expected,
+				Actual:   &entry,
+				Fields:   DiffLedgerEntries(expected, entry),
+			})
+		}
+	}
+	v.pending = v.pending[:0]
+	return nil
+}
+
+// findExtra reports all entries of the given type which are in the store but
+// were not found in the checkpoint.
+func (v *stateVerifier) findExtra(ctx context.Context, entryType xdr.LedgerEntryType) error {
+	var batch []xdr.LedgerKey
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		actual, err := v.fetch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, key := range batch {
+			k, err := v.key(key)
+			if err != nil {
+				return err
+			}
+			// entries ignored by TransformEntry are not reported
+			if entry, ok := actual[k]; ok {
+				v.report.Diffs = append(v.report.Diffs, StateDiff{
+					Type:   StateDiffExtra,
+					Key:    key,
+					Actual: &entry,
+				})
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for key, err := range v.store.LedgerKeys(ctx, entryType) {
+		if err != nil {
+			return errors.Wrapf(err, "error listing %s keys in store", entryType)
+		}
+		k, err := v.key(key)
+		if err != nil {
+			return err
+		}
+		if v.seen[k] {
+			continue
+		}
+		batch = append(batch, key)
+		if len(batch) >= v.config.BatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// DiffLedgerEntries returns a human readable description of every field which
+// differs between two ledger entries, e.g.
+// "Data.Account.Balance: expected 100, got 150". Byte arrays are compared as a
+// whole and printed in hex.
+func DiffLedgerEntries(expected, actual xdr.LedgerEntry) []string {
+	var diffs []string
+	diffValues("", reflect.ValueOf(expected), reflect.ValueOf(actual), &diffs)
+	return diffs
+}
+
+func diffValues(path string, expected, actual reflect.Value, diffs *[]string) {
+	report := func(e, a string) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: expected %s, got %s", path, e, a))
+	}
+	join := func(field string) string {
+		if path == "" {
+			return field
+		}
+		return path + "." + field
+	}
+
+	switch expected.Kind() {
+	case reflect.Pointer:
+		switch {
+		case expected.IsNil() && actual.IsNil():
+		case expected.IsNil():
+			report("<nil>", "<non-nil>")
+		case actual.IsNil():
+			report("<non-nil>", "<nil>")
+		default:
+			diffValues(path, expected.Elem(), actual.Elem(), diffs)
+		}
+	case reflect.Struct:
+		for i := 0; i < expected.NumField(); i++ {
+			if !expected.Type().Field(i).IsExported() {
+				continue
+			}
+			diffValues(join(expected.Type().Field(i).Name), expected.Field(i), actual.Field(i), diffs)
+		}
+	case reflect.Slice, reflect.Array:
+		if expected.Type().Elem().Kind() == reflect.Uint8 {
+			e, a := bytesOf(expected), bytesOf(actual)
+			if e != a {
+				report(e, a)
+			}
+			return
+		}
+		if expected.Len() != actual.Len() {
+			report(fmt.Sprintf("%d elements", expected.Len()), fmt.Sprintf("%d elements", actual.Len()))
+			return
+		}
+		for i := 0; i < expected.Len(); i++ {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), expected.Index(i), actual.Index(i), diffs)
+		}
+	default:
+		e, a := fmt.Sprintf("%v", expected.Interface()), fmt.Sprintf("%v", actual.Interface())
+		if e != a {
+			report(e, a)
+		}
+	}
+}
+
+func bytesOf(v reflect.Value) string {
+	b := make([]byte, v.Len())
+	for i := range b {
+		b[i] = byte(v.Index(i).Uint())
+	}
+	return hex.EncodeToString(b)
+}
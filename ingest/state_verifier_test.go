@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"context"
+	"iter"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// mapStateStore is a StateStore backed by a slice of entries.
+type mapStateStore []xdr.LedgerEntry
+
+func (m mapStateStore) GetLedgerEntries(ctx context.Context, keys []xdr.LedgerKey) ([]xdr.LedgerEntry, error) {
+	var result []xdr.LedgerEntry
+	for _, key := range keys {
+		for _, entry := range m {
+			entryKey, err := entry.LedgerKey()
+			if err != nil {
+				return nil, err
+			}
+			if entryKey.Equals(key) {
+				result = append(result, entry)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m mapStateStore) LedgerKeys(ctx context.Context, entryType xdr.LedgerEntryType) iter.Seq2[xdr.LedgerKey, error] {
+	return func(yield func(xdr.LedgerKey, error) bool) {
+		for _, entry := range m {
+			if entry.Data.Type != entryType {
+				continue
+			}
+			key, err := entry.LedgerKey()
+			if !yield(key, err) {
+				return
+			}
+		}
+	}
+}
+
+func mockVerifierCheckpoint(t *testing.T) *historyarchive.MockArchive {
+	return ingesttest.MockCheckpoint(t, 63,
+		accountEntry(trackedAddress1, 100, 10),
+		accountEntry(trackedAddress2, 200, 20),
+		*entryOffer(xdr.BucketEntryTypeLiveentry, trackedAddress1, 1).LiveEntry,
+	)
+}
+
+func TestVerifyStateMatches(t *testing.T) {
+	store := mapStateStore{
+		accountEntry(trackedAddress1, 100, 10),
+		accountEntry(trackedAddress2, 200, 20),
+	}
+	report, err := VerifyState(context.Background(), mockVerifierCheckpoint(t), 63, store, StateVerifierConfig{
+		EntryTypes:    []xdr.LedgerEntryType{xdr.LedgerEntryTypeAccount},
+		BatchSize:     1,
+		ReaderOptions: []CheckpointReaderOption{DisableBucketListValidation},
+	})
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.NoError(t, report.Err())
+	assert.Equal(t, 2, report.EntriesVerified)
+}
+
+func TestVerifyStateDiffs(t *testing.T) {
+	store := mapStateStore{
+		accountEntry(trackedAddress1, 150, 10),
+		accountEntry(trackedAddress3, 300, 30),
+	}
+	report, err := VerifyState(context.Background(), mockVerifierCheckpoint(t), 63, store, StateVerifierConfig{
+		EntryTypes:    []xdr.LedgerEntryType{xdr.LedgerEntryTypeAccount},
+		ReaderOptions: []CheckpointReaderOption{DisableBucketListValidation},
+	})
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.EqualError(t, report.Err(), "state does not match checkpoint 63: 1 missing, 1 extra, 1 differing entries")
+	require.Len(t, report.Diffs, 3)
+
+	modified := report.Diffs[0]
+	assert.Equal(t, StateDiffModified, modified.Type)
+	assert.Equal(t, accountKey(trackedAddress1), modified.Key)
+	assert.Equal(t, []string{"Data.Account.Balance: expected 100, got 150"}, modified.Fields)
+
+	missing := report.Diffs[1]
+	assert.Equal(t, StateDiffMissing, missing.Type)
+	assert.Equal(t, accountKey(trackedAddress2), missing.Key)
+	assert.Nil(t, missing.Actual)
+
+	extra := report.Diffs[2]
+	assert.Equal(t, StateDiffExtra, extra.Type)
+	assert.Equal(t, accountKey(trackedAddress3), extra.Key)
+	assert.Nil(t, extra.Expected)
+	assert.Equal(t, "extra", extra.Type.String())
+}
+
+func TestVerifyStateTransformEntry(t *testing.T) {
+	// the store doesn't keep track of last modified ledgers or of trackedAddress2
+	store := mapStateStore{
+		accountEntry(trackedAddress1, 100, 0),
+		accountEntry(trackedAddress3, 300, 0),
+	}
+	report, err := VerifyState(context.Background(), mockVerifierCheckpoint(t), 63, store, StateVerifierConfig{
+		EntryTypes: []xdr.LedgerEntryType{xdr.LedgerEntryTypeAccount},
+		TransformEntry: func(entry xdr.LedgerEntry) (xdr.LedgerEntry, bool) {
+			entry.LastModifiedLedgerSeq = 0
+			address := entry.Data.MustAccount().AccountId.Address()
+			return entry, address != trackedAddress2 && address != trackedAddress3
+		},
+		ReaderOptions: []CheckpointReaderOption{DisableBucketListValidation},
+	})
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, report.EntriesVerified)
+}
+
+func TestVerifyStateRequiresEntryTypes(t *testing.T) {
+	_, err := VerifyState(context.Background(), &historyarchive.MockArchive{}, 63, mapStateStore{}, StateVerifierConfig{})
+	assert.EqualError(t, err, "no entry types to verify")
+}
+
+func TestDiffLedgerEntries(t *testing.T) {
+	expected := accountEntry(trackedAddress1, 100, 10)
+	actual := accountEntry(trackedAddress2, 100, 11)
+	actual.Data.Account.Signers = []xdr.Signer{{Weight: 1}}
+	assert.Equal(t, []string{
+		"LastModifiedLedgerSeq: expected 10, got 11",
+		"Data.Account.AccountId.Ed25519: expected " +
+			"b62e01510c1677279da72e6df492ada2320aceedd63360037786f8ed7f52075a, got " +
+			"0eaf9086d5ac7aa86d9f044817265174b30e56d22ace4ba37f3510db6af066e1",
+		"Data.Account.Signers: expected 0 elements, got 1 elements",
+	}, DiffLedgerEntries(expected, actual))
+}
@@ -0,0 +1,175 @@
+// Package ingesttest contains fixtures for testing code which processes
+// history archive checkpoints and ledgers. It is internal to the SDK so the
+// testing packages it depends on are not imported by the SDK's users.
+package ingesttest
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// ProtocolVersion is the protocol version of the buckets and ledgers
+// returned by MockCheckpoint, AddMockCheckpoint and LedgerWithUpgradeChanges.
+const ProtocolVersion = 23
+
+// MockCheckpoint returns an archive where the given checkpoint consists of a
+// single bucket containing the given live entries, which can be streamed
+// once.
+func MockCheckpoint(t *testing.T, checkpoint uint32, entries ...xdr.LedgerEntry) *historyarchive.MockArchive {
+	archive := &historyarchive.MockArchive{}
+	AddMockCheckpoint(t, archive, checkpoint, 1, entries...)
+	return archive
+}
+
+// AddMockCheckpoint sets up archive so that the given checkpoint consists of a
+// single bucket containing the given live entries, which can be streamed
+// `reads` times. Several checkpoints can be added to the same archive.
+func AddMockCheckpoint(t *testing.T, archive *historyarchive.MockArchive, checkpoint uint32, reads int, entries ...xdr.LedgerEntry) {
+	zero := historyarchive.Hash{}.String()
+	bucketHash := historyarchive.Hash{byte(checkpoint), byte(checkpoint >> 8), 1}
+	has := historyarchive.HistoryArchiveState{CurrentLedger: checkpoint}
+	for i := range has.CurrentBuckets {
+		has.CurrentBuckets[i].Curr = zero
+		has.CurrentBuckets[i].Snap = zero
+	}
+	has.CurrentBuckets[0].Curr = bucketHash.String()
+
+	b := &bytes.Buffer{}
+	bucketEntries := []xdr.BucketEntry{{Type: xdr.BucketEntryTypeMetaentry, MetaEntry: &xdr.BucketMetadata{LedgerVersion: ProtocolVersion}}}
+	for i := range entries {
+		bucketEntries = append(bucketEntries, xdr.BucketEntry{Type: xdr.BucketEntryTypeLiveentry, LiveEntry: &entries[i]})
+	}
+	for _, entry := range bucketEntries {
+		require.NoError(t, xdr.MarshalFramed(b, entry))
+	}
+
+	archive.On("GetCheckpointManager").
+		Return(historyarchive.NewCheckpointManager(historyarchive.DefaultCheckpointFrequency))
+	archive.On("GetCheckpointHAS", checkpoint).Return(has, nil)
+	archive.On("BucketExists", bucketHash).Return(true, nil)
+	archive.On("BucketSize", bucketHash).Return(int64(100), nil)
+	for i := 0; i < reads; i++ {
+		stream := bytes.NewReader(b.Bytes())
+		archive.On("GetXdrStreamForHash", bucketHash).Return(xdr.NewStream(io.NopCloser(stream)), nil).Once()
+	}
+}
+
+// LedgerWithUpgradeChanges returns a ledger without transactions where all
+// the given changes are caused by a protocol upgrade.
+func LedgerWithUpgradeChanges(sequence uint32, evicted []xdr.LedgerKey, changes ...xdr.LedgerEntryChange) xdr.LedgerCloseMeta {
+	return xdr.LedgerCloseMeta{
+		V: 1,
+		V1: &xdr.LedgerCloseMetaV1{
+			LedgerHeader: xdr.LedgerHeaderHistoryEntry{
+				Header: xdr.LedgerHeader{
+					LedgerSeq:     xdr.Uint32(sequence),
+					LedgerVersion: ProtocolVersion,
+				},
+			},
+			TxSet: xdr.GeneralizedTransactionSet{
+				V:       1,
+				V1TxSet: &xdr.TransactionSetV1{},
+			},
+			UpgradesProcessing: []xdr.UpgradeEntryMeta{
+				{Changes: changes},
+			},
+			EvictedKeys: evicted,
+		},
+	}
+}
+
+// Transaction is a successful transaction of a ledger returned by
+// LedgerWithTransaction.
+type Transaction struct {
+	Tx xdr.Transaction
+	// TxChanges are the changes of the transaction before its operations
+	// were applied.
+	TxChanges xdr.LedgerEntryChanges
+	// OperationChanges are the changes caused by each operation of Tx.
+	OperationChanges []xdr.LedgerEntryChanges
+}
+
+// LedgerWithTransaction returns a ledger closed at closedAt with the single
+// given transaction, which is hashed with the test network passphrase. It
+// returns the ledger and the hash of the transaction.
+func LedgerWithTransaction(t *testing.T, sequence uint32, closedAt time.Time, tx Transaction) (xdr.LedgerCloseMeta, xdr.Hash) {
+	var (
+		results []xdr.OperationResult
+		metas   []xdr.OperationMeta
+	)
+	require.Len(t, tx.OperationChanges, len(tx.Tx.Operations))
+	for _, changes := range tx.OperationChanges {
+		results = append(results, xdr.OperationResult{Code: xdr.OperationResultCodeOpInner})
+		metas = append(metas, xdr.OperationMeta{Changes: changes})
+	}
+	envelope := xdr.TransactionEnvelope{
+		Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+		V1:   &xdr.TransactionV1Envelope{Tx: tx.Tx},
+	}
+	hash, err := network.HashTransactionInEnvelope(envelope, network.TestNetworkPassphrase)
+	require.NoError(t, err)
+
+	var closeTime xdr.TimePoint
+	if !closedAt.IsZero() {
+		closeTime = xdr.TimePoint(closedAt.Unix())
+	}
+	return xdr.LedgerCloseMeta{
+		V: 0,
+		V0: &xdr.LedgerCloseMetaV0{
+			LedgerHeader: xdr.LedgerHeaderHistoryEntry{
+				Header: xdr.LedgerHeader{
+					LedgerSeq:     xdr.Uint32(sequence),
+					LedgerVersion: 20,
+					ScpValue:      xdr.StellarValue{CloseTime: closeTime},
+				},
+			},
+			TxSet: xdr.TransactionSet{Txs: []xdr.TransactionEnvelope{envelope}},
+			TxProcessing: []xdr.TransactionResultMeta{{
+				Result: xdr.TransactionResultPair{
+					TransactionHash: hash,
+					Result: xdr.TransactionResult{
+						Result: xdr.TransactionResultResult{
+							Code:    xdr.TransactionResultCodeTxSuccess,
+							Results: &results,
+						},
+					},
+				},
+				TxApplyProcessing: xdr.TransactionMeta{
+					V: 2,
+					V2: &xdr.TransactionMetaV2{
+						TxChangesBefore: tx.TxChanges,
+						Operations:      metas,
+					},
+				},
+			}},
+		},
+	}, hash
+}
+
+// AppendUnsupportedTransaction appends a successful transaction with a single
+// operation to a ledger returned by LedgerWithTransaction. The meta of the
+// transaction has the unsupported version 0, so reading its changes fails
+// once the changes of the previous transactions were read.
+func AppendUnsupportedTransaction(t *testing.T, ledger *xdr.LedgerCloseMeta, source xdr.MuxedAccount) {
+	other, _ := LedgerWithTransaction(t, ledger.LedgerSequence(), time.Time{}, Transaction{
+		Tx: xdr.Transaction{
+			SourceAccount: source,
+			Operations: []xdr.Operation{{
+				Body: xdr.OperationBody{Type: xdr.OperationTypeBumpSequence, BumpSequenceOp: &xdr.BumpSequenceOp{}},
+			}},
+		},
+		OperationChanges: []xdr.LedgerEntryChanges{nil},
+	})
+	processing := other.V0.TxProcessing[0]
+	processing.TxApplyProcessing = xdr.TransactionMeta{V: 0, Operations: &[]xdr.OperationMeta{}}
+	ledger.V0.TxSet.Txs = append(ledger.V0.TxSet.Txs, other.V0.TxSet.Txs[0])
+	ledger.V0.TxProcessing = append(ledger.V0.TxProcessing, processing)
+}
@@ -55,14 +55,33 @@ func continuedFraction(price string) (xdrPrice xdr.Price, err error) {
 	}
 
 	number := &big.Rat{}
-	maxInt32 := &big.Rat{}
-	zero := &big.Rat{}
-	one := &big.Rat{}
-
 	_, ok := number.SetString(price)
 	if !ok {
 		return xdrPrice, fmt.Errorf("cannot parse price: %s", price)
 	}
+	return approximate(number)
+}
+
+// FromAmounts returns the price n/d, e.g. the price of an asset of which d
+// units were traded for n units of another asset. The price is the best
+// rational approximation of n/d whose numerator and denominator fit in a
+// 32-bit signed integer.
+func FromAmounts(n, d int64) (xdr.Price, error) {
+	if d == 0 {
+		return xdr.Price{}, ErrDivisionByZero
+	}
+	if n <= 0 || d < 0 {
+		return xdr.Price{}, fmt.Errorf("invalid price %d/%d", n, d)
+	}
+	return approximate(big.NewRat(n, d))
+}
+
+// approximate returns the best rational approximation of number within the
+// precision limits of a 32-bit signed integer. number is modified.
+func approximate(number *big.Rat) (xdrPrice xdr.Price, err error) {
+	maxInt32 := &big.Rat{}
+	zero := &big.Rat{}
+	one := &big.Rat{}
 
 	maxInt32.SetInt64(int64(math.MaxInt32))
 	zero.SetInt64(int64(0))
@@ -72,6 +72,22 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestFromAmounts(t *testing.T) {
+	p, err := FromAmounts(20, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, xdr.Price{N: 1, D: 2}, p)
+
+	// amounts which do not fit in an int32 are approximated
+	p, err = FromAmounts(math.MaxInt64, 3*(math.MaxInt64/4))
+	assert.NoError(t, err)
+	assert.Equal(t, xdr.Price{N: 4, D: 3}, p)
+
+	_, err = FromAmounts(1, 0)
+	assert.Equal(t, ErrDivisionByZero, err)
+	_, err = FromAmounts(0, 1)
+	assert.EqualError(t, err, "invalid price 0/1")
+}
+
 func TestStringFromFloat64(t *testing.T) {
 
 	tests := map[float64]string{
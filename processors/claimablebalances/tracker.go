@@ -0,0 +1,492 @@
+// Package claimablebalances tracks the lifecycle of claimable balances: their
+// creation, either by a create claimable balance operation or by the
+// revocation of a trustline of an account with pool shares, and their removal
+// when they are claimed or clawed back. It also keeps the open balances so
+// the balances an account can claim, or could no longer claim, can be
+// queried at any time.
+package claimablebalances
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/toid"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Balance is the state of a claimable balance.
+type Balance struct {
+	// ID is the strkey (B...) of the claimable balance ID.
+	ID     string
+	Asset  xdr.Asset
+	Amount xdr.Int64
+	// Claimants are the accounts which can claim the balance with their
+	// predicates, which can be marshaled to JSON.
+	Claimants []xdr.Claimant
+	Flags     xdr.ClaimableBalanceFlags
+	// Sponsor is the account sponsoring the reserve of the balance.
+	Sponsor string
+	// CreatedAt is the close time of the ledger the balance was created in.
+	// It is zero for the balances found in the checkpoint the tracker was
+	// bootstrapped from.
+	CreatedAt          time.Time
+	LastModifiedLedger uint32
+}
+
+func newBalance(entry xdr.LedgerEntry) (Balance, error) {
+	cb := entry.Data.MustClaimableBalance()
+	id, err := cb.BalanceId.EncodeToStrkey()
+	if err != nil {
+		return Balance{}, errors.Wrap(err, "error encoding claimable balance id")
+	}
+	balance := Balance{
+		ID:                 id,
+		Asset:              cb.Asset,
+		Amount:             cb.Amount,
+		Claimants:          cb.Claimants,
+		Flags:              cb.Flags(),
+		LastModifiedLedger: uint32(entry.LastModifiedLedgerSeq),
+	}
+	if sponsor := entry.SponsoringID(); sponsor != nil {
+		balance.Sponsor = sponsor.Address()
+	}
+	return balance, nil
+}
+
+// ClaimStatus is the status of a claimant of a balance at a point in time.
+type ClaimStatus int
+
+const (
+	// ClaimStatusClaimable means that the claimant can claim the balance.
+	ClaimStatusClaimable ClaimStatus = iota
+	// ClaimStatusPending means that the claimant cannot claim the balance yet
+	// but will be able to later.
+	ClaimStatusPending
+	// ClaimStatusExpired means that the claimant cannot claim the balance
+	// anymore.
+	ClaimStatusExpired
+)
+
+func (s ClaimStatus) String() string {
+	switch s {
+	case ClaimStatusClaimable:
+		return "claimable"
+	case ClaimStatusPending:
+		return "pending"
+	case ClaimStatusExpired:
+		return "expired"
+	default:
+		return fmt.Sprintf("ClaimStatus(%d)", int(s))
+	}
+}
+
+// Status returns the status of the claimant with the given address at the
+// given time. It returns an error if the account is not a claimant of the
+// balance.
+func (b Balance) Status(account string, at time.Time) (ClaimStatus, error) {
+	for _, claimant := range b.Claimants {
+		v0 := claimant.MustV0()
+		if v0.Destination.Address() != account {
+			continue
+		}
+		next, ok, err := v0.Predicate.NextSatisfied(b.CreatedAt, at)
+		switch {
+		case err != nil:
+			return 0, errors.Wrapf(err, "error evaluating predicate of %s", account)
+		case !ok:
+			return ClaimStatusExpired, nil
+		case next.Equal(at):
+			return ClaimStatusClaimable, nil
+		default:
+			return ClaimStatusPending, nil
+		}
+	}
+	return 0, errors.Errorf("%s is not a claimant of %s", account, b.ID)
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventCreated is emitted when a balance is created by a create claimable
+	// balance operation.
+	EventCreated EventType = iota
+	// EventCreatedFromRevocation is emitted when a balance is created because
+	// a trustline of an account with pool shares was revoked and the shares
+	// were redeemed.
+	EventCreatedFromRevocation
+	// EventClaimed is emitted when a balance is claimed.
+	EventClaimed
+	// EventClawedBack is emitted when a balance is clawed back by the issuer.
+	EventClawedBack
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventCreatedFromRevocation:
+		return "created_from_revocation"
+	case EventClaimed:
+		return "claimed"
+	case EventClawedBack:
+		return "clawed_back"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a step of the lifecycle of a claimable balance.
+type Event struct {
+	Type            EventType
+	LedgerSequence  uint32
+	ClosedAt        time.Time
+	TransactionHash string
+	OperationIndex  uint32
+	// OperationID is the toid of the operation.
+	OperationID int64
+	// Balance is the balance after it was created or before it was removed.
+	Balance Balance
+	// Claimant is the account which claimed the balance, it is only set for
+	// EventClaimed.
+	Claimant string
+	// LiquidityPoolID is the pool the balance was redeemed from, it is only
+	// set for EventCreatedFromRevocation.
+	LiquidityPoolID *xdr.PoolId
+}
+
+// Tracker maintains the open claimable balances.
+//
+// The state is bootstrapped from a history archive checkpoint and kept up to
+// date by processing every subsequent ledger in order with ProcessLedger,
+// which also returns the lifecycle events of the balances in the ledger.
+//
+// Tracker is safe for concurrent use: queries can be served while ledgers are
+// being processed.
+type Tracker struct {
+	mutex        sync.RWMutex
+	sequence     uint32
+	bootstrapped bool
+
+	balances map[string]Balance
+
+	networkPassphrase string
+}
+
+// NewTracker returns a new, empty Tracker. Bootstrap must be called before any
+// ledger can be processed.
+func NewTracker(networkPassphrase string) *Tracker {
+	return &Tracker{
+		networkPassphrase: networkPassphrase,
+		balances:          map[string]Balance{},
+	}
+}
+
+// Bootstrap replaces the state with the claimable balances found in the
+// given checkpoint of the history archive. Additional options are passed
+// through to the underlying CheckpointChangeReader.
+func (t *Tracker) Bootstrap(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...ingest.CheckpointReaderOption,
+) error {
+	opts = append(opts, ingest.WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			return entry.Data.Type == xdr.LedgerEntryTypeClaimableBalance
+		},
+		func(key xdr.LedgerKey) bool {
+			return key.Type == xdr.LedgerEntryTypeClaimableBalance
+		},
+	))
+	reader, err := ingest.NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "error creating checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.balances = map[string]Balance{}
+	t.bootstrapped = false
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		if change.Type != xdr.LedgerEntryTypeClaimableBalance || change.Post == nil {
+			continue
+		}
+		balance, err := newBalance(*change.Post)
+		if err != nil {
+			return err
+		}
+		t.balances[balance.ID] = balance
+	}
+	t.sequence = checkpoint
+	t.bootstrapped = true
+	return nil
+}
+
+// ProcessLedger updates the balances with the changes in the given ledger and
+// returns the lifecycle events of the ledger in the order they happened.
+// Ledgers must be processed in order, starting with the ledger right after
+// the checkpoint the Tracker was bootstrapped from. The balances are not
+// modified if an error is returned.
+func (t *Tracker) ProcessLedger(ledger xdr.LedgerCloseMeta) ([]Event, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.bootstrapped {
+		return nil, errors.New("claimable balance tracker has not been bootstrapped")
+	}
+	sequence := ledger.LedgerSequence()
+	if sequence != t.sequence+1 {
+		return nil, errors.Errorf("expected ledger %d but got %d", t.sequence+1, sequence)
+	}
+
+	reader, err := ingest.NewLedgerTransactionReaderFromLedgerCloseMeta(t.networkPassphrase, ledger)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating transaction reader")
+	}
+	defer reader.Close()
+
+	// The balances are only updated once the whole ledger was processed so
+	// they are left untouched if an error is returned.
+	updates := balanceUpdates{}
+	var events []Event
+	for {
+		tx, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading transaction")
+		}
+		if !tx.Result.Successful() {
+			continue
+		}
+		for i := range tx.Envelope.Operations() {
+			opEvents, err := t.processOperation(tx, uint32(i), updates)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error processing operation %d of transaction %s", i, tx.Hash.HexString())
+			}
+			events = append(events, opEvents...)
+		}
+	}
+
+	for id, balance := range updates {
+		if balance == nil {
+			delete(t.balances, id)
+		} else {
+			t.balances[id] = *balance
+		}
+	}
+	t.sequence = sequence
+	return events, nil
+}
+
+// balanceUpdates are the balances changed by the ledger being processed,
+// keyed by ID. Removed balances are nil.
+type balanceUpdates map[string]*Balance
+
+// balance returns the balance with the given ID, including the updates of
+// the ledger being processed.
+func (t *Tracker) balance(updates balanceUpdates, id string) (Balance, bool) {
+	if balance, ok := updates[id]; ok {
+		if balance == nil {
+			return Balance{}, false
+		}
+		return *balance, true
+	}
+	balance, ok := t.balances[id]
+	return balance, ok
+}
+
+func (t *Tracker) processOperation(tx ingest.LedgerTransaction, opIndex uint32, updates balanceUpdates) ([]Event, error) {
+	changes, err := tx.GetOperationChanges(opIndex)
+	if err != nil {
+		return nil, err
+	}
+	op := tx.Envelope.Operations()[opIndex]
+	source := op.SourceAccount
+	if source == nil {
+		envelopeSource := tx.Envelope.SourceAccount()
+		source = &envelopeSource
+	}
+	sequence := tx.Ledger.LedgerSequence()
+
+	var events []Event
+	for _, change := range changes {
+		if change.Type != xdr.LedgerEntryTypeClaimableBalance {
+			continue
+		}
+		event := Event{
+			LedgerSequence:  sequence,
+			ClosedAt:        tx.Ledger.ClosedAt(),
+			TransactionHash: tx.Hash.HexString(),
+			OperationIndex:  opIndex,
+			OperationID:     toid.New(int32(sequence), int32(tx.Index), int32(opIndex+1)).ToInt64(),
+		}
+
+		switch {
+		case change.Pre == nil:
+			event.Balance, err = newBalance(*change.Post)
+			if err != nil {
+				return nil, err
+			}
+			event.Balance.CreatedAt = event.ClosedAt
+			switch op.Body.Type {
+			case xdr.OperationTypeCreateClaimableBalance:
+				event.Type = EventCreated
+			case xdr.OperationTypeAllowTrust, xdr.OperationTypeSetTrustLineFlags:
+				event.Type = EventCreatedFromRevocation
+				event.LiquidityPoolID, err = revokedPool(tx, opIndex, changes, change.Post.Data.MustClaimableBalance())
+				if err != nil {
+					return nil, err
+				}
+			default:
+				return nil, errors.Errorf("unexpected creation of claimable balance %s by %s operation", event.Balance.ID, op.Body.Type)
+			}
+			balance := event.Balance
+			updates[balance.ID] = &balance
+
+		case change.Post == nil:
+			event.Balance, err = newBalance(*change.Pre)
+			if err != nil {
+				return nil, err
+			}
+			if existing, ok := t.balance(updates, event.Balance.ID); ok {
+				event.Balance.CreatedAt = existing.CreatedAt
+			}
+			switch op.Body.Type {
+			case xdr.OperationTypeClaimClaimableBalance:
+				event.Type = EventClaimed
+				event.Claimant = source.ToAccountId().Address()
+			case xdr.OperationTypeClawbackClaimableBalance:
+				event.Type = EventClawedBack
+			default:
+				return nil, errors.Errorf("unexpected removal of claimable balance %s by %s operation", event.Balance.ID, op.Body.Type)
+			}
+			updates[event.Balance.ID] = nil
+
+		default:
+			// only the sponsor of a balance can change, which is not part of
+			// its lifecycle
+			balance, err := newBalance(*change.Post)
+			if err != nil {
+				return nil, err
+			}
+			existing, _ := t.balance(updates, balance.ID)
+			balance.CreatedAt = existing.CreatedAt
+			updates[balance.ID] = &balance
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// revokedPool returns the liquidity pool the claimable balance created by a
+// trustline revocation was redeemed from, by recomputing the IDs of the
+// balances of the pools changed by the operation.
+func revokedPool(tx ingest.LedgerTransaction, opIndex uint32, changes []ingest.Change, cb xdr.ClaimableBalanceEntry) (*xdr.PoolId, error) {
+	seqNum := xdr.SequenceNumber(tx.Envelope.SeqNum())
+	txSource := tx.Envelope.SourceAccount().ToAccountId()
+	for _, change := range changes {
+		if change.Type != xdr.LedgerEntryTypeLiquidityPool || change.Pre == nil {
+			continue
+		}
+		poolID := change.Pre.Data.MustLiquidityPool().LiquidityPoolId
+		id, err := token_transfer.ClaimableBalanceIdFromRevocation(poolID, cb.Asset, seqNum, txSource, opIndex)
+		if err != nil {
+			return nil, err
+		}
+		if id.MustV0().Equals(cb.BalanceId.MustV0()) {
+			return &poolID, nil
+		}
+	}
+	return nil, errors.Errorf("cannot find the liquidity pool of claimable balance %s", cb.BalanceId.MustEncodeToStrkey())
+}
+
+// Sequence returns the sequence of the last ledger processed by the Tracker.
+func (t *Tracker) Sequence() uint32 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.sequence
+}
+
+// Len returns the number of open balances.
+func (t *Tracker) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.balances)
+}
+
+// Balance returns the open balance with the given strkey ID.
+func (t *Tracker) Balance(id string) (Balance, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	balance, ok := t.balances[id]
+	return balance, ok
+}
+
+// Pending returns the balances the account can claim at the given time or
+// later, sorted by ID.
+func (t *Tracker) Pending(account string, at time.Time) ([]Balance, error) {
+	return t.withStatus(account, at, ClaimStatusClaimable, ClaimStatusPending)
+}
+
+// Expired returns the balances the account is a claimant of but cannot claim
+// anymore at the given time, sorted by ID. They can only be claimed by other
+// claimants or clawed back.
+func (t *Tracker) Expired(account string, at time.Time) ([]Balance, error) {
+	return t.withStatus(account, at, ClaimStatusExpired)
+}
+
+func (t *Tracker) withStatus(account string, at time.Time, statuses ...ClaimStatus) ([]Balance, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var balances []Balance
+	for _, balance := range t.balances {
+		if !isClaimant(balance, account) {
+			continue
+		}
+		status, err := balance.Status(account, at)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range statuses {
+			if status == s {
+				balances = append(balances, balance)
+				break
+			}
+		}
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].ID < balances[j].ID
+	})
+	return balances, nil
+}
+
+func isClaimant(balance Balance, account string) bool {
+	for _, claimant := range balance.Claimants {
+		if claimant.MustV0().Destination.Address() == account {
+			return true
+		}
+	}
+	return false
+}
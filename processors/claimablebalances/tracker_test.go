@@ -0,0 +1,269 @@
+package claimablebalances
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/toid"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	issuer   = xdr.MustMuxedAddress("GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+	alice    = xdr.MustMuxedAddress("GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON")
+	bob      = xdr.MustMuxedAddress("GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z")
+	usdc     = xdr.MustNewCreditAsset("USDC", issuer.Address())
+	xlm      = xdr.MustNewNativeAsset()
+	poolID   = xdr.PoolId{7}
+	closedAt = time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	unconditional = xdr.ClaimPredicate{Type: xdr.ClaimPredicateTypeClaimPredicateUnconditional}
+)
+
+func beforeAbsoluteTime(t time.Time) xdr.ClaimPredicate {
+	absBefore := xdr.Int64(t.Unix())
+	return xdr.ClaimPredicate{Type: xdr.ClaimPredicateTypeClaimPredicateBeforeAbsoluteTime, AbsBefore: &absBefore}
+}
+
+func not(predicate xdr.ClaimPredicate) xdr.ClaimPredicate {
+	inner := &predicate
+	return xdr.ClaimPredicate{Type: xdr.ClaimPredicateTypeClaimPredicateNot, NotPredicate: &inner}
+}
+
+func claimant(account xdr.MuxedAccount, predicate xdr.ClaimPredicate) xdr.Claimant {
+	return xdr.Claimant{
+		Type: xdr.ClaimantTypeClaimantTypeV0,
+		V0:   &xdr.ClaimantV0{Destination: account.ToAccountId(), Predicate: predicate},
+	}
+}
+
+func balanceEntry(id xdr.ClaimableBalanceId, asset xdr.Asset, amount xdr.Int64, claimants ...xdr.Claimant) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeClaimableBalance,
+			ClaimableBalance: &xdr.ClaimableBalanceEntry{
+				BalanceId: id,
+				Claimants: claimants,
+				Asset:     asset,
+				Amount:    amount,
+			},
+		},
+	}
+}
+
+func balanceID(b byte) xdr.ClaimableBalanceId {
+	return xdr.ClaimableBalanceId{Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0, V0: &xdr.Hash{b}}
+}
+
+// existing is a balance found in the checkpoint, alice can claim it but bob
+// could only claim it before the ledger closed
+var existing = balanceEntry(balanceID(1), xlm, 100,
+	claimant(alice, unconditional),
+	claimant(bob, beforeAbsoluteTime(closedAt.Add(-time.Hour))),
+)
+
+func bootstrappedTracker(t *testing.T) *Tracker {
+	archive := ingesttest.MockCheckpoint(t, 63, existing)
+	tracker := NewTracker(network.TestNetworkPassphrase)
+	require.NoError(t, tracker.Bootstrap(context.Background(), archive, 63, ingest.DisableBucketListValidation))
+	archive.AssertExpectations(t)
+	return tracker
+}
+
+// operation is an operation with the changes it caused.
+type operation struct {
+	body    xdr.OperationBody
+	source  *xdr.MuxedAccount
+	changes xdr.LedgerEntryChanges
+}
+
+func created(entry xdr.LedgerEntry) xdr.LedgerEntryChanges {
+	return xdr.LedgerEntryChanges{{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &entry}}
+}
+
+func removed(entry xdr.LedgerEntry) xdr.LedgerEntryChanges {
+	key, err := entry.LedgerKey()
+	if err != nil {
+		panic(err)
+	}
+	return xdr.LedgerEntryChanges{
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &entry},
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &key},
+	}
+}
+
+// testLedger returns a ledger with a single successful transaction of issuer
+// with the given operations.
+func testLedger(t *testing.T, sequence uint32, ops ...operation) xdr.LedgerCloseMeta {
+	tx := ingesttest.Transaction{Tx: xdr.Transaction{SourceAccount: issuer, SeqNum: 42}}
+	for _, op := range ops {
+		tx.Tx.Operations = append(tx.Tx.Operations, xdr.Operation{SourceAccount: op.source, Body: op.body})
+		tx.OperationChanges = append(tx.OperationChanges, op.changes)
+	}
+	ledger, _ := ingesttest.LedgerWithTransaction(t, sequence, closedAt, tx)
+	return ledger
+}
+
+func TestTrackerBootstrap(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	assert.Equal(t, 1, tracker.Len())
+
+	balance, ok := tracker.Balance(balanceID(1).MustEncodeToStrkey())
+	require.True(t, ok)
+	assert.Equal(t, xdr.Int64(100), balance.Amount)
+	assert.True(t, balance.CreatedAt.IsZero())
+
+	status, err := balance.Status(alice.Address(), closedAt)
+	require.NoError(t, err)
+	assert.Equal(t, ClaimStatusClaimable, status)
+	status, err = balance.Status(bob.Address(), closedAt)
+	require.NoError(t, err)
+	assert.Equal(t, ClaimStatusExpired, status)
+	assert.Equal(t, "expired", status.String())
+	_, err = balance.Status(issuer.Address(), closedAt)
+	assert.EqualError(t, err, issuer.Address()+" is not a claimant of "+balance.ID)
+}
+
+func TestTrackerProcessLedger(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+
+	// bob can claim the new balance from a day after the ledger closed
+	newBalance := balanceEntry(balanceID(2), usdc, 50,
+		claimant(alice, beforeAbsoluteTime(closedAt.Add(time.Hour))),
+		claimant(bob, not(beforeAbsoluteTime(closedAt.Add(24*time.Hour)))),
+	)
+	revokedID, err := token_transfer.ClaimableBalanceIdFromRevocation(poolID, usdc, 42, issuer.ToAccountId(), 2)
+	require.NoError(t, err)
+	revoked := balanceEntry(revokedID, usdc, 10, claimant(bob, unconditional))
+	pool := xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeLiquidityPool,
+			LiquidityPool: &xdr.LiquidityPoolEntry{
+				LiquidityPoolId: poolID,
+				Body: xdr.LiquidityPoolEntryBody{
+					Type: xdr.LiquidityPoolTypeLiquidityPoolConstantProduct,
+					ConstantProduct: &xdr.LiquidityPoolEntryConstantProduct{
+						Params: xdr.LiquidityPoolConstantProductParameters{AssetA: xlm, AssetB: usdc, Fee: xdr.LiquidityPoolFeeV18},
+					},
+				},
+			},
+		},
+	}
+
+	events, err := tracker.ProcessLedger(testLedger(t, 64,
+		operation{
+			body: xdr.OperationBody{
+				Type:                     xdr.OperationTypeCreateClaimableBalance,
+				CreateClaimableBalanceOp: &xdr.CreateClaimableBalanceOp{Asset: usdc, Amount: 50},
+			},
+			changes: created(newBalance),
+		},
+		operation{
+			body: xdr.OperationBody{
+				Type:                    xdr.OperationTypeClaimClaimableBalance,
+				ClaimClaimableBalanceOp: &xdr.ClaimClaimableBalanceOp{BalanceId: balanceID(1)},
+			},
+			source:  &alice,
+			changes: removed(existing),
+		},
+		operation{
+			body: xdr.OperationBody{
+				Type:                xdr.OperationTypeSetTrustLineFlags,
+				SetTrustLineFlagsOp: &xdr.SetTrustLineFlagsOp{Trustor: bob.ToAccountId(), Asset: usdc},
+			},
+			changes: append(removed(pool), created(revoked)...),
+		},
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	assert.Equal(t, EventCreated, events[0].Type)
+	assert.Equal(t, uint32(64), events[0].LedgerSequence)
+	assert.Equal(t, toid.New(64, 1, 1).ToInt64(), events[0].OperationID)
+	assert.Equal(t, balanceID(2).MustEncodeToStrkey(), events[0].Balance.ID)
+	assert.Equal(t, closedAt, events[0].Balance.CreatedAt)
+	assert.Len(t, events[0].Balance.Claimants, 2)
+
+	assert.Equal(t, EventClaimed, events[1].Type)
+	assert.Equal(t, alice.Address(), events[1].Claimant)
+	assert.Equal(t, balanceID(1).MustEncodeToStrkey(), events[1].Balance.ID)
+
+	assert.Equal(t, EventCreatedFromRevocation, events[2].Type)
+	assert.Equal(t, "created_from_revocation", events[2].Type.String())
+	assert.Equal(t, &poolID, events[2].LiquidityPoolID)
+	assert.Equal(t, revokedID.MustEncodeToStrkey(), events[2].Balance.ID)
+
+	assert.Equal(t, 2, tracker.Len())
+	pending, err := tracker.Pending(bob.Address(), closedAt)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	expired, err := tracker.Expired(alice.Address(), closedAt.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, balanceID(2).MustEncodeToStrkey(), expired[0].ID)
+	status, err := expired[0].Status(bob.Address(), closedAt)
+	require.NoError(t, err)
+	assert.Equal(t, ClaimStatusPending, status)
+
+	events, err = tracker.ProcessLedger(testLedger(t, 65,
+		operation{
+			body: xdr.OperationBody{
+				Type:                       xdr.OperationTypeClawbackClaimableBalance,
+				ClawbackClaimableBalanceOp: &xdr.ClawbackClaimableBalanceOp{BalanceId: balanceID(2)},
+			},
+			changes: removed(newBalance),
+		},
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventClawedBack, events[0].Type)
+	assert.Empty(t, events[0].Claimant)
+	// the creation time is kept from the creation of the balance
+	assert.Equal(t, closedAt, events[0].Balance.CreatedAt)
+	assert.Equal(t, 1, tracker.Len())
+}
+
+func TestTrackerProcessLedgerErrors(t *testing.T) {
+	tracker := NewTracker(network.TestNetworkPassphrase)
+	_, err := tracker.ProcessLedger(testLedger(t, 64))
+	assert.EqualError(t, err, "claimable balance tracker has not been bootstrapped")
+
+	tracker = bootstrappedTracker(t)
+	_, err = tracker.ProcessLedger(testLedger(t, 65))
+	assert.EqualError(t, err, "expected ledger 64 but got 65")
+}
+
+func TestTrackerProcessLedgerRejected(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+
+	// the claim is processed before the second transaction fails, it must
+	// not be applied
+	ledger := testLedger(t, 64, operation{
+		body: xdr.OperationBody{
+			Type:                    xdr.OperationTypeClaimClaimableBalance,
+			ClaimClaimableBalanceOp: &xdr.ClaimClaimableBalanceOp{BalanceId: balanceID(1)},
+		},
+		source:  &alice,
+		changes: removed(existing),
+	})
+	ingesttest.AppendUnsupportedTransaction(t, &ledger, issuer)
+
+	_, err := tracker.ProcessLedger(ledger)
+	require.ErrorContains(t, err, "TransactionMeta.V=0 not supported")
+	assert.Equal(t, 1, tracker.Len())
+	pending, err := tracker.Pending(alice.Address(), closedAt)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+
+	_, err = tracker.ProcessLedger(testLedger(t, 64))
+	require.NoError(t, err)
+}
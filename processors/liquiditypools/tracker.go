@@ -0,0 +1,457 @@
+// Package liquiditypools tracks the state of the liquidity pools over time
+// and emits the lifecycle events of every pool, so it is possible to follow
+// deposits, withdrawals and trades and to compute the returns of liquidity
+// providers.
+package liquiditypools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/toid"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// year is the duration used to annualize returns.
+const year = 365 * 24 * time.Hour
+
+// PoolState is the state of a liquidity pool at a point in time.
+type PoolState struct {
+	ReserveA                 xdr.Int64
+	ReserveB                 xdr.Int64
+	TotalPoolShares          xdr.Int64
+	PoolSharesTrustLineCount xdr.Int64
+}
+
+// Price returns the price of a unit of asset A in units of asset B implied
+// by the reserves of the pool, or nil if the pool has no reserves.
+func (s PoolState) Price() *big.Rat {
+	if s.ReserveA == 0 || s.ReserveB == 0 {
+		return nil
+	}
+	return big.NewRat(int64(s.ReserveB), int64(s.ReserveA))
+}
+
+func poolState(pool xdr.LiquidityPoolEntry) PoolState {
+	cp := pool.Body.MustConstantProduct()
+	return PoolState{
+		ReserveA:                 cp.ReserveA,
+		ReserveB:                 cp.ReserveB,
+		TotalPoolShares:          cp.TotalPoolShares,
+		PoolSharesTrustLineCount: cp.PoolSharesTrustLineCount,
+	}
+}
+
+// PoolStats are the trades of a pool since Since, the close time of the
+// first ledger in which the pool was tracked.
+type PoolStats struct {
+	Since      time.Time
+	TradeCount uint64
+	// VolumeA and VolumeB are the amounts of asset A and B sold to the pool.
+	VolumeA xdr.Int64
+	VolumeB xdr.Int64
+	// FeesA and FeesB are the fees, in asset A and B, retained by the pool
+	// for the liquidity providers.
+	FeesA xdr.Int64
+	FeesB xdr.Int64
+}
+
+// Pool is the current state of a liquidity pool.
+type Pool struct {
+	ID     xdr.PoolId
+	AssetA xdr.Asset
+	AssetB xdr.Asset
+	// Fee is the fee of the pool in basis points.
+	Fee                xdr.Int32
+	State              PoolState
+	LastModifiedLedger uint32
+	Stats              PoolStats
+}
+
+// FeeAPR returns the annualized return of the fees earned by the liquidity
+// providers between Stats.Since and now, relative to the current value of
+// the reserves. Fees and reserves are valued at the current price of the
+// pool. ok is false if the return cannot be computed, because the pool is
+// empty or no time has passed.
+func (p Pool) FeeAPR(now time.Time) (apr float64, ok bool) {
+	elapsed := now.Sub(p.Stats.Since)
+	currentPrice := p.State.Price()
+	if elapsed <= 0 || currentPrice == nil {
+		return 0, false
+	}
+	// values in units of asset B
+	fees := new(big.Rat).Mul(big.NewRat(int64(p.Stats.FeesA), 1), currentPrice)
+	fees.Add(fees, big.NewRat(int64(p.Stats.FeesB), 1))
+	reserves := big.NewRat(2*int64(p.State.ReserveB), 1)
+	ratio, _ := new(big.Rat).Quo(fees, reserves).Float64()
+	return ratio * float64(year) / float64(elapsed), true
+}
+
+// ImpermanentLoss returns the loss, as a negative fraction, of providing
+// liquidity to a constant product pool compared to holding the assets when
+// the price changes from entryPrice to currentPrice, excluding fees. For
+// example, it returns about -0.057 if the price doubled.
+func ImpermanentLoss(entryPrice, currentPrice *big.Rat) (float64, error) {
+	if entryPrice == nil || currentPrice == nil || entryPrice.Sign() <= 0 || currentPrice.Sign() <= 0 {
+		return 0, errors.New("prices must be positive")
+	}
+	ratio, _ := new(big.Rat).Quo(currentPrice, entryPrice).Float64()
+	return 2*math.Sqrt(ratio)/(1+ratio) - 1, nil
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventCreated is emitted when a pool is created by the first pool share
+	// trustline.
+	EventCreated EventType = iota
+	// EventDeposit is emitted when liquidity is deposited into a pool.
+	EventDeposit
+	// EventWithdraw is emitted when liquidity is withdrawn from a pool.
+	EventWithdraw
+	// EventTrade is emitted when a pool is crossed by a manage offer or path
+	// payment operation.
+	EventTrade
+	// EventReservesChanged is emitted when the state of a pool changes for any
+	// other reason, e.g. when pool shares are redeemed because a trustline of
+	// one of the assets of the pool is revoked.
+	EventReservesChanged
+	// EventRemoved is emitted when a pool is removed with the last pool share
+	// trustline.
+	EventRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventDeposit:
+		return "deposit"
+	case EventWithdraw:
+		return "withdraw"
+	case EventTrade:
+		return "trade"
+	case EventReservesChanged:
+		return "reserves_changed"
+	case EventRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event describes a change of a liquidity pool caused by an operation.
+type Event struct {
+	Type            EventType
+	PoolID          xdr.PoolId
+	LedgerSequence  uint32
+	ClosedAt        time.Time
+	TransactionHash string
+	OperationIndex  uint32
+	// OperationID is the toid of the operation.
+	OperationID   int64
+	OperationType xdr.OperationType
+	// Pre is the state of the pool before the operation, it is nil for
+	// EventCreated. Post is the state of the pool after the operation, it is
+	// nil for EventRemoved.
+	Pre  *PoolState
+	Post *PoolState
+}
+
+// Tracker maintains the current state of every liquidity pool.
+//
+// The state is bootstrapped from a history archive checkpoint and kept up to
+// date by processing every subsequent ledger in order with ProcessLedger,
+// which also returns the events of the pools in the ledger.
+//
+// Tracker is safe for concurrent use: queries can be served while ledgers are
+// being processed.
+type Tracker struct {
+	mutex        sync.RWMutex
+	sequence     uint32
+	closedAt     time.Time
+	bootstrapped bool
+
+	pools map[xdr.PoolId]*Pool
+
+	networkPassphrase string
+}
+
+// NewTracker returns a new, empty Tracker. Bootstrap must be called before any
+// ledger can be processed.
+func NewTracker(networkPassphrase string) *Tracker {
+	return &Tracker{
+		networkPassphrase: networkPassphrase,
+		pools:             map[xdr.PoolId]*Pool{},
+	}
+}
+
+// Bootstrap replaces the state with the liquidity pools found in the given
+// checkpoint of the history archive. Additional options are passed through to
+// the underlying CheckpointChangeReader.
+func (t *Tracker) Bootstrap(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...ingest.CheckpointReaderOption,
+) error {
+	opts = append(opts, ingest.WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			return entry.Data.Type == xdr.LedgerEntryTypeLiquidityPool
+		},
+		func(key xdr.LedgerKey) bool {
+			return key.Type == xdr.LedgerEntryTypeLiquidityPool
+		},
+	))
+	reader, err := ingest.NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "error creating checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.bootstrap(reader, checkpoint)
+}
+
+func (t *Tracker) bootstrap(reader ingest.ChangeReader, checkpoint uint32) error {
+	t.pools = map[xdr.PoolId]*Pool{}
+	t.bootstrapped = false
+	t.closedAt = time.Time{}
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		if change.Type != xdr.LedgerEntryTypeLiquidityPool || change.Post == nil {
+			continue
+		}
+		t.upsert(*change.Post)
+	}
+	t.sequence = checkpoint
+	t.bootstrapped = true
+	return nil
+}
+
+// ProcessLedger updates the pools with the changes in the given ledger and
+// returns the events of the ledger in the order they happened. Ledgers must
+// be processed in order, starting with the ledger right after the checkpoint
+// the Tracker was bootstrapped from. The pools are not modified if an error is
+// returned.
+func (t *Tracker) ProcessLedger(ledger xdr.LedgerCloseMeta) ([]Event, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.bootstrapped {
+		return nil, errors.New("liquidity pool tracker has not been bootstrapped")
+	}
+	sequence := ledger.LedgerSequence()
+	if sequence != t.sequence+1 {
+		return nil, errors.Errorf("expected ledger %d but got %d", t.sequence+1, sequence)
+	}
+	closedAt := ledger.ClosedAt()
+
+	reader, err := ingest.NewLedgerTransactionReaderFromLedgerCloseMeta(t.networkPassphrase, ledger)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating transaction reader")
+	}
+	defer reader.Close()
+
+	// The pools are only updated once all the changes of the ledger were
+	// read so they are left untouched if an error is returned.
+	type poolChange struct {
+		event  Event
+		change ingest.Change
+	}
+	var changes []poolChange
+	for {
+		tx, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading transaction")
+		}
+		if !tx.Result.Successful() {
+			continue
+		}
+		for i, op := range tx.Envelope.Operations() {
+			opChanges, err := tx.GetOperationChanges(uint32(i))
+			if err != nil {
+				return nil, errors.Wrapf(err, "error getting changes of operation %d of transaction %s", i, tx.Hash.HexString())
+			}
+			for _, change := range opChanges {
+				if change.Type != xdr.LedgerEntryTypeLiquidityPool {
+					continue
+				}
+				changes = append(changes, poolChange{
+					event: Event{
+						LedgerSequence:  sequence,
+						ClosedAt:        closedAt,
+						TransactionHash: tx.Hash.HexString(),
+						OperationIndex:  uint32(i),
+						OperationID:     toid.New(int32(sequence), int32(tx.Index), int32(i+1)).ToInt64(),
+						OperationType:   op.Body.Type,
+					},
+					change: change,
+				})
+			}
+		}
+	}
+
+	if t.closedAt.IsZero() {
+		// the fees of the pools found in the checkpoint are accumulated from
+		// the first processed ledger
+		for _, pool := range t.pools {
+			pool.Stats.Since = closedAt
+		}
+	}
+	events := make([]Event, 0, len(changes))
+	for _, c := range changes {
+		event := c.event
+		t.apply(&event, c.change)
+		events = append(events, event)
+	}
+
+	t.sequence = sequence
+	t.closedAt = closedAt
+	return events, nil
+}
+
+// apply applies the change to the pools and fills the type, pool and states
+// of the event.
+func (t *Tracker) apply(event *Event, change ingest.Change) {
+	if change.Pre != nil {
+		pre := change.Pre.Data.MustLiquidityPool()
+		event.PoolID = pre.LiquidityPoolId
+		state := poolState(pre)
+		event.Pre = &state
+	}
+	if change.Post == nil {
+		event.Type = EventRemoved
+		delete(t.pools, event.PoolID)
+		return
+	}
+
+	pool := t.upsert(*change.Post)
+	event.PoolID = pool.ID
+	post := pool.State
+	event.Post = &post
+	if event.Pre == nil {
+		event.Type = EventCreated
+		pool.Stats.Since = event.ClosedAt
+		return
+	}
+
+	switch event.OperationType {
+	case xdr.OperationTypeLiquidityPoolDeposit:
+		event.Type = EventDeposit
+	case xdr.OperationTypeLiquidityPoolWithdraw:
+		event.Type = EventWithdraw
+	case xdr.OperationTypeManageSellOffer, xdr.OperationTypeManageBuyOffer, xdr.OperationTypeCreatePassiveSellOffer,
+		xdr.OperationTypePathPaymentStrictReceive, xdr.OperationTypePathPaymentStrictSend:
+		event.Type = EventTrade
+		pool.addTrade(*event.Pre, *event.Post)
+	default:
+		event.Type = EventReservesChanged
+	}
+}
+
+// addTrade adds a trade, which moved the reserves of the pool from pre to
+// post, to the stats of the pool.
+func (p *Pool) addTrade(pre, post PoolState) {
+	p.Stats.TradeCount++
+	// the fee is retained in the reserve of the asset sold to the pool
+	if soldA := post.ReserveA - pre.ReserveA; soldA > 0 {
+		p.Stats.VolumeA += soldA
+		p.Stats.FeesA += poolFee(soldA, p.Fee)
+	}
+	if soldB := post.ReserveB - pre.ReserveB; soldB > 0 {
+		p.Stats.VolumeB += soldB
+		p.Stats.FeesB += poolFee(soldB, p.Fee)
+	}
+}
+
+func poolFee(amount xdr.Int64, fee xdr.Int32) xdr.Int64 {
+	result := new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(int64(fee)))
+	return xdr.Int64(result.Quo(result, big.NewInt(10000)).Int64())
+}
+
+// upsert adds or updates the pool of the ledger entry and returns it.
+func (t *Tracker) upsert(entry xdr.LedgerEntry) *Pool {
+	lp := entry.Data.MustLiquidityPool()
+	pool, ok := t.pools[lp.LiquidityPoolId]
+	if !ok {
+		params := lp.Body.MustConstantProduct().Params
+		pool = &Pool{
+			ID:     lp.LiquidityPoolId,
+			AssetA: params.AssetA,
+			AssetB: params.AssetB,
+			Fee:    params.Fee,
+		}
+		t.pools[lp.LiquidityPoolId] = pool
+	}
+	pool.State = poolState(lp)
+	pool.LastModifiedLedger = uint32(entry.LastModifiedLedgerSeq)
+	return pool
+}
+
+// Sequence returns the sequence of the last ledger processed by the Tracker.
+func (t *Tracker) Sequence() uint32 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.sequence
+}
+
+// ClosedAt returns the close time of the last ledger processed by the
+// Tracker.
+func (t *Tracker) ClosedAt() time.Time {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.closedAt
+}
+
+// Len returns the number of pools.
+func (t *Tracker) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.pools)
+}
+
+// Pool returns the current state of the pool with the given ID.
+func (t *Tracker) Pool(id xdr.PoolId) (Pool, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	pool, ok := t.pools[id]
+	if !ok {
+		return Pool{}, false
+	}
+	return *pool, true
+}
+
+// Pools returns the current state of every pool, sorted by ID.
+func (t *Tracker) Pools() []Pool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	pools := make([]Pool, 0, len(t.pools))
+	for _, pool := range t.pools {
+		pools = append(pools, *pool)
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return string(pools[i].ID[:]) < string(pools[j].ID[:])
+	})
+	return pools
+}
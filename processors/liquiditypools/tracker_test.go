@@ -0,0 +1,260 @@
+package liquiditypools
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/toid"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	source   = xdr.MustMuxedAddress("GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON")
+	usdc     = xdr.MustNewCreditAsset("USDC", "GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+	eurc     = xdr.MustNewCreditAsset("EURC", "GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+	xlm      = xdr.MustNewNativeAsset()
+	poolA    = xdr.PoolId{1}
+	poolB    = xdr.PoolId{2}
+	closedAt = time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+)
+
+func poolEntry(id xdr.PoolId, assetA, assetB xdr.Asset, reserveA, reserveB, shares xdr.Int64) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeLiquidityPool,
+			LiquidityPool: &xdr.LiquidityPoolEntry{
+				LiquidityPoolId: id,
+				Body: xdr.LiquidityPoolEntryBody{
+					Type: xdr.LiquidityPoolTypeLiquidityPoolConstantProduct,
+					ConstantProduct: &xdr.LiquidityPoolEntryConstantProduct{
+						Params: xdr.LiquidityPoolConstantProductParameters{
+							AssetA: assetA,
+							AssetB: assetB,
+							Fee:    xdr.LiquidityPoolFeeV18,
+						},
+						ReserveA:                 reserveA,
+						ReserveB:                 reserveB,
+						TotalPoolShares:          shares,
+						PoolSharesTrustLineCount: 1,
+					},
+				},
+			},
+		},
+	}
+}
+
+func bootstrappedTracker(t *testing.T) *Tracker {
+	archive := ingesttest.MockCheckpoint(t, 63,
+		poolEntry(poolA, xlm, usdc, 1000000, 4000000, 2000000),
+		xdr.LedgerEntry{
+			Data: xdr.LedgerEntryData{
+				Type:    xdr.LedgerEntryTypeAccount,
+				Account: &xdr.AccountEntry{AccountId: source.ToAccountId()},
+			},
+		},
+	)
+	tracker := NewTracker(network.TestNetworkPassphrase)
+	require.NoError(t, tracker.Bootstrap(context.Background(), archive, 63, ingest.DisableBucketListValidation))
+	archive.AssertExpectations(t)
+	return tracker
+}
+
+// operation is an operation with the changes of the pools it caused.
+type operation struct {
+	opType  xdr.OperationType
+	changes xdr.LedgerEntryChanges
+}
+
+func poolChange(pre, post *xdr.LedgerEntry) xdr.LedgerEntryChanges {
+	switch {
+	case pre == nil:
+		return xdr.LedgerEntryChanges{{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: post}}
+	case post == nil:
+		key, err := pre.LedgerKey()
+		if err != nil {
+			panic(err)
+		}
+		return xdr.LedgerEntryChanges{
+			{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: pre},
+			{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &key},
+		}
+	default:
+		return xdr.LedgerEntryChanges{
+			{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: pre},
+			{Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated, Updated: post},
+		}
+	}
+}
+
+// operationBody returns an operation body of the given type which can be
+// hashed.
+func operationBody(opType xdr.OperationType) xdr.OperationBody {
+	body := xdr.OperationBody{Type: opType}
+	switch opType {
+	case xdr.OperationTypePayment:
+		body.PaymentOp = &xdr.PaymentOp{Destination: source, Asset: xlm}
+	case xdr.OperationTypePathPaymentStrictSend:
+		body.PathPaymentStrictSendOp = &xdr.PathPaymentStrictSendOp{Destination: source, SendAsset: xlm, DestAsset: usdc}
+	case xdr.OperationTypeChangeTrust:
+		body.ChangeTrustOp = &xdr.ChangeTrustOp{Line: xdr.ChangeTrustAsset{Type: xdr.AssetTypeAssetTypeNative}}
+	case xdr.OperationTypeLiquidityPoolDeposit:
+		body.LiquidityPoolDepositOp = &xdr.LiquidityPoolDepositOp{LiquidityPoolId: poolA}
+	case xdr.OperationTypeSetTrustLineFlags:
+		body.SetTrustLineFlagsOp = &xdr.SetTrustLineFlagsOp{Trustor: source.ToAccountId(), Asset: usdc}
+	}
+	return body
+}
+
+// testLedger returns a ledger with a single successful transaction with the
+// given operations.
+func testLedger(t *testing.T, sequence uint32, ops ...operation) (xdr.LedgerCloseMeta, xdr.Hash) {
+	tx := ingesttest.Transaction{Tx: xdr.Transaction{SourceAccount: source}}
+	for _, op := range ops {
+		tx.Tx.Operations = append(tx.Tx.Operations, xdr.Operation{Body: operationBody(op.opType)})
+		tx.OperationChanges = append(tx.OperationChanges, op.changes)
+	}
+	return ingesttest.LedgerWithTransaction(t, sequence, closedAt, tx)
+}
+
+func TestTrackerBootstrap(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	assert.Equal(t, 1, tracker.Len())
+
+	pool, ok := tracker.Pool(poolA)
+	require.True(t, ok)
+	assert.Equal(t, xlm, pool.AssetA)
+	assert.Equal(t, usdc, pool.AssetB)
+	assert.Equal(t, xdr.Int32(30), pool.Fee)
+	assert.Equal(t, big.NewRat(4, 1), pool.State.Price())
+
+	_, ok = tracker.Pool(poolB)
+	assert.False(t, ok)
+}
+
+func TestTrackerProcessLedger(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+
+	initial := poolEntry(poolA, xlm, usdc, 1000000, 4000000, 2000000)
+	// 10000 XLM are sold to the pool, the fee of 30 bps is retained in the
+	// XLM reserve
+	traded := poolEntry(poolA, xlm, usdc, 1010000, 3960000, 2000000)
+	deposited := poolEntry(poolA, xlm, usdc, 2020000, 7920000, 4000000)
+	created := poolEntry(poolB, eurc, usdc, 0, 0, 0)
+	ledger, hash := testLedger(t, 64,
+		operation{xdr.OperationTypePathPaymentStrictSend, poolChange(&initial, &traded)},
+		operation{xdr.OperationTypeLiquidityPoolDeposit, poolChange(&traded, &deposited)},
+		operation{xdr.OperationTypeChangeTrust, poolChange(nil, &created)},
+		operation{xdr.OperationTypePayment, nil},
+	)
+	events, err := tracker.ProcessLedger(ledger)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	assert.Equal(t, Event{
+		Type:            EventTrade,
+		PoolID:          poolA,
+		LedgerSequence:  64,
+		ClosedAt:        closedAt,
+		TransactionHash: xdr.Hash(hash).HexString(),
+		OperationIndex:  0,
+		OperationID:     toid.New(64, 1, 1).ToInt64(),
+		OperationType:   xdr.OperationTypePathPaymentStrictSend,
+		Pre:             &PoolState{ReserveA: 1000000, ReserveB: 4000000, TotalPoolShares: 2000000, PoolSharesTrustLineCount: 1},
+		Post:            &PoolState{ReserveA: 1010000, ReserveB: 3960000, TotalPoolShares: 2000000, PoolSharesTrustLineCount: 1},
+	}, events[0])
+	assert.Equal(t, "trade", events[0].Type.String())
+	assert.Equal(t, EventDeposit, events[1].Type)
+	assert.Equal(t, uint32(1), events[1].OperationIndex)
+	assert.Equal(t, EventCreated, events[2].Type)
+	assert.Equal(t, poolB, events[2].PoolID)
+	assert.Nil(t, events[2].Pre)
+	assert.Nil(t, events[2].Post.Price())
+
+	assert.Equal(t, uint32(64), tracker.Sequence())
+	assert.Equal(t, closedAt, tracker.ClosedAt())
+	pools := tracker.Pools()
+	require.Len(t, pools, 2)
+	assert.Equal(t, poolA, pools[0].ID)
+	assert.Equal(t, PoolStats{
+		Since:      closedAt,
+		TradeCount: 1,
+		VolumeA:    10000,
+		FeesA:      30,
+	}, pools[0].Stats)
+	assert.Equal(t, xdr.Int64(4000000), pools[0].State.TotalPoolShares)
+
+	// fees and reserves are valued in USDC at the current price, so over a
+	// day the return is 30 XLM of fees out of 2 * 2020000 XLM of reserves
+	apr, ok := pools[0].FeeAPR(closedAt.Add(24 * time.Hour))
+	require.True(t, ok)
+	assert.InDelta(t, 30.0/(2*2020000)*365, apr, 1e-9)
+	_, ok = pools[0].FeeAPR(closedAt)
+	assert.False(t, ok)
+	_, ok = pools[1].FeeAPR(closedAt.Add(time.Hour))
+	assert.False(t, ok)
+
+	emptied := poolEntry(poolA, xlm, usdc, 1000, 4000, 2000)
+	ledger, _ = testLedger(t, 65,
+		// redeemed pool shares of a revoked trustline
+		operation{xdr.OperationTypeSetTrustLineFlags, poolChange(&deposited, &emptied)},
+		operation{xdr.OperationTypeChangeTrust, poolChange(&created, nil)},
+	)
+	events, err = tracker.ProcessLedger(ledger)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventReservesChanged, events[0].Type)
+	assert.Equal(t, EventRemoved, events[1].Type)
+	assert.Equal(t, poolB, events[1].PoolID)
+	assert.Nil(t, events[1].Post)
+	assert.Equal(t, 1, tracker.Len())
+}
+
+func TestTrackerProcessLedgerErrors(t *testing.T) {
+	ledger, _ := testLedger(t, 64)
+	tracker := NewTracker(network.TestNetworkPassphrase)
+	_, err := tracker.ProcessLedger(ledger)
+	assert.EqualError(t, err, "liquidity pool tracker has not been bootstrapped")
+
+	tracker = bootstrappedTracker(t)
+	ledger, _ = testLedger(t, 65)
+	_, err = tracker.ProcessLedger(ledger)
+	assert.EqualError(t, err, "expected ledger 64 but got 65")
+
+	// a rejected ledger leaves the pools untouched
+	initial := poolEntry(poolA, xlm, usdc, 1000000, 4000000, 2000000)
+	traded := poolEntry(poolA, xlm, usdc, 1010000, 3960000, 2000000)
+	ledger, _ = testLedger(t, 64,
+		operation{xdr.OperationTypePathPaymentStrictSend, poolChange(&initial, &traded)},
+	)
+	ingesttest.AppendUnsupportedTransaction(t, &ledger, source)
+	_, err = tracker.ProcessLedger(ledger)
+	assert.ErrorContains(t, err, "TransactionMeta.V=0 not supported")
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	assert.True(t, tracker.ClosedAt().IsZero())
+	pool, ok := tracker.Pool(poolA)
+	require.True(t, ok)
+	assert.Equal(t, PoolState{ReserveA: 1000000, ReserveB: 4000000, TotalPoolShares: 2000000, PoolSharesTrustLineCount: 1}, pool.State)
+	assert.Equal(t, PoolStats{}, pool.Stats)
+}
+
+func TestImpermanentLoss(t *testing.T) {
+	loss, err := ImpermanentLoss(big.NewRat(1, 1), big.NewRat(2, 1))
+	require.NoError(t, err)
+	assert.InDelta(t, -0.0572, loss, 1e-4)
+
+	loss, err = ImpermanentLoss(big.NewRat(3, 7), big.NewRat(3, 7))
+	require.NoError(t, err)
+	assert.Zero(t, loss)
+
+	_, err = ImpermanentLoss(nil, big.NewRat(1, 1))
+	assert.EqualError(t, err, "prices must be positive")
+}
@@ -0,0 +1,263 @@
+// Package signers extracts the changes of the multisig configuration of
+// accounts, i.e. their signers, thresholds and flags, from ledger close meta
+// and keeps their history so the configuration of an account can be
+// retrieved as of any ledger.
+package signers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/txnbuild"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Thresholds are the thresholds of the operations of an account.
+type Thresholds struct {
+	Low    byte
+	Medium byte
+	High   byte
+}
+
+// Configuration is the multisig configuration of an account.
+type Configuration struct {
+	// Signers are the weights of the signers of the account, including the
+	// master key if its weight is not zero.
+	Signers    map[string]int32
+	Thresholds Thresholds
+	Flags      xdr.AccountFlags
+}
+
+func configuration(account xdr.AccountEntry) Configuration {
+	return Configuration{
+		Signers: account.SignerSummary(),
+		Thresholds: Thresholds{
+			Low:    account.ThresholdLow(),
+			Medium: account.ThresholdMedium(),
+			High:   account.ThresholdHigh(),
+		},
+		Flags: xdr.AccountFlags(account.Flags),
+	}
+}
+
+// Equals returns true if both configurations are the same.
+func (c Configuration) Equals(other Configuration) bool {
+	if c.Thresholds != other.Thresholds || c.Flags != other.Flags || len(c.Signers) != len(other.Signers) {
+		return false
+	}
+	for signer, weight := range c.Signers {
+		if otherWeight, ok := other.Signers[signer]; !ok || otherWeight != weight {
+			return false
+		}
+	}
+	return true
+}
+
+// SignerSummary returns the signers of the configuration, as used to verify
+// the signatures of a transaction.
+func (c Configuration) SignerSummary() txnbuild.SignerSummary {
+	summary := make(txnbuild.SignerSummary, len(c.Signers))
+	for signer, weight := range c.Signers {
+		summary[signer] = weight
+	}
+	return summary
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventAccountCreated is emitted when an account is created, Current is
+	// its initial configuration.
+	EventAccountCreated EventType = iota
+	// EventAccountRemoved is emitted when an account is merged, Previous is
+	// its last configuration.
+	EventAccountRemoved
+	// EventSignerAdded is emitted when a signer is added to an account. The
+	// master key is added when its weight changes from zero.
+	EventSignerAdded
+	// EventSignerRemoved is emitted when a signer is removed from an account.
+	// The master key is removed when its weight is set to zero.
+	EventSignerRemoved
+	// EventSignerWeightChanged is emitted when the weight of a signer changes.
+	EventSignerWeightChanged
+	// EventThresholdsChanged is emitted when the thresholds of an account
+	// change.
+	EventThresholdsChanged
+	// EventFlagsChanged is emitted when the flags of an account change.
+	EventFlagsChanged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAccountCreated:
+		return "account_created"
+	case EventAccountRemoved:
+		return "account_removed"
+	case EventSignerAdded:
+		return "signer_added"
+	case EventSignerRemoved:
+		return "signer_removed"
+	case EventSignerWeightChanged:
+		return "signer_weight_changed"
+	case EventThresholdsChanged:
+		return "thresholds_changed"
+	case EventFlagsChanged:
+		return "flags_changed"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a change of the configuration of an account.
+type Event struct {
+	Type            EventType
+	Account         string
+	LedgerSequence  uint32
+	ClosedAt        time.Time
+	TransactionHash string
+	// OperationIndex is the index of the operation which caused the change.
+	// It is nil if the change was caused by the transaction itself, e.g. when
+	// a pre-authorized transaction signer is removed.
+	OperationIndex *uint32
+
+	// Signer, PreviousWeight and Weight are only set for signer events.
+	Signer         string
+	PreviousWeight int32
+	Weight         int32
+
+	// Previous and Current are the configurations of the account before and
+	// after the change. Previous is nil for EventAccountCreated and Current
+	// is nil for EventAccountRemoved.
+	Previous *Configuration
+	Current  *Configuration
+}
+
+// EventsFromLedger returns the changes of the configuration of accounts in
+// all the transactions of the ledger, in the order they happened.
+func EventsFromLedger(lcm xdr.LedgerCloseMeta, networkPassphrase string) ([]Event, error) {
+	txReader, err := ingest.NewLedgerTransactionReaderFromLedgerCloseMeta(networkPassphrase, lcm)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating transaction reader")
+	}
+	defer txReader.Close()
+
+	var events []Event
+	for {
+		tx, err := txReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading transaction")
+		}
+		txEvents, err := EventsFromTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, txEvents...)
+	}
+	return events, nil
+}
+
+// EventsFromTransaction returns the changes of the configuration of accounts
+// in the transaction. Failed transactions can remove pre-authorized
+// transaction signers too.
+func EventsFromTransaction(tx ingest.LedgerTransaction) ([]Event, error) {
+	changes, err := tx.GetChanges()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting changes of transaction %s", tx.Hash.HexString())
+	}
+
+	var events []Event
+	for _, change := range changes {
+		if change.Type != xdr.LedgerEntryTypeAccount {
+			continue
+		}
+		template := Event{
+			LedgerSequence:  tx.Ledger.LedgerSequence(),
+			ClosedAt:        tx.Ledger.ClosedAt(),
+			TransactionHash: tx.Hash.HexString(),
+		}
+		if change.Reason == ingest.LedgerEntryChangeReasonOperation {
+			opIndex := change.OperationIndex
+			template.OperationIndex = &opIndex
+		}
+		events = append(events, diff(template, change)...)
+	}
+	return events, nil
+}
+
+// diff returns the events of the change of an account entry, there are none
+// if only other fields of the account, like its balance, changed.
+func diff(template Event, change ingest.Change) []Event {
+	var pre, post *Configuration
+	if change.Pre != nil {
+		account := change.Pre.Data.MustAccount()
+		template.Account = account.AccountId.Address()
+		c := configuration(account)
+		pre = &c
+	}
+	if change.Post != nil {
+		account := change.Post.Data.MustAccount()
+		template.Account = account.AccountId.Address()
+		c := configuration(account)
+		post = &c
+	}
+	template.Previous, template.Current = pre, post
+
+	switch {
+	case pre == nil:
+		template.Type = EventAccountCreated
+		return []Event{template}
+	case post == nil:
+		template.Type = EventAccountRemoved
+		return []Event{template}
+	}
+
+	var events []Event
+	signers := make([]string, 0, len(pre.Signers)+len(post.Signers))
+	for signer := range pre.Signers {
+		signers = append(signers, signer)
+	}
+	for signer := range post.Signers {
+		if _, ok := pre.Signers[signer]; !ok {
+			signers = append(signers, signer)
+		}
+	}
+	sort.Strings(signers)
+	for _, signer := range signers {
+		event := template
+		event.Signer = signer
+		event.PreviousWeight = pre.Signers[signer]
+		event.Weight = post.Signers[signer]
+		_, existed := pre.Signers[signer]
+		_, exists := post.Signers[signer]
+		switch {
+		case !existed:
+			event.Type = EventSignerAdded
+		case !exists:
+			event.Type = EventSignerRemoved
+		case event.PreviousWeight != event.Weight:
+			event.Type = EventSignerWeightChanged
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	if pre.Thresholds != post.Thresholds {
+		event := template
+		event.Type = EventThresholdsChanged
+		events = append(events, event)
+	}
+	if pre.Flags != post.Flags {
+		event := template
+		event.Type = EventFlagsChanged
+		events = append(events, event)
+	}
+	return events
+}
@@ -0,0 +1,130 @@
+package signers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/txnbuild"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	treasury = xdr.MustAddress("GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+	other    = xdr.MustAddress("GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z")
+	alice    = xdr.MustSigner("GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON")
+	bob      = xdr.MustSigner("GCXKG6RN4ONIEPCMNFB732A436Z5PNDSRLGWK7GBLCMQLIFO4S7EYWVU")
+	preAuth  = xdr.SignerKey{Type: xdr.SignerKeyTypeSignerKeyTypePreAuthTx, PreAuthTx: &xdr.Uint256{1}}
+	closedAt = time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+)
+
+// accountEntry returns an account with the given master weight, low, medium
+// and high thresholds, balance and signers.
+func accountEntry(id xdr.AccountId, thresholds xdr.Thresholds, balance xdr.Int64, signers ...xdr.Signer) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeAccount,
+			Account: &xdr.AccountEntry{
+				AccountId:  id,
+				Balance:    balance,
+				Thresholds: thresholds,
+				Signers:    signers,
+			},
+		},
+	}
+}
+
+func updated(pre, post xdr.LedgerEntry) xdr.LedgerEntryChanges {
+	return xdr.LedgerEntryChanges{
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &pre},
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated, Updated: &post},
+	}
+}
+
+// testLedger returns a ledger with a single transaction of treasury, with the
+// given transaction level changes and one operation per element of
+// opChanges.
+func testLedger(t *testing.T, sequence uint32, txChanges xdr.LedgerEntryChanges, opChanges ...xdr.LedgerEntryChanges) xdr.LedgerCloseMeta {
+	tx := ingesttest.Transaction{
+		Tx:               xdr.Transaction{SourceAccount: treasury.ToMuxedAccount(), SeqNum: xdr.SequenceNumber(sequence)},
+		TxChanges:        txChanges,
+		OperationChanges: opChanges,
+	}
+	for range opChanges {
+		tx.Tx.Operations = append(tx.Tx.Operations, xdr.Operation{
+			Body: xdr.OperationBody{Type: xdr.OperationTypeSetOptions, SetOptionsOp: &xdr.SetOptionsOp{}},
+		})
+	}
+	ledger, _ := ingesttest.LedgerWithTransaction(t, sequence, closedAt, tx)
+	return ledger
+}
+
+func TestEventsFromLedger(t *testing.T) {
+	initial := accountEntry(treasury, xdr.Thresholds{1, 1, 2, 2}, 100,
+		xdr.Signer{Key: alice, Weight: 1},
+		xdr.Signer{Key: preAuth, Weight: 1},
+	)
+	// the pre-authorized transaction signer is removed when the transaction
+	// is applied and the fee is charged
+	afterTx := accountEntry(treasury, xdr.Thresholds{1, 1, 2, 2}, 90, xdr.Signer{Key: alice, Weight: 1})
+	// the master key is disabled, bob is added, the weight of alice and the
+	// thresholds change
+	afterSetOptions := accountEntry(treasury, xdr.Thresholds{0, 1, 2, 3}, 90,
+		xdr.Signer{Key: alice, Weight: 2},
+		xdr.Signer{Key: bob, Weight: 1},
+	)
+	afterSetOptions.Data.Account.Flags = xdr.Uint32(xdr.AccountFlagsAuthRequiredFlag)
+	created := accountEntry(other, xdr.Thresholds{1, 0, 0, 0}, 10)
+	// only the balance changes
+	paid := accountEntry(other, xdr.Thresholds{1, 0, 0, 0}, 20)
+
+	events, err := EventsFromLedger(testLedger(t, 64,
+		updated(initial, afterTx),
+		updated(afterTx, afterSetOptions),
+		append(xdr.LedgerEntryChanges{{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &created}}, updated(created, paid)...),
+	), network.TestNetworkPassphrase)
+	require.NoError(t, err)
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type.String())
+	}
+	assert.Equal(t, []string{
+		"signer_removed",
+		"signer_removed",
+		"signer_weight_changed",
+		"signer_added",
+		"thresholds_changed",
+		"flags_changed",
+		"account_created",
+	}, types)
+
+	assert.Equal(t, treasury.Address(), events[0].Account)
+	assert.Equal(t, preAuth.Address(), events[0].Signer)
+	assert.Nil(t, events[0].OperationIndex)
+	assert.Equal(t, uint32(64), events[0].LedgerSequence)
+	assert.Equal(t, closedAt, events[0].ClosedAt)
+
+	// the master key is removed when its weight is set to zero
+	assert.Equal(t, treasury.Address(), events[1].Signer)
+	assert.Equal(t, int32(1), events[1].PreviousWeight)
+	assert.Equal(t, int32(0), events[1].Weight)
+	require.NotNil(t, events[1].OperationIndex)
+	assert.Equal(t, uint32(0), *events[1].OperationIndex)
+
+	assert.Equal(t, alice.Address(), events[2].Signer)
+	assert.Equal(t, int32(2), events[2].Weight)
+	assert.Equal(t, bob.Address(), events[3].Signer)
+	assert.Equal(t, Thresholds{Low: 1, Medium: 2, High: 3}, events[4].Current.Thresholds)
+	assert.Equal(t, Thresholds{Low: 1, Medium: 2, High: 2}, events[4].Previous.Thresholds)
+	assert.Equal(t, txnbuild.SignerSummary{alice.Address(): 2, bob.Address(): 1}, events[5].Current.SignerSummary())
+
+	assert.Equal(t, other.Address(), events[6].Account)
+	assert.Nil(t, events[6].Previous)
+	assert.Equal(t, map[string]int32{other.Address(): 1}, events[6].Current.Signers)
+	assert.Equal(t, uint32(1), *events[6].OperationIndex)
+}
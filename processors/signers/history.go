@@ -0,0 +1,209 @@
+package signers
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/support/collections/set"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/txnbuild"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// snapshot is the configuration of an account from a ledger until the next
+// snapshot. A nil configuration means the account did not exist.
+type snapshot struct {
+	ledger        uint32
+	configuration *Configuration
+}
+
+// History keeps the history of the configuration of accounts.
+//
+// The history starts at a history archive checkpoint and is extended by
+// processing every subsequent ledger in order with ProcessLedger, which also
+// returns the changes of the configuration of the accounts in the ledger.
+//
+// History is safe for concurrent use: queries can be served while ledgers are
+// being processed.
+type History struct {
+	mutex        sync.RWMutex
+	sequence     uint32
+	bootstrapped bool
+	start        uint32
+
+	snapshots map[string][]snapshot
+	accounts  set.Set[string]
+
+	networkPassphrase string
+}
+
+// HistoryOption configures a History.
+type HistoryOption func(*History)
+
+// WithAccounts restricts the history to the given accounts, e.g. the
+// treasury accounts of an organization. By default, the history of every
+// account is kept.
+func WithAccounts(accounts ...string) HistoryOption {
+	return func(h *History) {
+		if h.accounts == nil {
+			h.accounts = set.NewSet[string](len(accounts))
+		}
+		h.accounts.AddSlice(accounts)
+	}
+}
+
+// NewHistory returns a new, empty History. Bootstrap must be called before
+// any ledger can be processed.
+func NewHistory(networkPassphrase string, opts ...HistoryOption) *History {
+	h := &History{
+		networkPassphrase: networkPassphrase,
+		snapshots:         map[string][]snapshot{},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *History) tracked(account string) bool {
+	return h.accounts == nil || h.accounts.Contains(account)
+}
+
+// Bootstrap replaces the history with the configurations of the accounts
+// found in the given checkpoint of the history archive. Additional options
+// are passed through to the underlying CheckpointChangeReader.
+func (h *History) Bootstrap(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...ingest.CheckpointReaderOption,
+) error {
+	opts = append(opts, ingest.WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			return entry.Data.Type == xdr.LedgerEntryTypeAccount &&
+				h.tracked(entry.Data.MustAccount().AccountId.Address())
+		},
+		func(key xdr.LedgerKey) bool {
+			return key.Type == xdr.LedgerEntryTypeAccount &&
+				h.tracked(key.MustAccount().AccountId.Address())
+		},
+	))
+	reader, err := ingest.NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "error creating checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.snapshots = map[string][]snapshot{}
+	h.bootstrapped = false
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		if change.Type != xdr.LedgerEntryTypeAccount || change.Post == nil {
+			continue
+		}
+		account := change.Post.Data.MustAccount()
+		c := configuration(account)
+		h.snapshots[account.AccountId.Address()] = []snapshot{{ledger: checkpoint, configuration: &c}}
+	}
+	h.sequence = checkpoint
+	h.start = checkpoint
+	h.bootstrapped = true
+	return nil
+}
+
+// ProcessLedger adds the changes of the configuration of the tracked accounts
+// in the given ledger to the history and returns them, in the order they
+// happened. Ledgers must be processed in order, starting with the ledger
+// right after the checkpoint the History was bootstrapped from.
+func (h *History) ProcessLedger(ledger xdr.LedgerCloseMeta) ([]Event, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.bootstrapped {
+		return nil, errors.New("signer history has not been bootstrapped")
+	}
+	sequence := ledger.LedgerSequence()
+	if sequence != h.sequence+1 {
+		return nil, errors.Errorf("expected ledger %d but got %d", h.sequence+1, sequence)
+	}
+
+	events, err := EventsFromLedger(ledger, h.networkPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	tracked := events[:0]
+	for _, event := range events {
+		if !h.tracked(event.Account) {
+			continue
+		}
+		tracked = append(tracked, event)
+
+		// the last change of the account in the ledger determines its
+		// configuration as of the ledger
+		snapshots := h.snapshots[event.Account]
+		if n := len(snapshots); n > 0 && snapshots[n-1].ledger == sequence {
+			snapshots[n-1].configuration = event.Current
+		} else {
+			snapshots = append(snapshots, snapshot{ledger: sequence, configuration: event.Current})
+		}
+		h.snapshots[event.Account] = snapshots
+	}
+
+	h.sequence = sequence
+	return tracked, nil
+}
+
+// Sequence returns the sequence of the last ledger processed by the History.
+func (h *History) Sequence() uint32 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.sequence
+}
+
+// ConfigurationAt returns the configuration of the account as of the given
+// ledger, i.e. after the ledger was applied. ok is false if the account did
+// not exist. It returns an error if the ledger is outside of the history.
+func (h *History) ConfigurationAt(account string, ledger uint32) (c Configuration, ok bool, err error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if !h.bootstrapped || ledger < h.start || ledger > h.sequence {
+		return Configuration{}, false, errors.Errorf("ledger %d is outside of the history [%d, %d]", ledger, h.start, h.sequence)
+	}
+	if !h.tracked(account) {
+		return Configuration{}, false, errors.Errorf("account %s is not tracked", account)
+	}
+	snapshots := h.snapshots[account]
+	// index of the first snapshot after the ledger
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].ledger > ledger
+	})
+	if i == 0 || snapshots[i-1].configuration == nil {
+		return Configuration{}, false, nil
+	}
+	return *snapshots[i-1].configuration, true, nil
+}
+
+// SignerSummaryAt returns the signers of the account as of the given ledger,
+// which can be used to verify the signatures of the transactions of the
+// account at that time, e.g. with txnbuild.VerifyChallengeTxThreshold.
+func (h *History) SignerSummaryAt(account string, ledger uint32) (txnbuild.SignerSummary, bool, error) {
+	c, ok, err := h.ConfigurationAt(account, ledger)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return c.SignerSummary(), true, nil
+}
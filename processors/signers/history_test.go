@@ -0,0 +1,112 @@
+package signers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/txnbuild"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func TestHistory(t *testing.T) {
+	initial := accountEntry(treasury, xdr.Thresholds{1, 1, 2, 2}, 100, xdr.Signer{Key: alice, Weight: 1})
+	withBob := accountEntry(treasury, xdr.Thresholds{1, 1, 2, 2}, 100,
+		xdr.Signer{Key: alice, Weight: 1},
+		xdr.Signer{Key: bob, Weight: 1},
+	)
+	withoutMaster := accountEntry(treasury, xdr.Thresholds{0, 1, 2, 2}, 100,
+		xdr.Signer{Key: alice, Weight: 1},
+		xdr.Signer{Key: bob, Weight: 1},
+	)
+	otherAccount := accountEntry(other, xdr.Thresholds{1, 0, 0, 0}, 10)
+
+	archive := ingesttest.MockCheckpoint(t, 63, initial, otherAccount)
+	history := NewHistory(network.TestNetworkPassphrase, WithAccounts(treasury.Address()))
+	require.NoError(t, history.Bootstrap(context.Background(), archive, 63, ingest.DisableBucketListValidation))
+	archive.AssertExpectations(t)
+
+	otherWithSigner := accountEntry(other, xdr.Thresholds{1, 0, 0, 0}, 10, xdr.Signer{Key: alice, Weight: 1})
+	events, err := history.ProcessLedger(testLedger(t, 64, nil,
+		updated(initial, withBob),
+		updated(otherAccount, otherWithSigner),
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventSignerAdded, events[0].Type)
+
+	_, err = history.ProcessLedger(testLedger(t, 65, nil))
+	require.NoError(t, err)
+	// both changes in the same ledger
+	events, err = history.ProcessLedger(testLedger(t, 66, nil,
+		updated(withBob, withoutMaster),
+		updated(withoutMaster, initial),
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, uint32(66), history.Sequence())
+
+	for _, testCase := range []struct {
+		ledger   uint32
+		expected txnbuild.SignerSummary
+	}{
+		{63, txnbuild.SignerSummary{treasury.Address(): 1, alice.Address(): 1}},
+		{64, txnbuild.SignerSummary{treasury.Address(): 1, alice.Address(): 1, bob.Address(): 1}},
+		{65, txnbuild.SignerSummary{treasury.Address(): 1, alice.Address(): 1, bob.Address(): 1}},
+		{66, txnbuild.SignerSummary{treasury.Address(): 1, alice.Address(): 1}},
+	} {
+		summary, ok, err := history.SignerSummaryAt(treasury.Address(), testCase.ledger)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, testCase.expected, summary, "ledger %d", testCase.ledger)
+	}
+
+	_, _, err = history.SignerSummaryAt(treasury.Address(), 67)
+	assert.EqualError(t, err, "ledger 67 is outside of the history [63, 66]")
+	_, _, err = history.SignerSummaryAt(other.Address(), 64)
+	assert.EqualError(t, err, "account "+other.Address()+" is not tracked")
+
+	_, err = history.ProcessLedger(testLedger(t, 68, nil))
+	assert.EqualError(t, err, "expected ledger 67 but got 68")
+	_, err = NewHistory(network.TestNetworkPassphrase).ProcessLedger(testLedger(t, 64, nil))
+	assert.EqualError(t, err, "signer history has not been bootstrapped")
+}
+
+func TestHistoryAccountLifecycle(t *testing.T) {
+	archive := ingesttest.MockCheckpoint(t, 63)
+	history := NewHistory(network.TestNetworkPassphrase)
+	require.NoError(t, history.Bootstrap(context.Background(), archive, 63, ingest.DisableBucketListValidation))
+
+	created := accountEntry(other, xdr.Thresholds{1, 0, 0, 0}, 10)
+	_, err := history.ProcessLedger(testLedger(t, 64, nil,
+		xdr.LedgerEntryChanges{{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &created}},
+	))
+	require.NoError(t, err)
+	key, err := created.LedgerKey()
+	require.NoError(t, err)
+	events, err := history.ProcessLedger(testLedger(t, 65, nil,
+		xdr.LedgerEntryChanges{
+			{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &created},
+			{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &key},
+		},
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventAccountRemoved, events[0].Type)
+
+	_, ok, err := history.ConfigurationAt(other.Address(), 63)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	c, ok, err := history.ConfigurationAt(other.Address(), 64)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Thresholds{}, c.Thresholds)
+	_, ok, err = history.ConfigurationAt(other.Address(), 65)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
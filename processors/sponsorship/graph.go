@@ -0,0 +1,522 @@
+// Package sponsorship maintains the graph of reserve sponsorships, i.e. which
+// account sponsors the reserve of every account, trustline, offer, data
+// entry, signer and claimable balance, so sponsorships can be audited and
+// reconciled with the reserves of the sponsors.
+package sponsorship
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/support/collections/set"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Item is a ledger entry or a signer of an account, whose reserve can be
+// sponsored.
+type Item struct {
+	// Key is the key of the ledger entry. For signers, it is the key of the
+	// account of the signer.
+	Key xdr.LedgerKey
+	// Signer is the address of the signer if the item is a signer, it is empty
+	// otherwise.
+	Signer string
+}
+
+func (i Item) id() (string, error) {
+	key, err := i.Key.MarshalBinaryBase64()
+	if err != nil {
+		return "", errors.Wrap(err, "error encoding ledger key")
+	}
+	if i.Signer == "" {
+		return key, nil
+	}
+	return key + "/" + i.Signer, nil
+}
+
+// Sponsorship is the sponsorship of the reserves of an item.
+type Sponsorship struct {
+	Item    Item
+	Sponsor string
+	// Reserves is the number of base reserves sponsored, i.e. the
+	// contribution of the item to the NumSponsoring of the sponsor account.
+	Reserves uint32
+}
+
+// sponsorships returns the sponsorships of the entry and, for accounts, of
+// their signers, by item id.
+func sponsorships(entry *xdr.LedgerEntry) (map[string]Sponsorship, error) {
+	result := map[string]Sponsorship{}
+	if entry == nil {
+		return result, nil
+	}
+	key, err := entry.LedgerKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting ledger key")
+	}
+	add := func(item Item, sponsor xdr.AccountId, reserves uint32) error {
+		id, err := item.id()
+		if err != nil {
+			return err
+		}
+		result[id] = Sponsorship{Item: item, Sponsor: sponsor.Address(), Reserves: reserves}
+		return nil
+	}
+
+	if sponsor := entry.SponsoringID(); sponsor != nil {
+		if err := add(Item{Key: key}, *sponsor, entryReserves(entry.Data)); err != nil {
+			return nil, err
+		}
+	}
+	if account, ok := entry.Data.GetAccount(); ok {
+		ids := account.SignerSponsoringIDs()
+		for i, signer := range account.Signers {
+			if ids[i] == nil {
+				continue
+			}
+			if err := add(Item{Key: key, Signer: signer.Key.Address()}, *ids[i], 1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// entryReserves returns the number of base reserves of a ledger entry.
+func entryReserves(data xdr.LedgerEntryData) uint32 {
+	switch data.Type {
+	case xdr.LedgerEntryTypeAccount:
+		return 2
+	case xdr.LedgerEntryTypeTrustline:
+		if data.MustTrustLine().Asset.Type == xdr.AssetTypeAssetTypePoolShare {
+			return 2
+		}
+		return 1
+	case xdr.LedgerEntryTypeClaimableBalance:
+		return uint32(len(data.MustClaimableBalance().Claimants))
+	default:
+		return 1
+	}
+}
+
+// Event is a change of the sponsor of an item.
+type Event struct {
+	LedgerSequence uint32
+	Item           Item
+	// PreviousSponsor is the sponsor of the item before the change, it is
+	// empty if the item was not sponsored or did not exist.
+	PreviousSponsor string
+	// Sponsor is the sponsor of the item after the change, it is empty if
+	// the item is not sponsored anymore or was removed.
+	Sponsor string
+}
+
+// snapshot is the sponsorship of an item from a ledger until the next
+// snapshot. A nil sponsorship means the item was not sponsored.
+type snapshot struct {
+	ledger      uint32
+	sponsorship *Sponsorship
+}
+
+// Graph maintains the sponsor of every sponsored item and the items
+// sponsored by every account.
+//
+// The graph is bootstrapped from a history archive checkpoint and kept up to
+// date by processing every subsequent ledger in order with ProcessLedger,
+// which also returns the changes of sponsors in the ledger. The sponsors of
+// the items are kept for every ledger since the checkpoint, so they can also
+// be queried as of a past ledger with SponsorOfAt and SponsoredByAt.
+//
+// Graph is safe for concurrent use: queries can be served while ledgers are
+// being processed.
+type Graph struct {
+	mutex        sync.RWMutex
+	sequence     uint32
+	bootstrapped bool
+	start        uint32
+
+	// sponsorships are the sponsorships by item id
+	sponsorships map[string]Sponsorship
+	// sponsored are the ids of the items sponsored by every sponsor
+	sponsored map[string]set.Set[string]
+
+	// snapshots are the sponsorships of every item since the checkpoint, by
+	// item id
+	snapshots map[string][]snapshot
+	// everSponsored are the ids of the items sponsored by every sponsor at
+	// some point since the checkpoint
+	everSponsored map[string]set.Set[string]
+
+	networkPassphrase string
+}
+
+// NewGraph returns a new, empty Graph. Bootstrap must be called before any
+// ledger can be processed.
+func NewGraph(networkPassphrase string) *Graph {
+	return &Graph{
+		networkPassphrase: networkPassphrase,
+		sponsorships:      map[string]Sponsorship{},
+		sponsored:         map[string]set.Set[string]{},
+		snapshots:         map[string][]snapshot{},
+		everSponsored:     map[string]set.Set[string]{},
+	}
+}
+
+// sponsorable returns true if the reserve of ledger entries of the given
+// type can be sponsored.
+func sponsorable(entryType xdr.LedgerEntryType) bool {
+	switch entryType {
+	case xdr.LedgerEntryTypeAccount,
+		xdr.LedgerEntryTypeTrustline,
+		xdr.LedgerEntryTypeOffer,
+		xdr.LedgerEntryTypeData,
+		xdr.LedgerEntryTypeClaimableBalance:
+		return true
+	default:
+		return false
+	}
+}
+
+// Bootstrap replaces the graph with the sponsorships found in the given
+// checkpoint of the history archive. Additional options are passed through
+// to the underlying CheckpointChangeReader.
+func (g *Graph) Bootstrap(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...ingest.CheckpointReaderOption,
+) error {
+	opts = append(opts, ingest.WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			return sponsorable(entry.Data.Type)
+		},
+		func(key xdr.LedgerKey) bool {
+			return sponsorable(key.Type)
+		},
+	))
+	reader, err := ingest.NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "error creating checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.sponsorships = map[string]Sponsorship{}
+	g.sponsored = map[string]set.Set[string]{}
+	g.snapshots = map[string][]snapshot{}
+	g.everSponsored = map[string]set.Set[string]{}
+	g.bootstrapped = false
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		post, err := sponsorships(change.Post)
+		if err != nil {
+			return err
+		}
+		for id, s := range post {
+			g.add(id, s)
+			g.record(checkpoint, id, &s)
+		}
+	}
+	g.sequence = checkpoint
+	g.start = checkpoint
+	g.bootstrapped = true
+	return nil
+}
+
+// ProcessLedger applies the changes in the given ledger to the graph and
+// returns the changes of sponsors, in the order they happened. Ledgers must
+// be processed in order, starting with the ledger right after the checkpoint
+// the Graph was bootstrapped from. The graph is not modified if an error is
+// returned.
+func (g *Graph) ProcessLedger(ledger xdr.LedgerCloseMeta) ([]Event, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.bootstrapped {
+		return nil, errors.New("sponsorship graph has not been bootstrapped")
+	}
+	sequence := ledger.LedgerSequence()
+	if sequence != g.sequence+1 {
+		return nil, errors.Errorf("expected ledger %d but got %d", g.sequence+1, sequence)
+	}
+
+	reader, err := ingest.NewLedgerChangeReaderFromLedgerCloseMeta(g.networkPassphrase, ledger)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating change reader")
+	}
+	defer reader.Close()
+
+	// the changes are only applied once the whole ledger has been read
+	type sponsorshipChange struct {
+		pre, post map[string]Sponsorship
+	}
+	var changes []sponsorshipChange
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading change")
+		}
+		if !sponsorable(change.Type) {
+			continue
+		}
+		pre, err := sponsorships(change.Pre)
+		if err != nil {
+			return nil, err
+		}
+		post, err := sponsorships(change.Post)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, sponsorshipChange{pre: pre, post: post})
+	}
+
+	var events []Event
+	for _, change := range changes {
+		events = append(events, g.apply(sequence, change.pre, change.post)...)
+	}
+	g.sequence = sequence
+	return events, nil
+}
+
+// apply replaces the sponsorships of an entry before a change with the ones
+// after the change and returns the changes of sponsors, sorted by item.
+func (g *Graph) apply(sequence uint32, pre, post map[string]Sponsorship) []Event {
+	ids := make([]string, 0, len(pre)+len(post))
+	for id := range pre {
+		ids = append(ids, id)
+	}
+	for id := range post {
+		if _, ok := pre[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var events []Event
+	for _, id := range ids {
+		before, hadSponsor := pre[id]
+		after, hasSponsor := post[id]
+		if hadSponsor {
+			g.remove(id, before)
+		}
+		if hasSponsor {
+			g.add(id, after)
+		}
+		if hadSponsor != hasSponsor || before.Sponsor != after.Sponsor || before.Reserves != after.Reserves {
+			if hasSponsor {
+				g.record(sequence, id, &after)
+			} else {
+				g.record(sequence, id, nil)
+			}
+		}
+		if before.Sponsor == after.Sponsor {
+			continue
+		}
+		event := Event{
+			LedgerSequence:  sequence,
+			PreviousSponsor: before.Sponsor,
+			Sponsor:         after.Sponsor,
+		}
+		if hasSponsor {
+			event.Item = after.Item
+		} else {
+			event.Item = before.Item
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func (g *Graph) add(id string, s Sponsorship) {
+	g.sponsorships[id] = s
+	items, ok := g.sponsored[s.Sponsor]
+	if !ok {
+		items = set.Set[string]{}
+		g.sponsored[s.Sponsor] = items
+	}
+	items.Add(id)
+}
+
+func (g *Graph) remove(id string, s Sponsorship) {
+	delete(g.sponsorships, id)
+	if items, ok := g.sponsored[s.Sponsor]; ok {
+		items.Remove(id)
+		if len(items) == 0 {
+			delete(g.sponsored, s.Sponsor)
+		}
+	}
+}
+
+// record adds the sponsorship of an item as of the given ledger to its
+// history. The last change of the item in a ledger determines its
+// sponsorship as of the ledger.
+func (g *Graph) record(ledger uint32, id string, s *Sponsorship) {
+	snapshots := g.snapshots[id]
+	if n := len(snapshots); n > 0 && snapshots[n-1].ledger == ledger {
+		snapshots[n-1].sponsorship = s
+	} else {
+		snapshots = append(snapshots, snapshot{ledger: ledger, sponsorship: s})
+	}
+	g.snapshots[id] = snapshots
+	if s == nil {
+		return
+	}
+	items, ok := g.everSponsored[s.Sponsor]
+	if !ok {
+		items = set.Set[string]{}
+		g.everSponsored[s.Sponsor] = items
+	}
+	items.Add(id)
+}
+
+// sponsorshipAt returns the sponsorship of the item with the given id as of
+// the ledger, or nil if it was not sponsored.
+func (g *Graph) sponsorshipAt(id string, ledger uint32) *Sponsorship {
+	snapshots := g.snapshots[id]
+	// index of the first snapshot after the ledger
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].ledger > ledger
+	})
+	if i == 0 {
+		return nil
+	}
+	return snapshots[i-1].sponsorship
+}
+
+func (g *Graph) checkLedger(ledger uint32) error {
+	if !g.bootstrapped || ledger < g.start || ledger > g.sequence {
+		return errors.Errorf("ledger %d is outside of the history [%d, %d]", ledger, g.start, g.sequence)
+	}
+	return nil
+}
+
+// Sequence returns the sequence of the last ledger processed by the Graph.
+func (g *Graph) Sequence() uint32 {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.sequence
+}
+
+// Len returns the number of sponsored items.
+func (g *Graph) Len() int {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return len(g.sponsorships)
+}
+
+// SponsorOf returns the sponsor of the item, if it is sponsored.
+func (g *Graph) SponsorOf(item Item) (string, bool, error) {
+	id, err := item.id()
+	if err != nil {
+		return "", false, err
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	s, ok := g.sponsorships[id]
+	return s.Sponsor, ok, nil
+}
+
+// SponsorOfAt returns the sponsor of the item as of the given ledger, i.e.
+// after the ledger was applied, if it was sponsored. It returns an error if
+// the ledger is before the checkpoint the Graph was bootstrapped from or
+// after the last ledger processed.
+func (g *Graph) SponsorOfAt(item Item, ledger uint32) (string, bool, error) {
+	id, err := item.id()
+	if err != nil {
+		return "", false, err
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	if err := g.checkLedger(ledger); err != nil {
+		return "", false, err
+	}
+	s := g.sponsorshipAt(id, ledger)
+	if s == nil {
+		return "", false, nil
+	}
+	return s.Sponsor, true, nil
+}
+
+// SponsoredBy returns the items sponsored by the account, in a stable order.
+func (g *Graph) SponsoredBy(sponsor string) []Sponsorship {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	ids := make([]string, 0, len(g.sponsored[sponsor]))
+	for id := range g.sponsored[sponsor] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	result := make([]Sponsorship, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, g.sponsorships[id])
+	}
+	return result
+}
+
+// SponsoredByAt returns the items sponsored by the account as of the given
+// ledger, i.e. after the ledger was applied, in the same order as
+// SponsoredBy. It returns an error if the ledger is before the checkpoint the
+// Graph was bootstrapped from or after the last ledger processed.
+func (g *Graph) SponsoredByAt(sponsor string, ledger uint32) ([]Sponsorship, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	if err := g.checkLedger(ledger); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(g.everSponsored[sponsor]))
+	for id := range g.everSponsored[sponsor] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	result := []Sponsorship{}
+	for _, id := range ids {
+		if s := g.sponsorshipAt(id, ledger); s != nil && s.Sponsor == sponsor {
+			result = append(result, *s)
+		}
+	}
+	return result, nil
+}
+
+// Reserves returns the number of base reserves sponsored by the account. It
+// matches the NumSponsoring of the account entry.
+func (g *Graph) Reserves(sponsor string) uint32 {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	var reserves uint32
+	for id := range g.sponsored[sponsor] {
+		reserves += g.sponsorships[id].Reserves
+	}
+	return reserves
+}
+
+// Sponsors returns the accounts which sponsor at least one item, sorted.
+func (g *Graph) Sponsors() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	sponsors := make([]string, 0, len(g.sponsored))
+	for sponsor := range g.sponsored {
+		sponsors = append(sponsors, sponsor)
+	}
+	sort.Strings(sponsors)
+	return sponsors
+}
@@ -0,0 +1,299 @@
+package sponsorship
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	anchor  = xdr.MustAddress("GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+	wallet  = xdr.MustAddress("GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z")
+	user    = xdr.MustAddress("GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON")
+	signer  = xdr.MustSigner("GCXKG6RN4ONIEPCMNFB732A436Z5PNDSRLGWK7GBLCMQLIFO4S7EYWVU")
+	usdc    = xdr.MustNewCreditAsset("USDC", anchor.Address())
+	balance = xdr.ClaimableBalanceId{Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0, V0: &xdr.Hash{1}}
+)
+
+func sponsored(entry xdr.LedgerEntry, sponsor xdr.AccountId) xdr.LedgerEntry {
+	entry.Ext = xdr.LedgerEntryExt{V: 1, V1: &xdr.LedgerEntryExtensionV1{SponsoringId: &sponsor}}
+	return entry
+}
+
+// userAccount returns the account of user, with a signer sponsored by
+// signerSponsor.
+func userAccount(signerSponsor xdr.AccountId) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeAccount,
+			Account: &xdr.AccountEntry{
+				AccountId: user,
+				Signers:   []xdr.Signer{{Key: signer, Weight: 1}},
+				Ext: xdr.AccountEntryExt{
+					V: 1,
+					V1: &xdr.AccountEntryExtensionV1{
+						Ext: xdr.AccountEntryExtensionV1Ext{
+							V: 2,
+							V2: &xdr.AccountEntryExtensionV2{
+								NumSponsored:        3,
+								SignerSponsoringIDs: []xdr.SponsorshipDescriptor{&signerSponsor},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+var (
+	trustline = xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeTrustline,
+			TrustLine: &xdr.TrustLineEntry{
+				AccountId: user,
+				Asset:     usdc.ToTrustLineAsset(),
+				Limit:     1000,
+			},
+		},
+	}
+	claimableBalance = xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeClaimableBalance,
+			ClaimableBalance: &xdr.ClaimableBalanceEntry{
+				BalanceId: balance,
+				Claimants: []xdr.Claimant{
+					{Type: xdr.ClaimantTypeClaimantTypeV0, V0: &xdr.ClaimantV0{Destination: user}},
+					{Type: xdr.ClaimantTypeClaimantTypeV0, V0: &xdr.ClaimantV0{Destination: wallet}},
+				},
+				Asset:  usdc,
+				Amount: 10,
+			},
+		},
+	}
+	data = xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeData,
+			Data: &xdr.DataEntry{AccountId: user, DataName: "memo", DataValue: []byte("1")},
+		},
+	}
+)
+
+func key(entry xdr.LedgerEntry) xdr.LedgerKey {
+	k, err := entry.LedgerKey()
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func bootstrappedGraph(t *testing.T) *Graph {
+	archive := ingesttest.MockCheckpoint(t, 63,
+		sponsored(userAccount(wallet), anchor),
+		trustline,
+		sponsored(claimableBalance, anchor),
+	)
+	graph := NewGraph(network.TestNetworkPassphrase)
+	require.NoError(t, graph.Bootstrap(context.Background(), archive, 63, ingest.DisableBucketListValidation))
+	archive.AssertExpectations(t)
+	return graph
+}
+
+// testLedger returns a ledger with a single successful transaction of
+// anchor, whose operation caused the given changes.
+func testLedger(t *testing.T, sequence uint32, changes ...xdr.LedgerEntryChange) xdr.LedgerCloseMeta {
+	ledger, _ := ingesttest.LedgerWithTransaction(t, sequence, time.Time{}, ingesttest.Transaction{
+		Tx: xdr.Transaction{
+			SourceAccount: anchor.ToMuxedAccount(),
+			Operations: []xdr.Operation{{
+				Body: xdr.OperationBody{Type: xdr.OperationTypeEndSponsoringFutureReserves},
+			}},
+		},
+		OperationChanges: []xdr.LedgerEntryChanges{changes},
+	})
+	return ledger
+}
+
+func updated(pre, post xdr.LedgerEntry) []xdr.LedgerEntryChange {
+	return []xdr.LedgerEntryChange{
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &pre},
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated, Updated: &post},
+	}
+}
+
+func TestGraphBootstrap(t *testing.T) {
+	graph := bootstrappedGraph(t)
+	assert.Equal(t, uint32(63), graph.Sequence())
+	assert.Equal(t, 3, graph.Len())
+	assert.Equal(t, []string{anchor.Address(), wallet.Address()}, graph.Sponsors())
+
+	sponsor, ok, err := graph.SponsorOf(Item{Key: key(trustline)})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, sponsor)
+
+	_, ok, err = graph.SponsorOf(Item{Key: key(trustline), Signer: signer.Address()})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	sponsor, ok, err = graph.SponsorOf(Item{Key: key(userAccount(wallet)), Signer: signer.Address()})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, wallet.Address(), sponsor)
+
+	// the account and the claimable balance with two claimants
+	assert.Len(t, graph.SponsoredBy(anchor.Address()), 2)
+	assert.Equal(t, uint32(4), graph.Reserves(anchor.Address()))
+	assert.Equal(t, uint32(1), graph.Reserves(wallet.Address()))
+	assert.Equal(t, uint32(0), graph.Reserves(user.Address()))
+}
+
+func TestGraphProcessLedger(t *testing.T) {
+	graph := bootstrappedGraph(t)
+
+	// the sponsorship of the signer is transferred to anchor, the trustline
+	// becomes sponsored by wallet, a data entry sponsored by anchor is created
+	// and the claimable balance is claimed
+	var changes []xdr.LedgerEntryChange
+	changes = append(changes, updated(sponsored(userAccount(wallet), anchor), sponsored(userAccount(anchor), anchor))...)
+	changes = append(changes, updated(trustline, sponsored(trustline, wallet))...)
+	sponsoredData := sponsored(data, anchor)
+	changes = append(changes, xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &sponsoredData})
+	sponsoredBalance := sponsored(claimableBalance, anchor)
+	balanceKey := key(claimableBalance)
+	changes = append(changes,
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &sponsoredBalance},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &balanceKey},
+	)
+
+	events, err := graph.ProcessLedger(testLedger(t, 64, changes...))
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, Event{
+		LedgerSequence:  64,
+		Item:            Item{Key: key(userAccount(anchor)), Signer: signer.Address()},
+		PreviousSponsor: wallet.Address(),
+		Sponsor:         anchor.Address(),
+	}, events[0])
+	assert.Equal(t, Event{
+		LedgerSequence: 64,
+		Item:           Item{Key: key(trustline)},
+		Sponsor:        wallet.Address(),
+	}, events[1])
+	assert.Equal(t, anchor.Address(), events[2].Sponsor)
+	assert.Equal(t, key(data), events[2].Item.Key)
+	assert.Equal(t, Event{
+		LedgerSequence:  64,
+		Item:            Item{Key: balanceKey},
+		PreviousSponsor: anchor.Address(),
+	}, events[3])
+
+	assert.Equal(t, uint32(64), graph.Sequence())
+	assert.Equal(t, 4, graph.Len())
+	// the account, its signer and the data entry
+	assert.Equal(t, uint32(4), graph.Reserves(anchor.Address()))
+	assert.Len(t, graph.SponsoredBy(anchor.Address()), 3)
+	sponsoredByWallet := graph.SponsoredBy(wallet.Address())
+	require.Len(t, sponsoredByWallet, 1)
+	assert.Equal(t, Sponsorship{Item: Item{Key: key(trustline)}, Sponsor: wallet.Address(), Reserves: 1}, sponsoredByWallet[0])
+}
+
+func TestGraphProcessLedgerErrors(t *testing.T) {
+	graph := NewGraph(network.TestNetworkPassphrase)
+	_, err := graph.ProcessLedger(testLedger(t, 64))
+	assert.EqualError(t, err, "sponsorship graph has not been bootstrapped")
+
+	graph = bootstrappedGraph(t)
+	_, err = graph.ProcessLedger(testLedger(t, 65))
+	assert.EqualError(t, err, "expected ledger 64 but got 65")
+}
+
+func TestGraphProcessLedgerRejected(t *testing.T) {
+	graph := bootstrappedGraph(t)
+
+	// the trustline becomes sponsored before the second transaction fails,
+	// it must not be applied
+	ledger := testLedger(t, 64, updated(trustline, sponsored(trustline, wallet))...)
+	ingesttest.AppendUnsupportedTransaction(t, &ledger, anchor.ToMuxedAccount())
+
+	_, err := graph.ProcessLedger(ledger)
+	require.ErrorContains(t, err, "TransactionMeta.V=0 not supported")
+	assert.Equal(t, uint32(63), graph.Sequence())
+	_, ok, err := graph.SponsorOf(Item{Key: key(trustline)})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, uint32(1), graph.Reserves(wallet.Address()))
+
+	_, err = graph.ProcessLedger(testLedger(t, 64))
+	require.NoError(t, err)
+}
+
+func TestGraphHistory(t *testing.T) {
+	graph := bootstrappedGraph(t)
+
+	// wallet sponsors the trustline in ledger 64 and anchor revokes the
+	// sponsorship in ledger 65
+	_, err := graph.ProcessLedger(testLedger(t, 64, updated(trustline, sponsored(trustline, wallet))...))
+	require.NoError(t, err)
+	trustlineKey := key(trustline)
+	revoke, _ := ingesttest.LedgerWithTransaction(t, 65, time.Time{}, ingesttest.Transaction{
+		Tx: xdr.Transaction{
+			SourceAccount: wallet.ToMuxedAccount(),
+			Operations: []xdr.Operation{{
+				Body: xdr.OperationBody{
+					Type: xdr.OperationTypeRevokeSponsorship,
+					RevokeSponsorshipOp: &xdr.RevokeSponsorshipOp{
+						Type:      xdr.RevokeSponsorshipTypeRevokeSponsorshipLedgerEntry,
+						LedgerKey: &trustlineKey,
+					},
+				},
+			}},
+		},
+		OperationChanges: []xdr.LedgerEntryChanges{updated(sponsored(trustline, wallet), trustline)},
+	})
+	events, err := graph.ProcessLedger(revoke)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, wallet.Address(), events[0].PreviousSponsor)
+
+	for _, tc := range []struct {
+		ledger  uint32
+		sponsor string
+	}{
+		{63, ""},
+		{64, wallet.Address()},
+		{65, ""},
+	} {
+		sponsor, ok, err := graph.SponsorOfAt(Item{Key: key(trustline)}, tc.ledger)
+		require.NoError(t, err)
+		assert.Equal(t, tc.sponsor != "", ok, "ledger %d", tc.ledger)
+		assert.Equal(t, tc.sponsor, sponsor, "ledger %d", tc.ledger)
+	}
+
+	// before the revocation, wallet sponsors the signer and the trustline
+	before, err := graph.SponsoredByAt(wallet.Address(), 64)
+	require.NoError(t, err)
+	require.Len(t, before, 2)
+	assert.Equal(t, Sponsorship{Item: Item{Key: key(trustline)}, Sponsor: wallet.Address(), Reserves: 1}, before[1])
+	after, err := graph.SponsoredByAt(wallet.Address(), 65)
+	require.NoError(t, err)
+	assert.Equal(t, graph.SponsoredBy(wallet.Address()), after)
+	require.Len(t, after, 1)
+	assert.Equal(t, signer.Address(), after[0].Item.Signer)
+	atCheckpoint, err := graph.SponsoredByAt(anchor.Address(), 63)
+	require.NoError(t, err)
+	assert.Len(t, atCheckpoint, 2)
+
+	_, _, err = graph.SponsorOfAt(Item{Key: key(trustline)}, 62)
+	assert.EqualError(t, err, "ledger 62 is outside of the history [63, 65]")
+	_, err = graph.SponsoredByAt(wallet.Address(), 66)
+	assert.EqualError(t, err, "ledger 66 is outside of the history [63, 65]")
+}
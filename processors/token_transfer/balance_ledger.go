@@ -0,0 +1,524 @@
+package token_transfer
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/ingest/sac"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Balance is the balance of an asset held by an address. Holder is the strkey
+// of an account (G...), contract (C...), claimable balance (B...) or
+// liquidity pool (L...). Asset is the canonical representation of a classic
+// asset ("native" or "CODE:ISSUER") or, for custom tokens, the strkey of the
+// token contract.
+type Balance struct {
+	Holder string
+	Asset  string
+	Amount *big.Int
+}
+
+// BalanceMismatch is a difference between the balance tracked by a
+// BalanceLedger and the balance found in the ledger state.
+type BalanceMismatch struct {
+	Holder string
+	Asset  string
+	// Expected is the balance found in the ledger state.
+	Expected *big.Int
+	// Actual is the balance tracked by the BalanceLedger.
+	Actual *big.Int
+}
+
+// BalanceLedger maintains running balances for every (holder, asset) pair by
+// accumulating the token transfer events of consecutive ledgers. The
+// balances can be bootstrapped from a history archive checkpoint, saved to
+// and restored from snapshots and reconciled against a checkpoint.
+//
+// BalanceLedger is safe for concurrent use.
+type BalanceLedger struct {
+	mutex             sync.RWMutex
+	networkPassphrase string
+	checkpointManager historyarchive.CheckpointManager
+	snapshotDir       string
+
+	ledger   uint32
+	balances map[balanceKey]*big.Int
+}
+
+// BalanceLedgerOption configures a BalanceLedger.
+type BalanceLedgerOption func(*BalanceLedger)
+
+// WithCheckpointSnapshots configures the BalanceLedger to save a snapshot to
+// dir after every checkpoint ledger is applied. Snapshots are named
+// balances-<ledger>.json.gz and can be loaded with LoadSnapshot.
+func WithCheckpointSnapshots(dir string) BalanceLedgerOption {
+	return func(b *BalanceLedger) {
+		b.snapshotDir = dir
+	}
+}
+
+// NewBalanceLedger creates an empty BalanceLedger. Balances start at zero
+// unless Bootstrap, RestoreSnapshot or LoadSnapshot is called before the
+// first ledger is applied.
+func NewBalanceLedger(networkPassphrase string, opts ...BalanceLedgerOption) *BalanceLedger {
+	b := &BalanceLedger{
+		networkPassphrase: networkPassphrase,
+		checkpointManager: historyarchive.NewCheckpointManager(historyarchive.DefaultCheckpointFrequency),
+		balances:          make(map[balanceKey]*big.Int),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Ledger returns the sequence of the last ledger applied to the balances.
+func (b *BalanceLedger) Ledger() uint32 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.ledger
+}
+
+// Balance returns the balance of asset held by holder.
+func (b *BalanceLedger) Balance(holder, asset string) *big.Int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if amount, ok := b.balances[balanceKey{holder: holder, asset: asset}]; ok {
+		return new(big.Int).Set(amount)
+	}
+	return new(big.Int)
+}
+
+// Balances returns all non-zero balances ordered by holder and asset.
+func (b *BalanceLedger) Balances() []Balance {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return sortedBalances(b.balances)
+}
+
+// HolderBalances returns the non-zero balances of holder ordered by asset.
+func (b *BalanceLedger) HolderBalances(holder string) []Balance {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	var result []Balance
+	for key, amount := range b.balances {
+		if key.holder == holder {
+			result = append(result, Balance{Holder: key.holder, Asset: key.asset, Amount: new(big.Int).Set(amount)})
+		}
+	}
+	sortBalances(result)
+	return result
+}
+
+// Bootstrap replaces the balances with the balances found in the ledger state
+// read from reader, which is usually a CheckpointChangeReader for ledger.
+// Account, trustline, claimable balance, liquidity pool and Stellar Asset
+// Contract balance entries are read. Balances of custom tokens cannot be
+// derived from the ledger state and start at zero.
+func (b *BalanceLedger) Bootstrap(reader ingest.ChangeReader, ledger uint32) error {
+	balances, err := b.readBalances(reader)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.balances = balances
+	b.ledger = ledger
+	return nil
+}
+
+// ApplyEvents applies the token transfer events of ledger, as returned by
+// EventsProcessor.EventsFromLedger, to the balances. Ledgers must be applied
+// in order without gaps. If an error is returned, including when the snapshot
+// of a checkpoint ledger can't be saved, no balance is changed and the ledger
+// can be applied again.
+func (b *BalanceLedger) ApplyEvents(ledger uint32, events []*TokenTransferEvent) error {
+	deltas := make(map[balanceKey]*big.Int)
+	for _, event := range events {
+		if event.GetMeta().GetLedgerSequence() != ledger {
+			return errors.Errorf(
+				"event of ledger %d cannot be applied to ledger %d",
+				event.GetMeta().GetLedgerSequence(), ledger,
+			)
+		}
+		if err := addEventDeltas(deltas, event); err != nil {
+			return errors.Wrapf(err, "invalid %s event in transaction %s", event.GetEventType(), event.GetMeta().GetTxHash())
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.ledger != 0 && ledger != b.ledger+1 {
+		return errors.Errorf("expected ledger %d but got %d", b.ledger+1, ledger)
+	}
+	updated := make(map[balanceKey]*big.Int, len(deltas))
+	for key, delta := range deltas {
+		amount := new(big.Int).Set(delta)
+		if current, ok := b.balances[key]; ok {
+			amount.Add(amount, current)
+		}
+		updated[key] = amount
+	}
+
+	balances := b.balances
+	if b.snapshotDir != "" && b.checkpointManager.IsCheckpoint(ledger) {
+		// The snapshot is written before the new balances are committed so
+		// a failed snapshot leaves the ledger unapplied.
+		balances = maps.Clone(b.balances)
+		setBalances(balances, updated)
+		path := filepath.Join(b.snapshotDir, fmt.Sprintf("balances-%d.json.gz", ledger))
+		if err := saveSnapshot(path, ledger, balances); err != nil {
+			return errors.Wrapf(err, "could not save snapshot of ledger %d", ledger)
+		}
+	} else {
+		setBalances(balances, updated)
+	}
+	b.balances = balances
+	b.ledger = ledger
+	return nil
+}
+
+// Reconcile compares the balances with the ledger state read from reader,
+// which must be a CheckpointChangeReader for the ledger returned by Ledger.
+// Balances which cannot be derived from the ledger state, i.e. custom token
+// balances and XLM held by contracts, are not reconciled. The mismatches are
+// returned ordered by holder and asset.
+func (b *BalanceLedger) Reconcile(reader ingest.ChangeReader) ([]BalanceMismatch, error) {
+	expected, err := b.readBalances(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var mismatches []BalanceMismatch
+	for key, amount := range expected {
+		actual, ok := b.balances[key]
+		if !ok {
+			actual = new(big.Int)
+		}
+		if actual.Cmp(amount) != 0 {
+			mismatches = append(mismatches, BalanceMismatch{
+				Holder:   key.holder,
+				Asset:    key.asset,
+				Expected: new(big.Int).Set(amount),
+				Actual:   new(big.Int).Set(actual),
+			})
+		}
+	}
+	for key, amount := range b.balances {
+		if _, ok := expected[key]; ok || !reconcilable(key) {
+			continue
+		}
+		mismatches = append(mismatches, BalanceMismatch{
+			Holder:   key.holder,
+			Asset:    key.asset,
+			Expected: new(big.Int),
+			Actual:   new(big.Int).Set(amount),
+		})
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		if mismatches[i].Holder != mismatches[j].Holder {
+			return mismatches[i].Holder < mismatches[j].Holder
+		}
+		return mismatches[i].Asset < mismatches[j].Asset
+	})
+	return mismatches, nil
+}
+
+type balanceSnapshot struct {
+	Ledger   uint32            `json:"ledger"`
+	Balances []snapshotBalance `json:"balances"`
+}
+
+type snapshotBalance struct {
+	Holder string `json:"holder"`
+	Asset  string `json:"asset"`
+	Amount string `json:"amount"`
+}
+
+// WriteSnapshot writes the balances to w as gzip compressed JSON.
+func (b *BalanceLedger) WriteSnapshot(w io.Writer) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return writeSnapshot(w, b.ledger, b.balances)
+}
+
+func writeSnapshot(w io.Writer, ledger uint32, balances map[balanceKey]*big.Int) error {
+	snapshot := balanceSnapshot{Ledger: ledger, Balances: []snapshotBalance{}}
+	for _, balance := range sortedBalances(balances) {
+		snapshot.Balances = append(snapshot.Balances, snapshotBalance{
+			Holder: balance.Holder,
+			Asset:  balance.Asset,
+			Amount: balance.Amount.String(),
+		})
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(snapshot); err != nil {
+		return errors.Wrap(err, "error writing snapshot")
+	}
+	return zw.Close()
+}
+
+// RestoreSnapshot replaces the balances with a snapshot written by
+// WriteSnapshot.
+func (b *BalanceLedger) RestoreSnapshot(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "error opening snapshot")
+	}
+	defer zr.Close()
+
+	var snapshot balanceSnapshot
+	if err = json.NewDecoder(zr).Decode(&snapshot); err != nil {
+		return errors.Wrap(err, "error reading snapshot")
+	}
+	balances := make(map[balanceKey]*big.Int, len(snapshot.Balances))
+	for _, balance := range snapshot.Balances {
+		amount, ok := new(big.Int).SetString(balance.Amount, 10)
+		if !ok {
+			return errors.Errorf("invalid amount %q for %s in snapshot", balance.Amount, balance.Holder)
+		}
+		addBalance(balances, balanceKey{holder: balance.Holder, asset: balance.Asset}, amount)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.balances = balances
+	b.ledger = snapshot.Ledger
+	return nil
+}
+
+// SaveSnapshot writes the balances to the file at path. The file is replaced
+// atomically so a crash never leaves a partially written snapshot behind.
+func (b *BalanceLedger) SaveSnapshot(path string) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return saveSnapshot(path, b.ledger, b.balances)
+}
+
+func saveSnapshot(path string, ledger uint32, balances map[balanceKey]*big.Int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "error creating snapshot file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = writeSnapshot(tmp, ledger, balances); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "error closing snapshot file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "error renaming snapshot file")
+}
+
+// LoadSnapshot replaces the balances with the snapshot stored in the file at
+// path.
+func (b *BalanceLedger) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "error opening snapshot file")
+	}
+	defer f.Close()
+	return b.RestoreSnapshot(f)
+}
+
+// readBalances reads all the balances which can be derived from the ledger
+// entries read from reader.
+func (b *BalanceLedger) readBalances(reader ingest.ChangeReader) (map[balanceKey]*big.Int, error) {
+	balances := make(map[balanceKey]*big.Int)
+	// Stellar Asset Contract balances are keyed by the contract id, the
+	// asset is only known once the instance entry of the contract is read.
+	contractBalances := make(map[xdr.ContractId]map[string]*big.Int)
+	contractAssets := make(map[xdr.ContractId]string)
+
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading ledger state")
+		}
+		if change.Post == nil {
+			continue
+		}
+		entry := *change.Post
+
+		switch entry.Data.Type {
+		case xdr.LedgerEntryTypeAccount:
+			account := entry.Data.MustAccount()
+			addBalance(balances, balanceKey{holder: account.AccountId.Address(), asset: xlmAsset.StringCanonical()},
+				big.NewInt(int64(account.Balance)))
+		case xdr.LedgerEntryTypeTrustline:
+			trustLine := entry.Data.MustTrustLine()
+			if trustLine.Asset.Type == xdr.AssetTypeAssetTypePoolShare {
+				continue
+			}
+			addBalance(balances, balanceKey{holder: trustLine.AccountId.Address(), asset: trustLine.Asset.ToAsset().StringCanonical()},
+				big.NewInt(int64(trustLine.Balance)))
+		case xdr.LedgerEntryTypeClaimableBalance:
+			cb := entry.Data.MustClaimableBalance()
+			addBalance(balances, balanceKey{holder: cbIdToStrkey(cb.BalanceId), asset: cb.Asset.StringCanonical()},
+				big.NewInt(int64(cb.Amount)))
+		case xdr.LedgerEntryTypeLiquidityPool:
+			lp := entry.Data.MustLiquidityPool()
+			cp := lp.Body.MustConstantProduct()
+			holder := lpIdToStrkey(lp.LiquidityPoolId)
+			addBalance(balances, balanceKey{holder: holder, asset: cp.Params.AssetA.StringCanonical()}, big.NewInt(int64(cp.ReserveA)))
+			addBalance(balances, balanceKey{holder: holder, asset: cp.Params.AssetB.StringCanonical()}, big.NewInt(int64(cp.ReserveB)))
+		case xdr.LedgerEntryTypeContractData:
+			contractID, ok := entry.Data.MustContractData().Contract.GetContractId()
+			if !ok {
+				continue
+			}
+			if asset, ok := sac.AssetFromContractData(entry, b.networkPassphrase); ok {
+				contractAssets[contractID] = asset.StringCanonical()
+				continue
+			}
+			holderID, amount, ok := sac.ContractBalanceFromContractData(entry, b.networkPassphrase)
+			if !ok {
+				continue
+			}
+			if contractBalances[contractID] == nil {
+				contractBalances[contractID] = make(map[string]*big.Int)
+			}
+			contractBalances[contractID][strkey.MustEncode(strkey.VersionByteContract, holderID[:])] = amount
+		}
+	}
+
+	for contractID, holders := range contractBalances {
+		asset, ok := contractAssets[contractID]
+		if !ok {
+			continue
+		}
+		for holder, amount := range holders {
+			addBalance(balances, balanceKey{holder: holder, asset: asset}, amount)
+		}
+	}
+	return balances, nil
+}
+
+// reconcilable returns true if the balance can be derived from the ledger
+// state.
+func reconcilable(key balanceKey) bool {
+	if strkey.IsValidContractAddress(key.asset) {
+		// custom tokens
+		return false
+	}
+	if strkey.IsValidContractAddress(key.holder) {
+		// sac.ContractBalanceFromContractData does not support XLM balances
+		return key.asset != xlmAsset.StringCanonical()
+	}
+	return true
+}
+
+func eventAsset(event *TokenTransferEvent) string {
+	if asset := event.GetAsset(); asset != nil {
+		return asset.ToXdrAsset().StringCanonical()
+	}
+	return event.GetMeta().GetContractAddress()
+}
+
+func parseEventAmount(event *TokenTransferEvent) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(event.GetAmount(), 10)
+	if !ok {
+		return nil, errors.Errorf("invalid amount %q", event.GetAmount())
+	}
+	return amount, nil
+}
+
+func addEventDeltas(deltas map[balanceKey]*big.Int, event *TokenTransferEvent) error {
+	if event.GetEvent() == nil {
+		return errors.New("event is empty")
+	}
+	asset := eventAsset(event)
+	if asset == "" {
+		return errors.New("event has no asset")
+	}
+	amount, err := parseEventAmount(event)
+	if err != nil {
+		return err
+	}
+	negated := new(big.Int).Neg(amount)
+
+	switch ev := event.GetEvent().(type) {
+	case *TokenTransferEvent_Transfer:
+		addBalance(deltas, balanceKey{holder: ev.Transfer.From, asset: asset}, negated)
+		addBalance(deltas, balanceKey{holder: ev.Transfer.To, asset: asset}, amount)
+	case *TokenTransferEvent_Mint:
+		addBalance(deltas, balanceKey{holder: ev.Mint.To, asset: asset}, amount)
+	case *TokenTransferEvent_Burn:
+		addBalance(deltas, balanceKey{holder: ev.Burn.From, asset: asset}, negated)
+	case *TokenTransferEvent_Clawback:
+		addBalance(deltas, balanceKey{holder: ev.Clawback.From, asset: asset}, negated)
+	case *TokenTransferEvent_Fee:
+		// negative fees are refunds
+		addBalance(deltas, balanceKey{holder: ev.Fee.From, asset: asset}, negated)
+	default:
+		return errors.Errorf("unknown event type %T", ev)
+	}
+	return nil
+}
+
+// addBalance adds delta to the balance of key and removes the balance if it
+// becomes zero.
+func addBalance(balances map[balanceKey]*big.Int, key balanceKey, delta *big.Int) {
+	current, ok := balances[key]
+	if !ok {
+		current = new(big.Int)
+		balances[key] = current
+	}
+	current.Add(current, delta)
+	if current.Sign() == 0 {
+		delete(balances, key)
+	}
+}
+
+// setBalances replaces the balances of the given keys, removing zero balances.
+func setBalances(balances map[balanceKey]*big.Int, updated map[balanceKey]*big.Int) {
+	for key, amount := range updated {
+		if amount.Sign() == 0 {
+			delete(balances, key)
+		} else {
+			balances[key] = amount
+		}
+	}
+}
+
+func sortedBalances(balances map[balanceKey]*big.Int) []Balance {
+	result := make([]Balance, 0, len(balances))
+	for key, amount := range balances {
+		result = append(result, Balance{Holder: key.holder, Asset: key.asset, Amount: new(big.Int).Set(amount)})
+	}
+	sortBalances(result)
+	return result
+}
+
+func sortBalances(balances []Balance) {
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].Holder != balances[j].Holder {
+			return balances[i].Holder < balances[j].Holder
+		}
+		return balances[i].Asset < balances[j].Asset
+	})
+}
@@ -0,0 +1,180 @@
+package token_transfer
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	balanceContract    = strkey.MustEncode(strkey.VersionByteContract, make([]byte, 32))
+	customTokenAddress = strkey.MustEncode(strkey.VersionByteContract, bytes.Repeat([]byte{1}, 32))
+)
+
+func ledgerEventMeta(ledger uint32) *EventMeta {
+	return &EventMeta{LedgerSequence: ledger, TxHash: someTxHash.HexString()}
+}
+
+func mockStateReader(entries ...xdr.LedgerEntry) *ingest.MockChangeReader {
+	reader := &ingest.MockChangeReader{}
+	for i := range entries {
+		reader.On("Read").Return(ingest.Change{
+			Type:       entries[i].Data.Type,
+			ChangeType: xdr.LedgerEntryChangeTypeLedgerEntryState,
+			Post:       &entries[i],
+		}, nil).Once()
+	}
+	reader.On("Read").Return(ingest.Change{}, io.EOF).Once()
+	return reader
+}
+
+func accountBalanceEntry(account xdr.MuxedAccount, balance xdr.Int64) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type:    xdr.LedgerEntryTypeAccount,
+			Account: &xdr.AccountEntry{AccountId: account.ToAccountId(), Balance: balance},
+		},
+	}
+}
+
+func trustLineBalanceEntry(account xdr.MuxedAccount, asset xdr.Asset, balance xdr.Int64) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeTrustline,
+			TrustLine: &xdr.TrustLineEntry{
+				AccountId: account.ToAccountId(),
+				Asset:     asset.ToTrustLineAsset(),
+				Balance:   balance,
+			},
+		},
+	}
+}
+
+func TestBalanceLedgerApplyEvents(t *testing.T) {
+	ledger := NewBalanceLedger(someNetworkPassphrase)
+	require.NoError(t, ledger.Bootstrap(mockStateReader(
+		accountBalanceEntry(accountA, 100*oneUnit),
+		trustLineBalanceEntry(accountA, usdcAsset, 50*oneUnit),
+	), 63))
+	assert.Equal(t, uint32(63), ledger.Ledger())
+
+	a := protoAddressFromAccount(accountA)
+	b := protoAddressFromAccount(accountB)
+	lp := lpIdToStrkey(lpEthUsdcId)
+	customToken := NewTransferEvent(ledgerEventMeta(64), a, balanceContract, "170141183460469231731687303715884105727", nil)
+	customToken.Meta.ContractAddress = customTokenAddress
+
+	require.NoError(t, ledger.ApplyEvents(64, []*TokenTransferEvent{
+		NewFeeEvent(ledgerEventMeta(64), a, unitsToStr(oneUnit), xlmProtoAsset),
+		NewTransferEvent(ledgerEventMeta(64), a, b, unitsToStr(20*oneUnit), usdcProtoAsset),
+		NewTransferEvent(ledgerEventMeta(64), a, balanceContract, unitsToStr(10*oneUnit), usdcProtoAsset),
+		NewMintEvent(ledgerEventMeta(64), lp, unitsToStr(5*oneUnit), usdcProtoAsset),
+		NewBurnEvent(ledgerEventMeta(64), b, unitsToStr(2*oneUnit), usdcProtoAsset),
+		// fee refund
+		NewFeeEvent(ledgerEventMeta(64), a, unitsToStr(-oneUnit/2), xlmProtoAsset),
+		customToken,
+	}))
+
+	usdc := usdcAsset.StringCanonical()
+	assert.Equal(t, big.NewInt(int64(99*oneUnit+oneUnit/2)), ledger.Balance(a, "native"))
+	assert.Equal(t, big.NewInt(int64(20*oneUnit)), ledger.Balance(a, usdc))
+	assert.Equal(t, big.NewInt(int64(18*oneUnit)), ledger.Balance(b, usdc))
+	assert.Equal(t, big.NewInt(int64(10*oneUnit)), ledger.Balance(balanceContract, usdc))
+	assert.Equal(t, big.NewInt(int64(5*oneUnit)), ledger.Balance(lp, usdc))
+	assert.Equal(t, "170141183460469231731687303715884105727", ledger.Balance(balanceContract, customTokenAddress).String())
+	assert.Equal(t, "-170141183460469231731687303715884105727", ledger.Balance(a, customTokenAddress).String())
+	assert.Equal(t, new(big.Int), ledger.Balance(b, "native"))
+	assert.Len(t, ledger.HolderBalances(balanceContract), 2)
+
+	err := ledger.ApplyEvents(66, nil)
+	assert.EqualError(t, err, "expected ledger 65 but got 66")
+	err = ledger.ApplyEvents(65, []*TokenTransferEvent{
+		NewTransferEvent(ledgerEventMeta(64), a, b, unitsToStr(oneUnit), usdcProtoAsset),
+	})
+	assert.EqualError(t, err, "event of ledger 64 cannot be applied to ledger 65")
+	err = ledger.ApplyEvents(65, []*TokenTransferEvent{
+		NewTransferEvent(ledgerEventMeta(65), a, b, unitsToStr(oneUnit), usdcProtoAsset),
+		NewTransferEvent(ledgerEventMeta(65), a, b, "1.5", usdcProtoAsset),
+	})
+	assert.ErrorContains(t, err, `invalid amount "1.5"`)
+	assert.Equal(t, uint32(64), ledger.Ledger())
+	assert.Equal(t, big.NewInt(int64(20*oneUnit)), ledger.Balance(a, usdc))
+}
+
+func TestBalanceLedgerReconcile(t *testing.T) {
+	ledger := NewBalanceLedger(someNetworkPassphrase)
+	a := protoAddressFromAccount(accountA)
+	b := protoAddressFromAccount(accountB)
+	require.NoError(t, ledger.ApplyEvents(1, []*TokenTransferEvent{
+		NewMintEvent(ledgerEventMeta(1), a, unitsToStr(100*oneUnit), xlmProtoAsset),
+		NewMintEvent(ledgerEventMeta(1), b, unitsToStr(7*oneUnit), usdcProtoAsset),
+		NewMintEvent(ledgerEventMeta(1), balanceContract, unitsToStr(oneUnit), xlmProtoAsset),
+	}))
+
+	mismatches, err := ledger.Reconcile(mockStateReader(
+		accountBalanceEntry(accountA, 100*oneUnit),
+		accountBalanceEntry(accountB, 3*oneUnit),
+	))
+	require.NoError(t, err)
+	usdc := usdcAsset.StringCanonical()
+	assert.Equal(t, []BalanceMismatch{
+		{Holder: b, Asset: usdc, Expected: new(big.Int), Actual: big.NewInt(int64(7 * oneUnit))},
+		{Holder: b, Asset: "native", Expected: big.NewInt(int64(3 * oneUnit)), Actual: new(big.Int)},
+	}, mismatches)
+}
+
+func TestBalanceLedgerSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	ledger := NewBalanceLedger(someNetworkPassphrase, WithCheckpointSnapshots(dir))
+	a := protoAddressFromAccount(accountA)
+	require.NoError(t, ledger.ApplyEvents(62, []*TokenTransferEvent{
+		NewMintEvent(ledgerEventMeta(62), a, unitsToStr(oneUnit), usdcProtoAsset),
+	}))
+	require.NoError(t, ledger.ApplyEvents(63, []*TokenTransferEvent{
+		NewMintEvent(ledgerEventMeta(63), a, unitsToStr(oneUnit), usdcProtoAsset),
+	}))
+
+	restored := NewBalanceLedger(someNetworkPassphrase)
+	require.NoError(t, restored.LoadSnapshot(filepath.Join(dir, "balances-63.json.gz")))
+	assert.Equal(t, uint32(63), restored.Ledger())
+	assert.Equal(t, ledger.Balances(), restored.Balances())
+	assert.Equal(t, big.NewInt(int64(2*oneUnit)), restored.Balance(a, usdcAsset.StringCanonical()))
+
+	var buf bytes.Buffer
+	require.NoError(t, ledger.WriteSnapshot(&buf))
+	restored = NewBalanceLedger(someNetworkPassphrase)
+	require.NoError(t, restored.RestoreSnapshot(&buf))
+	assert.Equal(t, ledger.Balances(), restored.Balances())
+	assert.NoError(t, restored.ApplyEvents(64, nil))
+}
+
+func TestBalanceLedgerSnapshotError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	ledger := NewBalanceLedger(someNetworkPassphrase, WithCheckpointSnapshots(dir))
+	a := protoAddressFromAccount(accountA)
+	require.NoError(t, ledger.ApplyEvents(62, nil))
+
+	// the snapshot directory doesn't exist so the checkpoint can't be saved
+	events := []*TokenTransferEvent{
+		NewMintEvent(ledgerEventMeta(63), a, unitsToStr(oneUnit), usdcProtoAsset),
+	}
+	err := ledger.ApplyEvents(63, events)
+	assert.ErrorContains(t, err, "could not save snapshot of ledger 63")
+	assert.Equal(t, uint32(62), ledger.Ledger())
+	assert.Empty(t, ledger.Balances())
+
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	require.NoError(t, ledger.ApplyEvents(63, events))
+	assert.Equal(t, big.NewInt(int64(oneUnit)), ledger.Balance(a, usdcAsset.StringCanonical()))
+	assert.FileExists(t, filepath.Join(dir, "balances-63.json.gz"))
+}
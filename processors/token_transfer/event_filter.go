@@ -0,0 +1,237 @@
+package token_transfer
+
+import (
+	"math/big"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/support/collections/set"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// EventFilter selects the events returned by an EventsProcessor configured
+// with WithEventFilter. An event is returned only if it matches every
+// non-empty criterion; within a criterion matching any of the values is
+// enough.
+type EventFilter struct {
+	// Addresses matches events whose from or to address is one of the
+	// addresses (G..., M..., C..., B... or L...). Muxed account addresses
+	// (M...) match the events of their base account.
+	Addresses []string
+	// Assets matches events of the assets, including the events emitted by
+	// their Stellar Asset Contracts.
+	Assets []xdr.Asset
+	// ContractAddresses matches events of the token contracts (C...). This
+	// is how custom tokens are selected, the contract address of a Stellar
+	// Asset Contract matches the events of its asset.
+	ContractAddresses []string
+	// EventTypes matches events of the types, e.g. TransferEvent or FeeEvent.
+	EventTypes []string
+	// MinAmount matches events whose absolute amount is at least MinAmount.
+	MinAmount *big.Int
+}
+
+// WithEventFilter configures the EventsProcessor to only return the events
+// matching filter. Operations which cannot produce a matching event are
+// skipped without deriving their events. Fee events of every transaction are
+// still derived because EventsFromLedger validates them.
+func WithEventFilter(filter EventFilter) EventsProcessorOption {
+	return func(ep *EventsProcessor) {
+		ep.eventFilter = &filter
+	}
+}
+
+type eventFilter struct {
+	addresses  set.Set[string]
+	contracts  set.Set[string]
+	eventTypes set.Set[string]
+	minAmount  *big.Int
+}
+
+func newEventFilter(filter EventFilter, networkPassphrase string) *eventFilter {
+	f := &eventFilter{minAmount: filter.MinAmount}
+	if len(filter.Addresses) > 0 {
+		f.addresses = set.NewSet[string](len(filter.Addresses))
+		for _, address := range filter.Addresses {
+			f.addresses.Add(baseAddress(address))
+		}
+	}
+	if len(filter.Assets) > 0 || len(filter.ContractAddresses) > 0 {
+		f.contracts = set.NewSet[string](len(filter.Assets) + len(filter.ContractAddresses))
+		f.contracts.AddSlice(filter.ContractAddresses)
+		for _, asset := range filter.Assets {
+			if contractAddress, ok := assetContractAddress(asset, networkPassphrase); ok {
+				f.contracts.Add(contractAddress)
+			}
+		}
+	}
+	if len(filter.EventTypes) > 0 {
+		f.eventTypes = set.NewSet[string](len(filter.EventTypes))
+		f.eventTypes.AddSlice(filter.EventTypes)
+	}
+	return f
+}
+
+// baseAddress returns the address of the base account of muxed account
+// addresses and the address itself otherwise.
+func baseAddress(address string) string {
+	if !strkey.IsValidMuxedAccountEd25519PublicKey(address) {
+		return address
+	}
+	muxed, err := xdr.AddressToMuxedAccount(address)
+	if err != nil {
+		return address
+	}
+	return muxed.ToAccountId().Address()
+}
+
+func assetContractAddress(asset xdr.Asset, networkPassphrase string) (string, bool) {
+	contractID, err := asset.ContractID(networkPassphrase)
+	if err != nil {
+		return "", false
+	}
+	return strkey.MustEncode(strkey.VersionByteContract, contractID[:]), true
+}
+
+func (f *eventFilter) matches(event *TokenTransferEvent) bool {
+	if f.eventTypes != nil && !f.eventTypes.Contains(event.GetEventType()) {
+		return false
+	}
+	if f.contracts != nil && !f.contracts.Contains(event.GetMeta().GetContractAddress()) {
+		return false
+	}
+	if f.addresses != nil {
+		var from, to string
+		switch ev := event.GetEvent().(type) {
+		case *TokenTransferEvent_Transfer:
+			from, to = ev.Transfer.GetFrom(), ev.Transfer.GetTo()
+		case *TokenTransferEvent_Mint:
+			to = ev.Mint.GetTo()
+		case *TokenTransferEvent_Burn:
+			from = ev.Burn.GetFrom()
+		case *TokenTransferEvent_Clawback:
+			from = ev.Clawback.GetFrom()
+		case *TokenTransferEvent_Fee:
+			from = ev.Fee.GetFrom()
+		}
+		if !f.addresses.Contains(from) && !f.addresses.Contains(to) {
+			return false
+		}
+	}
+	if f.minAmount != nil {
+		amount, ok := new(big.Int).SetString(event.GetAmount(), 10)
+		if !ok || amount.Abs(amount).Cmp(f.minAmount) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *eventFilter) filter(events []*TokenTransferEvent) []*TokenTransferEvent {
+	filtered := events[:0:0]
+	for _, event := range events {
+		if f.matches(event) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
+
+// mayMatchContract returns false if none of the events emitted by the
+// contract can match the filter.
+func (f *eventFilter) mayMatchContract(contractID *xdr.ContractId) bool {
+	if f.contracts == nil {
+		return true
+	}
+	if contractID == nil {
+		return false
+	}
+	return f.contracts.Contains(strkey.MustEncode(strkey.VersionByteContract, contractID[:]))
+}
+
+// mayMatchOperation returns false if none of the events derived from the
+// operation can match the filter. It is conservative: operations whose
+// events involve accounts or assets which are not part of the operation
+// itself, e.g. the sellers of the offers crossed by a path payment, are only
+// pruned by event type.
+func (f *eventFilter) mayMatchOperation(tx ingest.LedgerTransaction, op xdr.Operation, networkPassphrase string) bool {
+	if f.eventTypes != nil && !f.eventTypes.Contains(TransferEvent) && !f.eventTypes.Contains(MintEvent) &&
+		!f.eventTypes.Contains(BurnEvent) && !f.eventTypes.Contains(ClawbackEvent) {
+		return false
+	}
+	if f.contracts != nil {
+		if assets, ok := operationAssets(op); ok && !f.anyAssetMatches(assets, networkPassphrase) {
+			return false
+		}
+	}
+	if f.addresses != nil {
+		if addresses, ok := operationAddresses(tx, op); ok {
+			for _, address := range addresses {
+				if f.addresses.Contains(address) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (f *eventFilter) anyAssetMatches(assets []xdr.Asset, networkPassphrase string) bool {
+	for _, asset := range assets {
+		if contractAddress, ok := assetContractAddress(asset, networkPassphrase); ok && f.contracts.Contains(contractAddress) {
+			return true
+		}
+	}
+	return false
+}
+
+// operationAssets returns all the assets of the events derived from op, if
+// they can be determined from the operation alone.
+func operationAssets(op xdr.Operation) ([]xdr.Asset, bool) {
+	switch op.Body.Type {
+	case xdr.OperationTypeCreateAccount, xdr.OperationTypeAccountMerge, xdr.OperationTypeInflation:
+		return []xdr.Asset{xlmAsset}, true
+	case xdr.OperationTypePayment:
+		return []xdr.Asset{op.Body.MustPaymentOp().Asset}, true
+	case xdr.OperationTypePathPaymentStrictSend:
+		body := op.Body.MustPathPaymentStrictSendOp()
+		return append([]xdr.Asset{body.SendAsset, body.DestAsset}, body.Path...), true
+	case xdr.OperationTypePathPaymentStrictReceive:
+		body := op.Body.MustPathPaymentStrictReceiveOp()
+		return append([]xdr.Asset{body.SendAsset, body.DestAsset}, body.Path...), true
+	case xdr.OperationTypeManageSellOffer:
+		body := op.Body.MustManageSellOfferOp()
+		return []xdr.Asset{body.Selling, body.Buying}, true
+	case xdr.OperationTypeManageBuyOffer:
+		body := op.Body.MustManageBuyOfferOp()
+		return []xdr.Asset{body.Selling, body.Buying}, true
+	case xdr.OperationTypeCreatePassiveSellOffer:
+		body := op.Body.MustCreatePassiveSellOfferOp()
+		return []xdr.Asset{body.Selling, body.Buying}, true
+	case xdr.OperationTypeCreateClaimableBalance:
+		return []xdr.Asset{op.Body.MustCreateClaimableBalanceOp().Asset}, true
+	case xdr.OperationTypeClawback:
+		return []xdr.Asset{op.Body.MustClawbackOp().Asset}, true
+	default:
+		return nil, false
+	}
+}
+
+// operationAddresses returns all the addresses of the events derived from
+// op, if they can be determined from the operation alone.
+func operationAddresses(tx ingest.LedgerTransaction, op xdr.Operation) ([]string, bool) {
+	source := protoAddressFromAccount(operationSourceAccount(tx, op))
+	switch op.Body.Type {
+	case xdr.OperationTypeCreateAccount:
+		return []string{source, op.Body.MustCreateAccountOp().Destination.Address()}, true
+	case xdr.OperationTypePayment:
+		return []string{source, protoAddressFromAccount(op.Body.MustPaymentOp().Destination)}, true
+	case xdr.OperationTypeAccountMerge:
+		return []string{source, protoAddressFromAccount(op.Body.MustDestination())}, true
+	case xdr.OperationTypeClawback:
+		return []string{source, protoAddressFromAccount(op.Body.MustClawbackOp().From)}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,133 @@
+package token_transfer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+func TestEventFilter(t *testing.T) {
+	usdcContract, ok := assetContractAddress(usdcAsset, someNetworkPassphrase)
+	require.True(t, ok)
+
+	usdcPayment := paymentOp(&accountA, accountB, usdcAsset, 100*oneUnit)
+	xlmPayment := paymentOp(&muxedAccountA, accountB, xlmAsset, 5*oneUnit)
+	usdcMint := paymentOp(&usdcAccount, accountB, usdcAsset, 100*oneUnit)
+
+	for _, testCase := range []struct {
+		name     string
+		filter   EventFilter
+		op       xdr.Operation
+		expected []*TokenTransferEvent
+	}{
+		{
+			name:   "empty filter matches everything",
+			filter: EventFilter{},
+			op:     usdcPayment,
+			expected: []*TokenTransferEvent{
+				transferEvent(protoAddressFromAccount(accountA), protoAddressFromAccount(accountB), unitsToStr(100*oneUnit), usdcProtoAsset),
+			},
+		},
+		{
+			name:   "asset matches",
+			filter: EventFilter{Assets: []xdr.Asset{usdcAsset}},
+			op:     usdcPayment,
+			expected: []*TokenTransferEvent{
+				transferEvent(protoAddressFromAccount(accountA), protoAddressFromAccount(accountB), unitsToStr(100*oneUnit), usdcProtoAsset),
+			},
+		},
+		{
+			name:   "asset does not match",
+			filter: EventFilter{Assets: []xdr.Asset{usdcAsset}},
+			op:     xlmPayment,
+		},
+		{
+			name:   "contract address of the stellar asset contract matches",
+			filter: EventFilter{ContractAddresses: []string{usdcContract}},
+			op:     usdcPayment,
+			expected: []*TokenTransferEvent{
+				transferEvent(protoAddressFromAccount(accountA), protoAddressFromAccount(accountB), unitsToStr(100*oneUnit), usdcProtoAsset),
+			},
+		},
+		{
+			name:   "muxed address matches events of its base account",
+			filter: EventFilter{Addresses: []string{muxedAccountA.Address()}},
+			op:     xlmPayment,
+			expected: []*TokenTransferEvent{
+				transferEvent(protoAddressFromAccount(muxedAccountA), protoAddressFromAccount(accountB), unitsToStr(5*oneUnit), xlmProtoAsset),
+			},
+		},
+		{
+			name:   "address does not match",
+			filter: EventFilter{Addresses: []string{ethIssuer}},
+			op:     xlmPayment,
+		},
+		{
+			name:   "event type",
+			filter: EventFilter{EventTypes: []string{MintEvent}},
+			op:     usdcMint,
+			expected: []*TokenTransferEvent{
+				mintEvent(protoAddressFromAccount(accountB), unitsToStr(100*oneUnit), usdcProtoAsset),
+			},
+		},
+		{
+			name:   "event type does not match",
+			filter: EventFilter{EventTypes: []string{MintEvent}},
+			op:     usdcPayment,
+		},
+		{
+			name:   "amount below minimum",
+			filter: EventFilter{MinAmount: big.NewInt(int64(10 * oneUnit))},
+			op:     xlmPayment,
+		},
+		{
+			name: "all criteria match",
+			filter: EventFilter{
+				Addresses:  []string{accountB.Address()},
+				Assets:     []xdr.Asset{usdcAsset},
+				EventTypes: []string{TransferEvent, MintEvent},
+				MinAmount:  big.NewInt(int64(100 * oneUnit)),
+			},
+			op: usdcMint,
+			expected: []*TokenTransferEvent{
+				mintEvent(protoAddressFromAccount(accountB), unitsToStr(100*oneUnit), usdcProtoAsset),
+			},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ttp := NewEventsProcessor(someNetworkPassphrase, WithEventFilter(testCase.filter))
+			events, err := ttp.EventsFromOperation(someTxV3(), 0, testCase.op, xdr.OperationResult{})
+			require.NoError(t, err)
+			require.Len(t, events, len(testCase.expected))
+			for i := range events {
+				assert.True(t, proto.Equal(testCase.expected[i], events[i]),
+					"Expected event: %+v\nFound event: %+v", testCase.expected[i], events[i])
+			}
+		})
+	}
+}
+
+func TestEventFilterPrunesOperations(t *testing.T) {
+	tx := someTxV3()
+	filter := newEventFilter(EventFilter{
+		Addresses: []string{accountA.Address()},
+		Assets:    []xdr.Asset{usdcAsset},
+	}, someNetworkPassphrase)
+
+	assert.True(t, filter.mayMatchOperation(tx, paymentOp(&accountA, accountB, usdcAsset, oneUnit), someNetworkPassphrase))
+	assert.False(t, filter.mayMatchOperation(tx, paymentOp(&accountA, accountB, ethAsset, oneUnit), someNetworkPassphrase))
+	assert.False(t, filter.mayMatchOperation(tx, paymentOp(&accountB, usdcAccount, usdcAsset, oneUnit), someNetworkPassphrase))
+	// the assets of the events of an allow trust operation depend on the
+	// revoked trustlines, it cannot be pruned
+	assert.True(t, filter.mayMatchOperation(tx, xdr.Operation{
+		Body: xdr.OperationBody{Type: xdr.OperationTypeAllowTrust, AllowTrustOp: &xdr.AllowTrustOp{}},
+	}, someNetworkPassphrase))
+
+	feesOnly := newEventFilter(EventFilter{EventTypes: []string{FeeEvent}}, someNetworkPassphrase)
+	assert.False(t, feesOnly.mayMatchOperation(tx, paymentOp(&accountA, accountB, usdcAsset, oneUnit), someNetworkPassphrase))
+}
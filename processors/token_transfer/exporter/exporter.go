@@ -0,0 +1,277 @@
+// Package exporter writes the token transfer events of a range of ledgers to
+// JSON Lines or CSV files, or to files of any format whose Writer is provided
+// in Config.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+)
+
+// DefaultLedgersPerFile is the default number of ledgers exported to a file.
+const DefaultLedgersPerFile = 1000
+
+// Config configures Export.
+type Config struct {
+	LedgerBackend     ledgerbackend.LedgerBackend
+	NetworkPassphrase string
+	// OutputDir is the directory the files are written to. It is created if
+	// it does not exist.
+	OutputDir string
+	Format    Format
+	// NewWriter, if set, returns the Writer of every file instead of
+	// NewWriter. It allows exporting formats which are not built into this
+	// package, Format is then only used as the extension of the files.
+	NewWriter func(w io.Writer) (Writer, error)
+	// LedgersPerFile is the number of ledgers exported to a file. File
+	// boundaries are aligned to multiples of LedgersPerFile. Defaults to
+	// DefaultLedgersPerFile.
+	LedgersPerFile uint32
+	// ProcessorOptions are the options of the token_transfer.EventsProcessor
+	// used to extract the events.
+	ProcessorOptions []token_transfer.EventsProcessorOption
+}
+
+// partition is a range of ledgers exported to a single file.
+type partition struct {
+	from, to uint32
+}
+
+func (p partition) contains(other partition) bool {
+	return p.from <= other.from && other.to <= p.to
+}
+
+func (p partition) fileName(format Format) string {
+	return fmt.Sprintf("%010d-%010d%s", p.from, p.to, format.Extension())
+}
+
+func parsePartition(name string, format Format) (partition, bool) {
+	var p partition
+	if !strings.HasSuffix(name, format.Extension()) {
+		return p, false
+	}
+	_, err := fmt.Sscanf(strings.TrimSuffix(name, format.Extension()), "%d-%d", &p.from, &p.to)
+	return p, err == nil && p.from <= p.to
+}
+
+// Export extracts the token transfer events of every ledger in ledgerRange
+// and writes them to files in config.OutputDir named
+// <first ledger>-<last ledger>.<format>, where both ledgers are zero padded.
+//
+// Export is resumable: a file is written to a temporary file which is only
+// renamed once all its ledgers have been exported, and ledgers which are
+// already covered by a file in the output directory are not exported again.
+// Files which are superseded by a larger file, e.g. because a previous export
+// ended in the middle of a partition, are removed.
+//
+// If ledgerRange is unbounded Export runs until ctx is cancelled.
+func Export(ctx context.Context, config Config, ledgerRange ledgerbackend.Range) error {
+	if config.LedgerBackend == nil {
+		return errors.New("ledger backend is required")
+	}
+	if config.OutputDir == "" {
+		return errors.New("output directory is required")
+	}
+	if config.NewWriter == nil {
+		if _, err := ParseFormat(string(config.Format)); err != nil {
+			return err
+		}
+	} else if config.Format == "" {
+		return errors.New("format is required")
+	}
+	if config.LedgersPerFile == 0 {
+		config.LedgersPerFile = DefaultLedgersPerFile
+	}
+	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+		return errors.Wrap(err, "could not create output directory")
+	}
+
+	e := &export{config: config, ledgerRange: ledgerRange}
+	if err := e.loadExisting(); err != nil {
+		return err
+	}
+
+	from := ledgerRange.From()
+	for e.exported(e.partition(from)) {
+		p := e.partition(from)
+		if ledgerRange.Bounded() && p.to >= ledgerRange.To() {
+			return nil
+		}
+		from = p.to + 1
+	}
+
+	prepareRange := ledgerbackend.UnboundedRange(from)
+	if ledgerRange.Bounded() {
+		prepareRange = ledgerbackend.BoundedRange(from, ledgerRange.To())
+	}
+	if err := config.LedgerBackend.PrepareRange(ctx, prepareRange); err != nil {
+		return errors.Wrapf(err, "could not prepare range %s", prepareRange)
+	}
+
+	processor := token_transfer.NewEventsProcessor(config.NetworkPassphrase, config.ProcessorOptions...)
+	var current *partitionFile
+	defer func() {
+		if current != nil {
+			current.abort()
+		}
+	}()
+
+	for sequence := from; !ledgerRange.Bounded() || sequence <= ledgerRange.To(); sequence++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := e.partition(sequence)
+		if sequence == p.from && !e.exported(p) {
+			var err error
+			if current, err = e.createPartitionFile(p); err != nil {
+				return err
+			}
+		}
+
+		// Ledgers of exported partitions still need to be read because
+		// backends only support reading ledgers sequentially.
+		ledger, err := config.LedgerBackend.GetLedger(ctx, sequence)
+		if err != nil {
+			return errors.Wrapf(err, "could not get ledger %d", sequence)
+		}
+		if current == nil {
+			continue
+		}
+
+		events, err := processor.EventsFromLedger(ledger)
+		if err != nil {
+			return errors.Wrapf(err, "could not extract events from ledger %d", sequence)
+		}
+		for _, event := range events {
+			if err = current.writer.Write(event); err != nil {
+				return errors.Wrapf(err, "could not write event of ledger %d", sequence)
+			}
+		}
+
+		if sequence == p.to {
+			// commit removes the file if it fails, it must not be aborted
+			// again
+			err = e.commit(current)
+			current = nil
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type export struct {
+	config      Config
+	ledgerRange ledgerbackend.Range
+	existing    []partition
+}
+
+// partition returns the partition of the ledger, clipped to the exported
+// range.
+func (e *export) partition(sequence uint32) partition {
+	size := e.config.LedgersPerFile
+	p := partition{from: sequence - sequence%size}
+	p.to = p.from + size - 1
+	if p.from < e.ledgerRange.From() {
+		p.from = e.ledgerRange.From()
+	}
+	if e.ledgerRange.Bounded() && p.to > e.ledgerRange.To() {
+		p.to = e.ledgerRange.To()
+	}
+	return p
+}
+
+func (e *export) loadExisting() error {
+	dirEntries, err := os.ReadDir(e.config.OutputDir)
+	if err != nil {
+		return errors.Wrap(err, "could not list output directory")
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		if p, ok := parsePartition(dirEntry.Name(), e.config.Format); ok {
+			e.existing = append(e.existing, p)
+		}
+	}
+	return nil
+}
+
+func (e *export) exported(p partition) bool {
+	for _, existing := range e.existing {
+		if existing.contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+type partitionFile struct {
+	partition partition
+	file      *os.File
+	writer    Writer
+}
+
+func (f *partitionFile) abort() {
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+func (e *export) createPartitionFile(p partition) (*partitionFile, error) {
+	name := filepath.Join(e.config.OutputDir, p.fileName(e.config.Format))
+	file, err := os.CreateTemp(e.config.OutputDir, filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create output file")
+	}
+	var writer Writer
+	if e.config.NewWriter != nil {
+		writer, err = e.config.NewWriter(file)
+	} else {
+		writer, err = NewWriter(file, e.config.Format)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &partitionFile{partition: p, file: file, writer: writer}, nil
+}
+
+// commit completes the file and removes the files it supersedes.
+func (e *export) commit(f *partitionFile) error {
+	if err := f.writer.Close(); err != nil {
+		f.abort()
+		return errors.Wrap(err, "could not flush output file")
+	}
+	if err := f.file.Close(); err != nil {
+		os.Remove(f.file.Name())
+		return errors.Wrap(err, "could not close output file")
+	}
+	name := filepath.Join(e.config.OutputDir, f.partition.fileName(e.config.Format))
+	if err := os.Rename(f.file.Name(), name); err != nil {
+		os.Remove(f.file.Name())
+		return errors.Wrap(err, "could not rename output file")
+	}
+
+	existing := []partition{f.partition}
+	for _, p := range e.existing {
+		if p != f.partition && f.partition.contains(p) {
+			if err := os.Remove(filepath.Join(e.config.OutputDir, p.fileName(e.config.Format))); err != nil {
+				return errors.Wrap(err, "could not remove superseded output file")
+			}
+			continue
+		}
+		existing = append(existing, p)
+	}
+	e.existing = existing
+	return nil
+}
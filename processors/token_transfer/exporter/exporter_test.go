@@ -0,0 +1,211 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	assetProto "github.com/stellar/go-stellar-sdk/asset"
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+const (
+	accountA = "GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON"
+	accountB = "GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z"
+	usdc     = "USDC:GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN"
+)
+
+func testEvents() []*token_transfer.TokenTransferEvent {
+	closedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	opIndex := uint32(1)
+	meta := &token_transfer.EventMeta{
+		LedgerSequence:   10,
+		ClosedAt:         timestamppb.New(closedAt),
+		TxHash:           "abcd",
+		TransactionIndex: 2,
+		OperationIndex:   &opIndex,
+		ContractAddress:  "CAAQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABDQF",
+		ToMuxedInfo:      token_transfer.NewMuxedInfoFromId(7),
+	}
+	feeMeta := &token_transfer.EventMeta{
+		LedgerSequence:   10,
+		ClosedAt:         timestamppb.New(closedAt),
+		TxHash:           "abcd",
+		TransactionIndex: 2,
+	}
+	return []*token_transfer.TokenTransferEvent{
+		token_transfer.NewFeeEvent(feeMeta, accountA, "100", assetProto.NewNativeAsset()),
+		token_transfer.NewTransferEvent(meta, accountA, accountB, "5000000",
+			assetProto.NewProtoAsset(xdr.MustNewCreditAsset("USDC", "GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN"))),
+	}
+}
+
+func writeEvents(t *testing.T, format Format, events []*token_transfer.TokenTransferEvent) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	require.NoError(t, err)
+	for _, event := range events {
+		require.NoError(t, w.Write(event))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestJSONLWriter(t *testing.T) {
+	events := testEvents()
+	lines := bytes.Split(bytes.TrimSpace(writeEvents(t, FormatJSONL, events)), []byte("\n"))
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		var event token_transfer.TokenTransferEvent
+		require.NoError(t, protojson.Unmarshal(line, &event))
+		assert.Equal(t, events[i].GetEventType(), event.GetEventType())
+		assert.Equal(t, events[i].GetAmount(), event.GetAmount())
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	assert.Equal(t,
+		"ledger_sequence,closed_at,tx_hash,transaction_index,operation_index,contract_address,to_muxed_info,to_muxed_info_type,event_type,from,to,asset,amount\n"+
+			"10,2025-01-02T03:04:05Z,abcd,2,,,,,fee,"+accountA+",,native,100\n"+
+			"10,2025-01-02T03:04:05Z,abcd,2,1,CAAQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABDQF,7,id,transfer,"+accountA+","+accountB+","+usdc+",5000000\n",
+		string(writeEvents(t, FormatCSV, testEvents())),
+	)
+}
+
+func TestRowMuxedInfoType(t *testing.T) {
+	event := func(info *token_transfer.MuxedInfo) *token_transfer.TokenTransferEvent {
+		meta := &token_transfer.EventMeta{ToMuxedInfo: info}
+		return token_transfer.NewMintEvent(meta, accountA, "1", assetProto.NewNativeAsset())
+	}
+
+	text := NewRow(event(token_transfer.NewMuxedInfoFromText("123")))
+	id := NewRow(event(token_transfer.NewMuxedInfoFromId(123)))
+	assert.Equal(t, text.ToMuxedInfo, id.ToMuxedInfo)
+	assert.Equal(t, "text", text.ToMuxedInfoType)
+	assert.Equal(t, "id", id.ToMuxedInfoType)
+	assert.Empty(t, NewRow(event(nil)).ToMuxedInfoType)
+}
+
+func emptyLedger(sequence uint32) xdr.LedgerCloseMeta {
+	return xdr.LedgerCloseMeta{
+		V: 1,
+		V1: &xdr.LedgerCloseMetaV1{
+			LedgerHeader: xdr.LedgerHeaderHistoryEntry{
+				Header: xdr.LedgerHeader{LedgerSeq: xdr.Uint32(sequence), LedgerVersion: 23},
+			},
+			TxSet: xdr.GeneralizedTransactionSet{V: 1, V1TxSet: &xdr.TransactionSetV1{}},
+		},
+	}
+}
+
+func mockBackend(from, to uint32) *ledgerbackend.MockDatabaseBackend {
+	backend := &ledgerbackend.MockDatabaseBackend{}
+	backend.On("PrepareRange", mock.Anything, ledgerbackend.BoundedRange(from, to)).Return(nil).Once()
+	for sequence := from; sequence <= to; sequence++ {
+		backend.On("GetLedger", mock.Anything, sequence).Return(emptyLedger(sequence), nil).Once()
+	}
+	return backend
+}
+
+func fileNames(t *testing.T, dir string) []string {
+	dirEntries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, dirEntry := range dirEntries {
+		names = append(names, dirEntry.Name())
+	}
+	return names
+}
+
+func TestExportResumes(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		NetworkPassphrase: network.TestNetworkPassphrase,
+		OutputDir:         dir,
+		Format:            FormatCSV,
+		LedgersPerFile:    10,
+	}
+
+	config.LedgerBackend = mockBackend(5, 24)
+	require.NoError(t, Export(context.Background(), config, ledgerbackend.BoundedRange(5, 24)))
+	config.LedgerBackend.(*ledgerbackend.MockDatabaseBackend).AssertExpectations(t)
+	assert.Equal(t, []string{"0000000005-0000000009.csv", "0000000010-0000000019.csv", "0000000020-0000000024.csv"}, fileNames(t, dir))
+
+	content, err := os.ReadFile(filepath.Join(dir, "0000000010-0000000019.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "ledger_sequence,closed_at,tx_hash,transaction_index,operation_index,contract_address,to_muxed_info,to_muxed_info_type,event_type,from,to,asset,amount\n", string(content))
+
+	// ledgers 5 to 19 are already exported, the partial file of ledgers 20
+	// to 24 is replaced by a complete file
+	config.LedgerBackend = mockBackend(20, 35)
+	require.NoError(t, Export(context.Background(), config, ledgerbackend.BoundedRange(5, 35)))
+	config.LedgerBackend.(*ledgerbackend.MockDatabaseBackend).AssertExpectations(t)
+	assert.Equal(t, []string{
+		"0000000005-0000000009.csv", "0000000010-0000000019.csv", "0000000020-0000000029.csv", "0000000030-0000000035.csv",
+	}, fileNames(t, dir))
+
+	// everything is exported
+	config.LedgerBackend = &ledgerbackend.MockDatabaseBackend{}
+	require.NoError(t, Export(context.Background(), config, ledgerbackend.BoundedRange(10, 29)))
+}
+
+func TestExportLeavesNoPartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	backend := &ledgerbackend.MockDatabaseBackend{}
+	backend.On("PrepareRange", mock.Anything, ledgerbackend.BoundedRange(0, 15)).Return(nil).Once()
+	for sequence := uint32(0); sequence < 12; sequence++ {
+		backend.On("GetLedger", mock.Anything, sequence).Return(emptyLedger(sequence), nil).Once()
+	}
+	backend.On("GetLedger", mock.Anything, uint32(12)).Return(xdr.LedgerCloseMeta{}, assert.AnError).Once()
+
+	err := Export(context.Background(), Config{
+		LedgerBackend:  backend,
+		OutputDir:      dir,
+		Format:         FormatJSONL,
+		LedgersPerFile: 10,
+	}, ledgerbackend.BoundedRange(0, 15))
+	assert.ErrorContains(t, err, "could not get ledger 12")
+	assert.Equal(t, []string{"0000000000-0000000009.jsonl"}, fileNames(t, dir))
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("csv")
+	require.NoError(t, err)
+	assert.Equal(t, FormatCSV, format)
+	_, err = ParseFormat("xml")
+	assert.EqualError(t, err, `unknown format "xml", expected jsonl or csv`)
+}
+
+func TestExportCustomWriter(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		NetworkPassphrase: network.TestNetworkPassphrase,
+		OutputDir:         dir,
+		NewWriter: func(w io.Writer) (Writer, error) {
+			return NewJSONLWriter(w), nil
+		},
+		LedgersPerFile: 10,
+	}
+	config.LedgerBackend = &ledgerbackend.MockDatabaseBackend{}
+	assert.EqualError(t, Export(context.Background(), config, ledgerbackend.BoundedRange(5, 14)), "format is required")
+
+	// the format is not built in but only used as the extension
+	config.Format = Format("events")
+	config.LedgerBackend = mockBackend(5, 14)
+	require.NoError(t, Export(context.Background(), config, ledgerbackend.BoundedRange(5, 14)))
+	config.LedgerBackend.(*ledgerbackend.MockDatabaseBackend).AssertExpectations(t)
+	assert.Equal(t, []string{"0000000005-0000000009.events", "0000000010-0000000014.events"}, fileNames(t, dir))
+}
@@ -0,0 +1,134 @@
+package exporter
+
+import (
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+)
+
+// Row is a token transfer event flattened into a single record, with one
+// column per EventMeta field. It is the record written by the CSV writer.
+type Row struct {
+	LedgerSequence   uint32
+	ClosedAt         time.Time
+	TxHash           string
+	TransactionIndex uint32
+	// OperationIndex is 1-indexed and nil for fee events.
+	OperationIndex  *uint32
+	ContractAddress string
+	// ToMuxedInfo is the text, id or hex encoded hash memo of the recipient,
+	// empty if there is none.
+	ToMuxedInfo string
+	// ToMuxedInfoType is "text", "id" or "hash", depending on the kind of
+	// ToMuxedInfo, and empty if there is none.
+	ToMuxedInfoType string
+	EventType       string
+	From            string
+	To              string
+	// Asset is "native" or "CODE:ISSUER" and empty for custom tokens.
+	Asset  string
+	Amount string
+}
+
+// NewRow flattens a token transfer event.
+func NewRow(event *token_transfer.TokenTransferEvent) Row {
+	meta := event.GetMeta()
+	row := Row{
+		LedgerSequence:   meta.GetLedgerSequence(),
+		ClosedAt:         meta.GetClosedAt().AsTime().UTC(),
+		TxHash:           meta.GetTxHash(),
+		TransactionIndex: meta.GetTransactionIndex(),
+		OperationIndex:   meta.OperationIndex,
+		ContractAddress:  meta.GetContractAddress(),
+		ToMuxedInfo:      muxedInfoString(meta.GetToMuxedInfo()),
+		ToMuxedInfoType:  muxedInfoType(meta.GetToMuxedInfo()),
+		EventType:        event.GetEventType(),
+		Amount:           event.GetAmount(),
+	}
+	if asset := event.GetAsset(); asset != nil {
+		row.Asset = asset.ToXdrAsset().StringCanonical()
+	}
+
+	switch {
+	case event.GetTransfer() != nil:
+		row.From, row.To = event.GetTransfer().GetFrom(), event.GetTransfer().GetTo()
+	case event.GetMint() != nil:
+		row.To = event.GetMint().GetTo()
+	case event.GetBurn() != nil:
+		row.From = event.GetBurn().GetFrom()
+	case event.GetClawback() != nil:
+		row.From = event.GetClawback().GetFrom()
+	case event.GetFee() != nil:
+		row.From = event.GetFee().GetFrom()
+	}
+	return row
+}
+
+// csvHeader are the column names of the CSV output. The Parquet schema of
+// the token-transfer-export tool declares its columns separately and must be
+// kept in sync with it.
+var csvHeader = []string{
+	"ledger_sequence",
+	"closed_at",
+	"tx_hash",
+	"transaction_index",
+	"operation_index",
+	"contract_address",
+	"to_muxed_info",
+	"to_muxed_info_type",
+	"event_type",
+	"from",
+	"to",
+	"asset",
+	"amount",
+}
+
+func (r Row) csvRecord() []string {
+	operationIndex := ""
+	if r.OperationIndex != nil {
+		operationIndex = strconv.FormatUint(uint64(*r.OperationIndex), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(r.LedgerSequence), 10),
+		r.ClosedAt.Format(time.RFC3339),
+		r.TxHash,
+		strconv.FormatUint(uint64(r.TransactionIndex), 10),
+		operationIndex,
+		r.ContractAddress,
+		r.ToMuxedInfo,
+		r.ToMuxedInfoType,
+		r.EventType,
+		r.From,
+		r.To,
+		r.Asset,
+		r.Amount,
+	}
+}
+
+func muxedInfoType(info *token_transfer.MuxedInfo) string {
+	switch info.GetContent().(type) {
+	case *token_transfer.MuxedInfo_Text:
+		return "text"
+	case *token_transfer.MuxedInfo_Id:
+		return "id"
+	case *token_transfer.MuxedInfo_Hash:
+		return "hash"
+	default:
+		return ""
+	}
+}
+
+func muxedInfoString(info *token_transfer.MuxedInfo) string {
+	switch info.GetContent().(type) {
+	case *token_transfer.MuxedInfo_Text:
+		return info.GetText()
+	case *token_transfer.MuxedInfo_Id:
+		return strconv.FormatUint(info.GetId(), 10)
+	case *token_transfer.MuxedInfo_Hash:
+		return hex.EncodeToString(info.GetHash())
+	default:
+		return ""
+	}
+}
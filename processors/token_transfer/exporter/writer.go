@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+)
+
+// Format is an output format of the exporter.
+type Format string
+
+const (
+	// FormatJSONL writes one protojson encoded event per line.
+	FormatJSONL Format = "jsonl"
+	// FormatCSV writes one Row per line, preceded by a header.
+	FormatCSV Format = "csv"
+)
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatJSONL, FormatCSV:
+		return f, nil
+	default:
+		return "", errors.Errorf("unknown format %q, expected jsonl or csv", s)
+	}
+}
+
+// Extension returns the file extension used for the format.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// Writer writes token transfer events to an output. Close must be called
+// after the last event has been written to flush the output, it does not
+// close the underlying io.Writer.
+type Writer interface {
+	Write(event *token_transfer.TokenTransferEvent) error
+	Close() error
+}
+
+// NewWriter returns a Writer which writes events to w in the given format.
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		return NewJSONLWriter(w), nil
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	default:
+		return nil, errors.Errorf("unknown format %q", format)
+	}
+}
+
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+// NewJSONLWriter returns a Writer which writes every event as a single line
+// of protojson.
+func NewJSONLWriter(w io.Writer) Writer {
+	return &jsonlWriter{w: bufio.NewWriter(w)}
+}
+
+func (j *jsonlWriter) Write(event *token_transfer.TokenTransferEvent) error {
+	line, err := protojson.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling event")
+	}
+	if _, err = j.w.Write(line); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+// NewCSVWriter returns a Writer which writes every event as a flattened Row.
+// The header is written before the first event.
+func NewCSVWriter(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	return c.w.Write(csvHeader)
+}
+
+func (c *csvWriter) Write(event *token_transfer.TokenTransferEvent) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write(NewRow(event).csvRecord())
+}
+
+func (c *csvWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,461 @@
+package token_transfer
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/support/collections/set"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+)
+
+// AssetFlows are the token flows of a single asset over a range of ledgers.
+// Volumes are in the smallest unit of the asset, i.e. stroops for classic
+// assets.
+type AssetFlows struct {
+	// Asset is the canonical representation of a classic asset ("native" or
+	// "CODE:ISSUER") or, for custom tokens, the strkey of the token contract.
+	Asset string
+
+	TransferCount  uint64
+	TransferVolume *big.Int
+	MintCount      uint64
+	MintVolume     *big.Int
+	BurnCount      uint64
+	BurnVolume     *big.Int
+	ClawbackCount  uint64
+	ClawbackVolume *big.Int
+
+	// FeeCount is the number of fee events, including refunds. FeesPaid is
+	// the sum of the fees net of refunds. Fees are only paid in XLM.
+	FeeCount uint64
+	FeesPaid *big.Int
+
+	// Senders and Receivers are the addresses which sent and received the
+	// asset in transfers.
+	Senders   set.Set[string]
+	Receivers set.Set[string]
+
+	// LiquidityPoolInflow is the amount moved into liquidity pools and
+	// LiquidityPoolOutflow the amount moved out of them. The claimable balance
+	// flows are defined the same way for claimable balances.
+	LiquidityPoolInflow     *big.Int
+	LiquidityPoolOutflow    *big.Int
+	ClaimableBalanceInflow  *big.Int
+	ClaimableBalanceOutflow *big.Int
+}
+
+// NewAssetFlows returns empty flows of asset.
+func NewAssetFlows(asset string) *AssetFlows {
+	return &AssetFlows{
+		Asset:                   asset,
+		TransferVolume:          new(big.Int),
+		MintVolume:              new(big.Int),
+		BurnVolume:              new(big.Int),
+		ClawbackVolume:          new(big.Int),
+		FeesPaid:                new(big.Int),
+		Senders:                 set.NewSet[string](0),
+		Receivers:               set.NewSet[string](0),
+		LiquidityPoolInflow:     new(big.Int),
+		LiquidityPoolOutflow:    new(big.Int),
+		ClaimableBalanceInflow:  new(big.Int),
+		ClaimableBalanceOutflow: new(big.Int),
+	}
+}
+
+// UniqueSenders returns the number of distinct addresses which sent the
+// asset.
+func (f *AssetFlows) UniqueSenders() int {
+	return len(f.Senders)
+}
+
+// UniqueReceivers returns the number of distinct addresses which received
+// the asset.
+func (f *AssetFlows) UniqueReceivers() int {
+	return len(f.Receivers)
+}
+
+// NetLiquidityPoolFlow returns the amount moved into liquidity pools minus
+// the amount moved out of them.
+func (f *AssetFlows) NetLiquidityPoolFlow() *big.Int {
+	return new(big.Int).Sub(f.LiquidityPoolInflow, f.LiquidityPoolOutflow)
+}
+
+// NetClaimableBalanceFlow returns the amount moved into claimable balances
+// minus the amount moved out of them.
+func (f *AssetFlows) NetClaimableBalanceFlow() *big.Int {
+	return new(big.Int).Sub(f.ClaimableBalanceInflow, f.ClaimableBalanceOutflow)
+}
+
+// Merge adds the flows of other, which must be of the same asset, to f.
+func (f *AssetFlows) Merge(other *AssetFlows) error {
+	if f.Asset != other.Asset {
+		return errors.Errorf("cannot merge flows of %s into flows of %s", other.Asset, f.Asset)
+	}
+	f.TransferCount += other.TransferCount
+	f.TransferVolume.Add(f.TransferVolume, other.TransferVolume)
+	f.MintCount += other.MintCount
+	f.MintVolume.Add(f.MintVolume, other.MintVolume)
+	f.BurnCount += other.BurnCount
+	f.BurnVolume.Add(f.BurnVolume, other.BurnVolume)
+	f.ClawbackCount += other.ClawbackCount
+	f.ClawbackVolume.Add(f.ClawbackVolume, other.ClawbackVolume)
+	f.FeeCount += other.FeeCount
+	f.FeesPaid.Add(f.FeesPaid, other.FeesPaid)
+	for sender := range other.Senders {
+		f.Senders.Add(sender)
+	}
+	for receiver := range other.Receivers {
+		f.Receivers.Add(receiver)
+	}
+	f.LiquidityPoolInflow.Add(f.LiquidityPoolInflow, other.LiquidityPoolInflow)
+	f.LiquidityPoolOutflow.Add(f.LiquidityPoolOutflow, other.LiquidityPoolOutflow)
+	f.ClaimableBalanceInflow.Add(f.ClaimableBalanceInflow, other.ClaimableBalanceInflow)
+	f.ClaimableBalanceOutflow.Add(f.ClaimableBalanceOutflow, other.ClaimableBalanceOutflow)
+	return nil
+}
+
+func (f *AssetFlows) addEvent(event *TokenTransferEvent, amount *big.Int) error {
+	var from, to string
+	switch ev := event.GetEvent().(type) {
+	case *TokenTransferEvent_Transfer:
+		from, to = ev.Transfer.GetFrom(), ev.Transfer.GetTo()
+		f.TransferCount++
+		f.TransferVolume.Add(f.TransferVolume, amount)
+		f.Senders.Add(from)
+		f.Receivers.Add(to)
+	case *TokenTransferEvent_Mint:
+		to = ev.Mint.GetTo()
+		f.MintCount++
+		f.MintVolume.Add(f.MintVolume, amount)
+	case *TokenTransferEvent_Burn:
+		from = ev.Burn.GetFrom()
+		f.BurnCount++
+		f.BurnVolume.Add(f.BurnVolume, amount)
+	case *TokenTransferEvent_Clawback:
+		from = ev.Clawback.GetFrom()
+		f.ClawbackCount++
+		f.ClawbackVolume.Add(f.ClawbackVolume, amount)
+	case *TokenTransferEvent_Fee:
+		// negative fees are refunds
+		f.FeeCount++
+		f.FeesPaid.Add(f.FeesPaid, amount)
+		return nil
+	default:
+		return errors.Errorf("unknown event type %T", ev)
+	}
+
+	switch addressKind(to) {
+	case 'L':
+		f.LiquidityPoolInflow.Add(f.LiquidityPoolInflow, amount)
+	case 'B':
+		f.ClaimableBalanceInflow.Add(f.ClaimableBalanceInflow, amount)
+	}
+	switch addressKind(from) {
+	case 'L':
+		f.LiquidityPoolOutflow.Add(f.LiquidityPoolOutflow, amount)
+	case 'B':
+		f.ClaimableBalanceOutflow.Add(f.ClaimableBalanceOutflow, amount)
+	}
+	return nil
+}
+
+// addressKind returns the first character of a strkey address, which
+// identifies its type, e.g. 'L' for liquidity pools.
+func addressKind(address string) byte {
+	if address == "" {
+		return 0
+	}
+	return address[0]
+}
+
+// LedgerRange is an inclusive range of ledgers.
+type LedgerRange struct {
+	First uint32
+	Last  uint32
+}
+
+func (lr LedgerRange) String() string {
+	return fmt.Sprintf("[%d, %d]", lr.First, lr.Last)
+}
+
+// FlowRollup aggregates the token flows of a range of ledgers per asset.
+// Rollups of disjoint ranges can be merged, so the flows of a large range
+// can be computed in parallel over sub-ranges.
+type FlowRollup struct {
+	// FirstLedger and LastLedger are the lowest and highest sequence of the
+	// ledgers aggregated in the rollup.
+	FirstLedger uint32
+	LastLedger  uint32
+	// Ledgers are the ranges of the ledgers aggregated in the rollup, sorted
+	// and disjoint. Adjacent ranges are coalesced so the ledgers are a single
+	// range unless rollups of non-adjacent ranges were merged.
+	Ledgers []LedgerRange
+	// Start is the beginning of the time bucket of the rollup, it is the zero
+	// time for rollups which are not bucketed by time.
+	Start  time.Time
+	Assets map[string]*AssetFlows
+}
+
+// NewFlowRollup returns an empty rollup.
+func NewFlowRollup() *FlowRollup {
+	return &FlowRollup{Assets: make(map[string]*AssetFlows)}
+}
+
+// NewLedgerFlowRollup returns the rollup of the events of a single ledger,
+// as returned by EventsProcessor.EventsFromLedger.
+func NewLedgerFlowRollup(ledger uint32, events []*TokenTransferEvent) (*FlowRollup, error) {
+	r := NewFlowRollup()
+	r.addLedgers(LedgerRange{First: ledger, Last: ledger})
+	for _, event := range events {
+		if err := r.AddEvent(event); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// AddEvent adds event to the rollup and extends the ledger range of the
+// rollup to the ledger of the event.
+func (r *FlowRollup) AddEvent(event *TokenTransferEvent) error {
+	if event.GetEvent() == nil {
+		return errors.New("event is empty")
+	}
+	asset := eventAsset(event)
+	if asset == "" {
+		return errors.New("event has no asset")
+	}
+	amount, err := parseEventAmount(event)
+	if err != nil {
+		return err
+	}
+	flows, ok := r.Assets[asset]
+	if !ok {
+		flows = NewAssetFlows(asset)
+		r.Assets[asset] = flows
+	}
+	if err = flows.addEvent(event, amount); err != nil {
+		return err
+	}
+	ledger := event.GetMeta().GetLedgerSequence()
+	r.addLedgers(LedgerRange{First: ledger, Last: ledger})
+	return nil
+}
+
+// addLedgerRange adds a range of ledgers to sorted and disjoint ranges,
+// coalescing it with the ranges it overlaps or is adjacent to.
+func addLedgerRange(ranges []LedgerRange, added LedgerRange) []LedgerRange {
+	// ledgers are usually added in order so the last range only needs to be
+	// extended
+	if n := len(ranges); n > 0 && ranges[n-1].First <= added.First &&
+		uint64(added.First) <= uint64(ranges[n-1].Last)+1 {
+		ranges[n-1].Last = max(ranges[n-1].Last, added.Last)
+		return ranges
+	}
+	ranges = append(ranges, added)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].First < ranges[j].First
+	})
+	coalesced := ranges[:1]
+	for _, lr := range ranges[1:] {
+		last := &coalesced[len(coalesced)-1]
+		if uint64(lr.First) <= uint64(last.Last)+1 {
+			last.Last = max(last.Last, lr.Last)
+		} else {
+			coalesced = append(coalesced, lr)
+		}
+	}
+	return coalesced
+}
+
+// ledgerRangesOverlap returns true if a ledger is in both sorted and disjoint
+// ranges.
+func ledgerRangesOverlap(a, b []LedgerRange) bool {
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i].First <= b[j].Last && b[j].First <= a[i].Last {
+			return true
+		}
+		if a[i].Last < b[j].Last {
+			i++
+		} else {
+			j++
+		}
+	}
+	return false
+}
+
+// addLedgers adds a range of ledgers to the rollup.
+func (r *FlowRollup) addLedgers(added LedgerRange) {
+	if added.First == 0 {
+		return
+	}
+	r.Ledgers = addLedgerRange(r.Ledgers, added)
+	r.FirstLedger = r.Ledgers[0].First
+	r.LastLedger = r.Ledgers[len(r.Ledgers)-1].Last
+}
+
+// Merge adds the flows of other to r. The rollups must be of disjoint ledger
+// ranges, otherwise events are counted twice.
+func (r *FlowRollup) Merge(other *FlowRollup) error {
+	if !r.Start.Equal(other.Start) {
+		return errors.Errorf("cannot merge rollup of bucket %v into rollup of bucket %v", other.Start, r.Start)
+	}
+	if ledgerRangesOverlap(r.Ledgers, other.Ledgers) {
+		return errors.Errorf(
+			"cannot merge rollup of ledgers [%d, %d] into rollup of overlapping ledgers [%d, %d]",
+			other.FirstLedger, other.LastLedger, r.FirstLedger, r.LastLedger,
+		)
+	}
+	for asset, otherFlows := range other.Assets {
+		flows, ok := r.Assets[asset]
+		if !ok {
+			flows = NewAssetFlows(asset)
+			r.Assets[asset] = flows
+		}
+		if err := flows.Merge(otherFlows); err != nil {
+			return err
+		}
+	}
+	for _, lr := range other.Ledgers {
+		r.addLedgers(lr)
+	}
+	return nil
+}
+
+// SortedAssets returns the flows of every asset sorted by asset.
+func (r *FlowRollup) SortedAssets() []*AssetFlows {
+	flows := make([]*AssetFlows, 0, len(r.Assets))
+	for _, f := range r.Assets {
+		flows = append(flows, f)
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].Asset < flows[j].Asset
+	})
+	return flows
+}
+
+// FlowAggregator aggregates token transfer events into FlowRollups of fixed
+// time buckets, based on the close time of the ledger of each event.
+// Aggregators of disjoint ledger ranges with the same bucket size can be
+// merged.
+//
+// FlowAggregator is not safe for concurrent use.
+type FlowAggregator struct {
+	bucketSize time.Duration
+	buckets    map[int64]*FlowRollup
+	// ledgers are the ranges of the ledgers added to the aggregator, sorted
+	// and disjoint. Unlike the ledgers of the buckets, they include the
+	// ledgers without events.
+	ledgers []LedgerRange
+}
+
+// NewFlowAggregator returns an aggregator of buckets of the given size, e.g.
+// time.Hour. Buckets are aligned to the unix epoch.
+func NewFlowAggregator(bucketSize time.Duration) (*FlowAggregator, error) {
+	if bucketSize <= 0 {
+		return nil, errors.New("bucket size must be positive")
+	}
+	return &FlowAggregator{bucketSize: bucketSize, buckets: make(map[int64]*FlowRollup)}, nil
+}
+
+// BucketSize returns the size of the time buckets of the aggregator.
+func (a *FlowAggregator) BucketSize() time.Duration {
+	return a.bucketSize
+}
+
+// AddLedger adds the events of a ledger, as returned by
+// EventsProcessor.EventsFromLedger, to the buckets of their close time and
+// returns the rollup of the ledger. Each ledger can only be added once. The
+// aggregator is not modified if an error is returned.
+func (a *FlowAggregator) AddLedger(ledger uint32, events []*TokenTransferEvent) (*FlowRollup, error) {
+	added := []LedgerRange{{First: ledger, Last: ledger}}
+	if ledgerRangesOverlap(a.ledgers, added) {
+		return nil, errors.Errorf("ledger %d was already added", ledger)
+	}
+	// the events are validated by the rollup of the ledger before any bucket
+	// is modified, so adding them to the buckets can't fail
+	ledgerRollup, err := NewLedgerFlowRollup(ledger, events)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if err = a.bucket(event.GetMeta().GetClosedAt().AsTime()).AddEvent(event); err != nil {
+			return nil, err
+		}
+	}
+	a.ledgers = addLedgerRange(a.ledgers, added[0])
+	return ledgerRollup, nil
+}
+
+// Ledgers returns the ranges of the ledgers added to the aggregator, sorted
+// and disjoint, including the ledgers without events.
+func (a *FlowAggregator) Ledgers() []LedgerRange {
+	return append([]LedgerRange(nil), a.ledgers...)
+}
+
+func (a *FlowAggregator) bucket(closedAt time.Time) *FlowRollup {
+	// time.Time.Truncate aligns to the zero time, not to the unix epoch,
+	// which differ for bucket sizes which are not a divisor of a day.
+	sinceEpoch := time.Duration(closedAt.UnixNano())
+	offset := sinceEpoch % a.bucketSize
+	if offset < 0 {
+		offset += a.bucketSize
+	}
+	key := int64(sinceEpoch - offset)
+	start := time.Unix(0, key).UTC()
+	r, ok := a.buckets[key]
+	if !ok {
+		r = NewFlowRollup()
+		r.Start = start
+		a.buckets[key] = r
+	}
+	return r
+}
+
+// Buckets returns the rollups of every non-empty bucket sorted by time.
+func (a *FlowAggregator) Buckets() []*FlowRollup {
+	buckets := make([]*FlowRollup, 0, len(a.buckets))
+	for _, r := range a.buckets {
+		buckets = append(buckets, r)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets
+}
+
+// Merge adds the buckets of other, which must have the same bucket size and
+// have aggregated a disjoint range of ledgers, to a. The aggregator is not
+// modified if an error is returned.
+func (a *FlowAggregator) Merge(other *FlowAggregator) error {
+	if a.bucketSize != other.bucketSize {
+		return errors.Errorf("cannot merge aggregator with bucket size %v into aggregator with bucket size %v",
+			other.bucketSize, a.bucketSize)
+	}
+	if ledgerRangesOverlap(a.ledgers, other.ledgers) {
+		return errors.Errorf(
+			"cannot merge aggregator of ledgers %v into aggregator of overlapping ledgers %v",
+			other.ledgers, a.ledgers,
+		)
+	}
+	// the ledgers of the buckets are a subset of the ledgers of the
+	// aggregators, but every bucket is checked before any is merged
+	for key, otherBucket := range other.buckets {
+		if bucket, ok := a.buckets[key]; ok && ledgerRangesOverlap(bucket.Ledgers, otherBucket.Ledgers) {
+			return errors.Errorf(
+				"cannot merge rollup of ledgers [%d, %d] into rollup of overlapping ledgers [%d, %d]",
+				otherBucket.FirstLedger, otherBucket.LastLedger, bucket.FirstLedger, bucket.LastLedger,
+			)
+		}
+	}
+	for _, otherBucket := range other.Buckets() {
+		if err := a.bucket(otherBucket.Start).Merge(otherBucket); err != nil {
+			return err
+		}
+	}
+	for _, lr := range other.ledgers {
+		a.ledgers = addLedgerRange(a.ledgers, lr)
+	}
+	return nil
+}
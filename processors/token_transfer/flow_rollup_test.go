@@ -0,0 +1,212 @@
+package token_transfer
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func flowEventMeta(ledger uint32, closedAt time.Time) *EventMeta {
+	meta := ledgerEventMeta(ledger)
+	meta.ClosedAt = timestamppb.New(closedAt)
+	return meta
+}
+
+func TestFlowRollup(t *testing.T) {
+	a, b := protoAddressFromAccount(accountA), protoAddressFromAccount(accountB)
+	lp := lpIdToStrkey(lpEthUsdcId)
+	cb := cbIdToStrkey(someBalanceId)
+	meta := ledgerEventMeta(10)
+
+	rollup, err := NewLedgerFlowRollup(10, []*TokenTransferEvent{
+		NewFeeEvent(meta, a, "100", xlmProtoAsset),
+		NewTransferEvent(meta, a, b, "50", usdcProtoAsset),
+		NewTransferEvent(meta, a, b, "25", usdcProtoAsset),
+		NewTransferEvent(meta, b, lp, "30", usdcProtoAsset),
+		NewTransferEvent(meta, lp, a, "10", usdcProtoAsset),
+		NewMintEvent(meta, cb, "40", usdcProtoAsset),
+		NewBurnEvent(meta, cb, "15", usdcProtoAsset),
+		NewClawbackEvent(meta, a, "5", usdcProtoAsset),
+		NewFeeEvent(meta, a, "-20", xlmProtoAsset),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(10), rollup.FirstLedger)
+	assert.Equal(t, uint32(10), rollup.LastLedger)
+	require.Len(t, rollup.Assets, 2)
+
+	xlm := rollup.Assets[xlmAsset.StringCanonical()]
+	assert.Equal(t, uint64(2), xlm.FeeCount)
+	assert.Equal(t, big.NewInt(80), xlm.FeesPaid)
+
+	usdc := rollup.Assets[usdcAsset.StringCanonical()]
+	assert.Equal(t, uint64(4), usdc.TransferCount)
+	assert.Equal(t, big.NewInt(115), usdc.TransferVolume)
+	assert.Equal(t, uint64(1), usdc.MintCount)
+	assert.Equal(t, big.NewInt(40), usdc.MintVolume)
+	assert.Equal(t, uint64(1), usdc.BurnCount)
+	assert.Equal(t, big.NewInt(15), usdc.BurnVolume)
+	assert.Equal(t, uint64(1), usdc.ClawbackCount)
+	assert.Equal(t, big.NewInt(5), usdc.ClawbackVolume)
+	assert.Equal(t, 3, usdc.UniqueSenders())
+	assert.Equal(t, 3, usdc.UniqueReceivers())
+	assert.Equal(t, big.NewInt(30), usdc.LiquidityPoolInflow)
+	assert.Equal(t, big.NewInt(10), usdc.LiquidityPoolOutflow)
+	assert.Equal(t, big.NewInt(20), usdc.NetLiquidityPoolFlow())
+	assert.Equal(t, big.NewInt(25), usdc.NetClaimableBalanceFlow())
+
+	_, err = NewLedgerFlowRollup(10, []*TokenTransferEvent{NewTransferEvent(meta, a, b, "x", usdcProtoAsset)})
+	assert.EqualError(t, err, `invalid amount "x"`)
+}
+
+func TestFlowRollupMergeOutOfOrder(t *testing.T) {
+	a, b := protoAddressFromAccount(accountA), protoAddressFromAccount(accountB)
+	rollup := func(first, last uint32) *FlowRollup {
+		r, err := NewLedgerFlowRollup(first, []*TokenTransferEvent{
+			NewTransferEvent(ledgerEventMeta(first), a, b, "10", usdcProtoAsset),
+		})
+		require.NoError(t, err)
+		r.addLedgers(LedgerRange{First: first, Last: last})
+		return r
+	}
+
+	merged := rollup(1, 100)
+	require.NoError(t, merged.Merge(rollup(201, 300)))
+	assert.Equal(t, []LedgerRange{{1, 100}, {201, 300}}, merged.Ledgers)
+	assert.Equal(t, uint32(1), merged.FirstLedger)
+	assert.Equal(t, uint32(300), merged.LastLedger)
+
+	// the gap is filled and the ranges are coalesced
+	require.NoError(t, merged.Merge(rollup(101, 200)))
+	assert.Equal(t, []LedgerRange{{1, 300}}, merged.Ledgers)
+	assert.Equal(t, big.NewInt(30), merged.Assets[usdcAsset.StringCanonical()].TransferVolume)
+
+	gap := rollup(1, 100)
+	require.NoError(t, gap.Merge(rollup(201, 300)))
+	assert.EqualError(t, gap.Merge(rollup(150, 250)),
+		"cannot merge rollup of ledgers [150, 250] into rollup of overlapping ledgers [1, 300]")
+	assert.NoError(t, gap.Merge(rollup(150, 200)))
+	assert.Equal(t, []LedgerRange{{1, 100}, {150, 300}}, gap.Ledgers)
+}
+
+func TestFlowAggregatorMerge(t *testing.T) {
+	a, b := protoAddressFromAccount(accountA), protoAddressFromAccount(accountB)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ledgers := []struct {
+		sequence uint32
+		closedAt time.Time
+		from     string
+	}{
+		{1, start.Add(10 * time.Minute), a},
+		{2, start.Add(50 * time.Minute), b},
+		{3, start.Add(70 * time.Minute), a},
+	}
+
+	// the ledgers aggregated by a single aggregator and by two aggregators
+	// of sub-ranges produce the same buckets
+	whole, err := NewFlowAggregator(time.Hour)
+	require.NoError(t, err)
+	first, err := NewFlowAggregator(time.Hour)
+	require.NoError(t, err)
+	second, err := NewFlowAggregator(time.Hour)
+	require.NoError(t, err)
+	for i, ledger := range ledgers {
+		events := []*TokenTransferEvent{
+			NewTransferEvent(flowEventMeta(ledger.sequence, ledger.closedAt), ledger.from, customTokenAddress, "7", nil),
+		}
+		events[0].Meta.ContractAddress = customTokenAddress
+		ledgerRollup, err := whole.AddLedger(ledger.sequence, events)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), ledgerRollup.Assets[customTokenAddress].TransferCount)
+		if i < 2 {
+			_, err = first.AddLedger(ledger.sequence, events)
+		} else {
+			_, err = second.AddLedger(ledger.sequence, events)
+		}
+		require.NoError(t, err)
+	}
+	require.NoError(t, first.Merge(second))
+	assert.Equal(t, whole.Buckets(), first.Buckets())
+
+	buckets := whole.Buckets()
+	require.Len(t, buckets, 2)
+	assert.Equal(t, start, buckets[0].Start)
+	assert.Equal(t, uint32(1), buckets[0].FirstLedger)
+	assert.Equal(t, uint32(2), buckets[0].LastLedger)
+	assert.Equal(t, big.NewInt(14), buckets[0].Assets[customTokenAddress].TransferVolume)
+	assert.Equal(t, 2, buckets[0].Assets[customTokenAddress].UniqueSenders())
+	assert.Equal(t, start.Add(time.Hour), buckets[1].Start)
+
+	// merging the same ledgers twice would count their events twice
+	assert.EqualError(t, first.Merge(second),
+		"cannot merge aggregator of ledgers [[3, 3]] into aggregator of overlapping ledgers [[1, 3]]")
+	assert.Equal(t, whole.Buckets(), first.Buckets())
+	_, err = whole.AddLedger(2, nil)
+	assert.EqualError(t, err, "ledger 2 was already added")
+
+	other, err := NewFlowAggregator(time.Minute)
+	require.NoError(t, err)
+	assert.EqualError(t, whole.Merge(other),
+		"cannot merge aggregator with bucket size 1m0s into aggregator with bucket size 1h0m0s")
+}
+
+func TestFlowAggregatorMergeLedgersWithoutEvents(t *testing.T) {
+	a := protoAddressFromAccount(accountA)
+	closedAt := time.Date(2025, 1, 1, 0, 10, 0, 0, time.UTC)
+	aggregator := func(ledgers ...uint32) *FlowAggregator {
+		agg, err := NewFlowAggregator(time.Hour)
+		require.NoError(t, err)
+		for _, ledger := range ledgers {
+			// only the first ledger has events
+			var events []*TokenTransferEvent
+			if ledger == ledgers[0] {
+				events = []*TokenTransferEvent{
+					NewTransferEvent(flowEventMeta(ledger, closedAt), a, customTokenAddress, "7", nil),
+				}
+				events[0].Meta.ContractAddress = customTokenAddress
+			}
+			_, err = agg.AddLedger(ledger, events)
+			require.NoError(t, err)
+		}
+		return agg
+	}
+
+	// the buckets of both aggregators only have events of ledgers 1 and 3,
+	// but ledger 3 was added to both
+	merged := aggregator(1, 2, 3)
+	assert.Equal(t, []LedgerRange{{1, 3}}, merged.Ledgers())
+	assert.EqualError(t, merged.Merge(aggregator(3, 4)),
+		"cannot merge aggregator of ledgers [[3, 4]] into aggregator of overlapping ledgers [[1, 3]]")
+	buckets := merged.Buckets()
+	require.Len(t, buckets, 1)
+	assert.Equal(t, []LedgerRange{{1, 1}}, buckets[0].Ledgers)
+	assert.Equal(t, big.NewInt(7), buckets[0].Assets[customTokenAddress].TransferVolume)
+
+	require.NoError(t, merged.Merge(aggregator(5, 6)))
+	assert.Equal(t, []LedgerRange{{1, 3}, {5, 6}}, merged.Ledgers())
+	require.NoError(t, merged.Merge(aggregator(4)))
+	assert.Equal(t, []LedgerRange{{1, 6}}, merged.Ledgers())
+	buckets = merged.Buckets()
+	require.Len(t, buckets, 1)
+	assert.Equal(t, big.NewInt(21), buckets[0].Assets[customTokenAddress].TransferVolume)
+}
+
+func TestFlowAggregatorBucketsAlignedToEpoch(t *testing.T) {
+	aggregator, err := NewFlowAggregator(7 * 24 * time.Hour)
+	require.NoError(t, err)
+	// 2025-01-01 is a Wednesday and the unix epoch a Thursday
+	closedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []*TokenTransferEvent{
+		NewTransferEvent(flowEventMeta(1, closedAt), protoAddressFromAccount(accountA), customTokenAddress, "7", nil),
+	}
+	events[0].Meta.ContractAddress = customTokenAddress
+	_, err = aggregator.AddLedger(1, events)
+	require.NoError(t, err)
+
+	buckets := aggregator.Buckets()
+	require.Len(t, buckets, 1)
+	assert.Equal(t, time.Date(2024, 12, 26, 0, 0, 0, 0, time.UTC), buckets[0].Start)
+}
@@ -46,6 +46,11 @@ type EventsProcessor struct {
 	// Instantiate TTP with this flag only if you know that your ledgerCloseMeta has unified events in TxMeta
 	// i.e, the ledgers were generated by stellar-core when the EMIT_CLASSIC_EVENTS and BACKFILL_STELLAR_ASSET_EVENTS flags were set to true
 	readFromUnifiedEventsStream bool
+
+	// eventFilter is set with WithEventFilter and compiled into filter by
+	// NewEventsProcessor.
+	eventFilter *EventFilter
+	filter      *eventFilter
 }
 
 type EventsProcessorOption func(*EventsProcessor)
@@ -68,6 +73,9 @@ func NewEventsProcessor(networkPassphrase string, options ...EventsProcessorOpti
 	for _, opt := range options {
 		opt(proc)
 	}
+	if proc.eventFilter != nil {
+		proc.filter = newEventFilter(*proc.eventFilter, networkPassphrase)
+	}
 	return proc
 }
 
@@ -156,7 +164,7 @@ func (p *EventsProcessor) EventsFromLedger(lcm xdr.LedgerCloseMeta) ([]*TokenTra
 			return nil, fmt.Errorf("error reading transaction: %w", err)
 		}
 
-		txEvents, err := p.EventsFromTransaction(tx)
+		txEvents, err := p.eventsFromTransaction(tx)
 		if err != nil {
 			return nil, err
 		}
@@ -183,7 +191,15 @@ func (p *EventsProcessor) EventsFromLedger(lcm xdr.LedgerCloseMeta) ([]*TokenTra
 	}
 
 	// Assemble final event order based on protocol
-	return p.assembleEventOrder(feeEvents, operationEvents, feeRefundEvents, isProtocol23Plus), nil
+	return p.filterEvents(p.assembleEventOrder(feeEvents, operationEvents, feeRefundEvents, isProtocol23Plus)), nil
+}
+
+// filterEvents returns the events matching the filter of the processor.
+func (p *EventsProcessor) filterEvents(events []*TokenTransferEvent) []*TokenTransferEvent {
+	if p.filter == nil {
+		return events
+	}
+	return p.filter.filter(events)
 }
 
 // assembleEventOrder creates the final ordered list of events based on protocol version
@@ -215,6 +231,18 @@ type TransactionEvents struct {
 //
 // If the transaction is unsuccessful, it only generates events for transaction fees.
 func (p *EventsProcessor) EventsFromTransaction(tx ingest.LedgerTransaction) (TransactionEvents, error) {
+	txEvents, err := p.eventsFromTransaction(tx)
+	if err != nil {
+		return txEvents, err
+	}
+	txEvents.FeeEvents = p.filterEvents(txEvents.FeeEvents)
+	txEvents.OperationEvents = p.filterEvents(txEvents.OperationEvents)
+	return txEvents, nil
+}
+
+// eventsFromTransaction returns the events of the transaction without
+// applying the filter of the processor to them.
+func (p *EventsProcessor) eventsFromTransaction(tx ingest.LedgerTransaction) (TransactionEvents, error) {
 	txEvents := TransactionEvents{}
 	var operationEvents []*TokenTransferEvent
 
@@ -251,7 +279,7 @@ func (p *EventsProcessor) EventsFromTransaction(tx ingest.LedgerTransaction) (Tr
 			opResult := operationResults[i]
 
 			// Process the operation and collect events
-			opEvents, err := p.EventsFromOperation(tx, uint32(i), op, opResult)
+			opEvents, err := p.eventsFromOperation(tx, uint32(i), op, opResult)
 			if err != nil {
 				return TransactionEvents{}, fmt.Errorf("error reading operation events for operation: %v, txHash: %v, error:%w", i, tx.Hash.HexString(), err)
 			}
@@ -273,9 +301,26 @@ func (p *EventsProcessor) EventsFromTransaction(tx ingest.LedgerTransaction) (Tr
 // It is implicitly assumed that the operation is successful, and thus will contribute towards generating events.
 // which is why we don't check for the success code in the OperationResult
 func (p *EventsProcessor) EventsFromOperation(tx ingest.LedgerTransaction, opIndex uint32, op xdr.Operation, opResult xdr.OperationResult) ([]*TokenTransferEvent, error) {
+	events, err := p.eventsFromOperation(tx, opIndex, op, opResult)
+	if err != nil {
+		return nil, err
+	}
+	return p.filterEvents(events), nil
+}
+
+// eventsFromOperation returns the events of the operation without applying
+// the filter of the processor to them. Operations which cannot produce events
+// matching the filter are skipped.
+func (p *EventsProcessor) eventsFromOperation(tx ingest.LedgerTransaction, opIndex uint32, op xdr.Operation, opResult xdr.OperationResult) ([]*TokenTransferEvent, error) {
 	var events []*TokenTransferEvent
 	var err error
 
+	// Before protocol 8 the events of every operation are needed to generate
+	// the XLM reconciliation events, so operations cannot be skipped.
+	if p.filter != nil && tx.Ledger.ProtocolVersion() >= 8 && !p.filter.mayMatchOperation(tx, op, p.networkPassphrase) {
+		return nil, nil
+	}
+
 	switch op.Body.Type {
 	case xdr.OperationTypeCreateAccount:
 		events, err = p.accountCreateEvents(tx, opIndex, op)
@@ -354,6 +399,9 @@ func (p *EventsProcessor) contractEventsFromOperation(tx ingest.LedgerTransactio
 	}
 	events := make([]*TokenTransferEvent, 0, len(contractEvents))
 	for _, contractEvent := range contractEvents {
+		if p.filter != nil && !p.filter.mayMatchContract(contractEvent.ContractId) {
+			continue
+		}
 		ev, err := p.parseEvent(tx, &opIndex, contractEvent)
 
 		// You dont bail on error here, since error here means that it is not a sep-41 compliant token event.
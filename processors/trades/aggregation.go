@@ -0,0 +1,194 @@
+package trades
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Candle aggregates the trades of an asset pair over a period of time, like
+// the trade aggregations of Horizon. Prices are the price of a unit of the
+// base asset in units of the counter asset.
+type Candle struct {
+	// Timestamp is the beginning of the period of the candle.
+	Timestamp     time.Time
+	TradeCount    int64
+	BaseVolume    *big.Int
+	CounterVolume *big.Int
+	Open          xdr.Price
+	High          xdr.Price
+	Low           xdr.Price
+	Close         xdr.Price
+
+	// first and last are the first and last trade of the candle, they
+	// determine Open and Close.
+	first, last Trade
+}
+
+// Average returns the average price of the trades of the candle, weighted
+// by their volume.
+func (c *Candle) Average() *big.Rat {
+	return new(big.Rat).SetFrac(c.CounterVolume, c.BaseVolume)
+}
+
+func (c *Candle) add(trade Trade, baseAmount, counterAmount xdr.Int64, tradePrice xdr.Price) {
+	if c.TradeCount == 0 {
+		c.Open, c.High, c.Low, c.Close = tradePrice, tradePrice, tradePrice, tradePrice
+		c.first, c.last = trade, trade
+	} else {
+		if tradePrice.Cheaper(c.Low) {
+			c.Low = tradePrice
+		}
+		if c.High.Cheaper(tradePrice) {
+			c.High = tradePrice
+		}
+		if trade.before(c.first) {
+			c.first, c.Open = trade, tradePrice
+		}
+		if c.last.before(trade) {
+			c.last, c.Close = trade, tradePrice
+		}
+	}
+	c.TradeCount++
+	c.BaseVolume.Add(c.BaseVolume, big.NewInt(int64(baseAmount)))
+	c.CounterVolume.Add(c.CounterVolume, big.NewInt(int64(counterAmount)))
+}
+
+func (c *Candle) merge(other *Candle) {
+	if other.TradeCount == 0 {
+		return
+	}
+	if c.TradeCount == 0 {
+		c.Open, c.High, c.Low, c.Close = other.Open, other.High, other.Low, other.Close
+		c.first, c.last = other.first, other.last
+	} else {
+		if other.Low.Cheaper(c.Low) {
+			c.Low = other.Low
+		}
+		if c.High.Cheaper(other.High) {
+			c.High = other.High
+		}
+		if other.first.before(c.first) {
+			c.first, c.Open = other.first, other.Open
+		}
+		if c.last.before(other.last) {
+			c.last, c.Close = other.last, other.Close
+		}
+	}
+	c.TradeCount += other.TradeCount
+	c.BaseVolume.Add(c.BaseVolume, other.BaseVolume)
+	c.CounterVolume.Add(c.CounterVolume, other.CounterVolume)
+}
+
+// Aggregator aggregates the trades of an asset pair into candles of a fixed
+// resolution. Like Horizon, the candles start at multiples of the
+// resolution since the unix epoch, shifted by an offset.
+//
+// Trades can be added in any order and aggregators of disjoint sets of
+// trades can be merged, so candles can be computed in parallel over ledger
+// ranges.
+//
+// Aggregator is not safe for concurrent use.
+type Aggregator struct {
+	base       xdr.Asset
+	counter    xdr.Asset
+	resolution time.Duration
+	offset     time.Duration
+	candles    map[int64]*Candle
+}
+
+// NewAggregator returns an aggregator of the trades between base and counter
+// into candles of the given resolution, e.g. time.Hour, starting at offset
+// past every multiple of the resolution.
+func NewAggregator(base, counter xdr.Asset, resolution, offset time.Duration) (*Aggregator, error) {
+	if base.Equals(counter) {
+		return nil, errors.New("base and counter assets must be different")
+	}
+	if resolution <= 0 {
+		return nil, errors.New("resolution must be positive")
+	}
+	if offset < 0 || offset >= resolution {
+		return nil, errors.New("offset must be positive and lower than the resolution")
+	}
+	return &Aggregator{
+		base:       base,
+		counter:    counter,
+		resolution: resolution,
+		offset:     offset,
+		candles:    make(map[int64]*Candle),
+	}, nil
+}
+
+// Add adds the trade to its candle and returns true if the trade is between
+// the assets of the aggregator. Trades of the reverse pair are inverted.
+// Trades without a price, because one of their amounts is zero, are
+// ignored.
+func (a *Aggregator) Add(trade Trade) bool {
+	if trade.Price.N == 0 || trade.Price.D == 0 {
+		return false
+	}
+	baseAmount, counterAmount, tradePrice := trade.BaseAmount, trade.CounterAmount, trade.Price
+	switch {
+	case trade.BaseAsset.Equals(a.base) && trade.CounterAsset.Equals(a.counter):
+	case trade.BaseAsset.Equals(a.counter) && trade.CounterAsset.Equals(a.base):
+		baseAmount, counterAmount = counterAmount, baseAmount
+		tradePrice.Invert()
+	default:
+		return false
+	}
+	a.candle(a.timestamp(trade.LedgerCloseTime)).add(trade, baseAmount, counterAmount, tradePrice)
+	return true
+}
+
+func (a *Aggregator) timestamp(t time.Time) time.Time {
+	sinceOffset := time.Duration(t.UnixNano()) - a.offset
+	start := sinceOffset - sinceOffset%a.resolution
+	if sinceOffset < 0 && sinceOffset%a.resolution != 0 {
+		start -= a.resolution
+	}
+	return time.Unix(0, int64(start+a.offset)).UTC()
+}
+
+func (a *Aggregator) candle(timestamp time.Time) *Candle {
+	c, ok := a.candles[timestamp.UnixNano()]
+	if !ok {
+		c = &Candle{Timestamp: timestamp, BaseVolume: new(big.Int), CounterVolume: new(big.Int)}
+		a.candles[timestamp.UnixNano()] = c
+	}
+	return c
+}
+
+// Candles returns copies of the candles with at least one trade, sorted by
+// time.
+func (a *Aggregator) Candles() []Candle {
+	candles := make([]Candle, 0, len(a.candles))
+	for _, c := range a.candles {
+		candle := *c
+		candle.BaseVolume = new(big.Int).Set(c.BaseVolume)
+		candle.CounterVolume = new(big.Int).Set(c.CounterVolume)
+		candles = append(candles, candle)
+	}
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].Timestamp.Before(candles[j].Timestamp)
+	})
+	return candles
+}
+
+// Merge adds the candles of other to a. Both aggregators must be of the same
+// asset pair, resolution and offset and must have aggregated disjoint sets
+// of trades.
+func (a *Aggregator) Merge(other *Aggregator) error {
+	if !a.base.Equals(other.base) || !a.counter.Equals(other.counter) {
+		return errors.New("cannot merge aggregators of different asset pairs")
+	}
+	if a.resolution != other.resolution || a.offset != other.offset {
+		return errors.New("cannot merge aggregators of different resolutions or offsets")
+	}
+	for _, c := range other.candles {
+		a.candle(c.Timestamp).merge(c)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Package trades extracts the trades of the Stellar DEX, i.e. the offers and
+// liquidity pools crossed by manage offer and path payment operations, from
+// ledger close meta and aggregates them into OHLCV candles.
+package trades
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/price"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/toid"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// TradeType is the type of the liquidity crossed by a trade.
+type TradeType int
+
+const (
+	// TradeTypeOrderBook is a trade against an offer of the order book.
+	TradeTypeOrderBook TradeType = iota
+	// TradeTypeLiquidityPool is a trade against a liquidity pool.
+	TradeTypeLiquidityPool
+)
+
+func (t TradeType) String() string {
+	switch t {
+	case TradeTypeOrderBook:
+		return "orderbook"
+	case TradeTypeLiquidityPool:
+		return "liquidity_pool"
+	default:
+		return fmt.Sprintf("TradeType(%d)", int(t))
+	}
+}
+
+// Trade is an exchange between the source account of an operation and an
+// offer or liquidity pool, as described by a single xdr.ClaimAtom of the
+// operation result.
+//
+// The base side of a trade is the offer or liquidity pool which was crossed,
+// it sold BaseAmount of BaseAsset. The counter side is the source account of
+// the operation, which sold CounterAmount of CounterAsset.
+type Trade struct {
+	// OperationID is the toid of the operation.
+	OperationID int64
+	// Order is the index of the claim of the trade among the offers and
+	// liquidity pools crossed by the operation. Claims which didn't trade
+	// anything are skipped without renumbering the following trades.
+	Order uint32
+
+	LedgerSequence  uint32
+	LedgerCloseTime time.Time
+	TransactionHash string
+	OperationIndex  uint32
+	OperationType   xdr.OperationType
+
+	Type TradeType
+	// BaseOfferID and BaseAccount are the ID and the owner of the crossed
+	// offer, they are only set for order book trades.
+	BaseOfferID int64
+	BaseAccount string
+	// LiquidityPoolID is the ID of the crossed pool, it is only set for
+	// liquidity pool trades.
+	LiquidityPoolID *xdr.PoolId
+	BaseAsset       xdr.Asset
+	BaseAmount      xdr.Int64
+
+	// CounterAccount is the source account of the operation. Muxed accounts
+	// are replaced by their base account.
+	CounterAccount string
+	CounterAsset   xdr.Asset
+	CounterAmount  xdr.Int64
+
+	// Price is the price of a unit of BaseAsset in units of CounterAsset, i.e.
+	// CounterAmount/BaseAmount. It is approximated if the amounts don't fit in
+	// an xdr.Price and it is zero if one of the amounts is zero.
+	Price xdr.Price
+}
+
+// PagingToken returns a unique identifier of the trade, which sorts trades
+// in the order they happened. It is the same as the paging token of the
+// trade in Horizon.
+func (t Trade) PagingToken() string {
+	return fmt.Sprintf("%d-%d", t.OperationID, t.Order)
+}
+
+// before returns true if t happened before other.
+func (t Trade) before(other Trade) bool {
+	if t.OperationID != other.OperationID {
+		return t.OperationID < other.OperationID
+	}
+	return t.Order < other.Order
+}
+
+// TradesFromLedger returns the trades of all the successful transactions of
+// the ledger, in the order they happened.
+func TradesFromLedger(lcm xdr.LedgerCloseMeta, networkPassphrase string) ([]Trade, error) {
+	txReader, err := ingest.NewLedgerTransactionReaderFromLedgerCloseMeta(networkPassphrase, lcm)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating transaction reader")
+	}
+	defer txReader.Close()
+
+	var trades []Trade
+	for {
+		tx, err := txReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading transaction")
+		}
+		txTrades, err := TradesFromTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, txTrades...)
+	}
+	return trades, nil
+}
+
+// TradesFromTransaction returns the trades of the operations of the
+// transaction, it returns no trades if the transaction failed.
+func TradesFromTransaction(tx ingest.LedgerTransaction) ([]Trade, error) {
+	if !tx.Result.Successful() {
+		return nil, nil
+	}
+	opResults, ok := tx.Result.OperationResults()
+	if !ok {
+		return nil, nil
+	}
+
+	var trades []Trade
+	for i, op := range tx.Envelope.Operations() {
+		if i >= len(opResults) {
+			return nil, errors.Errorf("missing result of operation %d of transaction %s", i, tx.Hash.HexString())
+		}
+		claims, ok := claimAtoms(op.Body.Type, opResults[i])
+		if !ok {
+			continue
+		}
+		source := op.SourceAccount
+		if source == nil {
+			envelopeSource := tx.Envelope.SourceAccount()
+			source = &envelopeSource
+		}
+		operationID := toid.New(int32(tx.Ledger.LedgerSequence()), int32(tx.Index), int32(i+1)).ToInt64()
+
+		// like in Horizon, the order of a trade is the index of its claim so
+		// skipped claims leave gaps
+		for order, claim := range claims {
+			if claim.AmountSold() == 0 && claim.AmountBought() == 0 {
+				// offers which are removed because they cannot be crossed
+				// anymore do not trade anything
+				continue
+			}
+			trade := Trade{
+				OperationID:     operationID,
+				Order:           uint32(order),
+				LedgerSequence:  tx.Ledger.LedgerSequence(),
+				LedgerCloseTime: tx.Ledger.ClosedAt(),
+				TransactionHash: tx.Hash.HexString(),
+				OperationIndex:  uint32(i),
+				OperationType:   op.Body.Type,
+				BaseAsset:       claim.AssetSold(),
+				BaseAmount:      claim.AmountSold(),
+				CounterAccount:  source.ToAccountId().Address(),
+				CounterAsset:    claim.AssetBought(),
+				CounterAmount:   claim.AmountBought(),
+			}
+			if claim.Type == xdr.ClaimAtomTypeClaimAtomTypeLiquidityPool {
+				poolID := claim.MustLiquidityPool().LiquidityPoolId
+				trade.Type = TradeTypeLiquidityPool
+				trade.LiquidityPoolID = &poolID
+			} else {
+				trade.Type = TradeTypeOrderBook
+				trade.BaseOfferID = int64(claim.OfferId())
+				sellerID := claim.SellerId()
+				trade.BaseAccount = sellerID.Address()
+			}
+			if trade.BaseAmount > 0 && trade.CounterAmount > 0 {
+				var err error
+				trade.Price, err = price.FromAmounts(int64(trade.CounterAmount), int64(trade.BaseAmount))
+				if err != nil {
+					return nil, errors.Wrapf(err, "error computing price of trade %s", trade.PagingToken())
+				}
+			}
+			trades = append(trades, trade)
+		}
+	}
+	return trades, nil
+}
+
+// claimAtoms returns the offers and liquidity pools crossed by the
+// operation, if it is an operation which can trade.
+func claimAtoms(opType xdr.OperationType, result xdr.OperationResult) ([]xdr.ClaimAtom, bool) {
+	tr, ok := result.GetTr()
+	if !ok {
+		return nil, false
+	}
+	switch opType {
+	case xdr.OperationTypeManageSellOffer:
+		success, ok := tr.MustManageSellOfferResult().GetSuccess()
+		return success.OffersClaimed, ok
+	case xdr.OperationTypeCreatePassiveSellOffer:
+		success, ok := tr.MustCreatePassiveSellOfferResult().GetSuccess()
+		return success.OffersClaimed, ok
+	case xdr.OperationTypeManageBuyOffer:
+		success, ok := tr.MustManageBuyOfferResult().GetSuccess()
+		return success.OffersClaimed, ok
+	case xdr.OperationTypePathPaymentStrictReceive:
+		success, ok := tr.MustPathPaymentStrictReceiveResult().GetSuccess()
+		return success.Offers, ok
+	case xdr.OperationTypePathPaymentStrictSend:
+		success, ok := tr.MustPathPaymentStrictSendResult().GetSuccess()
+		return success.Offers, ok
+	default:
+		return nil, false
+	}
+}
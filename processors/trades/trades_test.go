@@ -0,0 +1,289 @@
+package trades
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/toid"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	seller   = xdr.MustAddress("GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON")
+	buyer    = xdr.MustMuxedAddress("GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z")
+	usdc     = xdr.MustNewCreditAsset("USDC", "GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN")
+	xlm      = xdr.MustNewNativeAsset()
+	poolID   = xdr.PoolId{1, 2, 3}
+	closedAt = time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+)
+
+func testLedger(sequence uint32, closedAt time.Time) xdr.LedgerCloseMeta {
+	return xdr.LedgerCloseMeta{
+		V: 0,
+		V0: &xdr.LedgerCloseMetaV0{
+			LedgerHeader: xdr.LedgerHeaderHistoryEntry{
+				Header: xdr.LedgerHeader{
+					LedgerSeq: xdr.Uint32(sequence),
+					ScpValue:  xdr.StellarValue{CloseTime: xdr.TimePoint(closedAt.Unix())},
+				},
+			},
+		},
+	}
+}
+
+func orderBookClaim(offerID xdr.Int64, sold xdr.Asset, amountSold xdr.Int64, bought xdr.Asset, amountBought xdr.Int64) xdr.ClaimAtom {
+	return xdr.ClaimAtom{
+		Type: xdr.ClaimAtomTypeClaimAtomTypeOrderBook,
+		OrderBook: &xdr.ClaimOfferAtom{
+			SellerId:     seller,
+			OfferId:      offerID,
+			AssetSold:    sold,
+			AmountSold:   amountSold,
+			AssetBought:  bought,
+			AmountBought: amountBought,
+		},
+	}
+}
+
+func poolClaim(sold xdr.Asset, amountSold xdr.Int64, bought xdr.Asset, amountBought xdr.Int64) xdr.ClaimAtom {
+	return xdr.ClaimAtom{
+		Type: xdr.ClaimAtomTypeClaimAtomTypeLiquidityPool,
+		LiquidityPool: &xdr.ClaimLiquidityAtom{
+			LiquidityPoolId: poolID,
+			AssetSold:       sold,
+			AmountSold:      amountSold,
+			AssetBought:     bought,
+			AmountBought:    amountBought,
+		},
+	}
+}
+
+func testTransaction(ops []xdr.Operation, results []xdr.OperationResult) ingest.LedgerTransaction {
+	return ingest.LedgerTransaction{
+		Index: 3,
+		Envelope: xdr.TransactionEnvelope{
+			Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+			V1: &xdr.TransactionV1Envelope{
+				Tx: xdr.Transaction{SourceAccount: buyer, Operations: ops},
+			},
+		},
+		Result: xdr.TransactionResultPair{
+			Result: xdr.TransactionResult{
+				Result: xdr.TransactionResultResult{
+					Code:    xdr.TransactionResultCodeTxSuccess,
+					Results: &results,
+				},
+			},
+		},
+		Ledger: testLedger(100, closedAt),
+		Hash:   xdr.Hash{4, 5, 6},
+	}
+}
+
+func manageSellOffer(claims ...xdr.ClaimAtom) (xdr.Operation, xdr.OperationResult) {
+	op := xdr.Operation{
+		Body: xdr.OperationBody{
+			Type:              xdr.OperationTypeManageSellOffer,
+			ManageSellOfferOp: &xdr.ManageSellOfferOp{Selling: xlm, Buying: usdc},
+		},
+	}
+	result := xdr.OperationResult{
+		Code: xdr.OperationResultCodeOpInner,
+		Tr: &xdr.OperationResultTr{
+			Type: xdr.OperationTypeManageSellOffer,
+			ManageSellOfferResult: &xdr.ManageSellOfferResult{
+				Code: xdr.ManageSellOfferResultCodeManageSellOfferSuccess,
+				Success: &xdr.ManageOfferSuccessResult{
+					OffersClaimed: claims,
+					Offer:         xdr.ManageOfferSuccessResultOffer{Effect: xdr.ManageOfferEffectManageOfferDeleted},
+				},
+			},
+		},
+	}
+	return op, result
+}
+
+func pathPaymentStrictSend(claims ...xdr.ClaimAtom) (xdr.Operation, xdr.OperationResult) {
+	op := xdr.Operation{
+		Body: xdr.OperationBody{
+			Type:                    xdr.OperationTypePathPaymentStrictSend,
+			PathPaymentStrictSendOp: &xdr.PathPaymentStrictSendOp{SendAsset: xlm, DestAsset: usdc},
+		},
+	}
+	result := xdr.OperationResult{
+		Code: xdr.OperationResultCodeOpInner,
+		Tr: &xdr.OperationResultTr{
+			Type: xdr.OperationTypePathPaymentStrictSend,
+			PathPaymentStrictSendResult: &xdr.PathPaymentStrictSendResult{
+				Code:    xdr.PathPaymentStrictSendResultCodePathPaymentStrictSendSuccess,
+				Success: &xdr.PathPaymentStrictSendResultSuccess{Offers: claims},
+			},
+		},
+	}
+	return op, result
+}
+
+func TestTradesFromTransaction(t *testing.T) {
+	sellOp, sellResult := manageSellOffer(
+		orderBookClaim(42, usdc, 20, xlm, 100),
+		// removed offer which did not trade
+		orderBookClaim(43, usdc, 0, xlm, 0),
+	)
+	pathOp, pathResult := pathPaymentStrictSend(poolClaim(usdc, 30, xlm, 90))
+	paymentOp := xdr.Operation{
+		Body: xdr.OperationBody{
+			Type:      xdr.OperationTypePayment,
+			PaymentOp: &xdr.PaymentOp{Destination: buyer, Asset: xlm, Amount: 1},
+		},
+	}
+	paymentResult := xdr.OperationResult{
+		Code: xdr.OperationResultCodeOpInner,
+		Tr: &xdr.OperationResultTr{
+			Type:          xdr.OperationTypePayment,
+			PaymentResult: &xdr.PaymentResult{Code: xdr.PaymentResultCodePaymentSuccess},
+		},
+	}
+
+	trades, err := TradesFromTransaction(testTransaction(
+		[]xdr.Operation{sellOp, paymentOp, pathOp},
+		[]xdr.OperationResult{sellResult, paymentResult, pathResult},
+	))
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+
+	assert.Equal(t, Trade{
+		OperationID:     toid.New(100, 3, 1).ToInt64(),
+		Order:           0,
+		LedgerSequence:  100,
+		LedgerCloseTime: closedAt,
+		TransactionHash: xdr.Hash{4, 5, 6}.HexString(),
+		OperationIndex:  0,
+		OperationType:   xdr.OperationTypeManageSellOffer,
+		Type:            TradeTypeOrderBook,
+		BaseOfferID:     42,
+		BaseAccount:     seller.Address(),
+		BaseAsset:       usdc,
+		BaseAmount:      20,
+		CounterAccount:  buyer.Address(),
+		CounterAsset:    xlm,
+		CounterAmount:   100,
+		Price:           xdr.Price{N: 5, D: 1},
+	}, trades[0])
+	assert.Equal(t, toid.New(100, 3, 1).ToInt64(), trades[0].OperationID)
+	assert.Equal(t, "429496741889-0", trades[0].PagingToken())
+
+	assert.Equal(t, toid.New(100, 3, 3).ToInt64(), trades[1].OperationID)
+	assert.Equal(t, uint32(0), trades[1].Order)
+	assert.Equal(t, TradeTypeLiquidityPool, trades[1].Type)
+	assert.Equal(t, &poolID, trades[1].LiquidityPoolID)
+	assert.Equal(t, "", trades[1].BaseAccount)
+	assert.Equal(t, xdr.Price{N: 3, D: 1}, trades[1].Price)
+
+	failed := testTransaction([]xdr.Operation{sellOp}, []xdr.OperationResult{sellResult})
+	failed.Result.Result.Result.Code = xdr.TransactionResultCodeTxFailed
+	trades, err = TradesFromTransaction(failed)
+	require.NoError(t, err)
+	assert.Empty(t, trades)
+}
+
+func TestTradesFromTransactionOrderSkipsZeroClaims(t *testing.T) {
+	// the order of a trade is the index of its claim, as in Horizon
+	pathOp, pathResult := pathPaymentStrictSend(
+		orderBookClaim(43, usdc, 0, xlm, 0),
+		orderBookClaim(42, usdc, 20, xlm, 100),
+		poolClaim(usdc, 30, xlm, 90),
+	)
+	trades, err := TradesFromTransaction(testTransaction(
+		[]xdr.Operation{pathOp},
+		[]xdr.OperationResult{pathResult},
+	))
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	assert.Equal(t, uint32(1), trades[0].Order)
+	assert.Equal(t, int64(42), trades[0].BaseOfferID)
+	assert.Equal(t, "429496741889-1", trades[0].PagingToken())
+	assert.Equal(t, uint32(2), trades[1].Order)
+	assert.Equal(t, "429496741889-2", trades[1].PagingToken())
+}
+
+func TestAggregator(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	trade := func(opOrder int32, at time.Duration, base xdr.Asset, baseAmount xdr.Int64, counter xdr.Asset, counterAmount xdr.Int64, p xdr.Price) Trade {
+		return Trade{
+			OperationID:     toid.New(1, 1, opOrder).ToInt64(),
+			LedgerCloseTime: start.Add(at),
+			BaseAsset:       base,
+			BaseAmount:      baseAmount,
+			CounterAsset:    counter,
+			CounterAmount:   counterAmount,
+			Price:           p,
+		}
+	}
+	trades := []Trade{
+		trade(1, 5*time.Minute, usdc, 10, xlm, 50, xdr.Price{N: 5, D: 1}),
+		// trade of the reverse pair, 40 XLM sold for 10 USDC is a price of 4
+		// XLM per USDC
+		trade(2, 20*time.Minute, xlm, 40, usdc, 10, xdr.Price{N: 1, D: 4}),
+		trade(3, 50*time.Minute, usdc, 10, xlm, 60, xdr.Price{N: 6, D: 1}),
+		trade(4, 70*time.Minute, usdc, 10, xlm, 70, xdr.Price{N: 7, D: 1}),
+	}
+
+	whole, err := NewAggregator(usdc, xlm, time.Hour, 0)
+	require.NoError(t, err)
+	first, err := NewAggregator(usdc, xlm, time.Hour, 0)
+	require.NoError(t, err)
+	second, err := NewAggregator(usdc, xlm, time.Hour, 0)
+	require.NoError(t, err)
+	for i, tr := range trades {
+		assert.True(t, whole.Add(tr))
+		// trades are added out of order to the partial aggregators
+		if i%2 == 0 {
+			assert.True(t, second.Add(tr))
+		} else {
+			assert.True(t, first.Add(tr))
+		}
+	}
+	assert.False(t, whole.Add(trade(5, 0, usdc, 1, xdr.MustNewCreditAsset("EUR", seller.Address()), 1, xdr.Price{N: 1, D: 1})))
+
+	candles := whole.Candles()
+	require.Len(t, candles, 2)
+	assert.Equal(t, start, candles[0].Timestamp)
+	assert.Equal(t, int64(3), candles[0].TradeCount)
+	assert.Equal(t, big.NewInt(30), candles[0].BaseVolume)
+	assert.Equal(t, big.NewInt(150), candles[0].CounterVolume)
+	assert.Equal(t, xdr.Price{N: 5, D: 1}, candles[0].Open)
+	assert.Equal(t, xdr.Price{N: 6, D: 1}, candles[0].High)
+	assert.Equal(t, xdr.Price{N: 4, D: 1}, candles[0].Low)
+	assert.Equal(t, xdr.Price{N: 6, D: 1}, candles[0].Close)
+	assert.Equal(t, big.NewRat(5, 1), candles[0].Average())
+	assert.Equal(t, start.Add(time.Hour), candles[1].Timestamp)
+
+	// the candles returned are copies
+	candles[0].BaseVolume.Add(candles[0].BaseVolume, big.NewInt(1000))
+	candles[0].CounterVolume.SetInt64(0)
+	candles = whole.Candles()
+	assert.Equal(t, big.NewInt(30), candles[0].BaseVolume)
+	assert.Equal(t, big.NewInt(150), candles[0].CounterVolume)
+
+	require.NoError(t, first.Merge(second))
+	assert.Equal(t, candles, first.Candles())
+
+	withOffset, err := NewAggregator(usdc, xlm, time.Hour, 30*time.Minute)
+	require.NoError(t, err)
+	for _, tr := range trades {
+		withOffset.Add(tr)
+	}
+	candles = withOffset.Candles()
+	require.Len(t, candles, 2)
+	assert.Equal(t, start.Add(-30*time.Minute), candles[0].Timestamp)
+	assert.Equal(t, int64(2), candles[0].TradeCount)
+	assert.Equal(t, start.Add(30*time.Minute), candles[1].Timestamp)
+
+	_, err = NewAggregator(usdc, xlm, time.Hour, time.Hour)
+	assert.EqualError(t, err, "offset must be positive and lower than the resolution")
+}
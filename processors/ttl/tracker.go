@@ -0,0 +1,566 @@
+// Package ttl tracks the time to live of Soroban ledger entries so it is
+// possible to find out which contract data and contract code entries are about
+// to expire, and to get notified when entries are archived or restored.
+package ttl
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/support/collections/set"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// Entry is a contract data or contract code entry tracked by a Tracker.
+type Entry struct {
+	Key xdr.LedgerKey
+	// KeyHash is the sha256 hash of Key, which is the key of the TTL entry
+	// associated with the entry.
+	KeyHash            xdr.Hash
+	LiveUntilLedgerSeq uint32
+}
+
+// Persistent returns true if the entry is moved to the hot archive, rather
+// than deleted, when it is evicted.
+func (e Entry) Persistent() bool {
+	if data, ok := e.Key.GetContractData(); ok {
+		return data.Durability == xdr.ContractDataDurabilityPersistent
+	}
+	return true
+}
+
+// Expired returns true if the entry is not live anymore as of the given
+// ledger.
+func (e Entry) Expired(ledger uint32) bool {
+	return e.LiveUntilLedgerSeq < ledger
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventArchived is emitted when a persistent entry is evicted and moved
+	// to the hot archive.
+	EventArchived EventType = iota
+	// EventEvicted is emitted when a temporary entry is evicted and deleted.
+	EventEvicted
+	// EventRestored is emitted when a persistent entry is restored, either
+	// from the hot archive or after it expired but before it was evicted.
+	EventRestored
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventArchived:
+		return "archived"
+	case EventEvicted:
+		return "evicted"
+	case EventRestored:
+		return "restored"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event describes an entry which was archived, evicted or restored.
+type Event struct {
+	Type   EventType
+	Ledger uint32
+	// Entry is the entry after it was restored, or before it was archived or
+	// evicted.
+	Entry Entry
+}
+
+// Tracker maintains an index of contract data and contract code entries by
+// their LiveUntilLedgerSeq.
+//
+// The index is bootstrapped from a history archive checkpoint and kept up to
+// date by processing every subsequent ledger in order with ProcessLedger,
+// which also returns the archived, evicted and restored events of the ledger.
+//
+// Tracker is safe for concurrent use: queries can be served while ledgers are
+// being processed.
+type Tracker struct {
+	mutex        sync.RWMutex
+	sequence     uint32
+	bootstrapped bool
+
+	entries map[xdr.Hash]*Entry
+	// byLiveUntil indexes the key hashes of the entries by LiveUntilLedgerSeq
+	byLiveUntil map[uint32]set.Set[xdr.Hash]
+	// byContract indexes the key hashes of the contract data entries by
+	// contract
+	byContract map[xdr.ContractId]set.Set[xdr.Hash]
+	// codeKeyHashes maps contracts to the key hash of the contract code entry
+	// of their executable
+	codeKeyHashes map[xdr.ContractId]xdr.Hash
+
+	networkPassphrase string
+	contracts         set.Set[xdr.ContractId]
+}
+
+// TrackerOption configures a Tracker's behavior.
+type TrackerOption func(*Tracker)
+
+// WithContracts restricts the contract data entries tracked by the Tracker to
+// the ones of the given contracts. Contract code entries are always tracked.
+func WithContracts(contracts ...xdr.ContractId) TrackerOption {
+	return func(t *Tracker) {
+		t.contracts = set.NewSet[xdr.ContractId](len(contracts))
+		t.contracts.AddSlice(contracts)
+	}
+}
+
+// NewTracker returns a new, empty Tracker. Bootstrap must be called before any
+// ledger can be processed.
+func NewTracker(networkPassphrase string, opts ...TrackerOption) *Tracker {
+	t := &Tracker{
+		networkPassphrase: networkPassphrase,
+	}
+	t.reset()
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *Tracker) reset() {
+	t.entries = map[xdr.Hash]*Entry{}
+	t.byLiveUntil = map[uint32]set.Set[xdr.Hash]{}
+	t.byContract = map[xdr.ContractId]set.Set[xdr.Hash]{}
+	t.codeKeyHashes = map[xdr.ContractId]xdr.Hash{}
+}
+
+// tracksKey returns true if the entry with the given key belongs in the index.
+func (t *Tracker) tracksKey(key xdr.LedgerKey) bool {
+	switch key.Type {
+	case xdr.LedgerEntryTypeContractCode:
+		return true
+	case xdr.LedgerEntryTypeContractData:
+		if t.contracts == nil {
+			return true
+		}
+		id, ok := key.MustContractData().Contract.GetContractId()
+		return ok && t.contracts.Contains(id)
+	default:
+		return false
+	}
+}
+
+// Bootstrap replaces the index with the contract data and contract code
+// entries found in the given checkpoint of the history archive. Additional
+// options are passed through to the underlying CheckpointChangeReader.
+//
+// Note that all the TTL entries in the checkpoint are kept in memory until the
+// whole checkpoint is streamed because they can't be matched with their
+// entries until then.
+func (t *Tracker) Bootstrap(
+	ctx context.Context,
+	archive historyarchive.ArchiveInterface,
+	checkpoint uint32,
+	opts ...ingest.CheckpointReaderOption,
+) error {
+	opts = append(opts, ingest.WithFilter(
+		func(entry xdr.LedgerEntry) bool {
+			if entry.Data.Type == xdr.LedgerEntryTypeTtl {
+				return true
+			}
+			key, err := entry.LedgerKey()
+			return err == nil && t.tracksKey(key)
+		},
+		func(key xdr.LedgerKey) bool {
+			return key.Type == xdr.LedgerEntryTypeTtl || t.tracksKey(key)
+		},
+	))
+	reader, err := ingest.NewCheckpointChangeReader(ctx, archive, checkpoint, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "error creating checkpoint reader for ledger %d", checkpoint)
+	}
+	defer reader.Close()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.reset()
+	t.bootstrapped = false
+
+	ttls := map[xdr.Hash]uint32{}
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error reading checkpoint %d", checkpoint)
+		}
+		if ttl, ok := change.Post.Data.GetTtl(); ok {
+			ttls[ttl.KeyHash] = uint32(ttl.LiveUntilLedgerSeq)
+			continue
+		}
+		if _, err = t.upsert(*change.Post); err != nil {
+			return err
+		}
+	}
+	for keyHash := range t.entries {
+		t.setLiveUntil(keyHash, ttls[keyHash])
+	}
+
+	t.sequence = checkpoint
+	t.bootstrapped = true
+	return nil
+}
+
+// ProcessLedger updates the index with the changes in the given ledger and
+// returns the entries which were archived, evicted or restored in the ledger.
+// Ledgers must be processed in order, starting with the ledger right after the
+// checkpoint the Tracker was bootstrapped from. The index is not modified if
+// an error is returned.
+func (t *Tracker) ProcessLedger(ledger xdr.LedgerCloseMeta) ([]Event, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.bootstrapped {
+		return nil, errors.New("ttl tracker has not been bootstrapped")
+	}
+	sequence := ledger.LedgerSequence()
+	if sequence != t.sequence+1 {
+		return nil, errors.Errorf("expected ledger %d but got %d", t.sequence+1, sequence)
+	}
+
+	reader, err := ingest.NewLedgerChangeReaderFromLedgerCloseMeta(t.networkPassphrase, ledger)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating ledger change reader")
+	}
+	defer reader.Close()
+
+	var (
+		events   []Event
+		restored []xdr.Hash
+		// updates are applied once all the changes of the ledger were read
+		// so the index is left untouched if an error is returned
+		updates []func()
+	)
+	// TTL entries are applied once all the changes in the ledger are read
+	// because a TTL entry may be created before its contract data or contract
+	// code entry
+	ttls := map[xdr.Hash]uint32{}
+	for {
+		change, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading ledger changes")
+		}
+
+		switch change.Type {
+		case xdr.LedgerEntryTypeTtl:
+			if change.Post != nil {
+				ttl := change.Post.Data.MustTtl()
+				ttls[ttl.KeyHash] = uint32(ttl.LiveUntilLedgerSeq)
+			}
+		case xdr.LedgerEntryTypeContractData, xdr.LedgerEntryTypeContractCode:
+			if change.Post == nil {
+				key, err := change.Pre.LedgerKey()
+				if err != nil {
+					return nil, errors.Wrap(err, "error getting ledger key")
+				}
+				keyHash, err := hashKey(key)
+				if err != nil {
+					return nil, err
+				}
+				updates = append(updates, func() { t.remove(keyHash) })
+				continue
+			}
+			upsert, err := t.prepareUpsert(*change.Post)
+			if err != nil {
+				return nil, err
+			}
+			isRestore := change.ChangeType == xdr.LedgerEntryChangeTypeLedgerEntryRestored
+			updates = append(updates, func() {
+				if tracked := upsert(); tracked != nil && isRestore {
+					restored = append(restored, tracked.KeyHash)
+				}
+			})
+		}
+	}
+
+	evicted, err := ledger.EvictedLedgerKeys()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting evicted ledger keys")
+	}
+	var evictedKeyHashes []xdr.Hash
+	for _, key := range evicted {
+		if !t.tracksKey(key) {
+			continue
+		}
+		keyHash, err := hashKey(key)
+		if err != nil {
+			return nil, err
+		}
+		evictedKeyHashes = append(evictedKeyHashes, keyHash)
+	}
+
+	for _, update := range updates {
+		update()
+	}
+	for keyHash, liveUntil := range ttls {
+		t.setLiveUntil(keyHash, liveUntil)
+	}
+	for _, keyHash := range restored {
+		if entry, ok := t.entries[keyHash]; ok {
+			events = append(events, Event{Type: EventRestored, Ledger: sequence, Entry: *entry})
+		}
+	}
+	for _, keyHash := range evictedKeyHashes {
+		entry, ok := t.entries[keyHash]
+		if !ok {
+			continue
+		}
+		event := Event{Type: EventEvicted, Ledger: sequence, Entry: *entry}
+		if entry.Persistent() {
+			event.Type = EventArchived
+		}
+		events = append(events, event)
+		t.remove(keyHash)
+	}
+
+	t.sequence = sequence
+	return events, nil
+}
+
+// upsert adds the given contract data or contract code entry to the index, or
+// updates it, and returns it. nil is returned if the entry is not tracked.
+func (t *Tracker) upsert(ledgerEntry xdr.LedgerEntry) (*Entry, error) {
+	upsert, err := t.prepareUpsert(ledgerEntry)
+	if err != nil {
+		return nil, err
+	}
+	return upsert(), nil
+}
+
+// prepareUpsert returns a function which upserts the given contract data or
+// contract code entry without failing. All the errors are returned by
+// prepareUpsert itself, before the index is modified.
+func (t *Tracker) prepareUpsert(ledgerEntry xdr.LedgerEntry) (func() *Entry, error) {
+	key, err := ledgerEntry.LedgerKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting ledger key")
+	}
+	if !t.tracksKey(key) {
+		return func() *Entry { return nil }, nil
+	}
+
+	updateCodeKeyHash, err := t.prepareCodeKeyHash(ledgerEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	keyHash, err := hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return func() *Entry {
+		updateCodeKeyHash()
+		if entry, ok := t.entries[keyHash]; ok {
+			return entry
+		}
+
+		entry := &Entry{Key: key, KeyHash: keyHash}
+		t.entries[keyHash] = entry
+		t.index(entry)
+		if id, ok := contractID(key); ok {
+			if t.byContract[id] == nil {
+				t.byContract[id] = set.NewSet[xdr.Hash](1)
+			}
+			t.byContract[id].Add(keyHash)
+		}
+		return entry
+	}, nil
+}
+
+// prepareCodeKeyHash returns a function which keeps track of the contract
+// code entry referenced by the given contract instance.
+func (t *Tracker) prepareCodeKeyHash(ledgerEntry xdr.LedgerEntry) (func(), error) {
+	noop := func() {}
+	data, ok := ledgerEntry.Data.GetContractData()
+	if !ok {
+		return noop, nil
+	}
+	id, ok := data.Contract.GetContractId()
+	if !ok {
+		return noop, nil
+	}
+	instance, ok := data.Val.GetInstance()
+	if !ok {
+		return noop, nil
+	}
+	wasmHash, ok := instance.Executable.GetWasmHash()
+	if !ok {
+		return func() { delete(t.codeKeyHashes, id) }, nil
+	}
+	var key xdr.LedgerKey
+	if err := key.SetContractCode(wasmHash); err != nil {
+		return nil, errors.Wrap(err, "error creating contract code key")
+	}
+	keyHash, err := hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return func() { t.codeKeyHashes[id] = keyHash }, nil
+}
+
+func (t *Tracker) remove(keyHash xdr.Hash) {
+	entry, ok := t.entries[keyHash]
+	if !ok {
+		return
+	}
+	t.unindex(entry)
+	delete(t.entries, keyHash)
+	if id, ok := contractID(entry.Key); ok {
+		t.byContract[id].Remove(keyHash)
+		if len(t.byContract[id]) == 0 {
+			delete(t.byContract, id)
+		}
+		if entry.Key.MustContractData().Key.Type == xdr.ScValTypeScvLedgerKeyContractInstance {
+			delete(t.codeKeyHashes, id)
+		}
+	}
+}
+
+func (t *Tracker) setLiveUntil(keyHash xdr.Hash, liveUntil uint32) {
+	entry, ok := t.entries[keyHash]
+	if !ok {
+		return
+	}
+	t.unindex(entry)
+	entry.LiveUntilLedgerSeq = liveUntil
+	t.index(entry)
+}
+
+func (t *Tracker) index(entry *Entry) {
+	if t.byLiveUntil[entry.LiveUntilLedgerSeq] == nil {
+		t.byLiveUntil[entry.LiveUntilLedgerSeq] = set.NewSet[xdr.Hash](1)
+	}
+	t.byLiveUntil[entry.LiveUntilLedgerSeq].Add(entry.KeyHash)
+}
+
+func (t *Tracker) unindex(entry *Entry) {
+	keyHashes := t.byLiveUntil[entry.LiveUntilLedgerSeq]
+	keyHashes.Remove(entry.KeyHash)
+	if len(keyHashes) == 0 {
+		delete(t.byLiveUntil, entry.LiveUntilLedgerSeq)
+	}
+}
+
+// Sequence returns the sequence of the last ledger processed by the Tracker.
+func (t *Tracker) Sequence() uint32 {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.sequence
+}
+
+// Len returns the number of entries in the index.
+func (t *Tracker) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.entries)
+}
+
+// Get returns the tracked entry with the given key.
+func (t *Tracker) Get(key xdr.LedgerKey) (Entry, bool, error) {
+	keyHash, err := hashKey(key)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	entry, ok := t.entries[keyHash]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	return *entry, true, nil
+}
+
+// Expiring returns all the entries which won't be live anymore in the next n
+// ledgers, that is, the entries whose LiveUntilLedgerSeq is lower than
+// Sequence()+n. Entries which already expired but were not evicted yet are
+// included. The entries are sorted by LiveUntilLedgerSeq.
+func (t *Tracker) Expiring(n uint32) []Entry {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	cutoff := t.cutoff(n)
+	var result []Entry
+	for liveUntil, keyHashes := range t.byLiveUntil {
+		if liveUntil >= cutoff {
+			continue
+		}
+		for keyHash := range keyHashes {
+			result = append(result, *t.entries[keyHash])
+		}
+	}
+	sortEntries(result)
+	return result
+}
+
+// ExpiringForContract is like Expiring but only returns the contract data
+// entries of the given contract and the contract code entry of its wasm.
+func (t *Tracker) ExpiringForContract(contract xdr.ContractId, n uint32) []Entry {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	cutoff := t.cutoff(n)
+	var result []Entry
+	for keyHash := range t.byContract[contract] {
+		if entry := t.entries[keyHash]; entry.LiveUntilLedgerSeq < cutoff {
+			result = append(result, *entry)
+		}
+	}
+	if keyHash, ok := t.codeKeyHashes[contract]; ok {
+		if entry, ok := t.entries[keyHash]; ok && entry.LiveUntilLedgerSeq < cutoff {
+			result = append(result, *entry)
+		}
+	}
+	sortEntries(result)
+	return result
+}
+
+// cutoff returns Sequence()+n, saturated at math.MaxUint32 so a large n
+// returns all the entries instead of wrapping around.
+func (t *Tracker) cutoff(n uint32) uint32 {
+	return uint32(min(uint64(t.sequence)+uint64(n), math.MaxUint32))
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].LiveUntilLedgerSeq != entries[j].LiveUntilLedgerSeq {
+			return entries[i].LiveUntilLedgerSeq < entries[j].LiveUntilLedgerSeq
+		}
+		return string(entries[i].KeyHash[:]) < string(entries[j].KeyHash[:])
+	})
+}
+
+// contractID returns the contract of a contract data key.
+func contractID(key xdr.LedgerKey) (xdr.ContractId, bool) {
+	data, ok := key.GetContractData()
+	if !ok {
+		return xdr.ContractId{}, false
+	}
+	return data.Contract.GetContractId()
+}
+
+func hashKey(key xdr.LedgerKey) (xdr.Hash, error) {
+	b, err := key.MarshalBinary()
+	if err != nil {
+		return xdr.Hash{}, errors.Wrap(err, "error marshaling ledger key")
+	}
+	return sha256.Sum256(b), nil
+}
@@ -0,0 +1,220 @@
+package ttl
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/internal/ingesttest"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	contractA = xdr.ContractId{1}
+	contractB = xdr.ContractId{2}
+	wasmHash  = xdr.Hash{0xaa}
+
+	instanceA = contractDataEntry(contractA, xdr.ScVal{Type: xdr.ScValTypeScvLedgerKeyContractInstance}, xdr.ScVal{
+		Type: xdr.ScValTypeScvContractInstance,
+		Instance: &xdr.ScContractInstance{
+			Executable: xdr.ContractExecutable{
+				Type:     xdr.ContractExecutableTypeContractExecutableWasm,
+				WasmHash: &wasmHash,
+			},
+		},
+	}, xdr.ContractDataDurabilityPersistent)
+	balanceA = contractDataEntry(contractA, symbol("balance"), symbol("100"), xdr.ContractDataDurabilityPersistent)
+	nonceA   = contractDataEntry(contractA, symbol("nonce"), symbol("7"), xdr.ContractDataDurabilityTemporary)
+	newA     = contractDataEntry(contractA, symbol("new"), symbol("1"), xdr.ContractDataDurabilityTemporary)
+	dataB    = contractDataEntry(contractB, symbol("x"), symbol("y"), xdr.ContractDataDurabilityPersistent)
+	code     = xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type:         xdr.LedgerEntryTypeContractCode,
+			ContractCode: &xdr.ContractCodeEntry{Hash: wasmHash, Code: []byte{0, 1, 2}},
+		},
+	}
+	account = xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeAccount,
+			Account: &xdr.AccountEntry{
+				AccountId: xdr.MustAddress("GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT"),
+			},
+		},
+	}
+)
+
+func symbol(s string) xdr.ScVal {
+	sym := xdr.ScSymbol(s)
+	return xdr.ScVal{Type: xdr.ScValTypeScvSymbol, Sym: &sym}
+}
+
+func contractDataEntry(id xdr.ContractId, key, val xdr.ScVal, durability xdr.ContractDataDurability) xdr.LedgerEntry {
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeContractData,
+			ContractData: &xdr.ContractDataEntry{
+				Contract:   xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &id},
+				Key:        key,
+				Durability: durability,
+				Val:        val,
+			},
+		},
+	}
+}
+
+func ledgerKey(entry xdr.LedgerEntry) xdr.LedgerKey {
+	key, err := entry.LedgerKey()
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func ttlEntry(entry xdr.LedgerEntry, liveUntil uint32) xdr.LedgerEntry {
+	keyHash, err := hashKey(ledgerKey(entry))
+	if err != nil {
+		panic(err)
+	}
+	return xdr.LedgerEntry{
+		Data: xdr.LedgerEntryData{
+			Type: xdr.LedgerEntryTypeTtl,
+			Ttl:  &xdr.TtlEntry{KeyHash: keyHash, LiveUntilLedgerSeq: xdr.Uint32(liveUntil)},
+		},
+	}
+}
+
+func bootstrappedTracker(t *testing.T, opts ...TrackerOption) *Tracker {
+	archive := ingesttest.MockCheckpoint(t, 63,
+		instanceA, ttlEntry(instanceA, 1000),
+		balanceA, ttlEntry(balanceA, 100),
+		nonceA, ttlEntry(nonceA, 70),
+		dataB, ttlEntry(dataB, 80),
+		code, ttlEntry(code, 90),
+		account,
+	)
+	tracker := NewTracker(network.TestNetworkPassphrase, opts...)
+	require.NoError(t, tracker.Bootstrap(context.Background(), archive, 63, ingest.DisableBucketListValidation))
+	archive.AssertExpectations(t)
+	return tracker
+}
+
+func liveUntil(entries []Entry) []uint32 {
+	var result []uint32
+	for _, entry := range entries {
+		result = append(result, entry.LiveUntilLedgerSeq)
+	}
+	return result
+}
+
+func TestTrackerBootstrap(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	assert.Equal(t, 5, tracker.Len())
+
+	entry, ok, err := tracker.Get(ledgerKey(balanceA))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, uint32(100), entry.LiveUntilLedgerSeq)
+	assert.True(t, entry.Persistent())
+	assert.False(t, entry.Expired(100))
+	assert.True(t, entry.Expired(101))
+
+	_, ok, err = tracker.Get(ledgerKey(account))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, []uint32{70, 80}, liveUntil(tracker.Expiring(20)))
+	assert.Equal(t, []uint32{70, 90, 100}, liveUntil(tracker.ExpiringForContract(contractA, 40)))
+	assert.Empty(t, tracker.ExpiringForContract(contractB, 10))
+
+	// the cutoff doesn't wrap around
+	assert.Len(t, tracker.Expiring(math.MaxUint32), tracker.Len())
+	assert.Len(t, tracker.ExpiringForContract(contractA, math.MaxUint32), 4)
+}
+
+func TestTrackerWithContracts(t *testing.T) {
+	tracker := bootstrappedTracker(t, WithContracts(contractA))
+	assert.Equal(t, 4, tracker.Len())
+	assert.Equal(t, []uint32{70}, liveUntil(tracker.Expiring(20)))
+}
+
+func TestTrackerProcessLedger(t *testing.T) {
+	tracker := bootstrappedTracker(t)
+
+	oldTTL := ttlEntry(balanceA, 100)
+	newTTL := ttlEntry(balanceA, 200)
+	events, err := tracker.ProcessLedger(ingesttest.LedgerWithUpgradeChanges(64,
+		[]xdr.LedgerKey{ledgerKey(nonceA), ledgerKey(ttlEntry(nonceA, 70)), ledgerKey(dataB), ledgerKey(ttlEntry(dataB, 80))},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &oldTTL},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated, Updated: &newTTL},
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventEvicted, events[0].Type)
+	assert.Equal(t, ledgerKey(nonceA), events[0].Entry.Key)
+	assert.Equal(t, uint32(64), events[0].Ledger)
+	assert.Equal(t, EventArchived, events[1].Type)
+	assert.Equal(t, ledgerKey(dataB), events[1].Entry.Key)
+	assert.Equal(t, uint32(80), events[1].Entry.LiveUntilLedgerSeq)
+	assert.Equal(t, 3, tracker.Len())
+	assert.Equal(t, []uint32{90, 200}, liveUntil(tracker.ExpiringForContract(contractA, 200)))
+
+	restoredTTL := ttlEntry(dataB, 500)
+	newTTLA := ttlEntry(newA, 66)
+	events, err = tracker.ProcessLedger(ingesttest.LedgerWithUpgradeChanges(65, nil,
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryRestored, Restored: &dataB},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryRestored, Restored: &restoredTTL},
+		// the TTL entry is created before its contract data entry
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &newTTLA},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated, Created: &newA},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &balanceA},
+		xdr.LedgerEntryChange{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: ptr(ledgerKey(balanceA))},
+	))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventRestored, events[0].Type)
+	assert.Equal(t, "restored", events[0].Type.String())
+	assert.Equal(t, uint32(500), events[0].Entry.LiveUntilLedgerSeq)
+	assert.Equal(t, 4, tracker.Len())
+	assert.Equal(t, []uint32{66, 90}, liveUntil(tracker.ExpiringForContract(contractA, 100)))
+	assert.Equal(t, []uint32{66, 90, 500}, liveUntil(tracker.Expiring(500)))
+}
+
+func TestTrackerProcessLedgerErrors(t *testing.T) {
+	tracker := NewTracker(network.TestNetworkPassphrase)
+	_, err := tracker.ProcessLedger(ingesttest.LedgerWithUpgradeChanges(64, nil))
+	assert.EqualError(t, err, "ttl tracker has not been bootstrapped")
+
+	tracker = bootstrappedTracker(t)
+	_, err = tracker.ProcessLedger(ingesttest.LedgerWithUpgradeChanges(65, nil))
+	assert.EqualError(t, err, "expected ledger 64 but got 65")
+
+	// a rejected ledger leaves the index untouched: the fee changes of the
+	// transaction are read before its unsupported meta
+	removed := ledgerKey(balanceA)
+	ledger, _ := ingesttest.LedgerWithTransaction(t, 64, time.Time{}, ingesttest.Transaction{
+		Tx: xdr.Transaction{SourceAccount: xdr.MustMuxedAddress("GCCD6AJOYZCUAQLX32ZJF2MKFFAUJ53PVCFQI3RHWKL3V47QYE2BNAUT")},
+	})
+	txMeta := &ledger.V0.TxProcessing[0]
+	txMeta.FeeProcessing = xdr.LedgerEntryChanges{
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &balanceA},
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &removed},
+	}
+	txMeta.TxApplyProcessing = xdr.TransactionMeta{V: 0, Operations: &[]xdr.OperationMeta{}}
+	_, err = tracker.ProcessLedger(ledger)
+	assert.ErrorContains(t, err, "TransactionMeta.V=0 not supported")
+	assert.Equal(t, uint32(63), tracker.Sequence())
+	_, ok, err := tracker.Get(removed)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -24,7 +24,9 @@ const (
 	EventTypeIncrAllow
 	EventTypeDecrAllow
 	EventTypeSetAuthorized
+	// Only implemented by NewSep41Event
 	EventTypeSetAdmin
+	EventTypeApprove
 )
 
 var (
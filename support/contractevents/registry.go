@@ -0,0 +1,263 @@
+package contractevents
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var ErrUnknownEvent = errors.New("no decoder is registered for the event")
+
+// DecodedEvent is a contract event decoded by a Registry.
+type DecodedEvent interface {
+	// GetName returns the name of the event, i.e. its first topic.
+	GetName() string
+	GetContractID() xdr.ContractId
+}
+
+// Decoder decodes contract events.
+type Decoder func(event *Event) (DecodedEvent, error)
+
+// DataFormat is the layout of the data of an event described by an
+// EventSchema. The formats match the ones of the #[contractevent] macro of
+// the Soroban SDK.
+type DataFormat int
+
+const (
+	// DataFormatMap is a map of the data fields keyed by their names.
+	DataFormatMap DataFormat = iota
+	// DataFormatVec is a vector of the data fields in order.
+	DataFormatVec
+	// DataFormatSingleValue is the value of the only data field.
+	DataFormatSingleValue
+)
+
+// Field is a named topic or data field of an EventSchema.
+type Field struct {
+	Name string
+	Type xdr.ScValType
+}
+
+// EventSchema describes a user-defined contract event. The first topic of
+// the event is the symbol Name, followed by Topics. The data of the event
+// contains the Data fields in the given format.
+type EventSchema struct {
+	Name       string
+	Topics     []Field
+	Data       []Field
+	DataFormat DataFormat
+}
+
+func (s EventSchema) validate() error {
+	if s.Name == "" {
+		return errors.New("schema name is required")
+	}
+	if len(s.Name) > 32 {
+		return errors.Errorf("schema name %q is longer than 32 characters", s.Name)
+	}
+	names := map[string]bool{}
+	for _, field := range append(append([]Field{}, s.Topics...), s.Data...) {
+		if field.Name == "" {
+			return errors.Errorf("field of schema %q has no name", s.Name)
+		}
+		if names[field.Name] {
+			return errors.Errorf("duplicate field %q in schema %q", field.Name, s.Name)
+		}
+		names[field.Name] = true
+	}
+	switch s.DataFormat {
+	case DataFormatMap, DataFormatVec:
+	case DataFormatSingleValue:
+		if len(s.Data) != 1 {
+			return errors.Errorf("schema %q has single value data format but %d data fields", s.Name, len(s.Data))
+		}
+	default:
+		return errors.Errorf("unknown data format %d in schema %q", s.DataFormat, s.Name)
+	}
+	return nil
+}
+
+// SchemaEvent is an event decoded according to an EventSchema.
+type SchemaEvent struct {
+	Name       string
+	ContractID xdr.ContractId
+	// Fields are the topic and data fields of the event keyed by their
+	// names.
+	Fields map[string]xdr.ScVal
+}
+
+func (e *SchemaEvent) GetName() string {
+	return e.Name
+}
+
+func (e *SchemaEvent) GetContractID() xdr.ContractId {
+	return e.ContractID
+}
+
+// decoder returns the Decoder of events following the schema.
+func (s EventSchema) decoder() Decoder {
+	return func(event *Event) (DecodedEvent, error) {
+		topics := event.Body.V0.Topics
+		if len(topics) != len(s.Topics)+1 {
+			return nil, errors.Errorf("event %q has %d topics, expected %d", s.Name, len(topics), len(s.Topics)+1)
+		}
+		decoded := &SchemaEvent{
+			Name:       s.Name,
+			ContractID: *event.ContractId,
+			Fields:     make(map[string]xdr.ScVal, len(s.Topics)+len(s.Data)),
+		}
+		for i, field := range s.Topics {
+			if err := decoded.setField(field, topics[i+1]); err != nil {
+				return nil, err
+			}
+		}
+
+		data := event.Body.V0.Data
+		switch s.DataFormat {
+		case DataFormatSingleValue:
+			return decoded, decoded.setField(s.Data[0], data)
+
+		case DataFormatVec:
+			vec, ok := data.GetVec()
+			if !ok || vec == nil || len(*vec) != len(s.Data) {
+				return nil, errors.Errorf("data of event %q is not a vector of %d values", s.Name, len(s.Data))
+			}
+			for i, field := range s.Data {
+				if err := decoded.setField(field, (*vec)[i]); err != nil {
+					return nil, err
+				}
+			}
+
+		case DataFormatMap:
+			mapData, ok := data.GetMap()
+			if !ok || mapData == nil || len(*mapData) != len(s.Data) {
+				return nil, errors.Errorf("data of event %q is not a map of %d values", s.Name, len(s.Data))
+			}
+			values := make(map[string]xdr.ScVal, len(*mapData))
+			for _, entry := range *mapData {
+				key, ok := entry.Key.GetSym()
+				if !ok {
+					return nil, fmt.Errorf("invalid key type in data map: %s", entry.Key.Type)
+				}
+				values[string(key)] = entry.Val
+			}
+			for _, field := range s.Data {
+				value, ok := values[field.Name]
+				if !ok {
+					return nil, errors.Errorf("field %q not found in data of event %q", field.Name, s.Name)
+				}
+				if err := decoded.setField(field, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return decoded, nil
+	}
+}
+
+func (e *SchemaEvent) setField(field Field, value xdr.ScVal) error {
+	if value.Type != field.Type {
+		return errors.Errorf("field %q of event %q has type %s, expected %s", field.Name, e.Name, value.Type, field.Type)
+	}
+	e.Fields[field.Name] = value
+	return nil
+}
+
+type registryKey struct {
+	// contractID is nil for decoders of the events of any contract.
+	contractID *xdr.ContractId
+	name       xdr.ScSymbol
+}
+
+func (k registryKey) mapKey() string {
+	if k.contractID == nil {
+		return "*/" + string(k.name)
+	}
+	return fmt.Sprintf("%x/%s", k.contractID[:], k.name)
+}
+
+// Registry decodes contract events using the decoder registered for their
+// name, the symbol of their first topic. Decoders registered for a specific
+// contract take precedence over decoders registered for any contract.
+//
+// Registry is safe for concurrent use.
+type Registry struct {
+	mutex    sync.RWMutex
+	decoders map[string]Decoder
+}
+
+// NewRegistry returns a Registry decoding the SEP-41 token events of any
+// contract with NewSep41Event.
+func NewRegistry() *Registry {
+	r := &Registry{decoders: make(map[string]Decoder)}
+	for name := range SEP41_TOPICS {
+		r.Register(string(name), func(event *Event) (DecodedEvent, error) {
+			return NewSep41Event(event)
+		})
+	}
+	return r
+}
+
+// Register registers decoder for the events named name emitted by any
+// contract, replacing any decoder previously registered for them.
+func (r *Registry) Register(name string, decoder Decoder) {
+	r.register(registryKey{name: xdr.ScSymbol(name)}, decoder)
+}
+
+// RegisterForContract registers decoder for the events named name emitted by
+// the contract, replacing any decoder previously registered for them.
+func (r *Registry) RegisterForContract(contractID xdr.ContractId, name string, decoder Decoder) {
+	r.register(registryKey{contractID: &contractID, name: xdr.ScSymbol(name)}, decoder)
+}
+
+// RegisterSchema registers a decoder of the events of schema emitted by any
+// contract. The events are decoded to *SchemaEvent.
+func (r *Registry) RegisterSchema(schema EventSchema) error {
+	if err := schema.validate(); err != nil {
+		return err
+	}
+	r.Register(schema.Name, schema.decoder())
+	return nil
+}
+
+// RegisterSchemaForContract registers a decoder of the events of schema
+// emitted by the contract. The events are decoded to *SchemaEvent.
+func (r *Registry) RegisterSchemaForContract(contractID xdr.ContractId, schema EventSchema) error {
+	if err := schema.validate(); err != nil {
+		return err
+	}
+	r.RegisterForContract(contractID, schema.Name, schema.decoder())
+	return nil
+}
+
+func (r *Registry) register(key registryKey, decoder Decoder) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.decoders[key.mapKey()] = decoder
+}
+
+// Decode decodes event with the decoder registered for it. It returns an
+// error wrapping ErrUnknownEvent if there is no such decoder.
+func (r *Registry) Decode(event *Event) (DecodedEvent, error) {
+	if event.Type != xdr.ContractEventTypeContract || event.ContractId == nil || event.Body.V != 0 ||
+		len(event.Body.V0.Topics) == 0 {
+		return nil, ErrUnknownEvent
+	}
+	name, ok := event.Body.V0.Topics[0].GetSym()
+	if !ok {
+		return nil, ErrUnknownEvent
+	}
+
+	r.mutex.RLock()
+	decoder, ok := r.decoders[registryKey{contractID: event.ContractId, name: name}.mapKey()]
+	if !ok {
+		decoder, ok = r.decoders[registryKey{name: name}.mapKey()]
+	}
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownEvent, "event name '%s'", name)
+	}
+	return decoder(event)
+}
@@ -0,0 +1,345 @@
+package contractevents
+
+import (
+	"fmt"
+
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var (
+	SEP41_TOPICS = map[xdr.ScSymbol]EventType{
+		xdr.ScSymbol("transfer"):  EventTypeTransfer,
+		xdr.ScSymbol("mint"):      EventTypeMint,
+		xdr.ScSymbol("clawback"):  EventTypeClawback,
+		xdr.ScSymbol("burn"):      EventTypeBurn,
+		xdr.ScSymbol("approve"):   EventTypeApprove,
+		xdr.ScSymbol("set_admin"): EventTypeSetAdmin,
+	}
+
+	ErrNotSep41Event = errors.New("event is not a valid SEP-41 token event")
+)
+
+// Sep41Event is an event of the SEP-41 token interface emitted by any
+// contract, see https://stellar.org/protocol/sep-41. Unlike
+// StellarAssetContractEvent, the contract isn't required to be a Stellar
+// Asset Contract: the asset topic is optional and, if present, is not
+// validated against the contract ID.
+type Sep41Event interface {
+	DecodedEvent
+	GetType() EventType
+	// GetAsset returns the SEP-11 asset topic of the event, which is only
+	// emitted by Stellar Asset Contracts, or an empty string.
+	GetAsset() string
+}
+
+type sep41Event struct {
+	Name       string
+	Type       EventType
+	ContractID xdr.ContractId
+	Asset      string
+}
+
+func (e sep41Event) GetName() string {
+	return e.Name
+}
+
+func (e sep41Event) GetContractID() xdr.ContractId {
+	return e.ContractID
+}
+
+func (e sep41Event) GetType() EventType {
+	return e.Type
+}
+
+func (e sep41Event) GetAsset() string {
+	return e.Asset
+}
+
+// MuxedID is the to_muxed_id of a transfer or mint event (CAP-67), exactly
+// one of its fields is set.
+type MuxedID struct {
+	ID   *uint64
+	Text *string
+	Hash *xdr.Hash
+}
+
+// Sep41TransferEvent is a SEP-41 "transfer" event.
+type Sep41TransferEvent struct {
+	sep41Event
+
+	From   string
+	To     string
+	Amount xdr.Int128Parts
+	// ToMuxedID is set if the event was emitted for a payment to a muxed
+	// account or with a memo.
+	ToMuxedID *MuxedID
+}
+
+// Sep41MintEvent is a SEP-41 "mint" event.
+type Sep41MintEvent struct {
+	sep41Event
+
+	// Admin is only emitted by contracts which predate CAP-67, it is empty
+	// otherwise.
+	Admin     string
+	To        string
+	Amount    xdr.Int128Parts
+	ToMuxedID *MuxedID
+}
+
+// Sep41BurnEvent is a SEP-41 "burn" event.
+type Sep41BurnEvent struct {
+	sep41Event
+
+	From   string
+	Amount xdr.Int128Parts
+}
+
+// Sep41ClawbackEvent is a SEP-41 "clawback" event.
+type Sep41ClawbackEvent struct {
+	sep41Event
+
+	// Admin is only emitted by contracts which predate CAP-67, it is empty
+	// otherwise.
+	Admin  string
+	From   string
+	Amount xdr.Int128Parts
+}
+
+// Sep41ApproveEvent is a SEP-41 "approve" event.
+type Sep41ApproveEvent struct {
+	sep41Event
+
+	From             string
+	Spender          string
+	Amount           xdr.Int128Parts
+	ExpirationLedger uint32
+}
+
+// Sep41SetAdminEvent is a "set_admin" event of an admin-controlled token,
+// e.g. a Stellar Asset Contract.
+type Sep41SetAdminEvent struct {
+	sep41Event
+
+	Admin    string
+	NewAdmin string
+}
+
+// NewSep41Event decodes a SEP-41 token event emitted by any contract. The
+// topics of the event are its name, followed by addresses and an optional
+// SEP-11 asset string. Both the event formats before and after CAP-67 are
+// supported, e.g. "mint" events with or without the admin address.
+//
+// It returns an error wrapping ErrNotSep41Event if the event doesn't follow
+// the SEP-41 interface.
+func NewSep41Event(event *Event) (Sep41Event, error) {
+	if event.Type != xdr.ContractEventTypeContract || event.ContractId == nil || event.Body.V != 0 {
+		return nil, ErrNotSep41Event
+	}
+	topics := event.Body.V0.Topics
+	value := event.Body.V0.Data
+
+	if len(topics) == 0 {
+		return nil, ErrNotSep41Event
+	}
+	fn, ok := topics[0].GetSym()
+	if !ok {
+		return nil, ErrNotSep41Event
+	}
+	eventType, ok := SEP41_TOPICS[fn]
+	if !ok {
+		return nil, errors.Wrapf(ErrNotSep41Event, "unknown event name '%s'", fn)
+	}
+
+	addresses, asset, err := parseSep41Topics(topics[1:])
+	if err != nil {
+		return nil, errors.Wrap(ErrNotSep41Event, err.Error())
+	}
+	base := sep41Event{Name: string(fn), Type: eventType, ContractID: *event.ContractId, Asset: asset}
+
+	var decoded Sep41Event
+	switch eventType {
+	case EventTypeTransfer:
+		if len(addresses) != 2 {
+			return nil, invalidAddressCount(fn, len(addresses))
+		}
+		e := &Sep41TransferEvent{sep41Event: base, From: addresses[0], To: addresses[1]}
+		e.Amount, e.ToMuxedID, err = parseSep41Amount(value)
+		decoded = e
+
+	case EventTypeMint:
+		e := &Sep41MintEvent{sep41Event: base}
+		switch len(addresses) {
+		case 1:
+			e.To = addresses[0]
+		case 2:
+			e.Admin, e.To = addresses[0], addresses[1]
+		default:
+			return nil, invalidAddressCount(fn, len(addresses))
+		}
+		e.Amount, e.ToMuxedID, err = parseSep41Amount(value)
+		decoded = e
+
+	case EventTypeBurn:
+		if len(addresses) != 1 {
+			return nil, invalidAddressCount(fn, len(addresses))
+		}
+		e := &Sep41BurnEvent{sep41Event: base, From: addresses[0]}
+		e.Amount, err = parseI128(value)
+		decoded = e
+
+	case EventTypeClawback:
+		e := &Sep41ClawbackEvent{sep41Event: base}
+		switch len(addresses) {
+		case 1:
+			e.From = addresses[0]
+		case 2:
+			e.Admin, e.From = addresses[0], addresses[1]
+		default:
+			return nil, invalidAddressCount(fn, len(addresses))
+		}
+		e.Amount, err = parseI128(value)
+		decoded = e
+
+	case EventTypeApprove:
+		if len(addresses) != 2 {
+			return nil, invalidAddressCount(fn, len(addresses))
+		}
+		e := &Sep41ApproveEvent{sep41Event: base, From: addresses[0], Spender: addresses[1]}
+		e.Amount, e.ExpirationLedger, err = parseApproveData(value)
+		decoded = e
+
+	case EventTypeSetAdmin:
+		if len(addresses) != 1 {
+			return nil, invalidAddressCount(fn, len(addresses))
+		}
+		e := &Sep41SetAdminEvent{sep41Event: base, Admin: addresses[0]}
+		e.NewAdmin, err = parseAddress(value)
+		decoded = e
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrNotSep41Event, err.Error())
+	}
+	return decoded, nil
+}
+
+func invalidAddressCount(fn xdr.ScSymbol, count int) error {
+	return errors.Wrapf(ErrNotSep41Event, "unexpected number of addresses in '%s' event: %d", fn, count)
+}
+
+// parseSep41Topics returns the addresses of the topics, which may be
+// followed by a SEP-11 asset string.
+func parseSep41Topics(topics xdr.ScVec) ([]string, string, error) {
+	var asset string
+	if len(topics) > 0 {
+		if str, ok := topics[len(topics)-1].GetStr(); ok {
+			asset = string(str)
+			topics = topics[:len(topics)-1]
+		}
+	}
+	addresses := make([]string, 0, len(topics))
+	for i, topic := range topics {
+		address, err := parseAddress(topic)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "invalid topic %d", i+1)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, asset, nil
+}
+
+func parseAddress(value xdr.ScVal) (string, error) {
+	address, ok := value.GetAddress()
+	if !ok {
+		return "", fmt.Errorf("expected address, got %s", value.Type)
+	}
+	return address.String()
+}
+
+func parseI128(value xdr.ScVal) (xdr.Int128Parts, error) {
+	amount, ok := value.GetI128()
+	if !ok {
+		return amount, fmt.Errorf("expected i128 amount, got %s", value.Type)
+	}
+	return amount, nil
+}
+
+// parseSep41Amount parses the data of transfer and mint events, which is
+// either the amount or, since CAP-67, a map of the amount and the
+// to_muxed_id.
+func parseSep41Amount(value xdr.ScVal) (xdr.Int128Parts, *MuxedID, error) {
+	mapData, ok := value.GetMap()
+	if !ok {
+		amount, err := parseI128(value)
+		return amount, nil, err
+	}
+	if mapData == nil {
+		return xdr.Int128Parts{}, nil, errors.New("data map is empty")
+	}
+
+	var amount xdr.Int128Parts
+	var muxedID *MuxedID
+	foundAmount := false
+	for _, entry := range *mapData {
+		key, ok := entry.Key.GetSym()
+		if !ok {
+			return amount, nil, fmt.Errorf("invalid key type in data map: %s", entry.Key.Type)
+		}
+		switch string(key) {
+		case "amount":
+			var err error
+			if amount, err = parseI128(entry.Val); err != nil {
+				return amount, nil, err
+			}
+			foundAmount = true
+		case "to_muxed_id":
+			var err error
+			if muxedID, err = parseMuxedID(entry.Val); err != nil {
+				return amount, nil, err
+			}
+		}
+	}
+	if !foundAmount {
+		return amount, nil, errors.New("amount not found in data map")
+	}
+	return amount, muxedID, nil
+}
+
+func parseMuxedID(value xdr.ScVal) (*MuxedID, error) {
+	switch value.Type {
+	case xdr.ScValTypeScvU64:
+		id := uint64(*value.U64)
+		return &MuxedID{ID: &id}, nil
+	case xdr.ScValTypeScvString:
+		text := string(*value.Str)
+		return &MuxedID{Text: &text}, nil
+	case xdr.ScValTypeScvBytes:
+		if len(*value.Bytes) != len(xdr.Hash{}) {
+			return nil, fmt.Errorf("invalid to_muxed_id hash length: %d", len(*value.Bytes))
+		}
+		var hash xdr.Hash
+		copy(hash[:], *value.Bytes)
+		return &MuxedID{Hash: &hash}, nil
+	default:
+		return nil, fmt.Errorf("invalid to_muxed_id type: %s", value.Type)
+	}
+}
+
+// parseApproveData parses the data of approve events, a vector of the
+// amount and the expiration ledger.
+func parseApproveData(value xdr.ScVal) (xdr.Int128Parts, uint32, error) {
+	vec, ok := value.GetVec()
+	if !ok || vec == nil || len(*vec) != 2 {
+		return xdr.Int128Parts{}, 0, errors.New("expected [amount, expiration_ledger] data")
+	}
+	amount, err := parseI128((*vec)[0])
+	if err != nil {
+		return amount, 0, err
+	}
+	expirationLedger, ok := (*vec)[1].GetU32()
+	if !ok {
+		return amount, 0, fmt.Errorf("expected u32 expiration ledger, got %s", (*vec)[1].Type)
+	}
+	return amount, uint32(expirationLedger), nil
+}
@@ -0,0 +1,192 @@
+package contractevents
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stellar/go-stellar-sdk/keypair"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+var customContractID = xdr.ContractId{1, 2, 3}
+
+func makeContractEvent(contractID xdr.ContractId, data xdr.ScVal, topics ...xdr.ScVal) *Event {
+	return &Event{
+		Type:       xdr.ContractEventTypeContract,
+		ContractId: &contractID,
+		Body: xdr.ContractEventBody{
+			V:  0,
+			V0: &xdr.ContractEventV0{Topics: topics, Data: data},
+		},
+	}
+}
+
+func makeMap(entries ...xdr.ScMapEntry) xdr.ScVal {
+	scMap := xdr.ScMap(entries)
+	scMapPtr := &scMap
+	return xdr.ScVal{Type: xdr.ScValTypeScvMap, Map: &scMapPtr}
+}
+
+func makeVec(values ...xdr.ScVal) xdr.ScVal {
+	vec := xdr.ScVec(values)
+	vecPtr := &vec
+	return xdr.ScVal{Type: xdr.ScValTypeScvVec, Vec: &vecPtr}
+}
+
+func makeU32(value uint32) xdr.ScVal {
+	u32 := xdr.Uint32(value)
+	return xdr.ScVal{Type: xdr.ScValTypeScvU32, U32: &u32}
+}
+
+func makeU64(value uint64) xdr.ScVal {
+	u64 := xdr.Uint64(value)
+	return xdr.ScVal{Type: xdr.ScValTypeScvU64, U64: &u64}
+}
+
+func TestSep41Events(t *testing.T) {
+	from, to := randomAccount, keypair.MustRandom().Address()
+	amount := xdr.Int128Parts{Lo: 1000}
+
+	event, err := NewSep41Event(makeContractEvent(customContractID, makeAmount(1000),
+		makeSymbol("transfer"), makeAddress(from), makeAddress(to)))
+	require.NoError(t, err)
+	assert.Equal(t, EventTypeTransfer, event.GetType())
+	assert.Equal(t, "transfer", event.GetName())
+	assert.Equal(t, customContractID, event.GetContractID())
+	assert.Equal(t, "", event.GetAsset())
+	transfer := event.(*Sep41TransferEvent)
+	assert.Equal(t, from, transfer.From)
+	assert.Equal(t, to, transfer.To)
+	assert.Equal(t, amount, transfer.Amount)
+	assert.Nil(t, transfer.ToMuxedID)
+
+	// CAP-67 mint without admin and with a to_muxed_id
+	event, err = NewSep41Event(makeContractEvent(customContractID,
+		makeMap(
+			xdr.ScMapEntry{Key: makeSymbol("amount"), Val: makeAmount(1000)},
+			xdr.ScMapEntry{Key: makeSymbol("to_muxed_id"), Val: makeU64(7)},
+		),
+		makeSymbol("mint"), makeAddress(to), makeAsset(randomAsset)))
+	require.NoError(t, err)
+	mint := event.(*Sep41MintEvent)
+	assert.Equal(t, "", mint.Admin)
+	assert.Equal(t, to, mint.To)
+	assert.Equal(t, amount, mint.Amount)
+	assert.Equal(t, uint64(7), *mint.ToMuxedID.ID)
+	assert.Equal(t, string(*makeAsset(randomAsset).Str), mint.GetAsset())
+
+	// clawback with admin, as emitted before CAP-67
+	event, err = NewSep41Event(makeContractEvent(customContractID, makeAmount(1000),
+		makeSymbol("clawback"), makeAddress(to), makeAddress(from)))
+	require.NoError(t, err)
+	clawback := event.(*Sep41ClawbackEvent)
+	assert.Equal(t, to, clawback.Admin)
+	assert.Equal(t, from, clawback.From)
+
+	event, err = NewSep41Event(makeContractEvent(customContractID, makeAmount(1000),
+		makeSymbol("burn"), makeAddress(from)))
+	require.NoError(t, err)
+	assert.Equal(t, from, event.(*Sep41BurnEvent).From)
+
+	event, err = NewSep41Event(makeContractEvent(customContractID, makeVec(makeAmount(1000), makeU32(500)),
+		makeSymbol("approve"), makeAddress(from), makeAddress(zeroContract)))
+	require.NoError(t, err)
+	approve := event.(*Sep41ApproveEvent)
+	assert.Equal(t, from, approve.From)
+	assert.Equal(t, zeroContract, approve.Spender)
+	assert.Equal(t, amount, approve.Amount)
+	assert.Equal(t, uint32(500), approve.ExpirationLedger)
+
+	event, err = NewSep41Event(makeContractEvent(customContractID, makeAddress(to),
+		makeSymbol("set_admin"), makeAddress(from)))
+	require.NoError(t, err)
+	setAdmin := event.(*Sep41SetAdminEvent)
+	assert.Equal(t, from, setAdmin.Admin)
+	assert.Equal(t, to, setAdmin.NewAdmin)
+
+	// SAC events are SEP-41 events
+	sacEvent := GenerateEvent(EventTypeTransfer, from, to, "", randomAsset, big.NewInt(1000), passphrase)
+	event, err = NewSep41Event(&sacEvent)
+	require.NoError(t, err)
+	assert.Equal(t, string(*makeAsset(randomAsset).Str), event.GetAsset())
+}
+
+func TestSep41InvalidEvents(t *testing.T) {
+	from, to := randomAccount, keypair.MustRandom().Address()
+	for _, event := range []*Event{
+		makeContractEvent(customContractID, makeAmount(1), makeSymbol("swap"), makeAddress(from)),
+		makeContractEvent(customContractID, makeAmount(1), makeSymbol("transfer"), makeAddress(from)),
+		makeContractEvent(customContractID, makeAmount(1), makeSymbol("transfer"), makeAddress(from), makeSymbol("to")),
+		makeContractEvent(customContractID, makeU32(1), makeSymbol("transfer"), makeAddress(from), makeAddress(to)),
+		makeContractEvent(customContractID,
+			makeMap(xdr.ScMapEntry{Key: makeSymbol("to_muxed_id"), Val: makeU64(7)}),
+			makeSymbol("transfer"), makeAddress(from), makeAddress(to)),
+		makeContractEvent(customContractID, makeAmount(1), makeSymbol("approve"), makeAddress(from), makeAddress(to)),
+		makeContractEvent(customContractID, makeAmount(1)),
+	} {
+		_, err := NewSep41Event(event)
+		assert.ErrorIs(t, err, ErrNotSep41Event)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	from := randomAccount
+	registry := NewRegistry()
+
+	decoded, err := registry.Decode(makeContractEvent(customContractID, makeAmount(5),
+		makeSymbol("burn"), makeAddress(from)))
+	require.NoError(t, err)
+	assert.Equal(t, from, decoded.(*Sep41BurnEvent).From)
+
+	swap := makeContractEvent(customContractID,
+		makeMap(
+			xdr.ScMapEntry{Key: makeSymbol("amount_in"), Val: makeAmount(10)},
+			xdr.ScMapEntry{Key: makeSymbol("amount_out"), Val: makeAmount(20)},
+		),
+		makeSymbol("swap"), makeAddress(from))
+	_, err = registry.Decode(swap)
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+
+	require.NoError(t, registry.RegisterSchemaForContract(customContractID, EventSchema{
+		Name:   "swap",
+		Topics: []Field{{Name: "trader", Type: xdr.ScValTypeScvAddress}},
+		Data: []Field{
+			{Name: "amount_in", Type: xdr.ScValTypeScvI128},
+			{Name: "amount_out", Type: xdr.ScValTypeScvI128},
+		},
+	}))
+	decoded, err = registry.Decode(swap)
+	require.NoError(t, err)
+	assert.Equal(t, "swap", decoded.GetName())
+	assert.Equal(t, customContractID, decoded.GetContractID())
+	fields := decoded.(*SchemaEvent).Fields
+	assert.Equal(t, makeAddress(from), fields["trader"])
+	assert.Equal(t, makeAmount(20), fields["amount_out"])
+
+	// the schema is only registered for a single contract
+	_, err = registry.Decode(makeContractEvent(xdr.ContractId(zeroContractHash), swap.Body.V0.Data, swap.Body.V0.Topics...))
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+
+	// a contract-specific decoder takes precedence over the SEP-41 decoder
+	registry.RegisterForContract(customContractID, "burn", func(event *Event) (DecodedEvent, error) {
+		return &SchemaEvent{Name: "custom burn", ContractID: *event.ContractId}, nil
+	})
+	decoded, err = registry.Decode(makeContractEvent(customContractID, makeAmount(5),
+		makeSymbol("burn"), makeAddress(from)))
+	require.NoError(t, err)
+	assert.Equal(t, "custom burn", decoded.GetName())
+
+	_, err = registry.Decode(makeContractEvent(customContractID,
+		makeMap(xdr.ScMapEntry{Key: makeSymbol("amount_in"), Val: makeU32(10)}),
+		makeSymbol("swap"), makeAddress(from)))
+	assert.EqualError(t, err, `data of event "swap" is not a map of 2 values`)
+
+	assert.EqualError(t, registry.RegisterSchema(EventSchema{
+		Name:       "deposit",
+		Data:       []Field{{Name: "a", Type: xdr.ScValTypeScvI128}, {Name: "b", Type: xdr.ScValTypeScvI128}},
+		DataFormat: DataFormatSingleValue,
+	}), `schema "deposit" has single value data format but 2 data fields`)
+}
@@ -88,6 +88,16 @@ func (b *Filesystem) PutFile(pth string, in io.ReadCloser) error {
 	return e
 }
 
+func (b *Filesystem) DeleteFile(pth string) error {
+	pth = path.Join(b.prefix, pth)
+	log.WithField("path", pth).Trace("fs: delete file")
+	err := os.Remove(pth)
+	if err != nil {
+		log.WithField("path", pth).WithError(err).Error("fs: delete file")
+	}
+	return err
+}
+
 func (b *Filesystem) ListFiles(pth string) (chan string, chan error) {
 	ch := make(chan string)
 	errs := make(chan error)
@@ -99,6 +99,16 @@ func (b *GCSStorage) PutFile(pth string, in io.ReadCloser) error {
 	return w.Close()
 }
 
+func (b *GCSStorage) DeleteFile(pth string) error {
+	pth = path.Join(b.prefix, pth)
+	log.WithField("path", pth).Trace("gcs: delete file")
+	err := b.bucket.Object(pth).Delete(context.Background())
+	if err == storage.ErrObjectNotExist {
+		err = os.ErrNotExist
+	}
+	return err
+}
+
 func (b *GCSStorage) ListFiles(pth string) (chan string, chan error) {
 	prefix := path.Join(b.prefix, pth)
 	ch := make(chan string)
@@ -22,6 +22,13 @@ type Storage interface {
 	Close() error
 }
 
+// Deleter is implemented by the Storage backends which can delete files. It
+// isn't part of the `Storage` interface as read-only backends, e.g. HTTP, can't
+// delete files.
+type Deleter interface {
+	DeleteFile(path string) error
+}
+
 type ConnectOptions struct {
 	Context          context.Context
 	S3Region         string
@@ -6,6 +6,7 @@ import (
 	"path"
 
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/stellar/go-stellar-sdk/support/errors"
 	"github.com/stellar/go-stellar-sdk/support/log"
 )
 
@@ -180,6 +181,17 @@ func (b *OnDiskCache) PutFile(filepath string, in io.ReadCloser) error {
 	return b.Storage.PutFile(filepath, in)
 }
 
+// DeleteFile removes the file from the cache and deletes it from the wrapped
+// backend, if it implements `Deleter`.
+func (b *OnDiskCache) DeleteFile(filepath string) error {
+	deleter, ok := b.Storage.(Deleter)
+	if !ok {
+		return errors.New("wrapped backend cannot delete files")
+	}
+	b.Evict(filepath)
+	return deleter.DeleteFile(filepath)
+}
+
 // Close purges the cache, then forwards the call to the wrapped backend.
 func (b *OnDiskCache) Close() error {
 	// We only purge the cache, leaving the filesystem untouched:
@@ -198,6 +198,23 @@ func (b *S3Storage) PutFile(pth string, in io.ReadCloser) error {
 	return err
 }
 
+func (b *S3Storage) DeleteFile(pth string) error {
+	key := path.Join(b.prefix, pth)
+	params := &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	}
+	req, _ := b.svc.DeleteObjectRequest(params)
+	if b.unsignedRequests {
+		req.Handlers.Sign.Clear() // makes this request unsigned
+	}
+	req.SetContext(b.ctx)
+	logReq(req.HTTPRequest)
+	err := req.Send()
+	logResp(req.HTTPResponse)
+	return err
+}
+
 func (b *S3Storage) ListFiles(pth string) (chan string, chan error) {
 	prefix := path.Join(b.prefix, pth)
 	ch := make(chan string)
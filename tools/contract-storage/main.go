@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest/contractstorage"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/support/log"
+	"github.com/stellar/go-stellar-sdk/support/storage"
+	"github.com/stellar/go-stellar-sdk/tools/internal/ledgersource"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// This program prints the storage of a Soroban contract as of a given ledger.
+// The checkpoint preceding the ledger is read from the history archives and,
+// if the ledger is not a checkpoint ledger, the remaining ledgers are read
+// from Stellar RPC or from a datastore populated by galexie.
+func main() {
+	testnet := flag.Bool("testnet", false, "connect to the Stellar test network")
+	archiveURL := flag.String("archive-url", "", "history archive url, defaults to the SDF archive of the selected network")
+	contractID := flag.String("contract", "", "contract address (C...) whose storage will be printed")
+	ledger := flag.Uint("ledger", 0, "ledger as of which the storage is printed, defaults to the latest checkpoint ledger")
+	rpcURL := flag.String("rpc-url", "", "Stellar RPC url used to fetch ledgers after the checkpoint")
+	datastoreType := flag.String("datastore-type", "", "datastore type (GCS or S3) used to fetch ledgers after the checkpoint")
+	datastorePath := flag.String("datastore-path", "", "datastore bucket path, e.g. my-bucket/ledgers/pubnet")
+	datastoreRegion := flag.String("datastore-region", "", "datastore region, required for S3")
+	format := flag.String("format", "text", "output format: text or json")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format %s", *format)
+	}
+	contract, err := parseContract(*contractID)
+	if err != nil {
+		log.WithField("err", err).Fatal("invalid contract")
+	}
+
+	passphrase := network.PublicNetworkPassphrase
+	url := network.PublicNetworkhistoryArchiveURLs[0]
+	if *testnet {
+		passphrase = network.TestNetworkPassphrase
+		url = network.TestNetworkhistoryArchiveURLs[0]
+	}
+	if *archiveURL != "" {
+		url = *archiveURL
+	}
+
+	sourceConfig := ledgersource.Config{
+		NetworkPassphrase: passphrase,
+		RPCURL:            *rpcURL,
+		DatastoreType:     *datastoreType,
+		DatastorePath:     *datastorePath,
+		DatastoreRegion:   *datastoreRegion,
+	}
+	if err = run(url, sourceConfig, contract, uint32(*ledger), *format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run prints the storage of the contract as of the ledger, the latest
+// checkpoint ledger if 0. Errors are returned rather than logged with
+// log.Fatal, so the ledger backend is closed before the program exits.
+func run(archiveURL string, sourceConfig ledgersource.Config, contract xdr.ScAddress, sequence uint32, format string) error {
+	ctx := context.Background()
+	archive, err := historyarchive.Connect(archiveURL, historyarchive.ArchiveOptions{
+		NetworkPassphrase: sourceConfig.NetworkPassphrase,
+		ConnectOptions:    storage.ConnectOptions{Context: ctx, UserAgent: "contract-storage"},
+	})
+	if err != nil {
+		return fmt.Errorf("could not connect to history archive: %w", err)
+	}
+
+	if sequence == 0 {
+		var root historyarchive.HistoryArchiveState
+		if root, err = archive.GetRootHAS(); err != nil {
+			return fmt.Errorf("could not fetch root has: %w", err)
+		}
+		sequence = root.CurrentLedger
+	}
+
+	config := contractstorage.Config{Archive: archive, NetworkPassphrase: sourceConfig.NetworkPassphrase}
+	if !archive.GetCheckpointManager().IsCheckpoint(sequence) {
+		config.LedgerBackend, err = ledgersource.NewLedgerBackend(ctx, sourceConfig)
+		if errors.Is(err, ledgersource.ErrNotConfigured) {
+			return errors.New("-rpc-url or -datastore-type is required when -ledger is not a checkpoint ledger")
+		} else if err != nil {
+			return fmt.Errorf("could not create ledger backend: %w", err)
+		}
+		defer config.LedgerBackend.Close()
+	}
+
+	log.WithField("ledger", sequence).Info("Reconstructing contract storage")
+	result, err := contractstorage.Reconstruct(ctx, config, contract, sequence)
+	if err != nil {
+		return fmt.Errorf("could not reconstruct contract storage: %w", err)
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+	} else {
+		err = printText(os.Stdout, result)
+	}
+	if err != nil {
+		return fmt.Errorf("could not print contract storage: %w", err)
+	}
+	return nil
+}
+
+func parseContract(address string) (xdr.ScAddress, error) {
+	raw, err := strkey.Decode(strkey.VersionByteContract, address)
+	if err != nil {
+		return xdr.ScAddress{}, err
+	}
+	var id xdr.ContractId
+	copy(id[:], raw)
+	return xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &id}, nil
+}
+
+func printText(w io.Writer, result contractstorage.Storage) error {
+	contract, err := result.Contract.String()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "contract: %s\nledger: %d\n", contract, result.Ledger)
+	if result.Instance == nil {
+		fmt.Fprintln(w, "instance: (not found)")
+	} else {
+		fmt.Fprintf(w, "instance: %s\n", status(*result.Instance, result.Ledger))
+		for _, entry := range result.InstanceStorage() {
+			fmt.Fprintf(w, "  %s = %s\n", entry.Key.String(), entry.Val.String())
+		}
+	}
+	fmt.Fprintf(w, "entries: %d\n", len(result.Entries))
+	for _, entry := range result.Entries {
+		durability := "temporary"
+		if entry.Durability == xdr.ContractDataDurabilityPersistent {
+			durability = "persistent"
+		}
+		fmt.Fprintf(w, "  [%s] %s = %s (%s)\n",
+			durability, entry.Key.String(), entry.Value.String(), status(entry, result.Ledger))
+	}
+	return nil
+}
+
+func status(entry contractstorage.Entry, ledger uint32) string {
+	switch {
+	case entry.Archived:
+		return "archived"
+	case entry.Expired(ledger):
+		return fmt.Sprintf("expired at ledger %d", entry.LiveUntilLedgerSeq)
+	default:
+		return fmt.Sprintf("live until ledger %d", entry.LiveUntilLedgerSeq)
+	}
+}
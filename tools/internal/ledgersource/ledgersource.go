@@ -0,0 +1,73 @@
+// Package ledgersource creates the ledger backends of command line tools which
+// read ledgers either from Stellar RPC or from a datastore populated by
+// galexie.
+package ledgersource
+
+import (
+	"context"
+
+	"github.com/stellar/go-stellar-sdk/ingest"
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/support/datastore"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+)
+
+// ErrNotConfigured is returned by NewLedgerBackend if neither RPCURL nor
+// DatastoreType is set.
+var ErrNotConfigured = errors.New("-rpc-url or -datastore-type is required")
+
+// Config describes where ledgers are read from. It mirrors the -rpc-url,
+// -datastore-type, -datastore-path and -datastore-region flags of the tools.
+type Config struct {
+	NetworkPassphrase string
+	// RPCURL is the url of the Stellar RPC server ledgers are fetched from.
+	// It takes precedence over the datastore.
+	RPCURL string
+	// DatastoreType is the type of the datastore, GCS or S3.
+	DatastoreType string
+	// DatastorePath is the bucket path of the datastore, e.g.
+	// my-bucket/ledgers/pubnet.
+	DatastorePath string
+	// DatastoreRegion is the region of the datastore, required for S3.
+	DatastoreRegion string
+}
+
+// NewLedgerBackend returns a ledger backend reading ledgers from Stellar RPC
+// if config.RPCURL is set, or from the configured datastore otherwise.
+func NewLedgerBackend(ctx context.Context, config Config) (ledgerbackend.LedgerBackend, error) {
+	if config.RPCURL != "" {
+		return ledgerbackend.NewRPCLedgerBackend(ledgerbackend.RPCLedgerBackendOptions{RPCServerURL: config.RPCURL}), nil
+	}
+	if config.DatastoreType == "" {
+		return nil, ErrNotConfigured
+	}
+
+	params := map[string]string{"destination_bucket_path": config.DatastorePath}
+	if config.DatastoreRegion != "" {
+		params["region"] = config.DatastoreRegion
+	}
+	dataStoreConfig := datastore.DataStoreConfig{
+		Type:              config.DatastoreType,
+		Params:            params,
+		NetworkPassphrase: config.NetworkPassphrase,
+	}
+	dataStore, err := datastore.NewDataStore(ctx, dataStoreConfig)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := datastore.LoadSchema(ctx, dataStore, dataStoreConfig)
+	if err != nil {
+		dataStore.Close()
+		return nil, err
+	}
+	backend, err := ledgerbackend.NewBufferedStorageBackend(
+		ingest.DefaultBufferedStorageBackendConfig(schema.LedgersPerFile),
+		dataStore,
+		schema,
+	)
+	if err != nil {
+		dataStore.Close()
+		return nil, err
+	}
+	return backend, nil
+}
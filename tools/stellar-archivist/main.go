@@ -5,15 +5,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 	"github.com/stellar/go-stellar-sdk/historyarchive"
+	"github.com/stellar/go-stellar-sdk/ingest"
 	"github.com/stellar/go-stellar-sdk/support/errors"
 )
 
@@ -142,12 +146,155 @@ func scan(a string, opts *Options) {
 	}
 }
 
-func mirror(src string, dst string, opts *Options) {
+func verifyChain(a string, statePath string, reportPath string, opts *Options) {
+	arch := historyarchive.MustConnect(a, opts.ConnectOpts)
+	opts.SetRange(arch, nil)
+	report, verifyErr := arch.VerifyChain(context.Background(), historyarchive.ChainVerificationOptions{
+		Range:       opts.CommandOpts.Range,
+		Concurrency: opts.CommandOpts.Concurrency,
+		StatePath:   statePath,
+	})
+	// the report of a failed verification has the invalid items found before
+	// the failure
+	if err := writeChainReport(report, reportPath); err != nil {
+		if verifyErr != nil {
+			log.Error(verifyErr)
+		}
+		log.Fatal(err)
+	}
+	if verifyErr != nil {
+		log.Fatal(verifyErr)
+	}
+	if !report.Valid() {
+		log.Fatalf("Found %d invalid items", len(report.Invalid))
+	}
+}
+
+// writeChainReport writes the report as JSON to reportPath, or to stdout if
+// reportPath is empty.
+func writeChainReport(report historyarchive.ChainVerificationReport, reportPath string) error {
+	out := os.Stdout
+	if reportPath != "" {
+		var err error
+		out, err = os.Create(reportPath)
+		if err != nil {
+			return errors.Wrap(err, "Error creating report")
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "    ")
+	err := enc.Encode(report)
+	if reportPath != "" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error writing report")
+	}
+	return nil
+}
+
+func exportTransactions(a string, passphrase string, outputPath string, opts *Options) {
+	arch := historyarchive.MustConnect(a, opts.ConnectOpts)
+	out := os.Stdout
+	if outputPath != "" {
+		var err error
+		out, err = os.Create(outputPath)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "Error creating output"))
+		}
+		defer out.Close()
+	}
+	enc := json.NewEncoder(out)
+	count := 0
+	for tx, err := range arch.ExportTransactions(context.Background(), historyarchive.TransactionExportOptions{
+		Range:             historyarchive.Range{Low: uint32(opts.Low), High: opts.High},
+		Concurrency:       opts.CommandOpts.Concurrency,
+		NetworkPassphrase: passphrase,
+	}) {
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := enc.Encode(tx); err != nil {
+			log.Fatal(errors.Wrap(err, "Error writing transaction"))
+		}
+		count++
+	}
+	log.Printf("Exported %d transactions", count)
+}
+
+func gc(a string, retain uint32, opts *Options) {
+	arch := historyarchive.MustConnect(a, opts.ConnectOpts)
+	report, err := arch.CollectBuckets(historyarchive.BucketGCOptions{
+		RetainCheckpoints: retain,
+		DryRun:            opts.CommandOpts.DryRun,
+		Concurrency:       opts.CommandOpts.Concurrency,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatal(errors.Wrap(err, "Error writing report"))
+	}
+}
+
+func bucketStats(a string, statsOpts historyarchive.BucketStatsOptions, liveState bool, format string, outputPath string, opts *Options) {
+	arch := historyarchive.MustConnect(a, opts.ConnectOpts)
+	opts.SetRange(arch, nil)
+	statsOpts.Range = opts.CommandOpts.Range
+	statsOpts.Concurrency = opts.CommandOpts.Concurrency
+	if liveState {
+		statsOpts.LiveState = func(checkpoint uint32) (historyarchive.LiveStateStats, error) {
+			return ingest.CheckpointLiveStateStats(context.Background(), arch, checkpoint)
+		}
+	}
+	if format != "json" && format != "csv" {
+		log.Fatalf("unknown format %s", format)
+	}
+	report, err := arch.BucketStats(statsOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := os.Stdout
+	if outputPath != "" {
+		out, err = os.Create(outputPath)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "Error creating output"))
+		}
+		defer out.Close()
+	}
+	if format == "csv" {
+		err = report.WriteCSV(out)
+	} else {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "    ")
+		err = enc.Encode(report)
+	}
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "Error writing report"))
+	}
+}
+
+func serve(dir string, addr string, handlerOpts historyarchive.ArchiveHandlerOptions) {
+	if dir == "" {
+		log.Fatal("require a directory argument")
+	}
+	log.Printf("serving %v on %v\n", dir, addr)
+	handler := historyarchive.NewArchiveHandler(dir, handlerOpts)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func mirror(src string, dst string, mirrorOpts historyarchive.MirrorOptions, opts *Options) {
 	srcArch := historyarchive.MustConnect(src, opts.ConnectOpts)
 	dstArch := historyarchive.MustConnect(dst, opts.ConnectOpts)
 	opts.SetRange(srcArch, dstArch)
 	log.Printf("mirroring %v -> %v\n", src, dst)
-	e := historyarchive.Mirror(srcArch, dstArch, &opts.CommandOpts)
+	e := historyarchive.MirrorWithOptions(srcArch, dstArch, &opts.CommandOpts, mirrorOpts)
 	if e != nil {
 		log.Fatal(e)
 	}
@@ -313,15 +460,71 @@ func main() {
 		},
 	})
 
-	rootCmd.AddCommand(&cobra.Command{
+	var statePath, reportPath string
+	verifyChainCmd := &cobra.Command{
+		Use:   "verify-chain",
+		Short: "verify the hash chain of ledger headers, transaction sets and results",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.SetupLogging()
+			opts.MaybeProfile()
+			verifyChain(firstArg(args), statePath, reportPath, &opts)
+		},
+	}
+	verifyChainCmd.Flags().StringVar(
+		&statePath,
+		"state",
+		"",
+		"file to save progress to and resume from",
+	)
+	verifyChainCmd.Flags().StringVar(
+		&reportPath,
+		"report",
+		"",
+		"file to write the JSON report of invalid items to, instead of stdout",
+	)
+	rootCmd.AddCommand(verifyChainCmd)
+
+	var mirrorOpts historyarchive.MirrorOptions
+	mirrorCmd := &cobra.Command{
 		Use: "mirror",
 		Run: func(cmd *cobra.Command, args []string) {
 			opts.SetupLogging()
 			opts.MaybeProfile()
 			src, dst := srcDst(args)
-			mirror(src, dst, &opts)
+			mirror(src, dst, mirrorOpts, &opts)
 		},
-	})
+	}
+	mirrorCmd.Flags().StringSliceVar(
+		&mirrorOpts.Categories,
+		"categories",
+		nil,
+		"checkpoint categories to mirror (history, ledger, transactions, results, scp), all if empty",
+	)
+	mirrorCmd.Flags().BoolVar(
+		&mirrorOpts.SkipBuckets,
+		"skip-buckets",
+		false,
+		"skip the buckets referenced by the checkpoints",
+	)
+	mirrorCmd.Flags().Int64Var(
+		&mirrorOpts.MaxBytesPerSecond,
+		"max-bytes-per-second",
+		0,
+		"bandwidth limit of the copied files, unlimited if 0",
+	)
+	mirrorCmd.Flags().Float64Var(
+		&mirrorOpts.MaxRequestsPerSecond,
+		"max-requests-per-second",
+		0,
+		"limit of the requests to both archives, unlimited if 0",
+	)
+	mirrorCmd.Flags().StringVar(
+		&mirrorOpts.StatePath,
+		"state",
+		"",
+		"file to save progress to and resume from",
+	)
+	rootCmd.AddCommand(mirrorCmd)
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use: "repair",
@@ -333,6 +536,144 @@ func main() {
 		},
 	})
 
+	var (
+		statsOpts                    historyarchive.BucketStatsOptions
+		statsFormat, statsOutputPath string
+		statsLiveState               bool
+	)
+	bucketStatsCmd := &cobra.Command{
+		Use:   "bucket-stats",
+		Short: "report the bucket list sizes and growth of the checkpoints of the --low to --high range",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.SetupLogging()
+			opts.MaybeProfile()
+			bucketStats(firstArg(args), statsOpts, statsLiveState, statsFormat, statsOutputPath, &opts)
+		},
+	}
+	bucketStatsCmd.Flags().DurationVar(
+		&statsOpts.Interval,
+		"interval",
+		0,
+		"time between reported checkpoints, every checkpoint if 0",
+	)
+	bucketStatsCmd.Flags().BoolVar(
+		&statsOpts.Entries,
+		"entries",
+		false,
+		"read the buckets to count their entries by type and the size of their Soroban entries",
+	)
+	bucketStatsCmd.Flags().BoolVar(
+		&statsLiveState,
+		"live-state",
+		false,
+		"stream the live ledger state of every checkpoint to count its entries by type and the size of its Soroban entries",
+	)
+	bucketStatsCmd.Flags().StringVar(
+		&statsFormat,
+		"format",
+		"json",
+		"report format, json or csv",
+	)
+	bucketStatsCmd.Flags().StringVar(
+		&statsOutputPath,
+		"output",
+		"",
+		"file to write the report to, instead of stdout",
+	)
+	rootCmd.AddCommand(bucketStatsCmd)
+
+	var passphrase, outputPath string
+	exportTransactionsCmd := &cobra.Command{
+		Use:   "export-transactions",
+		Short: "write the transactions of the --low to --high ledgers, with their results, as JSON lines",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.SetupLogging()
+			opts.MaybeProfile()
+			exportTransactions(firstArg(args), passphrase, outputPath, &opts)
+		},
+	}
+	exportTransactionsCmd.Flags().StringVar(
+		&passphrase,
+		"network-passphrase",
+		"",
+		"network passphrase to hash the transactions with, the passphrase of the archive if empty",
+	)
+	exportTransactionsCmd.Flags().StringVar(
+		&outputPath,
+		"output",
+		"",
+		"file to write the transactions to, instead of stdout",
+	)
+	rootCmd.AddCommand(exportTransactionsCmd)
+
+	var retainCheckpoints uint32
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "delete the buckets not referenced by the recent checkpoints, only list them with --dryrun",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.SetupLogging()
+			opts.MaybeProfile()
+			gc(firstArg(args), retainCheckpoints, &opts)
+		},
+	}
+	gcCmd.Flags().Uint32Var(
+		&retainCheckpoints,
+		"retain",
+		0,
+		"number of most recent checkpoints whose buckets are retained, 0 to retain the buckets of every checkpoint",
+	)
+	rootCmd.AddCommand(gcCmd)
+
+	var (
+		serveAddr   string
+		handlerOpts historyarchive.ArchiveHandlerOptions
+	)
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "serve an archive directory over HTTP, with optional fault injection",
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.SetupLogging()
+			serve(firstArg(args), serveAddr, handlerOpts)
+		},
+	}
+	serveCmd.Flags().StringVar(
+		&serveAddr,
+		"addr",
+		"localhost:8000",
+		"address to listen on",
+	)
+	serveCmd.Flags().DurationVar(
+		&handlerOpts.Latency,
+		"latency",
+		0,
+		"latency added to every response",
+	)
+	serveCmd.Flags().Float64Var(
+		&handlerOpts.ServerErrorRate,
+		"error-rate",
+		0,
+		"fraction of requests failing with --error-status",
+	)
+	serveCmd.Flags().IntVar(
+		&handlerOpts.ServerErrorStatus,
+		"error-status",
+		http.StatusServiceUnavailable,
+		"status of the failing requests",
+	)
+	serveCmd.Flags().Float64Var(
+		&handlerOpts.TruncateRate,
+		"truncate-rate",
+		0,
+		"fraction of responses with a truncated body",
+	)
+	serveCmd.Flags().Int64Var(
+		&handlerOpts.Seed,
+		"seed",
+		time.Now().UnixNano(),
+		"seed of the injected faults",
+	)
+	rootCmd.AddCommand(serveCmd)
+
 	rootCmd.AddCommand(&cobra.Command{
 		Use: "dumpxdr",
 		Run: func(cmd *cobra.Command, args []string) {
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer/exporter"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+	"github.com/stellar/go-stellar-sdk/support/log"
+	"github.com/stellar/go-stellar-sdk/tools/internal/ledgersource"
+)
+
+// This program exports the token transfer events of a range of ledgers to
+// JSON Lines, CSV or Parquet files. Ledgers are read from Stellar RPC or from
+// a datastore populated by galexie.
+func main() {
+	testnet := flag.Bool("testnet", false, "export events of the Stellar test network")
+	start := flag.Uint("start", 0, "first ledger to export")
+	end := flag.Uint("end", 0, "last ledger to export, if 0 new ledgers are exported until the program is interrupted")
+	output := flag.String("output", "", "directory the files are written to")
+	formatName := flag.String("format", "jsonl", "output format: jsonl, csv or parquet")
+	ledgersPerFile := flag.Uint("ledgers-per-file", exporter.DefaultLedgersPerFile, "number of ledgers exported to a file")
+	unifiedEvents := flag.Bool("unified-events", false, "derive events from the unified events stream (CAP-67) instead of ledger changes")
+	rpcURL := flag.String("rpc-url", "", "Stellar RPC url used to fetch ledgers")
+	datastoreType := flag.String("datastore-type", "", "datastore type (GCS or S3) used to fetch ledgers")
+	datastorePath := flag.String("datastore-path", "", "datastore bucket path, e.g. my-bucket/ledgers/pubnet")
+	datastoreRegion := flag.String("datastore-region", "", "datastore region, required for S3")
+	flag.Parse()
+
+	format := formatParquet
+	if *formatName != string(formatParquet) {
+		var err error
+		if format, err = exporter.ParseFormat(*formatName); err != nil {
+			log.WithField("err", err).Fatal("invalid format")
+		}
+	}
+	if *start == 0 {
+		log.Fatal("-start is required")
+	}
+	if *output == "" {
+		log.Fatal("-output is required")
+	}
+	ledgerRange := ledgerbackend.UnboundedRange(uint32(*start))
+	if *end != 0 {
+		if *end < *start {
+			log.Fatal("-end must not be lower than -start")
+		}
+		ledgerRange = ledgerbackend.BoundedRange(uint32(*start), uint32(*end))
+	}
+
+	passphrase := network.PublicNetworkPassphrase
+	if *testnet {
+		passphrase = network.TestNetworkPassphrase
+	}
+
+	sourceConfig := ledgersource.Config{
+		NetworkPassphrase: passphrase,
+		RPCURL:            *rpcURL,
+		DatastoreType:     *datastoreType,
+		DatastorePath:     *datastorePath,
+		DatastoreRegion:   *datastoreRegion,
+	}
+	config := exporter.Config{
+		NetworkPassphrase: passphrase,
+		OutputDir:         *output,
+		Format:            format,
+		LedgersPerFile:    uint32(*ledgersPerFile),
+	}
+	if format == formatParquet {
+		config.NewWriter = newParquetWriter
+	}
+	if *unifiedEvents {
+		config.ProcessorOptions = append(config.ProcessorOptions, token_transfer.WithUnifiedEventsStreamEnabled())
+	}
+
+	if err := run(sourceConfig, config, ledgerRange); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run exports the events of the range with the ledger backend of
+// sourceConfig. Errors are returned rather than logged with log.Fatal, so the
+// ledger backend is closed before the program exits.
+func run(sourceConfig ledgersource.Config, config exporter.Config, ledgerRange ledgerbackend.Range) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend, err := ledgersource.NewLedgerBackend(ctx, sourceConfig)
+	if err != nil {
+		return errors.Wrap(err, "could not create ledger backend")
+	}
+	defer backend.Close()
+	config.LedgerBackend = backend
+
+	log.WithField("range", ledgerRange.String()).WithField("format", config.Format).Info("Exporting token transfer events")
+	if err = exporter.Export(ctx, config, ledgerRange); err != nil {
+		if ctx.Err() != nil {
+			log.Info("Export interrupted, run the same command again to resume")
+			return nil
+		}
+		return errors.Wrap(err, "could not export token transfer events")
+	}
+	log.Info("Export completed")
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer/exporter"
+	"github.com/stellar/go-stellar-sdk/support/errors"
+)
+
+// formatParquet writes exporter.Rows to a snappy compressed Parquet file. It
+// is implemented here rather than in the exporter package so that the Parquet
+// dependencies stay out of the SDK module.
+const formatParquet exporter.Format = "parquet"
+
+// parquetRow is the Parquet schema of an exporter.Row.
+type parquetRow struct {
+	LedgerSequence   int64   `parquet:"name=ledger_sequence, type=INT64"`
+	ClosedAt         int64   `parquet:"name=closed_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	TxHash           string  `parquet:"name=tx_hash, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TransactionIndex int64   `parquet:"name=transaction_index, type=INT64"`
+	OperationIndex   *int64  `parquet:"name=operation_index, type=INT64, repetitiontype=OPTIONAL"`
+	ContractAddress  string  `parquet:"name=contract_address, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ToMuxedInfo      *string `parquet:"name=to_muxed_info, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ToMuxedInfoType  *string `parquet:"name=to_muxed_info_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY, repetitiontype=OPTIONAL"`
+	EventType        string  `parquet:"name=event_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	From             *string `parquet:"name=from, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	To               *string `parquet:"name=to, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Asset            *string `parquet:"name=asset, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Amount           string  `parquet:"name=amount, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func newParquetRow(r exporter.Row) parquetRow {
+	row := parquetRow{
+		LedgerSequence:   int64(r.LedgerSequence),
+		ClosedAt:         r.ClosedAt.UnixMilli(),
+		TxHash:           r.TxHash,
+		TransactionIndex: int64(r.TransactionIndex),
+		ContractAddress:  r.ContractAddress,
+		ToMuxedInfo:      optionalString(r.ToMuxedInfo),
+		ToMuxedInfoType:  optionalString(r.ToMuxedInfoType),
+		EventType:        r.EventType,
+		From:             optionalString(r.From),
+		To:               optionalString(r.To),
+		Asset:            optionalString(r.Asset),
+		Amount:           r.Amount,
+	}
+	if r.OperationIndex != nil {
+		index := int64(*r.OperationIndex)
+		row.OperationIndex = &index
+	}
+	return row
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type parquetWriter struct {
+	w *writer.ParquetWriter
+}
+
+// newParquetWriter returns a writer which writes every event as a flattened
+// exporter.Row to a snappy compressed Parquet file.
+func newParquetWriter(w io.Writer) (exporter.Writer, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating parquet writer")
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetWriter{w: pw}, nil
+}
+
+func (p *parquetWriter) Write(event *token_transfer.TokenTransferEvent) error {
+	return p.w.Write(newParquetRow(exporter.NewRow(event)))
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
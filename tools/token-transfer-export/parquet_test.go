@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	assetProto "github.com/stellar/go-stellar-sdk/asset"
+	"github.com/stellar/go-stellar-sdk/processors/token_transfer"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+const (
+	accountA = "GBXGQJWVLWOYHFLVTKWV5FGHA3LNYY2JQKM7OAJAUEQFU6LPCSEFVXON"
+	accountB = "GCCOBXW2XQNUSL467IEILE6MMCNRR66SSVL4YQADUNYYNUVREF3FIV2Z"
+	usdc     = "USDC:GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN"
+)
+
+func TestParquetWriter(t *testing.T) {
+	closedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	opIndex := uint32(1)
+	meta := &token_transfer.EventMeta{
+		LedgerSequence:   10,
+		ClosedAt:         timestamppb.New(closedAt),
+		TxHash:           "abcd",
+		TransactionIndex: 2,
+		OperationIndex:   &opIndex,
+		ContractAddress:  "CAAQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABDQF",
+		ToMuxedInfo:      token_transfer.NewMuxedInfoFromId(7),
+	}
+	feeMeta := &token_transfer.EventMeta{
+		LedgerSequence:   10,
+		ClosedAt:         timestamppb.New(closedAt),
+		TxHash:           "abcd",
+		TransactionIndex: 2,
+	}
+	events := []*token_transfer.TokenTransferEvent{
+		token_transfer.NewFeeEvent(feeMeta, accountA, "100", assetProto.NewNativeAsset()),
+		token_transfer.NewTransferEvent(meta, accountA, accountB, "5000000",
+			assetProto.NewProtoAsset(xdr.MustNewCreditAsset("USDC", "GA5ZSEJYB37JRC5AVCIA5MOP4RHTM335X2KGX3IHOJAPP5RE34K4KZVN"))),
+	}
+
+	var out bytes.Buffer
+	w, err := newParquetWriter(&out)
+	require.NoError(t, err)
+	for _, event := range events {
+		require.NoError(t, w.Write(event))
+	}
+	require.NoError(t, w.Close())
+
+	file, err := buffer.NewBufferFile(out.Bytes())
+	require.NoError(t, err)
+	pr, err := reader.NewParquetReader(file, new(parquetRow), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	require.Equal(t, int64(2), pr.GetNumRows())
+
+	rows := make([]parquetRow, 2)
+	require.NoError(t, pr.Read(&rows))
+	assert.Equal(t, "fee", rows[0].EventType)
+	assert.Nil(t, rows[0].OperationIndex)
+	assert.Nil(t, rows[0].To)
+	assert.Equal(t, "transfer", rows[1].EventType)
+	assert.Equal(t, int64(1), *rows[1].OperationIndex)
+	assert.Equal(t, accountB, *rows[1].To)
+	assert.Equal(t, usdc, *rows[1].Asset)
+	assert.Equal(t, "7", *rows[1].ToMuxedInfo)
+	assert.Equal(t, "id", *rows[1].ToMuxedInfoType)
+	assert.Equal(t, closedAt.UnixMilli(), rows[1].ClosedAt)
+}
@@ -0,0 +1,140 @@
+package xdr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Evaluate returns true if the predicate is satisfied by a ledger closed at
+// the given time. createdAt is the close time of the ledger in which the
+// claimable balance was created, relative predicates are evaluated from it.
+// Claimable balance entries only contain absolute predicates, because
+// relative predicates are converted when the balance is created.
+//
+// The predicate is evaluated in its JSON form (see MarshalJSON), the one
+// returned by Horizon, so that its absolute time bounds are interpreted
+// exactly as they are displayed.
+func (c ClaimPredicate) Evaluate(createdAt, at time.Time) (bool, error) {
+	predicate, err := c.toJSON()
+	if err != nil {
+		return false, err
+	}
+	return predicate.evaluate(createdAt.Unix(), at.Unix())
+}
+
+// NextSatisfied returns the earliest close time, not before at, for which the
+// predicate is satisfied. ok is false if the predicate can never be satisfied
+// from at onwards, i.e. the claimant cannot claim the balance anymore. Like
+// Evaluate, it evaluates the JSON form of the predicate.
+func (c ClaimPredicate) NextSatisfied(createdAt, at time.Time) (next time.Time, ok bool, err error) {
+	predicate, err := c.toJSON()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	created := createdAt.Unix()
+	// the predicate can only change at the times in which one of its time
+	// bounds is crossed, so it is enough to evaluate it at those times
+	times := []int64{at.Unix()}
+	if err = predicate.timeBounds(created, &times); err != nil {
+		return time.Time{}, false, err
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	for _, t := range times {
+		if t < at.Unix() {
+			continue
+		}
+		satisfied, err := predicate.evaluate(created, t)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if satisfied {
+			if t == at.Unix() {
+				return at, true, nil
+			}
+			return time.Unix(t, 0).UTC(), true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// absBefore returns the absolute time bound of the predicate as a unix
+// timestamp, preferring abs_before like toXDR does.
+func (c claimPredicateJSON) absBefore() int64 {
+	if c.AbsBefore != nil {
+		return c.AbsBefore.UTC().Unix()
+	}
+	return *c.AbsBeforeEpoch
+}
+
+// evaluate checks the fields of the predicate in the same order as toXDR.
+func (c claimPredicateJSON) evaluate(createdAt, at int64) (bool, error) {
+	switch {
+	case c.Unconditional:
+		return true, nil
+	case c.RelBefore != nil:
+		return at < relativeDeadline(createdAt, *c.RelBefore), nil
+	case c.AbsBefore != nil || c.AbsBeforeEpoch != nil:
+		return at < c.absBefore(), nil
+	case c.Not != nil:
+		satisfied, err := c.Not.evaluate(createdAt, at)
+		return !satisfied, err
+	case c.And != nil:
+		for _, inner := range *c.And {
+			satisfied, err := inner.evaluate(createdAt, at)
+			if err != nil || !satisfied {
+				return false, err
+			}
+		}
+		return true, nil
+	case c.Or != nil:
+		for _, inner := range *c.Or {
+			satisfied, err := inner.evaluate(createdAt, at)
+			if err != nil || satisfied {
+				return satisfied, err
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("empty predicate")
+	}
+}
+
+// timeBounds appends the times, as unix timestamps, at which the predicate
+// can change.
+func (c claimPredicateJSON) timeBounds(createdAt int64, times *[]int64) error {
+	switch {
+	case c.Unconditional:
+	case c.RelBefore != nil:
+		*times = append(*times, relativeDeadline(createdAt, *c.RelBefore))
+	case c.AbsBefore != nil || c.AbsBeforeEpoch != nil:
+		*times = append(*times, c.absBefore())
+	case c.Not != nil:
+		return c.Not.timeBounds(createdAt, times)
+	case c.And != nil:
+		for _, inner := range *c.And {
+			if err := inner.timeBounds(createdAt, times); err != nil {
+				return err
+			}
+		}
+	case c.Or != nil:
+		for _, inner := range *c.Or {
+			if err := inner.timeBounds(createdAt, times); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("empty predicate")
+	}
+	return nil
+}
+
+// relativeDeadline converts a relative predicate to an absolute one like
+// stellar-core does, saturating on overflow.
+func relativeDeadline(createdAt, relBefore int64) int64 {
+	if relBefore > math.MaxInt64-createdAt {
+		return math.MaxInt64
+	}
+	return createdAt + relBefore
+}
@@ -0,0 +1,87 @@
+package xdr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimPredicateEvaluate(t *testing.T) {
+	createdAt := time.Unix(1000, 0).UTC()
+	for _, testCase := range []struct {
+		name      string
+		predicate string
+		at        int64
+		satisfied bool
+		// next is the next time the predicate is satisfied, -1 if never
+		next int64
+	}{
+		{"unconditional", `{"unconditional":true}`, 5000, true, 5000},
+		{"before absolute time", `{"abs_before":"1970-01-01T00:33:20Z"}`, 1999, true, 1999},
+		{"after absolute time", `{"abs_before":"1970-01-01T00:33:20Z"}`, 2000, false, -1},
+		{"before relative time", `{"rel_before":"100"}`, 1099, true, 1099},
+		{"after relative time", `{"rel_before":"100"}`, 1100, false, -1},
+		{"not before", `{"not":{"abs_before":"1970-01-01T00:33:20Z"}}`, 1500, false, 2000},
+		{
+			"window",
+			`{"and":[{"not":{"abs_before":"1970-01-01T00:33:20Z"}},{"abs_before":"1970-01-01T00:50:00Z"}]}`,
+			1500, false, 2000,
+		},
+		{
+			"expired window",
+			`{"and":[{"not":{"abs_before":"1970-01-01T00:33:20Z"}},{"abs_before":"1970-01-01T00:50:00Z"}]}`,
+			3000, false, -1,
+		},
+		{
+			"or",
+			`{"or":[{"rel_before":"100"},{"not":{"abs_before":"1970-01-01T01:06:40Z"}}]}`,
+			1200, false, 4000,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var predicate ClaimPredicate
+			require.NoError(t, json.Unmarshal([]byte(testCase.predicate), &predicate))
+
+			at := time.Unix(testCase.at, 0).UTC()
+			satisfied, err := predicate.Evaluate(createdAt, at)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.satisfied, satisfied)
+
+			next, ok, err := predicate.NextSatisfied(createdAt, at)
+			require.NoError(t, err)
+			if testCase.next < 0 {
+				assert.False(t, ok)
+			} else {
+				assert.True(t, ok)
+				assert.Equal(t, testCase.next, next.Unix())
+			}
+		})
+	}
+
+	_, err := ClaimPredicate{Type: 100}.Evaluate(createdAt, createdAt)
+	assert.Error(t, err)
+}
+
+func TestClaimPredicateJSONEvaluate(t *testing.T) {
+	createdAt := time.Unix(1000, 0).UTC()
+
+	// abs_before_epoch is used when abs_before is missing
+	var predicate claimPredicateJSON
+	require.NoError(t, json.Unmarshal([]byte(`{"not":{"abs_before_epoch":"2000"}}`), &predicate))
+	satisfied, err := predicate.evaluate(createdAt.Unix(), 1999)
+	require.NoError(t, err)
+	assert.False(t, satisfied)
+	satisfied, err = predicate.evaluate(createdAt.Unix(), 2000)
+	require.NoError(t, err)
+	assert.True(t, satisfied)
+
+	_, err = claimPredicateJSON{}.evaluate(createdAt.Unix(), 2000)
+	assert.EqualError(t, err, "empty predicate")
+
+	var missing *ClaimPredicate
+	_, err = ClaimPredicate{Type: ClaimPredicateTypeClaimPredicateNot, NotPredicate: &missing}.Evaluate(createdAt, createdAt)
+	assert.EqualError(t, err, "missing not predicate")
+}
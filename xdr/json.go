@@ -154,8 +154,12 @@ func (c ClaimPredicate) toJSON() (claimPredicateJSON, error) {
 	case ClaimPredicateTypeClaimPredicateUnconditional:
 		payload.Unconditional = true
 	case ClaimPredicateTypeClaimPredicateNot:
+		inner := c.MustNotPredicate()
+		if inner == nil {
+			return payload, fmt.Errorf("missing not predicate")
+		}
 		payload.Not = new(claimPredicateJSON)
-		*payload.Not, err = c.MustNotPredicate().toJSON()
+		*payload.Not, err = inner.toJSON()
 	case ClaimPredicateTypeClaimPredicateBeforeAbsoluteTime:
 		absBeforeEpoch := int64(c.MustAbsBefore())
 		payload.AbsBefore = newiso8601Time(absBeforeEpoch)